@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
+
+	"github.com/your-org/console-auth-proxy/internal/injector"
+	"github.com/your-org/console-auth-proxy/internal/version"
+)
+
+type options struct {
+	listenAddress string
+	certFile      string
+	keyFile       string
+	configFile    string
+	kubeconfig    string
+}
+
+func main() {
+	opts := &options{}
+
+	rootCmd := &cobra.Command{
+		Use:   "auth-proxy-injector",
+		Short: "Sidecar injector for the console auth proxy",
+		Long: `A mutating admission webhook that injects console-auth-proxy as a sidecar into pods
+annotated with ` + injector.InjectAnnotation + `: "true" and points annotated services at it.`,
+		Version: version.Version,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(opts)
+		},
+		SilenceUsage: true,
+	}
+
+	rootCmd.Flags().StringVar(&opts.listenAddress, "listen-address", "0.0.0.0:8443", "Address to listen on")
+	rootCmd.Flags().StringVar(&opts.certFile, "tls-cert-file", "", "TLS certificate file")
+	rootCmd.Flags().StringVar(&opts.keyFile, "tls-key-file", "", "TLS private key file")
+	rootCmd.Flags().StringVar(&opts.configFile, "config", "", "Sidecar configuration file")
+	rootCmd.Flags().StringVar(&opts.kubeconfig, "kubeconfig", "", "Kubeconfig used to resolve named service ports, defaults to the in-cluster config")
+	rootCmd.MarkFlagRequired("tls-cert-file")
+	rootCmd.MarkFlagRequired("tls-key-file")
+	rootCmd.MarkFlagRequired("config")
+
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func run(opts *options) error {
+	cfg, err := injector.LoadConfig(opts.configFile)
+	if err != nil {
+		return err
+	}
+
+	// Load the certificate on every handshake so rotated certificates are picked up
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(opts.certFile, opts.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		return &cert, nil
+	}
+	if _, err := getCertificate(nil); err != nil {
+		return err
+	}
+
+	// Named service target ports are resolved against the pods they select
+	restConfig, err := clientcmd.BuildConfigFromFlags("", opts.kubeconfig)
+	if err != nil {
+		return fmt.Errorf("failed to load Kubernetes config: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/mutate", injector.NewWebhook(cfg, client.CoreV1()))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+
+	httpServer := &http.Server{
+		Addr:         opts.listenAddress,
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			GetCertificate: getCertificate,
+			MinVersion:     tls.VersionTLS12,
+		},
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		klog.Infof("Starting sidecar injector on %s with image %s", opts.listenAddress, cfg.Image)
+		if err := httpServer.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errChan <- err
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-errChan:
+		return fmt.Errorf("server failed: %w", err)
+	case sig := <-sigChan:
+		klog.Infof("Received signal %v, shutting down", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return httpServer.Shutdown(ctx)
+}
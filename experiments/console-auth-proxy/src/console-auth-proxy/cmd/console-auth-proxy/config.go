@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+)
+
+// newValidateCommand creates the validate subcommand, which loads and
+// validates the configuration without starting the server
+func newValidateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate",
+		Short: "Validate the configuration and exit",
+		Args:  cobra.NoArgs,
+		// Invalid configuration is not a usage error
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := initConfig(); err != nil {
+				return fmt.Errorf("failed to initialize config: %w", err)
+			}
+
+			if err := cfg.Validate(); err != nil {
+				return fmt.Errorf("invalid configuration: %w", err)
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), "Configuration is valid")
+			return nil
+		},
+	}
+}
+
+// newConfigCommand creates the config subcommand and its children
+func newConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "print",
+		Short: "Print the effective configuration with secrets redacted",
+		Long: `Print the configuration after merging flags, CAP_* environment variables, the
+config file and defaults. Each value is annotated with its source.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := initConfig(); err != nil {
+				return fmt.Errorf("failed to initialize config: %w", err)
+			}
+			cfg.SetDefaults()
+
+			out, err := cfg.EffectiveYAML(func(key string) string {
+				return valueSource(cmd, key)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to render config: %w", err)
+			}
+
+			_, err = cmd.OutOrStdout().Write(out)
+			return err
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of the configuration file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(config.Schema())
+		},
+	})
+
+	return cmd
+}
+
+// valueSource describes where the value of a config key came from, following
+// viper's precedence: flag, environment, config file, default
+func valueSource(cmd *cobra.Command, key string) string {
+	for name, flagKey := range flagKeys {
+		if flagKey == key && cmd.Root().PersistentFlags().Changed(name) {
+			return "flag --" + name
+		}
+	}
+
+	for _, name := range envVars(key) {
+		if _, ok := os.LookupEnv(name); ok {
+			return "env " + name
+		}
+	}
+
+	if viper.InConfig(key) {
+		return "file"
+	}
+
+	return "default"
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/your-org/console-auth-proxy/internal/cli"
+)
+
+// newLoginCommand creates the login subcommand, which obtains a CLI token
+// through the device flow and caches it locally
+func newLoginCommand() *cobra.Command {
+	opts := &cli.LoginOptions{}
+	tokenStdin := false
+
+	cmd := &cobra.Command{
+		Use:   "login",
+		Short: "Log in to a console-auth-proxy and cache a CLI token",
+		Long: `Log in to a console-auth-proxy using the device authorization flow. The command
+prints a URL and a code to confirm in the browser, then caches the issued token.
+Use --token-stdin to store a token copied from the /auth/token page instead.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := cli.DefaultCachePath()
+			if err != nil {
+				return err
+			}
+
+			// Read the token from stdin so it stays out of shell history and ps
+			if tokenStdin {
+				if opts.Token != "" {
+					return fmt.Errorf("--token and --token-stdin are mutually exclusive")
+				}
+				line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+				opts.Token = strings.TrimSpace(line)
+				if opts.Token == "" {
+					return fmt.Errorf("no token on stdin: %v", err)
+				}
+			}
+
+			opts.Out = cmd.OutOrStdout()
+			token, err := cli.Login(cmd.Context(), opts)
+			if err != nil {
+				return err
+			}
+
+			cache := &cli.TokenCache{Path: path}
+			if err := cache.Put(token); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Logged in to %s as %s\n", token.URL, token.Username)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.URL, "url", "", "Public URL of the proxy")
+	cmd.Flags().StringVar(&opts.Token, "token", "", "Store this token instead of running the device flow, visible in shell history and ps")
+	cmd.Flags().BoolVar(&tokenStdin, "token-stdin", false, "Read the token to store from stdin instead of running the device flow")
+	cmd.Flags().BoolVar(&opts.InsecureSkipTLSVerify, "insecure-skip-tls-verify", false, "Skip TLS certificate verification of the proxy")
+	cmd.MarkFlagRequired("url")
+
+	return cmd
+}
@@ -23,6 +23,26 @@ var (
 	cfg     *config.Config
 )
 
+// flagKeys maps command line flags to the config keys they set
+var flagKeys = map[string]string{
+	"listen-address":                 "server.listen_address",
+	"backend-url":                    "proxy.backend.url",
+	"auth-source":                    "auth.auth_source",
+	"issuer-url":                     "auth.issuer_url",
+	"client-id":                      "auth.client_id",
+	"client-secret":                  "auth.client_secret",
+	"redirect-url":                   "auth.redirect_url",
+	"secure-cookies":                 "auth.secure_cookies",
+	"auth-tls-insecure-skip-verify":  "auth.tls.insecure_skip_verify",
+	"auth-tls-server-name":           "auth.tls.server_name",
+	"proxy-tls-insecure-skip-verify": "proxy.tls.insecure_skip_verify",
+	"proxy-tls-server-name":          "proxy.tls.server_name",
+	"proxy-tls-ca-file":              "proxy.tls.ca_file",
+	"proxy-tls-cert-file":            "proxy.tls.cert_file",
+	"proxy-tls-key-file":             "proxy.tls.key_file",
+	"tls-profile":                    "tls_profile",
+}
+
 func main() {
 	rootCmd := &cobra.Command{
 		Use:   "console-auth-proxy",
@@ -31,12 +51,14 @@ func main() {
 authentication module to provide OAuth2/OIDC authentication for backend applications.`,
 		Version: version.Version,
 		RunE:    run,
+		// Errors are printed by main
+		SilenceErrors: true,
 	}
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./config.yaml)")
 	rootCmd.PersistentFlags().String("listen-address", "0.0.0.0:8080", "Address to listen on")
 	rootCmd.PersistentFlags().String("backend-url", "", "Backend URL to proxy to")
-	rootCmd.PersistentFlags().String("auth-source", "oidc", "Authentication source (openshift or oidc)")
+	rootCmd.PersistentFlags().String("auth-source", "oidc", "Authentication source (openshift, oidc, mtls or local)")
 	rootCmd.PersistentFlags().String("issuer-url", "", "OIDC issuer URL")
 	rootCmd.PersistentFlags().String("client-id", "", "OAuth2 client ID")
 	rootCmd.PersistentFlags().String("client-secret", "", "OAuth2 client secret")
@@ -54,17 +76,15 @@ authentication module to provide OAuth2/OIDC authentication for backend applicat
 	rootCmd.PersistentFlags().String("proxy-tls-cert-file", "", "Client certificate file for backend connections")
 	rootCmd.PersistentFlags().String("proxy-tls-key-file", "", "Client private key file for backend connections")
 
-	// Bind flags to viper with custom mappings for nested config
-	viper.BindPFlags(rootCmd.PersistentFlags())
-	
-	// Map CLI flags to nested config structure
-	viper.BindPFlag("auth.tls.insecure_skip_verify", rootCmd.PersistentFlags().Lookup("auth-tls-insecure-skip-verify"))
-	viper.BindPFlag("auth.tls.server_name", rootCmd.PersistentFlags().Lookup("auth-tls-server-name"))
-	viper.BindPFlag("proxy.tls.insecure_skip_verify", rootCmd.PersistentFlags().Lookup("proxy-tls-insecure-skip-verify"))
-	viper.BindPFlag("proxy.tls.server_name", rootCmd.PersistentFlags().Lookup("proxy-tls-server-name"))
-	viper.BindPFlag("proxy.tls.ca_file", rootCmd.PersistentFlags().Lookup("proxy-tls-ca-file"))
-	viper.BindPFlag("proxy.tls.cert_file", rootCmd.PersistentFlags().Lookup("proxy-tls-cert-file"))
-	viper.BindPFlag("proxy.tls.key_file", rootCmd.PersistentFlags().Lookup("proxy-tls-key-file"))
+	// TLS profile for the listener, backend and auth provider connections
+	rootCmd.PersistentFlags().String("tls-profile", "default", "TLS profile (default, fips, pq-hybrid or modern)")
+
+	// Bind flags to their nested config keys
+	for name, key := range flagKeys {
+		viper.BindPFlag(key, rootCmd.PersistentFlags().Lookup(name))
+	}
+
+	rootCmd.AddCommand(newValidateCommand(), newConfigCommand(), newLoginCommand())
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -127,6 +147,22 @@ func run(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+var envKeyReplacer = strings.NewReplacer(".", "_", "-", "_")
+
+// envVars returns the environment variables for a config key: one named after
+// the key, and one named after its flag if it has one (e.g. CAP_BACKEND_URL)
+func envVars(key string) []string {
+	names := []string{"CAP_" + strings.ToUpper(envKeyReplacer.Replace(key))}
+	for name, flagKey := range flagKeys {
+		if flagKey == key {
+			if flagEnv := "CAP_" + strings.ToUpper(envKeyReplacer.Replace(name)); flagEnv != names[0] {
+				names = append(names, flagEnv)
+			}
+		}
+	}
+	return names
+}
+
 func initConfig() error {
 	if cfgFile != "" {
 		viper.SetConfigFile(cfgFile)
@@ -139,9 +175,15 @@ func initConfig() error {
 
 	// Read environment variables with key replacer for nested configs
 	viper.SetEnvPrefix("CAP") // Console Auth Proxy
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	viper.SetEnvKeyReplacer(envKeyReplacer)
 	viper.AutomaticEnv()
 
+	// AutomaticEnv only applies to keys viper already knows about, bind all of
+	// them so every setting can come from the environment
+	for _, field := range config.Fields() {
+		viper.BindEnv(append([]string{field.Key}, envVars(field.Key)...)...)
+	}
+
 	// Read config file if it exists
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
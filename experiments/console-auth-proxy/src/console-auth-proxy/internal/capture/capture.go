@@ -0,0 +1,257 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+)
+
+// Entry is a captured request/response pair. Credentials are redacted when
+// the entry is recorded, so they are never kept in memory or written out.
+type Entry struct {
+	Started  time.Time
+	Duration time.Duration
+	ClientIP string
+
+	Method         string
+	URL            string
+	Proto          string
+	RequestHeaders http.Header
+
+	// Headers sent to the backend after the proxy rewrote them
+	UpstreamHeaders http.Header
+
+	Status          int
+	ResponseHeaders http.Header
+	ResponseSize    int64
+
+	// Authenticated user and how the proxy handled the request
+	User     string
+	Decision string
+
+	redact *redactor
+}
+
+// Filter selects captured entries
+type Filter struct {
+	Since time.Time
+	Until time.Time
+	User  string
+}
+
+func (f *Filter) matches(e *Entry) bool {
+	if !f.Since.IsZero() && e.Started.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Started.After(f.Until) {
+		return false
+	}
+	return f.User == "" || e.User == f.User
+}
+
+// Recorder keeps the most recent entries in a ring buffer and optionally
+// mirrors them to a HAR file
+type Recorder struct {
+	enabled bool
+	file    string
+	redact  *redactor
+
+	mu      sync.Mutex
+	entries []*Entry
+	next    int
+	dirty   bool
+}
+
+// New creates a recorder. The HAR file, if configured, is rewritten every flush
+// interval. authHeader and identityHeaders are the headers the proxy sends the
+// backend credentials and the user's identity in, redacted like Authorization
+// and hashed respectively.
+func New(cfg *config.CaptureConfig, authHeader string, identityHeaders ...string) *Recorder {
+	r := &Recorder{
+		enabled: cfg.Enabled,
+		file:    cfg.File,
+		redact:  newRedactor(authHeader, identityHeaders),
+	}
+	if !r.enabled {
+		return r
+	}
+
+	r.entries = make([]*Entry, 0, cfg.MaxEntries)
+	klog.Warningf("Traffic capture is enabled, keeping up to %d requests with credentials redacted", cfg.MaxEntries)
+
+	if r.file != "" {
+		go wait.Forever(func() {
+			if err := r.Flush(); err != nil {
+				klog.Errorf("Failed to write capture file: %v", err)
+			}
+		}, cfg.FlushInterval)
+	}
+	return r
+}
+
+// Enabled reports whether requests are captured
+func (r *Recorder) Enabled() bool {
+	return r.enabled
+}
+
+// Handler wraps next, recording every request it serves
+func (r *Recorder) Handler(next http.Handler) http.Handler {
+	if !r.enabled {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		entry := &Entry{
+			Started:        time.Now(),
+			ClientIP:       clientIP(req),
+			Method:         req.Method,
+			URL:            redactURL(requestURL(req)),
+			Proto:          req.Proto,
+			RequestHeaders: r.redact.headers(req.Header),
+			redact:         r.redact,
+		}
+
+		cw := &captureResponseWriter{ResponseWriter: w, entry: entry}
+		next.ServeHTTP(cw, req.WithContext(context.WithValue(req.Context(), entryKey{}, entry)))
+
+		entry.Duration = time.Since(entry.Started)
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		r.add(entry)
+	})
+}
+
+func (r *Recorder) add(entry *Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.entries) < cap(r.entries) {
+		r.entries = append(r.entries, entry)
+	} else if len(r.entries) > 0 {
+		r.entries[r.next] = entry
+		r.next = (r.next + 1) % len(r.entries)
+	}
+	r.dirty = true
+}
+
+// Entries returns the captured entries matching filter, oldest first
+func (r *Recorder) Entries(filter Filter) []*Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []*Entry
+	for i := range r.entries {
+		entry := r.entries[(r.next+i)%len(r.entries)]
+		if filter.matches(entry) {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// entryKey is the context key of the entry of a request
+type entryKey struct{}
+
+func entryFrom(r *http.Request) *Entry {
+	entry, _ := r.Context().Value(entryKey{}).(*Entry)
+	return entry
+}
+
+// SetUser records the authenticated user of a request
+func SetUser(r *http.Request, username string) {
+	if entry := entryFrom(r); entry != nil {
+		entry.User = username
+	}
+}
+
+// SetDecision records how the proxy handled a request
+func SetDecision(r *http.Request, format string, args ...interface{}) {
+	if entry := entryFrom(r); entry != nil {
+		entry.Decision = fmt.Sprintf(format, args...)
+	}
+}
+
+// SetUpstreamHeaders records the headers of the request sent to the backend
+func SetUpstreamHeaders(r *http.Request) {
+	if entry := entryFrom(r); entry != nil {
+		entry.UpstreamHeaders = entry.redact.headers(r.Header)
+	}
+}
+
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// captureResponseWriter records the status, headers and size of a response
+type captureResponseWriter struct {
+	http.ResponseWriter
+	entry       *Entry
+	wroteHeader bool
+}
+
+func (w *captureResponseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.entry.Status = statusCode
+		w.entry.ResponseHeaders = w.entry.redact.headers(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *captureResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.entry.ResponseSize += int64(n)
+	return n, err
+}
+
+func (w *captureResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack supports WebSocket upgrades through the proxy
+func (w *captureResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("underlying response writer does not support hijacking")
+	}
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.entry.Status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (w *captureResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,268 @@
+package capture
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+)
+
+func newTestRecorder(maxEntries int) *Recorder {
+	return New(&config.CaptureConfig{Enabled: true, MaxEntries: maxEntries}, "")
+}
+
+func TestRedactHeaders(t *testing.T) {
+	headers := http.Header{
+		"Authorization":            {"Bearer secret-token"},
+		"Cookie":                   {"openshift-session-token=abc; csrf-token=def"},
+		"Set-Cookie":               {"session=xyz; Path=/; HttpOnly; Secure"},
+		"Location":                 {"https://app.example.com/auth/callback?code=the-code&state=the-state&next=%2Fhome"},
+		"X-Forwarded-Access-Token": {"upstream-token"},
+		"X-Forwarded-User":         {"alice"},
+	}
+
+	redacted := newRedactor("", nil).headers(headers)
+
+	assert.Equal(t, "Bearer "+Hash("secret-token"), redacted.Get("Authorization"))
+	assert.Equal(t, "openshift-session-token="+Hash("abc")+"; csrf-token="+Hash("def"), redacted.Get("Cookie"))
+	assert.Equal(t, "session="+Hash("xyz")+"; Path=/; HttpOnly; Secure", redacted.Get("Set-Cookie"))
+	assert.Equal(t, Hash("upstream-token"), redacted.Get("X-Forwarded-Access-Token"))
+	assert.Equal(t, "alice", redacted.Get("X-Forwarded-User"))
+
+	location := redacted.Get("Location")
+	assert.NotContains(t, location, "the-code")
+	assert.NotContains(t, location, "the-state")
+	assert.Contains(t, location, "next=%2Fhome")
+
+	// The original headers are left alone
+	assert.Equal(t, "Bearer secret-token", headers.Get("Authorization"))
+}
+
+func TestHash(t *testing.T) {
+	assert.Equal(t, Hash("token"), Hash("token"))
+	assert.NotEqual(t, Hash("token"), Hash("other"))
+	assert.True(t, strings.HasPrefix(Hash("token"), "hmac-sha256:"))
+
+	// Hashes are keyed, so they don't match a plain SHA-256 of the value
+	sum := sha256.Sum256([]byte("token"))
+	assert.NotContains(t, Hash("token"), hex.EncodeToString(sum[:8]))
+	assert.Empty(t, Hash(""))
+}
+
+func TestHandlerRecordsRequests(t *testing.T) {
+	r := newTestRecorder(10)
+
+	handler := r.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		SetUser(req, "alice")
+		SetDecision(req, "public by %s", "rules[0]")
+
+		upstream := req.Clone(req.Context())
+		upstream.Header.Set("X-Forwarded-User", "alice")
+		upstream.Header.Set("Authorization", "Bearer upstream-token")
+		SetUpstreamHeaders(upstream)
+
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "new-session", Path: "/"})
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("hello"))
+	}))
+
+	req := httptest.NewRequest("GET", "http://proxy.example.com/api?token=abc&page=2", nil)
+	req.Header.Set("Cookie", "session=old-session")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := r.Entries(Filter{})
+	require.Len(t, entries, 1)
+	entry := entries[0]
+
+	assert.Equal(t, "GET", entry.Method)
+	assert.NotContains(t, entry.URL, "token=abc")
+	assert.Contains(t, entry.URL, "page=2")
+	assert.Equal(t, http.StatusTeapot, entry.Status)
+	assert.Equal(t, int64(5), entry.ResponseSize)
+	assert.Equal(t, "alice", entry.User)
+	assert.Equal(t, "public by rules[0]", entry.Decision)
+	assert.Equal(t, "session="+Hash("old-session"), entry.RequestHeaders.Get("Cookie"))
+	assert.Equal(t, "session="+Hash("new-session")+"; Path=/", entry.ResponseHeaders.Get("Set-Cookie"))
+	assert.Equal(t, "Bearer "+Hash("upstream-token"), entry.UpstreamHeaders.Get("Authorization"))
+	assert.Equal(t, "alice", entry.UpstreamHeaders.Get("X-Forwarded-User"))
+}
+
+func TestHandlerRedactsConfiguredHeaders(t *testing.T) {
+	// proxy.headers.auth_header set to a name that doesn't look like a credential
+	r := New(&config.CaptureConfig{Enabled: true, MaxEntries: 10}, "X-Backend-Auth", "X-Remote-User", "X-Remote-Groups")
+
+	handler := r.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		upstream := req.Clone(req.Context())
+		upstream.Header.Set("X-Backend-Auth", "Bearer upstream-token")
+		upstream.Header.Set("X-Remote-User", "alice")
+		upstream.Header.Set("X-Remote-Groups", "admins")
+		upstream.Header.Set("X-Request-Id", "42")
+		SetUpstreamHeaders(upstream)
+	}))
+
+	req := httptest.NewRequest("GET", "http://proxy.example.com/api", nil)
+	req.Header.Set("x-backend-auth", "raw-token")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := r.Entries(Filter{})
+	require.Len(t, entries, 1)
+	entry := entries[0]
+
+	assert.Equal(t, Hash("raw-token"), entry.RequestHeaders.Get("X-Backend-Auth"))
+	assert.Equal(t, "Bearer "+Hash("upstream-token"), entry.UpstreamHeaders.Get("X-Backend-Auth"))
+	assert.Equal(t, Hash("alice"), entry.UpstreamHeaders.Get("X-Remote-User"))
+	assert.Equal(t, Hash("admins"), entry.UpstreamHeaders.Get("X-Remote-Groups"))
+	assert.Equal(t, "42", entry.UpstreamHeaders.Get("X-Request-Id"))
+}
+
+func TestRingBuffer(t *testing.T) {
+	r := newTestRecorder(3)
+	handler := r.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	for _, path := range []string{"/1", "/2", "/3", "/4", "/5"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	var paths []string
+	for _, entry := range r.Entries(Filter{}) {
+		paths = append(paths, strings.TrimPrefix(entry.URL, "http://example.com"))
+	}
+	assert.Equal(t, []string{"/3", "/4", "/5"}, paths)
+}
+
+func TestFilter(t *testing.T) {
+	now := time.Now()
+	r := newTestRecorder(10)
+	r.add(&Entry{Started: now.Add(-time.Hour), User: "alice"})
+	r.add(&Entry{Started: now.Add(-10 * time.Minute), User: "bob"})
+	r.add(&Entry{Started: now.Add(-time.Minute), User: "alice"})
+
+	assert.Len(t, r.Entries(Filter{}), 3)
+	assert.Len(t, r.Entries(Filter{User: "alice"}), 2)
+	assert.Len(t, r.Entries(Filter{Since: now.Add(-15 * time.Minute)}), 2)
+	assert.Len(t, r.Entries(Filter{Since: now.Add(-15 * time.Minute), User: "alice"}), 1)
+	assert.Len(t, r.Entries(Filter{Until: now.Add(-30 * time.Minute)}), 1)
+}
+
+func TestDisabledRecorder(t *testing.T) {
+	r := New(&config.CaptureConfig{}, "")
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Annotations are no-ops without a capture
+		SetUser(req, "alice")
+		SetDecision(req, "authenticated")
+	})
+
+	handler := r.Handler(next)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.Empty(t, r.Entries(Filter{}))
+	assert.False(t, r.Enabled())
+}
+
+// fakeAuthenticator authenticates requests with an X-Test-User header
+type fakeAuthenticator struct {
+	auth.Authenticator
+	groups []string
+}
+
+func (a *fakeAuthenticator) Authenticate(w http.ResponseWriter, r *http.Request) (*auth.User, error) {
+	username := r.Header.Get("X-Test-User")
+	if username == "" {
+		return nil, errors.New("no user")
+	}
+	return &auth.User{Username: username, Groups: a.groups}, nil
+}
+
+func TestDownloadHandler(t *testing.T) {
+	r := newTestRecorder(10)
+	r.add(&Entry{
+		Started:         time.Now(),
+		Method:          "GET",
+		URL:             "http://example.com/api?page=1",
+		Proto:           "HTTP/1.1",
+		RequestHeaders:  http.Header{"Cookie": {"session=" + Hash("s")}},
+		ResponseHeaders: http.Header{"Content-Type": {"text/plain"}},
+		Status:          http.StatusOK,
+		User:            "alice",
+		Decision:        "authenticated",
+	})
+	r.add(&Entry{Started: time.Now(), URL: "http://example.com/", Status: http.StatusOK, User: "bob"})
+
+	handler := r.DownloadHandler(&fakeAuthenticator{groups: []string{"sre"}}, []string{"admin"}, []string{"sre"})
+
+	// Unauthenticated
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/auth/admin/capture", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Not an admin
+	handler = r.DownloadHandler(&fakeAuthenticator{}, []string{"admin"}, []string{"sre"})
+	req := httptest.NewRequest("GET", "/auth/admin/capture", nil)
+	req.Header.Set("X-Test-User", "mallory")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Admin group member, filtered by user
+	handler = r.DownloadHandler(&fakeAuthenticator{groups: []string{"sre"}}, []string{"admin"}, []string{"sre"})
+	req = httptest.NewRequest("GET", "/auth/admin/capture?since=1h&user=alice", nil)
+	req.Header.Set("X-Test-User", "carol")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Disposition"), ".har")
+
+	var har harLog
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &har))
+	assert.Equal(t, "1.2", har.Log.Version)
+	require.Len(t, har.Log.Entries, 1)
+	entry := har.Log.Entries[0]
+	assert.Equal(t, "alice", entry.User)
+	assert.Equal(t, "authenticated", entry.Decision)
+	assert.Equal(t, []harHeader{{Name: "page", Value: "1"}}, entry.Request.QueryString)
+	assert.Equal(t, []harCookie{{Name: "session", Value: Hash("s")}}, entry.Request.Cookies)
+	assert.Equal(t, "text/plain", entry.Response.Content.MimeType)
+
+	// Invalid time window
+	req = httptest.NewRequest("GET", "/auth/admin/capture?since=yesterday", nil)
+	req.Header.Set("X-Test-User", "admin")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestFlush(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "capture.har")
+	r := newTestRecorder(10)
+	r.file = file
+
+	// Nothing to write yet
+	require.NoError(t, r.Flush())
+	_, err := os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+
+	r.add(&Entry{Started: time.Now(), URL: "http://example.com/", Status: http.StatusOK})
+	require.NoError(t, r.Flush())
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	var har harLog
+	require.NoError(t, json.Unmarshal(data, &har))
+	assert.Len(t, har.Log.Entries, 1)
+
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
@@ -0,0 +1,91 @@
+package capture
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+)
+
+// DownloadHandler serves the capture as a HAR file to admin users. The
+// since and until query parameters take RFC 3339 times, since also takes a
+// duration such as 15m; user selects the requests of one user.
+func (r *Recorder) DownloadHandler(authenticator auth.Authenticator, adminUsers, adminGroups []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user, err := authenticator.Authenticate(w, req)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !isAdmin(user, adminUsers, adminGroups) {
+			klog.Warningf("User %s is not allowed to download the traffic capture", user.Username)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		filter, err := parseFilter(req, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		entries := r.Entries(filter)
+		klog.Infof("User %s downloaded %d captured requests", user.Username, len(entries))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="capture-%s.har"`, time.Now().UTC().Format("20060102T150405Z")))
+		w.Header().Set("Cache-Control", "no-store")
+		if err := WriteHAR(w, entries); err != nil {
+			klog.Errorf("Failed to write capture: %v", err)
+		}
+	})
+}
+
+func parseFilter(r *http.Request, now time.Time) (Filter, error) {
+	query := r.URL.Query()
+	filter := Filter{User: query.Get("user")}
+
+	if since := query.Get("since"); since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			filter.Since = now.Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, since); err == nil {
+			filter.Since = t
+		} else {
+			return filter, fmt.Errorf("since must be an RFC 3339 time or a duration, got: %s", since)
+		}
+	}
+
+	if until := query.Get("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return filter, fmt.Errorf("until must be an RFC 3339 time, got: %s", until)
+		}
+		filter.Until = t
+	}
+
+	return filter, nil
+}
+
+func isAdmin(user *auth.User, adminUsers, adminGroups []string) bool {
+	for _, name := range adminUsers {
+		if name == user.Username {
+			return true
+		}
+	}
+	for _, group := range user.Groups {
+		for _, adminGroup := range adminGroups {
+			if group == adminGroup {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/your-org/console-auth-proxy/internal/version"
+)
+
+// HAR 1.2 (http://www.softwareishard.com/blog/har-12-spec/). Fields the proxy
+// adds are prefixed with an underscore, as the format allows.
+
+type harLog struct {
+	Log harContent `json:"log"`
+}
+
+type harContent struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+
+	ClientIP        string      `json:"_clientIP,omitempty"`
+	User            string      `json:"_user,omitempty"`
+	Decision        string      `json:"_decision,omitempty"`
+	UpstreamHeaders []harHeader `json:"_upstreamHeaders,omitempty"`
+}
+
+type harRequest struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []harCookie `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	QueryString []harHeader `json:"queryString"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []harCookie `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	Content     harBody     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	HTTPOnly bool   `json:"httpOnly,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+}
+
+type harBody struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// WriteHAR writes entries as a HAR document
+func WriteHAR(w io.Writer, entries []*Entry) error {
+	har := harLog{Log: harContent{
+		Version: "1.2",
+		Creator: harCreator{Name: "console-auth-proxy", Version: version.Version},
+		Entries: make([]harEntry, 0, len(entries)),
+	}}
+	for _, entry := range entries {
+		har.Log.Entries = append(har.Log.Entries, toHAR(entry))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(har)
+}
+
+func toHAR(e *Entry) harEntry {
+	millis := float64(e.Duration.Microseconds()) / 1000
+
+	var query []harHeader
+	if u, err := url.Parse(e.URL); err == nil {
+		for name, values := range u.Query() {
+			for _, value := range values {
+				query = append(query, harHeader{Name: name, Value: value})
+			}
+		}
+		sort.Slice(query, func(i, j int) bool { return query[i].Name < query[j].Name })
+	}
+
+	// Cookie values are already redacted in the headers they are parsed from
+	request := &http.Request{Header: e.RequestHeaders}
+	var requestCookies []harCookie
+	for _, cookie := range request.Cookies() {
+		requestCookies = append(requestCookies, harCookie{Name: cookie.Name, Value: cookie.Value})
+	}
+
+	response := &http.Response{Header: e.ResponseHeaders}
+	var responseCookies []harCookie
+	for _, cookie := range response.Cookies() {
+		responseCookies = append(responseCookies, harCookie{
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+			Path:     cookie.Path,
+			Domain:   cookie.Domain,
+			HTTPOnly: cookie.HttpOnly,
+			Secure:   cookie.Secure,
+		})
+	}
+
+	return harEntry{
+		StartedDateTime: e.Started.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		Time:            millis,
+		Request: harRequest{
+			Method:      e.Method,
+			URL:         e.URL,
+			HTTPVersion: e.Proto,
+			Cookies:     emptyIfNil(requestCookies),
+			Headers:     harHeaders(e.RequestHeaders),
+			QueryString: emptyIfNil(query),
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Response: harResponse{
+			Status:      e.Status,
+			StatusText:  http.StatusText(e.Status),
+			HTTPVersion: e.Proto,
+			Cookies:     emptyIfNil(responseCookies),
+			Headers:     harHeaders(e.ResponseHeaders),
+			Content: harBody{
+				Size:     e.ResponseSize,
+				MimeType: e.ResponseHeaders.Get("Content-Type"),
+			},
+			RedirectURL: e.ResponseHeaders.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    e.ResponseSize,
+		},
+		Timings:         harTimings{Wait: millis},
+		ClientIP:        e.ClientIP,
+		User:            e.User,
+		Decision:        e.Decision,
+		UpstreamHeaders: harHeaders(e.UpstreamHeaders),
+	}
+}
+
+// harHeaders flattens headers in a stable order
+func harHeaders(headers http.Header) []harHeader {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := []harHeader{}
+	for _, name := range names {
+		for _, value := range headers[name] {
+			result = append(result, harHeader{Name: name, Value: value})
+		}
+	}
+	return result
+}
+
+func emptyIfNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
+
+// Flush rewrites the HAR file from the ring buffer if new entries were recorded
+func (r *Recorder) Flush() error {
+	if r.file == "" {
+		return nil
+	}
+
+	r.mu.Lock()
+	dirty := r.dirty
+	r.dirty = false
+	r.mu.Unlock()
+	if !dirty {
+		return nil
+	}
+
+	if err := r.writeFile(); err != nil {
+		// Try again on the next flush
+		r.mu.Lock()
+		r.dirty = true
+		r.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// writeFile writes a temporary file and renames it, so readers never see a partial capture
+func (r *Recorder) writeFile() error {
+	tmp, err := os.CreateTemp(filepath.Dir(r.file), filepath.Base(r.file)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create capture file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := WriteHAR(tmp, r.Entries(Filter{})); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write capture file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write capture file: %w", err)
+	}
+	return os.Rename(tmp.Name(), r.file)
+}
@@ -0,0 +1,161 @@
+package capture
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// sensitiveParams are query parameters carrying codes, tokens or secrets
+var sensitiveParams = map[string]bool{
+	"code":          true,
+	"state":         true,
+	"access_token":  true,
+	"id_token":      true,
+	"refresh_token": true,
+	"token":         true,
+	"client_secret": true,
+	"device_code":   true,
+	"password":      true,
+}
+
+// hashKey keys the hashes of redacted values. It is generated per process, so
+// identical values can be correlated within a capture, but short or guessable
+// values can't be recovered by hashing candidates offline.
+var hashKey = newHashKey()
+
+func newHashKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("capture: failed to generate hash key: %v", err))
+	}
+	return key
+}
+
+// Hash returns the redacted form of a value, a truncated HMAC-SHA256 keyed
+// with a random per-process key
+func Hash(value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write([]byte(value))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// redactor redacts credentials from headers. Besides well-known names, it
+// covers the headers the proxy is configured to send the backend credentials
+// and identities in, whatever they are called.
+type redactor struct {
+	// authHeader carries the backend credentials, redacted like Authorization
+	authHeader string
+	// identityHeaders carry the user's identity and are hashed
+	identityHeaders map[string]bool
+}
+
+func newRedactor(authHeader string, identityHeaders []string) *redactor {
+	rd := &redactor{identityHeaders: make(map[string]bool)}
+	if authHeader != "" {
+		rd.authHeader = http.CanonicalHeaderKey(authHeader)
+	}
+	for _, name := range identityHeaders {
+		if name != "" {
+			rd.identityHeaders[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+	return rd
+}
+
+// headers returns a copy of headers with credentials replaced by hashes
+func (rd *redactor) headers(headers http.Header) http.Header {
+	redacted := make(http.Header, len(headers))
+	for name, values := range headers {
+		copied := make([]string, len(values))
+		for i, value := range values {
+			copied[i] = rd.header(name, value)
+		}
+		redacted[name] = copied
+	}
+	return redacted
+}
+
+func (rd *redactor) header(name, value string) string {
+	canonical := http.CanonicalHeaderKey(name)
+	if canonical == rd.authHeader {
+		return redactCredentials(value)
+	}
+	if rd.identityHeaders[canonical] {
+		return Hash(value)
+	}
+
+	switch canonical {
+	case "Authorization", "Proxy-Authorization":
+		return redactCredentials(value)
+	case "Cookie":
+		return redactCookies(value)
+	case "Set-Cookie":
+		// Keep the attributes, they are what usually goes wrong
+		pair, attributes, found := strings.Cut(value, ";")
+		redacted := redactCookies(pair)
+		if found {
+			redacted += ";" + attributes
+		}
+		return redacted
+	case "Location", "Referer":
+		return redactURL(value)
+	default:
+		lower := strings.ToLower(canonical)
+		if strings.Contains(lower, "token") || strings.Contains(lower, "secret") || strings.Contains(lower, "password") {
+			return Hash(value)
+		}
+		return value
+	}
+}
+
+// redactCredentials hashes credentials, keeping the scheme to tell bearer
+// tokens from basic credentials
+func redactCredentials(value string) string {
+	if scheme, credentials, found := strings.Cut(value, " "); found {
+		return scheme + " " + Hash(credentials)
+	}
+	return Hash(value)
+}
+
+// redactCookies hashes the values of a Cookie header, keeping the names
+func redactCookies(value string) string {
+	parts := strings.Split(value, ";")
+	for i, part := range parts {
+		name, cookieValue, found := strings.Cut(strings.TrimSpace(part), "=")
+		if found {
+			parts[i] = name + "=" + Hash(cookieValue)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// redactURL hashes sensitive query parameters, e.g. the code and state of an
+// authorization response
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+	u.RawQuery = redactQuery(u.Query()).Encode()
+	return u.String()
+}
+
+func redactQuery(query url.Values) url.Values {
+	for name, values := range query {
+		if sensitiveParams[strings.ToLower(name)] {
+			for i, value := range values {
+				values[i] = Hash(value)
+			}
+		}
+	}
+	return query
+}
@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CachedToken is a CLI token stored for a proxy URL
+type CachedToken struct {
+	URL       string    `json:"url"`
+	Token     string    `json:"token"`
+	Username  string    `json:"username,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired reports whether the token is known to have expired
+func (t *CachedToken) Expired() bool {
+	return !t.ExpiresAt.IsZero() && time.Now().After(t.ExpiresAt)
+}
+
+// TokenCache stores CLI tokens per proxy URL in a file only the user can read
+type TokenCache struct {
+	Path string
+}
+
+// DefaultCachePath returns the token cache location in the user's config directory
+func DefaultCachePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find user config directory: %w", err)
+	}
+	return filepath.Join(dir, "console-auth-proxy", "tokens.json"), nil
+}
+
+func (c *TokenCache) load() (map[string]*CachedToken, error) {
+	tokens := make(map[string]*CachedToken)
+
+	data, err := os.ReadFile(c.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token cache: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse token cache %s: %w", c.Path, err)
+	}
+	return tokens, nil
+}
+
+// Get returns the cached token for a proxy URL
+func (c *TokenCache) Get(proxyURL string) (*CachedToken, error) {
+	baseURL, err := normalizeURL(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := c.load()
+	if err != nil {
+		return nil, err
+	}
+
+	token, ok := tokens[baseURL]
+	if !ok {
+		return nil, fmt.Errorf("no token cached for %s, run: console-auth-proxy login --url %s", baseURL, baseURL)
+	}
+	if token.Expired() {
+		return nil, fmt.Errorf("the token for %s expired, run: console-auth-proxy login --url %s", baseURL, baseURL)
+	}
+	return token, nil
+}
+
+// Put stores a token, replacing any previous token for the same URL
+func (c *TokenCache) Put(token *CachedToken) error {
+	tokens, err := c.load()
+	if err != nil {
+		return err
+	}
+
+	// Drop expired tokens while we're at it
+	for url, cached := range tokens {
+		if cached.Expired() {
+			delete(tokens, url)
+		}
+	}
+	tokens[token.URL] = token
+
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0700); err != nil {
+		return fmt.Errorf("failed to create token cache directory: %w", err)
+	}
+
+	// Write to a temporary file first so a crash can't leave a truncated cache
+	tmp := c.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write token cache: %w", err)
+	}
+	return os.Rename(tmp, c.Path)
+}
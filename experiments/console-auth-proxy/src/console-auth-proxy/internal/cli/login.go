@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/your-org/console-auth-proxy/pkg/auth/clitoken"
+)
+
+// LoginOptions configures a CLI login against a console-auth-proxy
+type LoginOptions struct {
+	// URL is the public base URL of the proxy
+	URL string
+	// Token skips the device flow and stores a token copied from /auth/token
+	Token string
+	// InsecureSkipTLSVerify disables certificate verification of the proxy
+	InsecureSkipTLSVerify bool
+	// Out receives the instructions for the user
+	Out io.Writer
+	// HTTPClient overrides the client used to talk to the proxy
+	HTTPClient *http.Client
+}
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Login obtains a CLI token through the device authorization grant, or checks
+// the given token, and returns it ready to be cached
+func Login(ctx context.Context, opts *LoginOptions) (*CachedToken, error) {
+	baseURL, err := normalizeURL(opts.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	client := opts.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+		if opts.InsecureSkipTLSVerify {
+			client.Transport = &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			}
+		}
+	}
+
+	token := &CachedToken{URL: baseURL, Token: opts.Token}
+	if token.Token == "" {
+		if err := deviceLogin(ctx, client, baseURL, opts.Out, token); err != nil {
+			return nil, err
+		}
+	}
+
+	username, err := whoami(ctx, client, baseURL, token.Token)
+	if err != nil {
+		return nil, err
+	}
+	token.Username = username
+
+	return token, nil
+}
+
+// deviceLogin runs the device authorization grant (RFC 8628)
+func deviceLogin(ctx context.Context, client *http.Client, baseURL string, out io.Writer, token *CachedToken) error {
+	var device deviceAuthorizationResponse
+	if err := postForm(ctx, client, baseURL+"/auth/device/code", url.Values{}, &device); err != nil {
+		return fmt.Errorf("failed to start device login: %w", err)
+	}
+	if device.DeviceCode == "" {
+		return fmt.Errorf("failed to start device login: no device code in response")
+	}
+
+	fmt.Fprintf(out, "To log in, open %s\nand confirm the code %s\n\nWaiting for approval...\n",
+		device.VerificationURIComplete, device.UserCode)
+
+	interval := time.Duration(device.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(device.ExpiresIn) * time.Second)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+
+		var resp tokenResponse
+		err := postForm(ctx, client, baseURL+"/auth/device/token", url.Values{
+			"grant_type":  {clitoken.DeviceCodeGrantType},
+			"device_code": {device.DeviceCode},
+		}, &resp)
+		if err != nil && resp.Error == "" {
+			return fmt.Errorf("failed to poll for token: %w", err)
+		}
+
+		switch resp.Error {
+		case "":
+			token.Token = resp.AccessToken
+			token.ExpiresAt = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+			return nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied":
+			return fmt.Errorf("login was denied")
+		case "expired_token":
+			return fmt.Errorf("the code expired before it was approved, run login again")
+		default:
+			return fmt.Errorf("login failed: %s %s", resp.Error, resp.ErrorDescription)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the code expired before it was approved, run login again")
+		}
+	}
+}
+
+// whoami checks the token against /auth/info and returns the username
+func whoami(ctx context.Context, client *http.Client, baseURL, token string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/auth/info", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to verify token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token was rejected by %s (status %d)", baseURL, resp.StatusCode)
+	}
+
+	var info struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", fmt.Errorf("failed to decode user info: %w", err)
+	}
+	return info.Username, nil
+}
+
+// postForm posts a form and decodes the JSON response. OAuth errors are
+// decoded into result as well, the returned error then carries the status.
+func postForm(ctx context.Context, client *http.Client, endpoint string, form url.Values, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("unexpected response from %s (status %d): %w", endpoint, resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", endpoint, resp.StatusCode)
+	}
+	return nil
+}
+
+// normalizeURL validates the proxy URL and strips trailing slashes
+func normalizeURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid proxy URL %q, expected http(s)://host", raw)
+	}
+	return strings.TrimRight(u.Scheme+"://"+u.Host+u.Path, "/"), nil
+}
@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+	"github.com/your-org/console-auth-proxy/pkg/auth/clitoken"
+)
+
+// noSession rejects every browser session, only CLI tokens are accepted
+type noSession struct {
+	auth.Authenticator
+}
+
+func (noSession) Authenticate(w http.ResponseWriter, r *http.Request) (*auth.User, error) {
+	return nil, errors.New("no session")
+}
+
+// syncBuffer collects the login instructions written while polling
+type syncBuffer struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	return len(p), nil
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
+
+func newTestServer(t *testing.T) (*httptest.Server, *clitoken.Store) {
+	store := clitoken.NewStore(time.Hour, time.Minute, time.Second, 10)
+	authenticator := clitoken.NewAuthenticator(store, noSession{})
+
+	mux := http.NewServeMux()
+	clitoken.NewHandler(store, noSession{}, false, "").Register(mux)
+	mux.HandleFunc("/auth/info", func(w http.ResponseWriter, r *http.Request) {
+		user, err := authenticator.Authenticate(w, r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"username": user.Username})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, store
+}
+
+func TestDeviceLogin(t *testing.T) {
+	server, store := newTestServer(t)
+	out := &syncBuffer{}
+
+	// Approve the code once the instructions are printed
+	go func() {
+		userCode := regexp.MustCompile(`[A-Z]{4}-[A-Z]{4}`)
+		for i := 0; i < 100; i++ {
+			if code := userCode.FindString(out.String()); code != "" {
+				store.Approve(code, &auth.User{Username: "alice"}, true)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	token, err := Login(ctx, &LoginOptions{URL: server.URL + "/", Out: out})
+	require.NoError(t, err)
+	assert.Equal(t, server.URL, token.URL)
+	assert.Equal(t, "alice", token.Username)
+	assert.Contains(t, out.String(), server.URL+"/auth/device?user_code=")
+	assert.False(t, token.Expired())
+}
+
+func TestTokenLogin(t *testing.T) {
+	server, store := newTestServer(t)
+	issued, _ := store.IssueToken(&auth.User{Username: "bob"})
+
+	token, err := Login(context.Background(), &LoginOptions{URL: server.URL, Token: issued})
+	require.NoError(t, err)
+	assert.Equal(t, "bob", token.Username)
+
+	_, err = Login(context.Background(), &LoginOptions{URL: server.URL, Token: clitoken.TokenPrefix + "unknown"})
+	assert.Error(t, err)
+
+	_, err = Login(context.Background(), &LoginOptions{URL: "proxy.example.com"})
+	assert.Error(t, err)
+}
+
+func TestTokenCache(t *testing.T) {
+	cache := &TokenCache{Path: filepath.Join(t.TempDir(), "console-auth-proxy", "tokens.json")}
+
+	_, err := cache.Get("https://proxy.example.com")
+	assert.Error(t, err)
+
+	require.NoError(t, cache.Put(&CachedToken{URL: "https://proxy.example.com", Token: "cap_a"}))
+	require.NoError(t, cache.Put(&CachedToken{URL: "https://other.example.com", Token: "cap_b", ExpiresAt: time.Now().Add(-time.Minute)}))
+
+	token, err := cache.Get("https://proxy.example.com/")
+	require.NoError(t, err)
+	assert.Equal(t, "cap_a", token.Token)
+
+	_, err = cache.Get("https://other.example.com")
+	assert.Error(t, err, "expired tokens are not returned")
+
+	info, err := os.Stat(cache.Path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
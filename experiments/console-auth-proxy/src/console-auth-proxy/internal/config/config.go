@@ -1,10 +1,17 @@
 package config
 
 import (
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
 	"time"
 
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/your-org/console-auth-proxy/internal/secrets"
+	"github.com/your-org/console-auth-proxy/pkg/tlsprofile"
 )
 
 // Config represents the complete configuration for the console auth proxy
@@ -12,7 +19,12 @@ type Config struct {
 	Server        ServerConfig        `mapstructure:"server" yaml:"server"`
 	Auth          AuthConfig          `mapstructure:"auth" yaml:"auth"`
 	Proxy         ProxyConfig         `mapstructure:"proxy" yaml:"proxy"`
+	Policy        PolicyConfig        `mapstructure:"policy" yaml:"policy"`
 	Observability ObservabilityConfig `mapstructure:"observability" yaml:"observability"`
+
+	// TLS profile for the listener and the backend and auth provider connections:
+	// "default", "fips", "pq-hybrid" or "modern"
+	TLSProfile string `mapstructure:"tls_profile" yaml:"tls_profile"`
 }
 
 // ServerConfig contains HTTP server configuration
@@ -23,6 +35,10 @@ type ServerConfig struct {
 	IdleTimeout     time.Duration `mapstructure:"idle_timeout" yaml:"idle_timeout"`
 	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout" yaml:"shutdown_timeout"`
 	TLS             TLSConfig     `mapstructure:"tls" yaml:"tls"`
+
+	// Accept cleartext HTTP/2 (h2c), e.g. from gRPC clients inside the cluster.
+	// HTTP/2 is always offered over TLS.
+	H2C bool `mapstructure:"h2c" yaml:"h2c"`
 }
 
 // TLSConfig contains TLS configuration
@@ -35,13 +51,14 @@ type TLSConfig struct {
 // AuthConfig maps directly to the console auth.Config structure
 // This preserves exact compatibility with the console auth module
 type AuthConfig struct {
-	AuthSource             string   `mapstructure:"auth_source" yaml:"auth_source"`                         // "openshift" or "oidc"
+	AuthSource             string   `mapstructure:"auth_source" yaml:"auth_source"`                         // "openshift", "oidc", "mtls" or "local"
 	IssuerURL              string   `mapstructure:"issuer_url" yaml:"issuer_url"`
 	LogoutRedirectOverride string   `mapstructure:"logout_redirect_override" yaml:"logout_redirect_override"`
 	IssuerCA               string   `mapstructure:"issuer_ca" yaml:"issuer_ca"`
 	RedirectURL            string   `mapstructure:"redirect_url" yaml:"redirect_url"`
 	ClientID               string   `mapstructure:"client_id" yaml:"client_id"`
-	ClientSecret           string   `mapstructure:"client_secret" yaml:"client_secret"`
+	ClientSecret           string   `mapstructure:"client_secret" yaml:"client_secret" secret:"true"`
+	ClientSecretFile       string   `mapstructure:"client_secret_file" yaml:"client_secret_file"`
 	Scope                  []string `mapstructure:"scope" yaml:"scope"`
 	K8sCA                  string   `mapstructure:"k8s_ca" yaml:"k8s_ca"`
 	SuccessURL             string   `mapstructure:"success_url" yaml:"success_url"`
@@ -51,14 +68,86 @@ type AuthConfig struct {
 	OCLoginCommand         string   `mapstructure:"oc_login_command" yaml:"oc_login_command"`
 
 	// Cookie encryption keys (base64 encoded)
-	CookieAuthenticationKey string `mapstructure:"cookie_authentication_key" yaml:"cookie_authentication_key"`
-	CookieEncryptionKey     string `mapstructure:"cookie_encryption_key" yaml:"cookie_encryption_key"`
+	CookieAuthenticationKey     string `mapstructure:"cookie_authentication_key" yaml:"cookie_authentication_key" secret:"true"`
+	CookieAuthenticationKeyFile string `mapstructure:"cookie_authentication_key_file" yaml:"cookie_authentication_key_file"`
+	CookieEncryptionKey         string `mapstructure:"cookie_encryption_key" yaml:"cookie_encryption_key" secret:"true"`
+	CookieEncryptionKeyFile     string `mapstructure:"cookie_encryption_key_file" yaml:"cookie_encryption_key_file"`
 
 	// TLS configuration for auth provider connections
 	TLS AuthTLSConfig `mapstructure:"tls" yaml:"tls"`
 
 	// Kubernetes configuration for token validation
 	KubeConfig KubeConfig `mapstructure:"kube_config" yaml:"kube_config"`
+
+	// Groups allowed through the proxy; any authenticated user if empty
+	AllowedGroups []string `mapstructure:"allowed_groups" yaml:"allowed_groups"`
+
+	// Step-up authentication rules, evaluated in order (first matching path prefix wins)
+	StepUp []StepUpRule `mapstructure:"step_up" yaml:"step_up"`
+
+	// Client certificate authentication, alone (auth_source "mtls") or combined with openshift/oidc
+	MTLS MTLSConfig `mapstructure:"mtls" yaml:"mtls"`
+
+	// Local users from an htpasswd file (auth_source "local"), for development clusters
+	Local LocalAuthConfig `mapstructure:"local" yaml:"local"`
+
+	// CLI tokens from /auth/token and the device authorization grant
+	CLI CLIConfig `mapstructure:"cli" yaml:"cli"`
+}
+
+// CLIConfig contains CLI token configuration
+type CLIConfig struct {
+	Disabled      bool          `mapstructure:"disabled" yaml:"disabled"`
+	TokenTTL      time.Duration `mapstructure:"token_ttl" yaml:"token_ttl"`
+	DeviceCodeTTL time.Duration `mapstructure:"device_code_ttl" yaml:"device_code_ttl"`
+	PollInterval  time.Duration `mapstructure:"poll_interval" yaml:"poll_interval"`
+	// Public URL of the proxy in device verification URIs, defaults to the origin of redirect_url
+	PublicURL string `mapstructure:"public_url" yaml:"public_url"`
+	// Device logins waiting for approval at a time, further requests are refused
+	MaxPendingDevices int `mapstructure:"max_pending_devices" yaml:"max_pending_devices"`
+	// Limit for starting device logins per client IP
+	DeviceRateLimit RateLimit `mapstructure:"device_rate_limit" yaml:"device_rate_limit"`
+}
+
+// LocalAuthConfig contains htpasswd-based local users configuration
+type LocalAuthConfig struct {
+	HtpasswdFile   string        `mapstructure:"htpasswd_file" yaml:"htpasswd_file"`
+	GroupsFile     string        `mapstructure:"groups_file" yaml:"groups_file"` // YAML map of username to groups
+	ReloadInterval time.Duration `mapstructure:"reload_interval" yaml:"reload_interval"`
+	SessionTTL     time.Duration `mapstructure:"session_ttl" yaml:"session_ttl"`
+}
+
+// MTLSConfig contains client certificate authentication configuration
+type MTLSConfig struct {
+	Enabled            bool              `mapstructure:"enabled" yaml:"enabled"` // combined mode with an OAuth source; implied by auth_source "mtls"
+	CAFile             string            `mapstructure:"ca_file" yaml:"ca_file"`
+	CRLFiles           []string          `mapstructure:"crl_files" yaml:"crl_files"`
+	CRLRefreshInterval time.Duration     `mapstructure:"crl_refresh_interval" yaml:"crl_refresh_interval"`
+	UserMapping        []CertMappingRule `mapstructure:"user_mapping" yaml:"user_mapping"`
+	ForwardHeader      string            `mapstructure:"forward_header" yaml:"forward_header"` // XFCC-style header with the verified certificate details
+}
+
+// CertMappingRule maps a certificate field to a user. username and id are
+// regexp templates expanded against match (e.g. "system:serviceaccount:$1:$2").
+type CertMappingRule struct {
+	Field    string `mapstructure:"field" yaml:"field"` // subject.cn, subject.dn, subject.o, subject.ou, san.dns, san.email, san.uri
+	Match    string `mapstructure:"match" yaml:"match"`
+	Username string `mapstructure:"username" yaml:"username"`
+	ID       string `mapstructure:"id" yaml:"id"`
+}
+
+// MTLSEnabled reports whether client certificate authentication is active
+func (a *AuthConfig) MTLSEnabled() bool {
+	return a.MTLS.Enabled || strings.ToLower(a.AuthSource) == "mtls"
+}
+
+// StepUpRule requires a stronger authentication context for a path prefix.
+// acr/amr/auth_time come from the OIDC ID token, so step-up needs auth_source "oidc".
+type StepUpRule struct {
+	PathPrefix  string        `mapstructure:"path_prefix" yaml:"path_prefix"`
+	RequiredACR []string      `mapstructure:"required_acr" yaml:"required_acr"` // any one of these acr values
+	RequiredAMR []string      `mapstructure:"required_amr" yaml:"required_amr"` // all of these amr values
+	MaxAge      time.Duration `mapstructure:"max_age" yaml:"max_age"`           // max time since the user last authenticated
 }
 
 // KubeConfig contains Kubernetes client configuration
@@ -66,17 +155,53 @@ type KubeConfig struct {
 	InCluster      bool   `mapstructure:"in_cluster" yaml:"in_cluster"`
 	ConfigPath     string `mapstructure:"config_path" yaml:"config_path"`
 	ServerURL      string `mapstructure:"server_url" yaml:"server_url"`
-	BearerToken    string `mapstructure:"bearer_token" yaml:"bearer_token"`
+	BearerToken    string `mapstructure:"bearer_token" yaml:"bearer_token" secret:"true"`
 	BearerTokenFile string `mapstructure:"bearer_token_file" yaml:"bearer_token_file"`
 	CAFile         string `mapstructure:"ca_file" yaml:"ca_file"`
 }
 
 // ProxyConfig contains reverse proxy configuration
 type ProxyConfig struct {
-	Backend  BackendConfig  `mapstructure:"backend" yaml:"backend"`
-	Headers  HeaderConfig   `mapstructure:"headers" yaml:"headers"`
-	Timeouts TimeoutConfig  `mapstructure:"timeouts" yaml:"timeouts"`
-	TLS      ProxyTLSConfig `mapstructure:"tls" yaml:"tls"`
+	Backend   BackendConfig   `mapstructure:"backend" yaml:"backend"`
+	Headers   HeaderConfig    `mapstructure:"headers" yaml:"headers"`
+	Timeouts  TimeoutConfig   `mapstructure:"timeouts" yaml:"timeouts"`
+	TLS       ProxyTLSConfig  `mapstructure:"tls" yaml:"tls"`
+	Rewrite   RewriteConfig   `mapstructure:"rewrite" yaml:"rewrite"`
+	RateLimit RateLimitConfig `mapstructure:"rate_limit" yaml:"rate_limit"`
+
+	GRPC      GRPCConfig      `mapstructure:"grpc" yaml:"grpc"`
+
+	// Access rules, evaluated in order (first match wins, authenticated if none match)
+	Rules []AccessRule `mapstructure:"rules" yaml:"rules"`
+
+	// Path prefixes proxied without authentication, e.g. "/static/".
+	// Shorthand for public rules evaluated after rules.
+	PublicPaths []string `mapstructure:"public_paths" yaml:"public_paths"`
+}
+
+// GRPCConfig controls handling of gRPC and gRPC-Web requests
+type GRPCConfig struct {
+	// Requests with these content types skip the CSRF token check. Browsers
+	// can't send them cross-origin without a CORS preflight. A type also
+	// matches its +suffix variants, e.g. application/grpc+proto.
+	CSRFExemptContentTypes []string `mapstructure:"csrf_exempt_content_types" yaml:"csrf_exempt_content_types"`
+	CSRFExemptionDisabled  bool     `mapstructure:"csrf_exemption_disabled" yaml:"csrf_exemption_disabled"`
+}
+
+// Access rule actions
+const (
+	AccessPublic        = "public"
+	AccessAuthenticated = "authenticated"
+	AccessDeny          = "deny"
+)
+
+// AccessRule decides how proxied requests for matching paths are handled.
+// Path is a glob where * matches within a path segment and ** across segments.
+type AccessRule struct {
+	Path    string   `mapstructure:"path" yaml:"path"`       // glob, e.g. "/static/**"
+	Regex   string   `mapstructure:"regex" yaml:"regex"`     // regular expression matched against the whole path
+	Methods []string `mapstructure:"methods" yaml:"methods"` // any method if empty
+	Action  string   `mapstructure:"action" yaml:"action"`   // public, authenticated or deny
 }
 
 // BackendConfig defines the backend service to proxy to
@@ -84,6 +209,15 @@ type BackendConfig struct {
 	URL               string `mapstructure:"url" yaml:"url"`
 	HealthCheckPath   string `mapstructure:"health_check_path" yaml:"health_check_path"`
 	HealthCheckInterval time.Duration `mapstructure:"health_check_interval" yaml:"health_check_interval"`
+
+	// Endpoints (scheme://host[:port]) to balance across. The path of URL is
+	// appended to each of them. Defaults to URL itself.
+	Endpoints     []string `mapstructure:"endpoints" yaml:"endpoints"`
+	LoadBalancing string   `mapstructure:"load_balancing" yaml:"load_balancing"` // "round_robin" or "least_connections"
+
+	// Protocol spoken to the backend: "http1" (default), "h2" (HTTP/2 over TLS)
+	// or "h2c" (cleartext HTTP/2 with prior knowledge). gRPC backends need h2 or h2c.
+	Protocol string `mapstructure:"protocol" yaml:"protocol"`
 }
 
 // HeaderConfig defines header manipulation for proxied requests
@@ -92,6 +226,7 @@ type HeaderConfig struct {
 	UserHeader   string `mapstructure:"user_header" yaml:"user_header"`
 	UserIDHeader string `mapstructure:"user_id_header" yaml:"user_id_header"`
 	EmailHeader  string `mapstructure:"email_header" yaml:"email_header"`
+	GroupsHeader string `mapstructure:"groups_header" yaml:"groups_header"`
 	
 	// Authorization header handling
 	AuthHeader      string `mapstructure:"auth_header" yaml:"auth_header"`
@@ -113,6 +248,91 @@ type TimeoutConfig struct {
 	IdleConn          time.Duration `mapstructure:"idle_conn" yaml:"idle_conn"`
 	MaxIdleConns      int           `mapstructure:"max_idle_conns" yaml:"max_idle_conns"`
 	MaxIdleConnsPerHost int         `mapstructure:"max_idle_conns_per_host" yaml:"max_idle_conns_per_host"`
+
+	// Timeout for a single attempt, including retries (0 = no per-attempt limit)
+	PerTry time.Duration `mapstructure:"per_try" yaml:"per_try"`
+
+	Retry            RetryConfig            `mapstructure:"retry" yaml:"retry"`
+	OutlierDetection OutlierDetectionConfig `mapstructure:"outlier_detection" yaml:"outlier_detection"`
+	CircuitBreaker   CircuitBreakerConfig   `mapstructure:"circuit_breaker" yaml:"circuit_breaker"`
+}
+
+// RetryConfig defines retries of idempotent requests against other endpoints
+type RetryConfig struct {
+	MaxRetries int           `mapstructure:"max_retries" yaml:"max_retries"` // 0 disables retries
+	RetryOn    []int         `mapstructure:"retry_on" yaml:"retry_on"`       // Status codes to retry, connect errors are always retried
+	Backoff    time.Duration `mapstructure:"backoff" yaml:"backoff"`
+
+	// Retry budget: each request earns BudgetRatio retries, with a floor of
+	// MinRetriesPerSecond so low-traffic backends can still retry
+	BudgetRatio         float64 `mapstructure:"budget_ratio" yaml:"budget_ratio"`
+	MinRetriesPerSecond int     `mapstructure:"min_retries_per_second" yaml:"min_retries_per_second"`
+}
+
+// OutlierDetectionConfig defines passive ejection of failing endpoints
+type OutlierDetectionConfig struct {
+	ConsecutiveFailures int           `mapstructure:"consecutive_failures" yaml:"consecutive_failures"` // 5xx or connect errors, 0 disables
+	BaseEjectionTime    time.Duration `mapstructure:"base_ejection_time" yaml:"base_ejection_time"`     // Multiplied by the number of ejections
+	MaxEjectionTime     time.Duration `mapstructure:"max_ejection_time" yaml:"max_ejection_time"`
+	MaxEjectionPercent  int           `mapstructure:"max_ejection_percent" yaml:"max_ejection_percent"`
+}
+
+// CircuitBreakerConfig defines when the proxy stops sending requests to the backend
+type CircuitBreakerConfig struct {
+	ConsecutiveFailures int           `mapstructure:"consecutive_failures" yaml:"consecutive_failures"` // 0 disables the breaker
+	OpenDuration        time.Duration `mapstructure:"open_duration" yaml:"open_duration"`
+	MaxRequests         int           `mapstructure:"max_requests" yaml:"max_requests"` // Concurrent request limit, 0 = unlimited
+}
+
+// RateLimitConfig defines token-bucket rate limits for auth endpoints and
+// proxied requests. Buckets are kept in memory, and shared between replicas
+// when Peers is configured.
+type RateLimitConfig struct {
+	// Limit for /auth/ endpoints per client IP
+	Auth RateLimit `mapstructure:"auth" yaml:"auth"`
+	// Limit for proxied requests per authenticated user and route
+	Proxy RateLimit `mapstructure:"proxy" yaml:"proxy"`
+	// Path prefixes with their own bucket and limit, longest prefix wins.
+	// Requests matching no route share a bucket per user.
+	Routes []RouteRateLimit `mapstructure:"routes" yaml:"routes"`
+	// Proxies (CIDRs) whose X-Forwarded-For header is trusted for the client IP
+	TrustedProxies []string `mapstructure:"trusted_proxies" yaml:"trusted_proxies"`
+	// Replicas sharing the buckets, unset keeps them per replica
+	Peers RateLimitPeersConfig `mapstructure:"peers" yaml:"peers"`
+}
+
+// RateLimitPeersConfig shares rate limit buckets between replicas. Each bucket
+// is owned by one replica, found through a headless Service, and the others
+// ask it for tokens.
+type RateLimitPeersConfig struct {
+	Service         string        `mapstructure:"service" yaml:"service"` // Headless Service resolving to every replica
+	Port            int           `mapstructure:"port" yaml:"port"`       // Port replicas serve bucket requests on
+	Secret          string        `mapstructure:"secret" yaml:"secret" secret:"true"`
+	SecretFile      string        `mapstructure:"secret_file" yaml:"secret_file"`
+	RefreshInterval time.Duration `mapstructure:"refresh_interval" yaml:"refresh_interval"` // How often the Service is resolved
+	Timeout         time.Duration `mapstructure:"timeout" yaml:"timeout"`                   // Owners slower than this are skipped
+}
+
+// Enabled reports whether buckets are shared between replicas
+func (p RateLimitPeersConfig) Enabled() bool {
+	return p.Service != ""
+}
+
+// RateLimit is a token bucket refilled at RequestsPerSecond up to Burst
+type RateLimit struct {
+	RequestsPerSecond float64 `mapstructure:"requests_per_second" yaml:"requests_per_second"` // 0 disables the limit
+	Burst             int     `mapstructure:"burst" yaml:"burst"`                             // Defaults to RequestsPerSecond rounded up
+}
+
+// Enabled reports whether the limit is configured
+func (l RateLimit) Enabled() bool {
+	return l.RequestsPerSecond > 0
+}
+
+// RouteRateLimit is a rate limit for proxied requests under a path prefix
+type RouteRateLimit struct {
+	PathPrefix string `mapstructure:"path_prefix" yaml:"path_prefix"`
+	RateLimit  `mapstructure:",squash" yaml:",inline"`
 }
 
 // ProxyTLSConfig contains TLS settings for backend connections
@@ -124,17 +344,107 @@ type ProxyTLSConfig struct {
 	KeyFile            string `mapstructure:"key_file" yaml:"key_file"`
 }
 
+// RewriteConfig defines request and response rewriting for backends that are
+// mounted under a path prefix but generate URLs as if they were served from /
+type RewriteConfig struct {
+	// Public path prefix the backend is mounted under, e.g. "/grafana"
+	PathPrefix string `mapstructure:"path_prefix" yaml:"path_prefix"`
+	// Remove PathPrefix from the request path before forwarding to the backend
+	StripPrefix bool `mapstructure:"strip_prefix" yaml:"strip_prefix"`
+
+	// Location header rewriting for redirects issued by the backend
+	Location LocationRewriteConfig `mapstructure:"location" yaml:"location"`
+
+	// Set-Cookie rewriting
+	Cookies CookieRewriteConfig `mapstructure:"cookies" yaml:"cookies"`
+
+	// Response body rewriting for apps that can't be configured with a base URL
+	Body BodyRewriteConfig `mapstructure:"body" yaml:"body"`
+}
+
+// LocationRewriteConfig defines how Location headers from the backend are rewritten
+type LocationRewriteConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// Additional hosts (host[:port]) that should be treated as the backend, e.g. "localhost:5000"
+	InternalHosts []string `mapstructure:"internal_hosts" yaml:"internal_hosts"`
+	// Public URL to use for absolute redirects, e.g. "https://apps.example.com". Relative redirects are used if empty
+	PublicURL string `mapstructure:"public_url" yaml:"public_url"`
+}
+
+// CookieRewriteConfig defines how Set-Cookie headers from the backend are rewritten
+type CookieRewriteConfig struct {
+	// Scope cookie paths under PathPrefix
+	RewritePath bool `mapstructure:"rewrite_path" yaml:"rewrite_path"`
+	// Replace the cookie Domain attribute. Use "-" to drop it so cookies are host-only
+	Domain string `mapstructure:"domain" yaml:"domain"`
+}
+
+// BodyRewriteConfig defines HTML/JS body rewriting
+type BodyRewriteConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// Inject a <base href> element into HTML documents
+	InjectBaseHref bool `mapstructure:"inject_base_href" yaml:"inject_base_href"`
+	// Content types to rewrite (default: text/html)
+	ContentTypes []string `mapstructure:"content_types" yaml:"content_types"`
+	// Root-relative path prefixes rewritten inside JavaScript string literals, e.g. "/static/"
+	JSPathRoots []string `mapstructure:"js_path_roots" yaml:"js_path_roots"`
+	// Maximum response body size to buffer for rewriting; larger bodies are passed through
+	MaxBodySize int64 `mapstructure:"max_body_size" yaml:"max_body_size"`
+}
+
 // AuthTLSConfig contains TLS settings for auth provider connections
 type AuthTLSConfig struct {
 	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify" yaml:"insecure_skip_verify"`
 	ServerName         string `mapstructure:"server_name" yaml:"server_name"` // Override SNI server name
 }
 
+// PolicyConfig contains CORS and security response header policies
+type PolicyConfig struct {
+	// CORS rules, evaluated in order; the first rule whose path prefix matches applies
+	CORS            []CORSRule            `mapstructure:"cors" yaml:"cors"`
+	SecurityHeaders SecurityHeadersConfig `mapstructure:"security_headers" yaml:"security_headers"`
+}
+
+// CORSRule defines the cross-origin policy for requests under a path prefix
+type CORSRule struct {
+	PathPrefix string `mapstructure:"path_prefix" yaml:"path_prefix"`
+	// Allowed origins: exact ("https://dash.example.com"), wildcard subdomain ("https://*.example.com") or "*"
+	AllowedOrigins   []string      `mapstructure:"allowed_origins" yaml:"allowed_origins"`
+	AllowedMethods   []string      `mapstructure:"allowed_methods" yaml:"allowed_methods"`
+	AllowedHeaders   []string      `mapstructure:"allowed_headers" yaml:"allowed_headers"`
+	ExposedHeaders   []string      `mapstructure:"exposed_headers" yaml:"exposed_headers"`
+	AllowCredentials bool          `mapstructure:"allow_credentials" yaml:"allow_credentials"`
+	MaxAge           time.Duration `mapstructure:"max_age" yaml:"max_age"` // Preflight cache duration
+}
+
+// SecurityHeadersConfig defines security headers added to every response
+type SecurityHeadersConfig struct {
+	Disabled bool `mapstructure:"disabled" yaml:"disabled"`
+	// Replace values set by the backend instead of only filling in missing headers
+	Override bool `mapstructure:"override" yaml:"override"`
+
+	HSTS                  HSTSConfig        `mapstructure:"hsts" yaml:"hsts"`
+	ContentSecurityPolicy string            `mapstructure:"content_security_policy" yaml:"content_security_policy"`
+	FrameOptions          string            `mapstructure:"frame_options" yaml:"frame_options"`               // DENY or SAMEORIGIN
+	ReferrerPolicy        string            `mapstructure:"referrer_policy" yaml:"referrer_policy"`
+	ContentTypeOptions    string            `mapstructure:"content_type_options" yaml:"content_type_options"` // nosniff
+	Custom                map[string]string `mapstructure:"custom" yaml:"custom"`
+}
+
+// HSTSConfig defines the Strict-Transport-Security header, sent on HTTPS responses only
+type HSTSConfig struct {
+	Disabled          bool          `mapstructure:"disabled" yaml:"disabled"`
+	MaxAge            time.Duration `mapstructure:"max_age" yaml:"max_age"`
+	IncludeSubDomains bool          `mapstructure:"include_subdomains" yaml:"include_subdomains"`
+	Preload           bool          `mapstructure:"preload" yaml:"preload"`
+}
+
 // ObservabilityConfig contains monitoring and logging configuration
 type ObservabilityConfig struct {
 	Metrics MetricsConfig `mapstructure:"metrics" yaml:"metrics"`
 	Logging LoggingConfig `mapstructure:"logging" yaml:"logging"`
 	Health  HealthConfig  `mapstructure:"health" yaml:"health"`
+	Capture CaptureConfig `mapstructure:"capture" yaml:"capture"`
 }
 
 // MetricsConfig defines Prometheus metrics configuration
@@ -158,8 +468,23 @@ type HealthConfig struct {
 	ReadinessPath string `mapstructure:"readiness_path" yaml:"readiness_path"`
 }
 
+// CaptureConfig records request/response pairs for debugging, with tokens,
+// cookies and Authorization values redacted to hashes
+type CaptureConfig struct {
+	Enabled       bool          `mapstructure:"enabled" yaml:"enabled"`
+	MaxEntries    int           `mapstructure:"max_entries" yaml:"max_entries"`       // size of the ring buffer
+	File          string        `mapstructure:"file" yaml:"file"`                     // HAR file rewritten from the ring buffer, optional
+	FlushInterval time.Duration `mapstructure:"flush_interval" yaml:"flush_interval"` // how often the HAR file is rewritten
+	AdminUsers    []string      `mapstructure:"admin_users" yaml:"admin_users"`       // users allowed to download the capture
+	AdminGroups   []string      `mapstructure:"admin_groups" yaml:"admin_groups"`     // groups allowed to download the capture
+}
+
 // SetDefaults sets default values for the configuration
 func (c *Config) SetDefaults() {
+	if c.TLSProfile == "" {
+		c.TLSProfile = tlsprofile.Default
+	}
+
 	// Server defaults
 	if c.Server.ListenAddress == "" {
 		c.Server.ListenAddress = "0.0.0.0:8080"
@@ -193,6 +518,38 @@ func (c *Config) SetDefaults() {
 	if len(c.Auth.Scope) == 0 {
 		c.Auth.Scope = []string{"openid", "profile", "email"}
 	}
+	if c.Auth.MTLS.CRLRefreshInterval == 0 {
+		c.Auth.MTLS.CRLRefreshInterval = 5 * time.Minute
+	}
+	if c.Auth.MTLS.ForwardHeader == "" {
+		c.Auth.MTLS.ForwardHeader = "X-Forwarded-Client-Cert"
+	}
+	if c.Auth.Local.ReloadInterval == 0 {
+		c.Auth.Local.ReloadInterval = 5 * time.Second
+	}
+	if c.Auth.Local.SessionTTL == 0 {
+		c.Auth.Local.SessionTTL = 12 * time.Hour
+	}
+	if c.Auth.CLI.TokenTTL == 0 {
+		c.Auth.CLI.TokenTTL = 24 * time.Hour
+	}
+	if c.Auth.CLI.DeviceCodeTTL == 0 {
+		c.Auth.CLI.DeviceCodeTTL = 10 * time.Minute
+	}
+	if c.Auth.CLI.PollInterval == 0 {
+		c.Auth.CLI.PollInterval = 5 * time.Second
+	}
+	if c.Auth.CLI.PublicURL == "" && c.Auth.RedirectURL != "" {
+		if redirectURL, err := url.Parse(c.Auth.RedirectURL); err == nil && redirectURL.Host != "" {
+			c.Auth.CLI.PublicURL = redirectURL.Scheme + "://" + redirectURL.Host
+		}
+	}
+	if c.Auth.CLI.MaxPendingDevices == 0 {
+		c.Auth.CLI.MaxPendingDevices = 1000
+	}
+	if !c.Auth.CLI.DeviceRateLimit.Enabled() {
+		c.Auth.CLI.DeviceRateLimit = RateLimit{RequestsPerSecond: 0.2, Burst: 5}
+	}
 
 	// Proxy defaults
 	if c.Proxy.Headers.UserHeader == "" {
@@ -204,6 +561,9 @@ func (c *Config) SetDefaults() {
 	if c.Proxy.Headers.EmailHeader == "" {
 		c.Proxy.Headers.EmailHeader = "X-Forwarded-Email"
 	}
+	if c.Proxy.Headers.GroupsHeader == "" {
+		c.Proxy.Headers.GroupsHeader = "X-Forwarded-Groups"
+	}
 	if c.Proxy.Headers.AuthHeader == "" {
 		c.Proxy.Headers.AuthHeader = "Authorization"
 	}
@@ -213,6 +573,15 @@ func (c *Config) SetDefaults() {
 	if c.Proxy.Backend.HealthCheckInterval == 0 {
 		c.Proxy.Backend.HealthCheckInterval = 30 * time.Second
 	}
+	c.Proxy.Rewrite.PathPrefix = strings.TrimSuffix(c.Proxy.Rewrite.PathPrefix, "/")
+	if c.Proxy.Rewrite.Body.Enabled {
+		if len(c.Proxy.Rewrite.Body.ContentTypes) == 0 {
+			c.Proxy.Rewrite.Body.ContentTypes = []string{"text/html"}
+		}
+		if c.Proxy.Rewrite.Body.MaxBodySize == 0 {
+			c.Proxy.Rewrite.Body.MaxBodySize = 10 * 1024 * 1024
+		}
+	}
 
 	// Timeout defaults
 	if c.Proxy.Timeouts.Dial == 0 {
@@ -237,6 +606,89 @@ func (c *Config) SetDefaults() {
 		c.Proxy.Timeouts.MaxIdleConnsPerHost = 10
 	}
 
+	// Load balancing, retry and outlier detection defaults
+	if len(c.Proxy.Backend.Endpoints) == 0 && c.Proxy.Backend.URL != "" {
+		c.Proxy.Backend.Endpoints = []string{c.Proxy.Backend.URL}
+	}
+	if c.Proxy.Backend.LoadBalancing == "" {
+		c.Proxy.Backend.LoadBalancing = "round_robin"
+	}
+	if len(c.Proxy.Timeouts.Retry.RetryOn) == 0 {
+		c.Proxy.Timeouts.Retry.RetryOn = []int{502, 503, 504}
+	}
+	if c.Proxy.Timeouts.Retry.Backoff == 0 {
+		c.Proxy.Timeouts.Retry.Backoff = 25 * time.Millisecond
+	}
+	if c.Proxy.Timeouts.Retry.BudgetRatio == 0 {
+		c.Proxy.Timeouts.Retry.BudgetRatio = 0.2
+	}
+	if c.Proxy.Timeouts.Retry.MinRetriesPerSecond == 0 {
+		c.Proxy.Timeouts.Retry.MinRetriesPerSecond = 3
+	}
+	if c.Proxy.Timeouts.OutlierDetection.BaseEjectionTime == 0 {
+		c.Proxy.Timeouts.OutlierDetection.BaseEjectionTime = 30 * time.Second
+	}
+	if c.Proxy.Timeouts.OutlierDetection.MaxEjectionTime == 0 {
+		c.Proxy.Timeouts.OutlierDetection.MaxEjectionTime = 5 * time.Minute
+	}
+	if c.Proxy.Timeouts.OutlierDetection.MaxEjectionPercent == 0 {
+		c.Proxy.Timeouts.OutlierDetection.MaxEjectionPercent = 50
+	}
+	if c.Proxy.Timeouts.CircuitBreaker.OpenDuration == 0 {
+		c.Proxy.Timeouts.CircuitBreaker.OpenDuration = 30 * time.Second
+	}
+
+	// Rate limit defaults
+	if c.Proxy.RateLimit.Peers.Port == 0 {
+		c.Proxy.RateLimit.Peers.Port = 8090
+	}
+	if c.Proxy.RateLimit.Peers.RefreshInterval == 0 {
+		c.Proxy.RateLimit.Peers.RefreshInterval = 10 * time.Second
+	}
+	if c.Proxy.RateLimit.Peers.Timeout == 0 {
+		c.Proxy.RateLimit.Peers.Timeout = 100 * time.Millisecond
+	}
+	setDefaultBurst := func(l *RateLimit) {
+		if l.Enabled() && l.Burst == 0 {
+			l.Burst = int(math.Ceil(l.RequestsPerSecond))
+		}
+	}
+	setDefaultBurst(&c.Proxy.RateLimit.Auth)
+	setDefaultBurst(&c.Proxy.RateLimit.Proxy)
+	setDefaultBurst(&c.Auth.CLI.DeviceRateLimit)
+	for i := range c.Proxy.RateLimit.Routes {
+		setDefaultBurst(&c.Proxy.RateLimit.Routes[i].RateLimit)
+	}
+
+	// Policy defaults
+	for i := range c.Policy.CORS {
+		rule := &c.Policy.CORS[i]
+		if rule.PathPrefix == "" {
+			rule.PathPrefix = "/"
+		}
+		if len(rule.AllowedMethods) == 0 {
+			rule.AllowedMethods = []string{"GET", "HEAD", "POST"}
+		}
+		if len(rule.AllowedHeaders) == 0 {
+			rule.AllowedHeaders = []string{"Content-Type", "X-Requested-With"}
+		}
+	}
+	if c.Policy.SecurityHeaders.HSTS.MaxAge == 0 {
+		c.Policy.SecurityHeaders.HSTS.MaxAge = 365 * 24 * time.Hour
+	}
+	if c.Policy.SecurityHeaders.ContentSecurityPolicy == "" {
+		c.Policy.SecurityHeaders.ContentSecurityPolicy = "frame-ancestors 'self'"
+	}
+	if c.Policy.SecurityHeaders.FrameOptions == "" {
+		c.Policy.SecurityHeaders.FrameOptions = "SAMEORIGIN"
+	}
+	if c.Policy.SecurityHeaders.ReferrerPolicy == "" {
+		c.Policy.SecurityHeaders.ReferrerPolicy = "strict-origin-when-cross-origin"
+	}
+	if c.Policy.SecurityHeaders.ContentTypeOptions == "" {
+		c.Policy.SecurityHeaders.ContentTypeOptions = "nosniff"
+	}
+
 	// Observability defaults
 	if c.Observability.Metrics.Path == "" {
 		c.Observability.Metrics.Path = "/metrics"
@@ -256,6 +708,18 @@ func (c *Config) SetDefaults() {
 	if c.Observability.Health.ReadinessPath == "" {
 		c.Observability.Health.ReadinessPath = "/readyz"
 	}
+	if c.Proxy.Backend.Protocol == "" {
+		c.Proxy.Backend.Protocol = "http1"
+	}
+	if len(c.Proxy.GRPC.CSRFExemptContentTypes) == 0 {
+		c.Proxy.GRPC.CSRFExemptContentTypes = []string{"application/grpc", "application/grpc-web", "application/grpc-web-text"}
+	}
+	if c.Observability.Capture.MaxEntries == 0 {
+		c.Observability.Capture.MaxEntries = 1000
+	}
+	if c.Observability.Capture.FlushInterval == 0 {
+		c.Observability.Capture.FlushInterval = 10 * time.Second
+	}
 }
 
 // GetKubernetesConfig builds a Kubernetes rest.Config from the configuration
@@ -275,8 +739,15 @@ func (a *AuthConfig) GetKubernetesConfig() (*rest.Config, error) {
 		config.Host = a.KubeConfig.ServerURL
 	}
 	
-	if a.KubeConfig.BearerToken != "" {
-		config.BearerToken = a.KubeConfig.BearerToken
+	// A file reference is handed to client-go, which re-reads rotated tokens
+	if kind, target := secrets.Parse(a.KubeConfig.BearerToken); kind == secrets.KindFile {
+		config.BearerTokenFile = target
+	} else if a.KubeConfig.BearerToken != "" {
+		token, err := secrets.Resolve(a.KubeConfig.BearerToken)
+		if err != nil {
+			return nil, fmt.Errorf("kube_config.bearer_token: %w", err)
+		}
+		config.BearerToken = token
 	}
 	
 	if a.KubeConfig.BearerTokenFile != "" {
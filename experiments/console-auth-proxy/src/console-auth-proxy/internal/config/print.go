@@ -0,0 +1,117 @@
+package config
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/your-org/console-auth-proxy/internal/secrets"
+)
+
+// RedactedValue replaces secrets in printed configuration
+const RedactedValue = secrets.RedactedValue
+
+// EffectiveYAML renders the configuration as YAML with secrets redacted.
+// source, if set, returns a comment describing where a key's value came from.
+func (c *Config) EffectiveYAML(source func(key string) string) ([]byte, error) {
+	node := &yaml.Node{Kind: yaml.DocumentNode}
+	root, err := structNode(reflect.ValueOf(c).Elem(), "", source)
+	if err != nil {
+		return nil, err
+	}
+	node.Content = []*yaml.Node{root}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(node); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), encoder.Close()
+}
+
+func structNode(v reflect.Value, prefix string, source func(string) string) (*yaml.Node, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+
+	var err error
+	walkFields(v.Type(), func(name string, f reflect.StructField) {
+		if err != nil {
+			return
+		}
+
+		field := v.FieldByIndex(f.Index)
+		var value *yaml.Node
+		if isNested(f.Type) {
+			value, err = structNode(field, prefix+name+".", source)
+		} else if isSecret(f) && !field.IsZero() && !secrets.IsReference(field.String()) {
+			value = &yaml.Node{Kind: yaml.ScalarNode, Value: RedactedValue}
+		} else {
+			value, err = valueNode(field)
+		}
+		if err != nil {
+			return
+		}
+
+		keyNode := &yaml.Node{Kind: yaml.ScalarNode, Value: name}
+		if source != nil && !isNested(f.Type) {
+			// Block lists and maps start on the next line, comment the key instead
+			if value.Kind == yaml.ScalarNode || value.Style == yaml.FlowStyle {
+				value.LineComment = source(prefix + name)
+			} else {
+				keyNode.LineComment = source(prefix + name)
+			}
+		}
+		node.Content = append(node.Content, keyNode, value)
+	})
+
+	return node, err
+}
+
+// valueNode renders a value inside lists and maps, where no sources are known
+func valueNode(v reflect.Value) (*yaml.Node, error) {
+	if v.Type() == durationType {
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: v.Interface().(time.Duration).String()}, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return structNode(v, "", nil)
+	case reflect.Slice:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		if v.Len() == 0 {
+			node.Style = yaml.FlowStyle
+		}
+		for i := 0; i < v.Len(); i++ {
+			item, err := valueNode(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, item)
+		}
+		return node, nil
+	case reflect.Map:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		if v.Len() == 0 {
+			node.Style = yaml.FlowStyle
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, key := range keys {
+			item, err := valueNode(v.MapIndex(key))
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key.String()}, item)
+		}
+		return node, nil
+	}
+
+	node := &yaml.Node{}
+	if err := node.Encode(v.Interface()); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Field is a configuration value addressed by its dotted key, e.g. "auth.client_id"
+type Field struct {
+	Key    string
+	Secret bool
+}
+
+// Fields returns the leaf configuration keys in declaration order. Lists and
+// maps are leaves, their elements have no keys of their own.
+func Fields() []Field {
+	var fields []Field
+	walkStruct(reflect.TypeOf(Config{}), "", func(key string, f reflect.StructField) {
+		if isNested(f.Type) {
+			return
+		}
+		fields = append(fields, Field{Key: key, Secret: isSecret(f)})
+	})
+	return fields
+}
+
+// Schema returns a JSON Schema describing the configuration file
+func Schema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "console-auth-proxy configuration"
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	if t == durationType {
+		return map[string]interface{}{
+			"type":    []string{"string", "integer"},
+			"pattern": `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`,
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		walkFields(t, func(name string, f reflect.StructField) {
+			property := typeSchema(f.Type)
+			if isSecret(f) {
+				property["writeOnly"] = true
+			}
+			properties[name] = property
+		})
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default:
+		return map[string]interface{}{"type": "string"}
+	}
+}
+
+// walkStruct calls fn for every field of t and its nested structs with the dotted key
+func walkStruct(t reflect.Type, prefix string, fn func(key string, f reflect.StructField)) {
+	walkFields(t, func(name string, f reflect.StructField) {
+		key := prefix + name
+		fn(key, f)
+		if isNested(f.Type) {
+			walkStruct(f.Type, key+".", fn)
+		}
+	})
+}
+
+// walkFields calls fn for every configuration field of t with its mapstructure
+// name, flattening squashed embedded structs
+func walkFields(t reflect.Type, fn func(name string, f reflect.StructField)) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(f.Tag.Get("mapstructure"), ",")
+		if opts == "squash" {
+			walkFields(f.Type, func(name string, embedded reflect.StructField) {
+				embedded.Index = append(append([]int(nil), f.Index...), embedded.Index...)
+				fn(name, embedded)
+			})
+			continue
+		}
+		if name == "" || name == "-" {
+			continue
+		}
+		fn(name, f)
+	}
+}
+
+func isNested(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != durationType
+}
+
+func isSecret(f reflect.StructField) bool {
+	return f.Tag.Get("secret") == "true"
+}
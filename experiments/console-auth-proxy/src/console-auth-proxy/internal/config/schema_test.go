@@ -0,0 +1,97 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFields(t *testing.T) {
+	secrets := map[string]bool{}
+	keys := map[string]bool{}
+	for _, f := range Fields() {
+		keys[f.Key] = true
+		if f.Secret {
+			secrets[f.Key] = true
+		}
+	}
+
+	assert.True(t, keys["server.listen_address"])
+	assert.True(t, keys["proxy.rate_limit.auth.requests_per_second"])
+	assert.True(t, keys["auth.step_up"], "lists are leaves")
+	assert.False(t, keys["auth.tls"], "nested structs are not leaves")
+
+	assert.Equal(t, map[string]bool{
+		"auth.client_secret":             true,
+		"auth.cookie_authentication_key": true,
+		"auth.cookie_encryption_key":     true,
+		"auth.kube_config.bearer_token":  true,
+		"proxy.rate_limit.peers.secret":  true,
+	}, secrets)
+}
+
+func TestSchema(t *testing.T) {
+	schema := Schema()
+	assert.Equal(t, "object", schema["type"])
+	assert.Equal(t, false, schema["additionalProperties"])
+
+	property := func(path ...string) map[string]interface{} {
+		node := schema
+		for _, name := range path {
+			node = node["properties"].(map[string]interface{})[name].(map[string]interface{})
+		}
+		return node
+	}
+
+	assert.Equal(t, "string", property("server", "listen_address")["type"])
+	assert.Equal(t, "boolean", property("auth", "secure_cookies")["type"])
+	assert.Equal(t, []string{"string", "integer"}, property("server", "read_timeout")["type"])
+	assert.Equal(t, true, property("auth", "client_secret")["writeOnly"])
+
+	// Squashed fields are inlined into list items
+	routes := property("proxy", "rate_limit", "routes")
+	assert.Equal(t, "array", routes["type"])
+	items := routes["items"].(map[string]interface{})["properties"].(map[string]interface{})
+	assert.Contains(t, items, "path_prefix")
+	assert.Contains(t, items, "requests_per_second")
+}
+
+func TestEffectiveYAML(t *testing.T) {
+	cfg := &Config{}
+	cfg.Auth.ClientID = "demo"
+	cfg.Auth.ClientSecret = "hunter2"
+	cfg.Auth.StepUp = []StepUpRule{{PathPrefix: "/admin", MaxAge: 5 * time.Minute}}
+	cfg.SetDefaults()
+
+	out, err := cfg.EffectiveYAML(func(key string) string {
+		if key == "auth.client_id" {
+			return "file"
+		}
+		return ""
+	})
+	require.NoError(t, err)
+
+	yaml := string(out)
+	assert.NotContains(t, yaml, "hunter2")
+	assert.Contains(t, yaml, "client_secret: "+RedactedValue)
+	assert.Contains(t, yaml, "client_id: demo # file")
+	assert.Contains(t, yaml, "read_timeout: 30s")
+	assert.Contains(t, yaml, "max_age: 5m0s")
+	assert.True(t, strings.HasPrefix(yaml, "server:\n  listen_address: 0.0.0.0:8080"))
+}
+
+func TestEffectiveYAMLReferences(t *testing.T) {
+	cfg := &Config{}
+	cfg.Auth.ClientSecret = "${OIDC_CLIENT_SECRET}"
+	cfg.Auth.CookieEncryptionKey = "file:///run/secrets/cookie/encryption-key"
+
+	out, err := cfg.EffectiveYAML(nil)
+	require.NoError(t, err)
+
+	// References name where a secret lives, not the secret itself
+	assert.Contains(t, string(out), "client_secret: ${OIDC_CLIENT_SECRET}")
+	assert.Contains(t, string(out), "cookie_encryption_key: file:///run/secrets/cookie/encryption-key")
+}
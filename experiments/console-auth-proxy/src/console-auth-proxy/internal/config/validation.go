@@ -2,8 +2,13 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"net/url"
+	"regexp"
 	"strings"
+	"time"
+
+	"github.com/your-org/console-auth-proxy/pkg/tlsprofile"
 )
 
 // Validate validates the configuration and returns an error if invalid
@@ -16,10 +21,43 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("auth config: %w", err)
 	}
 
+	if c.Auth.MTLSEnabled() && !c.Server.TLS.Enabled {
+		return fmt.Errorf("auth config: mtls requires server.tls.enabled")
+	}
+
 	if err := c.Proxy.Validate(); err != nil {
 		return fmt.Errorf("proxy config: %w", err)
 	}
 
+	if err := c.Policy.Validate(); err != nil {
+		return fmt.Errorf("policy config: %w", err)
+	}
+
+	if err := c.Observability.Capture.Validate(); err != nil {
+		return fmt.Errorf("observability config: capture: %w", err)
+	}
+
+	if _, err := tlsprofile.Get(c.TLSProfile); err != nil {
+		return fmt.Errorf("tls_profile: %w", err)
+	}
+
+	return nil
+}
+
+// Validate validates traffic capture configuration
+func (c *CaptureConfig) Validate() error {
+	if c.MaxEntries < 0 {
+		return fmt.Errorf("max_entries cannot be negative")
+	}
+
+	if c.FlushInterval < 0 {
+		return fmt.Errorf("flush_interval cannot be negative")
+	}
+
+	if c.Enabled && len(c.AdminUsers) == 0 && len(c.AdminGroups) == 0 {
+		return fmt.Errorf("admin_users or admin_groups is required to download the capture")
+	}
+
 	return nil
 }
 
@@ -36,6 +74,9 @@ func (s *ServerConfig) Validate() error {
 		if s.TLS.KeyFile == "" {
 			return fmt.Errorf("tls.key_file is required when TLS is enabled")
 		}
+		if s.H2C {
+			return fmt.Errorf("h2c can't be used with TLS, which offers HTTP/2 already")
+		}
 	}
 
 	return nil
@@ -43,12 +84,53 @@ func (s *ServerConfig) Validate() error {
 
 // Validate validates authentication configuration
 func (a *AuthConfig) Validate() error {
+	if err := a.CLI.Validate(); err != nil {
+		return err
+	}
+
+	if err := a.validateSecrets(); err != nil {
+		return err
+	}
+
+	// Only OIDC and local users have groups, everyone else would be denied
+	if len(a.AllowedGroups) > 0 {
+		switch strings.ToLower(a.AuthSource) {
+		case "", "oidc", "local":
+			if a.MTLS.Enabled {
+				return fmt.Errorf("allowed_groups can't be combined with mtls, client certificate users have no groups")
+			}
+		default:
+			return fmt.Errorf("allowed_groups require auth_source 'oidc' or 'local', %s users have no groups", a.AuthSource)
+		}
+	}
+
 	// Validate auth source
 	switch strings.ToLower(a.AuthSource) {
 	case "openshift", "oidc":
 		// Valid auth sources
+	case "mtls":
+		// Client certificates only, no OAuth settings needed
+		if len(a.StepUp) > 0 {
+			return fmt.Errorf("step_up rules require auth_source 'oidc'")
+		}
+		return a.MTLS.Validate()
+	case "local":
+		// Local users, no OAuth settings needed
+		if len(a.StepUp) > 0 {
+			return fmt.Errorf("step_up rules require auth_source 'oidc'")
+		}
+		if a.MTLS.Enabled {
+			return fmt.Errorf("mtls can only be combined with auth_source 'openshift' or 'oidc'")
+		}
+		return a.Local.Validate()
 	default:
-		return fmt.Errorf("auth_source must be 'openshift' or 'oidc', got: %s", a.AuthSource)
+		return fmt.Errorf("auth_source must be 'openshift', 'oidc', 'mtls' or 'local', got: %s", a.AuthSource)
+	}
+
+	if a.MTLS.Enabled {
+		if err := a.MTLS.Validate(); err != nil {
+			return err
+		}
 	}
 
 	// Validate required fields
@@ -64,8 +146,8 @@ func (a *AuthConfig) Validate() error {
 		return fmt.Errorf("client_id is required")
 	}
 
-	if a.ClientSecret == "" {
-		return fmt.Errorf("client_secret is required")
+	if a.ClientSecret == "" && a.ClientSecretFile == "" {
+		return fmt.Errorf("client_secret or client_secret_file is required")
 	}
 
 	if a.RedirectURL == "" {
@@ -126,6 +208,162 @@ func (a *AuthConfig) Validate() error {
 		}
 	}
 
+	// Validate step-up rules
+	if len(a.StepUp) > 0 && strings.ToLower(a.AuthSource) != "oidc" {
+		return fmt.Errorf("step_up rules require auth_source 'oidc'")
+	}
+
+	for i, rule := range a.StepUp {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("step_up[%d]: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate validates CLI token configuration
+func (c *CLIConfig) Validate() error {
+	if c.Disabled {
+		return nil
+	}
+
+	// Zero values are replaced by defaults after validation
+	if c.TokenTTL < 0 || c.DeviceCodeTTL < 0 {
+		return fmt.Errorf("cli.token_ttl and cli.device_code_ttl cannot be negative")
+	}
+
+	if c.PollInterval != 0 && c.PollInterval < time.Second {
+		return fmt.Errorf("cli.poll_interval must be at least 1s")
+	}
+
+	if c.PublicURL != "" {
+		publicURL, err := url.Parse(c.PublicURL)
+		if err != nil || (publicURL.Scheme != "http" && publicURL.Scheme != "https") || publicURL.Host == "" {
+			return fmt.Errorf("cli.public_url must be an absolute http or https URL")
+		}
+	}
+
+	if c.MaxPendingDevices < 0 {
+		return fmt.Errorf("cli.max_pending_devices cannot be negative")
+	}
+
+	if err := c.DeviceRateLimit.Validate(); err != nil {
+		return fmt.Errorf("cli.device_rate_limit: %w", err)
+	}
+
+	return nil
+}
+
+// validateSecrets checks that each secret is set at most one way. References
+// are resolved when the server starts, the files may not exist where the
+// configuration is validated.
+func (a *AuthConfig) validateSecrets() error {
+	for _, secret := range []struct {
+		name, value, file string
+	}{
+		{"client_secret", a.ClientSecret, a.ClientSecretFile},
+		{"cookie_authentication_key", a.CookieAuthenticationKey, a.CookieAuthenticationKeyFile},
+		{"cookie_encryption_key", a.CookieEncryptionKey, a.CookieEncryptionKeyFile},
+		{"kube_config.bearer_token", a.KubeConfig.BearerToken, a.KubeConfig.BearerTokenFile},
+	} {
+		if secret.value != "" && secret.file != "" {
+			return fmt.Errorf("%s and %s_file are mutually exclusive", secret.name, secret.name)
+		}
+	}
+
+	return nil
+}
+
+// Validate validates local users configuration
+func (l *LocalAuthConfig) Validate() error {
+	if l.HtpasswdFile == "" {
+		return fmt.Errorf("local.htpasswd_file is required")
+	}
+
+	if l.ReloadInterval < 0 {
+		return fmt.Errorf("local.reload_interval cannot be negative")
+	}
+
+	if l.SessionTTL < 0 {
+		return fmt.Errorf("local.session_ttl cannot be negative")
+	}
+
+	return nil
+}
+
+// Validate validates client certificate authentication configuration
+func (m *MTLSConfig) Validate() error {
+	if m.CAFile == "" {
+		return fmt.Errorf("mtls.ca_file is required")
+	}
+
+	if m.CRLRefreshInterval < 0 {
+		return fmt.Errorf("mtls.crl_refresh_interval cannot be negative")
+	}
+
+	for i, rule := range m.UserMapping {
+		switch strings.ToLower(rule.Field) {
+		case "subject.cn", "subject.dn", "subject.o", "subject.ou", "san.dns", "san.email", "san.uri":
+		default:
+			return fmt.Errorf("mtls.user_mapping[%d]: unsupported field %q", i, rule.Field)
+		}
+
+		if rule.Match != "" {
+			if _, err := regexp.Compile(rule.Match); err != nil {
+				return fmt.Errorf("mtls.user_mapping[%d]: invalid match: %w", i, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Validate validates an access rule
+func (r *AccessRule) Validate() error {
+	if (r.Path == "") == (r.Regex == "") {
+		return fmt.Errorf("exactly one of path or regex is required")
+	}
+
+	if r.Path != "" && !strings.HasPrefix(r.Path, "/") {
+		return fmt.Errorf("path must start with /, got: %s", r.Path)
+	}
+
+	if r.Regex != "" {
+		if _, err := regexp.Compile(r.Regex); err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+	}
+
+	for _, method := range r.Methods {
+		if method == "" || strings.ToUpper(method) != method {
+			return fmt.Errorf("methods must be upper case, got: %q", method)
+		}
+	}
+
+	switch r.Action {
+	case AccessPublic, AccessAuthenticated, AccessDeny:
+	default:
+		return fmt.Errorf("action must be one of public, authenticated or deny, got: %q", r.Action)
+	}
+
+	return nil
+}
+
+// Validate validates a step-up authentication rule
+func (r *StepUpRule) Validate() error {
+	if !strings.HasPrefix(r.PathPrefix, "/") {
+		return fmt.Errorf("path_prefix must start with /")
+	}
+
+	if len(r.RequiredACR) == 0 && len(r.RequiredAMR) == 0 && r.MaxAge == 0 {
+		return fmt.Errorf("at least one of required_acr, required_amr or max_age is required")
+	}
+
+	if r.MaxAge < 0 {
+		return fmt.Errorf("max_age cannot be negative")
+	}
+
 	return nil
 }
 
@@ -139,6 +377,172 @@ func (p *ProxyConfig) Validate() error {
 		return fmt.Errorf("headers: %w", err)
 	}
 
+	if err := p.Rewrite.Validate(); err != nil {
+		return fmt.Errorf("rewrite: %w", err)
+	}
+
+	if err := p.Timeouts.Validate(); err != nil {
+		return fmt.Errorf("timeouts: %w", err)
+	}
+
+	if err := p.RateLimit.Validate(); err != nil {
+		return fmt.Errorf("rate_limit: %w", err)
+	}
+
+	for i, rule := range p.Rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("rules[%d]: %w", i, err)
+		}
+	}
+
+	for _, path := range p.PublicPaths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("public_paths must start with /, got: %s", path)
+		}
+	}
+
+	return nil
+}
+
+// Validate validates rate limit configuration
+func (r *RateLimitConfig) Validate() error {
+	if err := r.Auth.Validate(); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+
+	if err := r.Proxy.Validate(); err != nil {
+		return fmt.Errorf("proxy: %w", err)
+	}
+
+	for i, route := range r.Routes {
+		if !strings.HasPrefix(route.PathPrefix, "/") {
+			return fmt.Errorf("routes[%d]: path_prefix must start with /", i)
+		}
+		if !route.Enabled() {
+			return fmt.Errorf("routes[%d]: requests_per_second must be positive", i)
+		}
+		if err := route.RateLimit.Validate(); err != nil {
+			return fmt.Errorf("routes[%d]: %w", i, err)
+		}
+	}
+
+	for _, cidr := range r.TrustedProxies {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid trusted_proxies entry %q: %w", cidr, err)
+		}
+	}
+
+	if err := r.Peers.Validate(); err != nil {
+		return fmt.Errorf("peers: %w", err)
+	}
+
+	return nil
+}
+
+// Validate validates rate limit peer configuration
+func (p *RateLimitPeersConfig) Validate() error {
+	if !p.Enabled() {
+		return nil
+	}
+
+	if p.Secret == "" && p.SecretFile == "" {
+		return fmt.Errorf("secret or secret_file is required to authenticate replicas")
+	}
+
+	if p.Port < 1 || p.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+
+	if p.RefreshInterval < 0 || p.Timeout < 0 {
+		return fmt.Errorf("refresh_interval and timeout cannot be negative")
+	}
+
+	return nil
+}
+
+// Validate validates a token bucket limit
+func (l *RateLimit) Validate() error {
+	if l.RequestsPerSecond < 0 {
+		return fmt.Errorf("requests_per_second cannot be negative")
+	}
+
+	if l.Burst < 0 {
+		return fmt.Errorf("burst cannot be negative")
+	}
+
+	return nil
+}
+
+// Validate validates timeout, retry, outlier detection and circuit breaker configuration
+func (t *TimeoutConfig) Validate() error {
+	if t.PerTry < 0 {
+		return fmt.Errorf("per_try cannot be negative")
+	}
+
+	if t.Retry.MaxRetries < 0 {
+		return fmt.Errorf("retry.max_retries cannot be negative")
+	}
+
+	for _, status := range t.Retry.RetryOn {
+		if status < 500 || status > 599 {
+			return fmt.Errorf("retry.retry_on must only contain 5xx status codes, got: %d", status)
+		}
+	}
+
+	if t.Retry.BudgetRatio < 0 || t.Retry.BudgetRatio > 1 {
+		return fmt.Errorf("retry.budget_ratio must be between 0 and 1, got: %v", t.Retry.BudgetRatio)
+	}
+
+	if t.Retry.MinRetriesPerSecond < 0 {
+		return fmt.Errorf("retry.min_retries_per_second cannot be negative")
+	}
+
+	if t.OutlierDetection.ConsecutiveFailures < 0 {
+		return fmt.Errorf("outlier_detection.consecutive_failures cannot be negative")
+	}
+
+	if t.OutlierDetection.MaxEjectionPercent < 0 || t.OutlierDetection.MaxEjectionPercent > 100 {
+		return fmt.Errorf("outlier_detection.max_ejection_percent must be between 0 and 100")
+	}
+
+	if t.CircuitBreaker.ConsecutiveFailures < 0 || t.CircuitBreaker.MaxRequests < 0 {
+		return fmt.Errorf("circuit_breaker thresholds cannot be negative")
+	}
+
+	return nil
+}
+
+// Validate validates rewrite configuration
+func (r *RewriteConfig) Validate() error {
+	if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+		return fmt.Errorf("path_prefix must start with /")
+	}
+
+	needsPrefix := r.StripPrefix || r.Cookies.RewritePath || r.Body.Enabled
+	if needsPrefix && r.PathPrefix == "" {
+		return fmt.Errorf("path_prefix is required when strip_prefix, cookies.rewrite_path or body rewriting is enabled")
+	}
+
+	if r.Location.PublicURL != "" {
+		u, err := url.Parse(r.Location.PublicURL)
+		if err != nil {
+			return fmt.Errorf("location.public_url is not a valid URL: %w", err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("location.public_url must be an absolute URL")
+		}
+	}
+
+	for _, root := range r.Body.JSPathRoots {
+		if !strings.HasPrefix(root, "/") {
+			return fmt.Errorf("body.js_path_roots entries must start with /, got: %s", root)
+		}
+	}
+
+	if r.Body.MaxBodySize < 0 {
+		return fmt.Errorf("body.max_body_size cannot be negative")
+	}
+
 	return nil
 }
 
@@ -161,6 +565,48 @@ func (b *BackendConfig) Validate() error {
 		return fmt.Errorf("url must include a host")
 	}
 
+	for _, endpoint := range b.Endpoints {
+		endpointURL, err := url.Parse(endpoint)
+		if err != nil {
+			return fmt.Errorf("endpoint is not a valid URL: %w", err)
+		}
+		if endpointURL.Scheme != "http" && endpointURL.Scheme != "https" {
+			return fmt.Errorf("endpoint scheme must be http or https, got: %s", endpointURL.Scheme)
+		}
+		if endpointURL.Host == "" {
+			return fmt.Errorf("endpoint must include a host: %s", endpoint)
+		}
+	}
+
+	// h2c is cleartext only, h2 is negotiated with TLS
+	schemes := []string{parsedURL.Scheme}
+	for _, endpoint := range b.Endpoints {
+		endpointURL, _ := url.Parse(endpoint)
+		schemes = append(schemes, endpointURL.Scheme)
+	}
+	for _, scheme := range schemes {
+		switch {
+		case b.Protocol == "h2c" && scheme != "http":
+			return fmt.Errorf("protocol h2c requires http URLs, got: %s", scheme)
+		case b.Protocol == "h2" && scheme != "https":
+			return fmt.Errorf("protocol h2 requires https URLs, got: %s", scheme)
+		}
+	}
+
+	switch b.Protocol {
+	case "", "http1", "h2", "h2c":
+		// Valid values
+	default:
+		return fmt.Errorf("protocol must be 'http1', 'h2' or 'h2c', got: %s", b.Protocol)
+	}
+
+	switch b.LoadBalancing {
+	case "", "round_robin", "least_connections":
+		// Valid values
+	default:
+		return fmt.Errorf("load_balancing must be 'round_robin' or 'least_connections', got: %s", b.LoadBalancing)
+	}
+
 	// Validate health check path if provided
 	if b.HealthCheckPath != "" {
 		if !strings.HasPrefix(b.HealthCheckPath, "/") {
@@ -215,6 +661,71 @@ func (h *HeaderConfig) Validate() error {
 	return nil
 }
 
+// Validate validates CORS and security header policies
+func (p *PolicyConfig) Validate() error {
+	for i, rule := range p.CORS {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("cors[%d]: %w", i, err)
+		}
+	}
+
+	switch strings.ToUpper(p.SecurityHeaders.FrameOptions) {
+	case "", "DENY", "SAMEORIGIN":
+		// Valid values
+	default:
+		return fmt.Errorf("security_headers.frame_options must be 'DENY' or 'SAMEORIGIN', got: %s", p.SecurityHeaders.FrameOptions)
+	}
+
+	if p.SecurityHeaders.HSTS.MaxAge < 0 {
+		return fmt.Errorf("security_headers.hsts.max_age cannot be negative")
+	}
+
+	for name := range p.SecurityHeaders.Custom {
+		if !isValidHeaderName(name) {
+			return fmt.Errorf("security_headers.custom header name is not valid: %s", name)
+		}
+	}
+
+	return nil
+}
+
+// Validate validates a CORS rule
+func (r *CORSRule) Validate() error {
+	if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+		return fmt.Errorf("path_prefix must start with /")
+	}
+
+	if len(r.AllowedOrigins) == 0 {
+		return fmt.Errorf("at least one allowed origin is required")
+	}
+
+	for _, origin := range r.AllowedOrigins {
+		if origin == "*" {
+			if r.AllowCredentials {
+				return fmt.Errorf("allowed origin '*' cannot be combined with allow_credentials")
+			}
+			continue
+		}
+
+		u, err := url.Parse(strings.Replace(origin, "*.", "wildcard.", 1))
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("allowed origin must be scheme://host[:port], got: %s", origin)
+		}
+	}
+
+	for _, name := range append(r.AllowedHeaders, r.ExposedHeaders...) {
+		if name != "*" && !isValidHeaderName(name) {
+			return fmt.Errorf("header name is not valid: %s", name)
+		}
+	}
+
+	if r.MaxAge < 0 {
+		return fmt.Errorf("max_age cannot be negative")
+	}
+
+	return nil
+}
+
 // isValidHeaderName checks if a string is a valid HTTP header name
 func isValidHeaderName(name string) bool {
 	if name == "" {
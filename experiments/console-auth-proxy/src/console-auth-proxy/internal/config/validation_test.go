@@ -0,0 +1,33 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAllowedGroups(t *testing.T) {
+	for _, tc := range []struct {
+		source string
+		mtls   bool
+		valid  bool
+	}{
+		{"oidc", false, true},
+		{"local", false, true},
+		{"openshift", false, false},
+		{"mtls", false, false},
+		{"oidc", true, false},
+	} {
+		a := &AuthConfig{AuthSource: tc.source, AllowedGroups: []string{"admins"}}
+		a.MTLS.Enabled = tc.mtls
+		err := a.Validate()
+		if tc.valid {
+			// Other settings of the source may still be missing
+			if err != nil {
+				assert.NotContains(t, err.Error(), "allowed_groups", "%s mtls=%v", tc.source, tc.mtls)
+			}
+		} else {
+			assert.ErrorContains(t, err, "allowed_groups", "%s mtls=%v", tc.source, tc.mtls)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package injector
+
+import (
+	"fmt"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultContainerName is the name of the injected sidecar container
+	DefaultContainerName = "auth-proxy"
+
+	// DefaultPort is the port the sidecar listens on
+	DefaultPort = 8180
+)
+
+// Config describes the sidecar container injected into annotated pods
+type Config struct {
+	// Container image of the proxy
+	Image string `json:"image"`
+
+	// Image pull policy of the sidecar container
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+
+	// Name of the sidecar container, also used as the name of its port
+	ContainerName string `json:"containerName,omitempty"`
+
+	// Port the sidecar listens on
+	Port int32 `json:"port,omitempty"`
+
+	// Arguments passed to the proxy, e.g. --config for a mounted config file
+	Args []string `json:"args,omitempty"`
+
+	// Environment shared by all sidecars, e.g. the OIDC client from a secret.
+	// Settings generated from pod annotations take precedence.
+	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// Environment sources shared by all sidecars
+	EnvFrom []corev1.EnvFromSource `json:"envFrom,omitempty"`
+
+	// Volume mounts of the sidecar; the volumes must exist in the pod
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+
+	// Resource requests and limits of the sidecar
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// LoadConfig reads the injector configuration from a YAML file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read injector config: %w", err)
+	}
+
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse injector config %s: %w", path, err)
+	}
+	cfg.SetDefaults()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// SetDefaults fills in unset values
+func (c *Config) SetDefaults() {
+	if c.ContainerName == "" {
+		c.ContainerName = DefaultContainerName
+	}
+	if c.Port == 0 {
+		c.Port = DefaultPort
+	}
+	if c.ImagePullPolicy == "" {
+		c.ImagePullPolicy = corev1.PullIfNotPresent
+	}
+}
+
+// Validate checks the injector configuration
+func (c *Config) Validate() error {
+	if c.Image == "" {
+		return fmt.Errorf("image is required")
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535, got: %d", c.Port)
+	}
+	// Named ports are limited to 15 characters
+	if len(c.ContainerName) > 15 {
+		return fmt.Errorf("containerName must be at most 15 characters, got: %s", c.ContainerName)
+	}
+	return nil
+}
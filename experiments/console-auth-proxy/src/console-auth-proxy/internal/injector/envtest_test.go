@@ -0,0 +1,128 @@
+package injector
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// TestWebhookEnvtest registers the webhook with a real API server. It needs the
+// envtest binaries, e.g. KUBEBUILDER_ASSETS="$(setup-envtest use -p path)".
+func TestWebhookEnvtest(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS not set, skipping envtest")
+	}
+
+	path := "mutate"
+	failurePolicy := admissionregistrationv1.Fail
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	webhookConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "auth-proxy-injector"},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name: "inject.auth-proxy.opendatahub.io",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				Service: &admissionregistrationv1.ServiceReference{
+					Namespace: "default",
+					Name:      "auth-proxy-injector",
+					Path:      &path,
+				},
+			},
+			Rules: []admissionregistrationv1.RuleWithOperations{{
+				Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+				Rule: admissionregistrationv1.Rule{
+					APIGroups:   []string{""},
+					APIVersions: []string{"v1"},
+					Resources:   []string{"pods", "services"},
+				},
+			}},
+			FailurePolicy:           &failurePolicy,
+			SideEffects:             &sideEffects,
+			AdmissionReviewVersions: []string{"v1"},
+		}},
+	}
+
+	env := &envtest.Environment{
+		WebhookInstallOptions: envtest.WebhookInstallOptions{
+			MutatingWebhooks: []*admissionregistrationv1.MutatingWebhookConfiguration{webhookConfig},
+		},
+	}
+
+	// Serve the webhook before the configuration is installed
+	require.NoError(t, env.WebhookInstallOptions.PrepWithoutInstalling())
+	options := &env.WebhookInstallOptions
+	cert, err := tls.LoadX509KeyPair(
+		filepath.Join(options.LocalServingCertDir, "tls.crt"),
+		filepath.Join(options.LocalServingCertDir, "tls.key"),
+	)
+	require.NoError(t, err)
+
+	listener, err := tls.Listen("tcp", net.JoinHostPort(options.LocalServingHost, fmt.Sprint(options.LocalServingPort)),
+		&tls.Config{Certificates: []tls.Certificate{cert}})
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.Handle("/mutate", NewWebhook(testConfig(), nil))
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Close()
+
+	restConfig, err := env.Start()
+	require.NoError(t, err)
+	defer env.Stop()
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	pod, err := client.CoreV1().Pods("default").Create(ctx, testPod(map[string]string{
+		InjectAnnotation:        "true",
+		BackendPortAnnotation:   "http",
+		AllowedGroupsAnnotation: "admins",
+	}), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	require.Len(t, pod.Spec.Containers, 2)
+	sidecar := pod.Spec.Containers[1]
+	assert.Equal(t, DefaultContainerName, sidecar.Name)
+	value, _ := envValue(sidecar.Env, "CAP_PROXY_BACKEND_URL")
+	assert.Equal(t, "http://127.0.0.1:8080", value)
+	value, _ = envValue(sidecar.Env, "CAP_AUTH_ALLOWED_GROUPS")
+	assert.Equal(t, "admins", value)
+	assert.Equal(t, statusInjected, pod.Annotations[StatusAnnotation])
+
+	// Invalid annotations are rejected by the API server
+	invalid := testPod(map[string]string{
+		InjectAnnotation:      "true",
+		BackendPortAnnotation: "grpc",
+	})
+	invalid.Name = "invalid"
+	_, err = client.CoreV1().Pods("default").Create(ctx, invalid, metav1.CreateOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no container port named grpc")
+
+	service, err := client.CoreV1().Services("default").Create(ctx, &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Annotations: map[string]string{InjectAnnotation: "true"},
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromInt32(8080)}},
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, intstr.FromString(DefaultContainerName), service.Spec.Ports[0].TargetPort)
+}
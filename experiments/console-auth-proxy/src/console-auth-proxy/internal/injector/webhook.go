@@ -0,0 +1,425 @@
+package injector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// InjectAnnotation requests a sidecar for a pod, and a target port rewrite for a service
+	InjectAnnotation = "auth-proxy.opendatahub.io/inject"
+
+	// StatusAnnotation marks pods that already have a sidecar
+	StatusAnnotation = "auth-proxy.opendatahub.io/status"
+
+	// BackendPortAnnotation is the container port (number or name) the sidecar proxies to
+	BackendPortAnnotation = "auth-proxy.opendatahub.io/backend-port"
+
+	// AllowedGroupsAnnotation is a comma-separated list of groups allowed through the proxy
+	AllowedGroupsAnnotation = "auth-proxy.opendatahub.io/allowed-groups"
+
+	// PublicPathsAnnotation is a comma-separated list of path prefixes served without authentication
+	PublicPathsAnnotation = "auth-proxy.opendatahub.io/public-paths"
+
+	statusInjected = "injected"
+
+	// maxRequestSize limits the size of admission reviews read from the API server
+	maxRequestSize = 3 << 20
+)
+
+// Webhook is a mutating admission webhook injecting the proxy as a sidecar
+type Webhook struct {
+	config *Config
+	// pods resolves named service target ports, nil leaves them unresolved
+	pods corev1client.PodsGetter
+}
+
+// NewWebhook creates a webhook injecting the configured sidecar. Named target
+// ports of services are resolved against the selected pods through pods.
+func NewWebhook(cfg *Config, pods corev1client.PodsGetter) *Webhook {
+	return &Webhook{config: cfg, pods: pods}
+}
+
+// patchOperation is a single JSON patch (RFC 6902) operation
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// ServeHTTP handles AdmissionReview requests
+func (wh *Webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+		http.Error(w, fmt.Sprintf("Unsupported content type %q", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	review := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+		http.Error(w, "Invalid admission review", http.StatusBadRequest)
+		return
+	}
+
+	response := wh.Mutate(review.Request)
+	response.UID = review.Request.UID
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&admissionv1.AdmissionReview{
+		TypeMeta: review.TypeMeta,
+		Response: response,
+	})
+}
+
+// Mutate returns the admission response for a pod or service
+func (wh *Webhook) Mutate(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	var (
+		patch []patchOperation
+		err   error
+	)
+
+	switch req.Kind.Kind {
+	case "Pod":
+		pod := &corev1.Pod{}
+		if err := json.Unmarshal(req.Object.Raw, pod); err != nil {
+			return deny(fmt.Errorf("failed to decode pod: %w", err))
+		}
+		patch, err = wh.mutatePod(pod)
+	case "Service":
+		service := &corev1.Service{}
+		if err := json.Unmarshal(req.Object.Raw, service); err != nil {
+			return deny(fmt.Errorf("failed to decode service: %w", err))
+		}
+		if service.Namespace == "" {
+			service.Namespace = req.Namespace
+		}
+		patch, err = wh.mutateService(service)
+	default:
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	if err != nil {
+		klog.Infof("Rejecting %s %s/%s: %v", req.Kind.Kind, req.Namespace, req.Name, err)
+		return deny(err)
+	}
+	if len(patch) == 0 {
+		return &admissionv1.AdmissionResponse{Allowed: true}
+	}
+
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return deny(fmt.Errorf("failed to encode patch: %w", err))
+	}
+
+	klog.V(2).Infof("Mutating %s %s/%s", req.Kind.Kind, req.Namespace, req.Name)
+	patchType := admissionv1.PatchTypeJSONPatch
+	return &admissionv1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     patchBytes,
+		PatchType: &patchType,
+	}
+}
+
+// mutatePod appends the sidecar to an annotated pod
+func (wh *Webhook) mutatePod(pod *corev1.Pod) ([]patchOperation, error) {
+	if !injectRequested(pod.Annotations) || pod.Annotations[StatusAnnotation] == statusInjected {
+		return nil, nil
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == wh.config.ContainerName {
+			return nil, nil
+		}
+	}
+
+	backendPort, err := resolveBackendPort(pod)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := wh.sidecarEnv(pod.Annotations, backendPort)
+	if err != nil {
+		return nil, err
+	}
+
+	return []patchOperation{
+		{
+			Op:    "add",
+			Path:  "/spec/containers/-",
+			Value: wh.sidecar(env),
+		},
+		{
+			Op:    "add",
+			Path:  "/metadata/annotations/" + escapePointer(StatusAnnotation),
+			Value: statusInjected,
+		},
+	}, nil
+}
+
+// sidecar builds the proxy container
+func (wh *Webhook) sidecar(env []corev1.EnvVar) corev1.Container {
+	probe := func(path string) *corev1.Probe {
+		return &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{
+					Path: path,
+					Port: intstr.FromString(wh.config.ContainerName),
+				},
+			},
+		}
+	}
+
+	return corev1.Container{
+		Name:            wh.config.ContainerName,
+		Image:           wh.config.Image,
+		ImagePullPolicy: wh.config.ImagePullPolicy,
+		Args:            wh.config.Args,
+		Ports: []corev1.ContainerPort{{
+			Name:          wh.config.ContainerName,
+			ContainerPort: wh.config.Port,
+			Protocol:      corev1.ProtocolTCP,
+		}},
+		Env:            env,
+		EnvFrom:        wh.config.EnvFrom,
+		VolumeMounts:   wh.config.VolumeMounts,
+		Resources:      wh.config.Resources,
+		LivenessProbe:  probe("/healthz"),
+		ReadinessProbe: probe("/readyz"),
+	}
+}
+
+// sidecarEnv generates the proxy configuration from pod annotations. The
+// generated variables follow the configured ones, so they take precedence.
+func (wh *Webhook) sidecarEnv(annotations map[string]string, backendPort int32) ([]corev1.EnvVar, error) {
+	env := append([]corev1.EnvVar{}, wh.config.Env...)
+	env = append(env,
+		corev1.EnvVar{Name: "CAP_SERVER_LISTEN_ADDRESS", Value: fmt.Sprintf("0.0.0.0:%d", wh.config.Port)},
+		corev1.EnvVar{Name: "CAP_PROXY_BACKEND_URL", Value: fmt.Sprintf("http://127.0.0.1:%d", backendPort)},
+	)
+
+	if groups := splitList(annotations[AllowedGroupsAnnotation]); len(groups) > 0 {
+		if !groupsSupported(wh.config.Env) {
+			return nil, fmt.Errorf("%s requires auth source oidc or local without mtls, other users have no groups", AllowedGroupsAnnotation)
+		}
+		env = append(env, corev1.EnvVar{Name: "CAP_AUTH_ALLOWED_GROUPS", Value: strings.Join(groups, ",")})
+	}
+
+	if paths := splitList(annotations[PublicPathsAnnotation]); len(paths) > 0 {
+		for _, path := range paths {
+			if !strings.HasPrefix(path, "/") {
+				return nil, fmt.Errorf("%s: path must start with /, got: %s", PublicPathsAnnotation, path)
+			}
+		}
+		env = append(env, corev1.EnvVar{Name: "CAP_PROXY_PUBLIC_PATHS", Value: strings.Join(paths, ",")})
+	}
+
+	return env, nil
+}
+
+// groupsSupported reports whether the configured auth source provides user
+// groups. Only literal values are checked; the proxy rejects the combination
+// at startup if it is configured some other way.
+func groupsSupported(env []corev1.EnvVar) bool {
+	for _, e := range env {
+		switch e.Name {
+		case "CAP_AUTH_AUTH_SOURCE", "CAP_AUTH_SOURCE":
+			if source := strings.ToLower(e.Value); source != "" && source != "oidc" && source != "local" {
+				return false
+			}
+		case "CAP_AUTH_MTLS_ENABLED":
+			if enabled, _ := strconv.ParseBool(e.Value); enabled {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// mutateService points the backend port of an annotated service at the sidecar
+func (wh *Webhook) mutateService(service *corev1.Service) ([]patchOperation, error) {
+	if !injectRequested(service.Annotations) {
+		return nil, nil
+	}
+
+	backendPort, hasBackendPort := service.Annotations[BackendPortAnnotation]
+	if !hasBackendPort && len(service.Spec.Ports) != 1 {
+		return nil, fmt.Errorf("%s is required for services with %d ports", BackendPortAnnotation, len(service.Spec.Ports))
+	}
+
+	sidecarPort := intstr.FromString(wh.config.ContainerName)
+	var pods []corev1.Pod
+	if hasBackendPort {
+		var err error
+		if pods, err = wh.selectedPods(service); err != nil {
+			return nil, err
+		}
+	}
+
+	var patch []patchOperation
+	matched := false
+	for i, port := range service.Spec.Ports {
+		if port.TargetPort == sidecarPort {
+			matched = true
+			continue
+		}
+		if hasBackendPort && !targetsPort(port, backendPort, pods) {
+			continue
+		}
+
+		matched = true
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  fmt.Sprintf("/spec/ports/%d/targetPort", i),
+			Value: sidecarPort,
+		})
+	}
+
+	if !matched {
+		return nil, fmt.Errorf("no service port targets %s %s", BackendPortAnnotation, backendPort)
+	}
+	return patch, nil
+}
+
+// selectedPods lists the pods a service selects, to resolve named ports
+func (wh *Webhook) selectedPods(service *corev1.Service) ([]corev1.Pod, error) {
+	if wh.pods == nil || len(service.Spec.Selector) == 0 {
+		return nil, nil
+	}
+
+	list, err := wh.pods.Pods(service.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(service.Spec.Selector).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods selected by the service: %w", err)
+	}
+	return list.Items, nil
+}
+
+// targetsPort reports whether a service port forwards to the given container
+// port. Names on either side are resolved against the container ports of pods,
+// so a target port named http matches a backend port given as its number.
+func targetsPort(port corev1.ServicePort, containerPort string, pods []corev1.Pod) bool {
+	target := port.TargetPort
+	// An unset target port defaults to the service port
+	if target.Type == intstr.Int && target.IntVal == 0 {
+		target = intstr.FromInt32(port.Port)
+	}
+
+	backend := intstr.Parse(strings.TrimSpace(containerPort))
+	if target.String() == backend.String() {
+		return true
+	}
+
+	for i := range pods {
+		targetNumber, ok := containerPortNumber(&pods[i], target)
+		if !ok {
+			continue
+		}
+		if backendNumber, ok := containerPortNumber(&pods[i], backend); ok && targetNumber == backendNumber {
+			return true
+		}
+	}
+	return false
+}
+
+// containerPortNumber resolves a port number or name against a pod's containers
+func containerPortNumber(pod *corev1.Pod, port intstr.IntOrString) (int32, bool) {
+	if port.Type == intstr.Int {
+		return port.IntVal, true
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, p := range container.Ports {
+			if p.Name == port.StrVal {
+				return p.ContainerPort, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// resolveBackendPort finds the container port the sidecar proxies to
+func resolveBackendPort(pod *corev1.Pod) (int32, error) {
+	value := strings.TrimSpace(pod.Annotations[BackendPortAnnotation])
+
+	if value == "" {
+		var ports []corev1.ContainerPort
+		for _, container := range pod.Spec.Containers {
+			ports = append(ports, container.Ports...)
+		}
+		if len(ports) != 1 {
+			return 0, fmt.Errorf("%s is required for pods with %d container ports", BackendPortAnnotation, len(ports))
+		}
+		return ports[0].ContainerPort, nil
+	}
+
+	if port, err := strconv.ParseInt(value, 10, 32); err == nil {
+		if port < 1 || port > 65535 {
+			return 0, fmt.Errorf("%s must be between 1 and 65535, got: %s", BackendPortAnnotation, value)
+		}
+		return int32(port), nil
+	}
+
+	// Resolve a named container port
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == value {
+				return port.ContainerPort, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("%s: no container port named %s", BackendPortAnnotation, value)
+}
+
+func injectRequested(annotations map[string]string) bool {
+	inject, _ := strconv.ParseBool(annotations[InjectAnnotation])
+	return inject
+}
+
+// splitList splits a comma-separated annotation, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// escapePointer escapes a JSON pointer (RFC 6901) reference token
+func escapePointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+func deny(err error) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: err.Error(),
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+		},
+	}
+}
@@ -0,0 +1,319 @@
+package injector
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testConfig() *Config {
+	cfg := &Config{
+		Image: "quay.io/example/console-auth-proxy:latest",
+		Env: []corev1.EnvVar{
+			{Name: "CAP_AUTH_ISSUER_URL", Value: "https://idp.example.com"},
+		},
+	}
+	cfg.SetDefaults()
+	return cfg
+}
+
+func admissionRequest(t *testing.T, kind string, obj interface{}) *admissionv1.AdmissionRequest {
+	raw, err := json.Marshal(obj)
+	require.NoError(t, err)
+	return &admissionv1.AdmissionRequest{
+		UID:       "test-uid",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: kind},
+		Namespace: "default",
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func decodePatch(t *testing.T, resp *admissionv1.AdmissionResponse) []patchOperation {
+	require.True(t, resp.Allowed, "response denied: %v", resp.Result)
+	if resp.Patch == nil {
+		return nil
+	}
+	var patch []patchOperation
+	require.NoError(t, json.Unmarshal(resp.Patch, &patch))
+	return patch
+}
+
+func testPod(annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Annotations: annotations},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "app",
+				Image: "app:latest",
+				Ports: []corev1.ContainerPort{
+					{Name: "http", ContainerPort: 8080},
+					{Name: "metrics", ContainerPort: 9090},
+				},
+			}},
+		},
+	}
+}
+
+func envValue(env []corev1.EnvVar, name string) (string, bool) {
+	value, found := "", false
+	// Later entries win, as in Kubernetes
+	for _, e := range env {
+		if e.Name == name {
+			value, found = e.Value, true
+		}
+	}
+	return value, found
+}
+
+func TestMutatePod(t *testing.T) {
+	wh := NewWebhook(testConfig(), nil)
+
+	pod := testPod(map[string]string{
+		InjectAnnotation:        "true",
+		BackendPortAnnotation:   "http",
+		AllowedGroupsAnnotation: "admins, data-science",
+		PublicPathsAnnotation:   "/static/,/favicon.ico",
+	})
+
+	patch := decodePatch(t, wh.Mutate(admissionRequest(t, "Pod", pod)))
+	require.Len(t, patch, 2)
+
+	assert.Equal(t, "/spec/containers/-", patch[0].Path)
+	raw, err := json.Marshal(patch[0].Value)
+	require.NoError(t, err)
+	var container corev1.Container
+	require.NoError(t, json.Unmarshal(raw, &container))
+
+	assert.Equal(t, DefaultContainerName, container.Name)
+	assert.Equal(t, "quay.io/example/console-auth-proxy:latest", container.Image)
+	require.Len(t, container.Ports, 1)
+	assert.Equal(t, DefaultContainerName, container.Ports[0].Name)
+	assert.Equal(t, int32(DefaultPort), container.Ports[0].ContainerPort)
+
+	for name, expected := range map[string]string{
+		"CAP_AUTH_ISSUER_URL":       "https://idp.example.com",
+		"CAP_SERVER_LISTEN_ADDRESS": "0.0.0.0:8180",
+		"CAP_PROXY_BACKEND_URL":     "http://127.0.0.1:8080",
+		"CAP_AUTH_ALLOWED_GROUPS":   "admins,data-science",
+		"CAP_PROXY_PUBLIC_PATHS":    "/static/,/favicon.ico",
+	} {
+		value, ok := envValue(container.Env, name)
+		assert.True(t, ok, "missing %s", name)
+		assert.Equal(t, expected, value, name)
+	}
+
+	assert.Equal(t, "/metadata/annotations/auth-proxy.opendatahub.io~1status", patch[1].Path)
+	assert.Equal(t, statusInjected, patch[1].Value)
+}
+
+func TestMutatePodSkipped(t *testing.T) {
+	wh := NewWebhook(testConfig(), nil)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+	}{
+		{"not annotated", nil},
+		{"disabled", map[string]string{InjectAnnotation: "false"}},
+		{"already injected", map[string]string{InjectAnnotation: "true", StatusAnnotation: statusInjected}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch := decodePatch(t, wh.Mutate(admissionRequest(t, "Pod", testPod(tt.annotations))))
+			assert.Empty(t, patch)
+		})
+	}
+}
+
+func TestMutatePodBackendPort(t *testing.T) {
+	wh := NewWebhook(testConfig(), nil)
+
+	tests := []struct {
+		name    string
+		port    string
+		want    string
+		wantErr bool
+	}{
+		{name: "number", port: "9090", want: "http://127.0.0.1:9090"},
+		{name: "name", port: "metrics", want: "http://127.0.0.1:9090"},
+		{name: "unknown name", port: "grpc", wantErr: true},
+		{name: "out of range", port: "70000", wantErr: true},
+		{name: "ambiguous", port: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{InjectAnnotation: "true"}
+			if tt.port != "" {
+				annotations[BackendPortAnnotation] = tt.port
+			}
+
+			resp := wh.Mutate(admissionRequest(t, "Pod", testPod(annotations)))
+			if tt.wantErr {
+				assert.False(t, resp.Allowed)
+				return
+			}
+
+			patch := decodePatch(t, resp)
+			require.NotEmpty(t, patch)
+			container := patch[0].Value.(map[string]interface{})
+			raw, _ := json.Marshal(container["env"])
+			var env []corev1.EnvVar
+			require.NoError(t, json.Unmarshal(raw, &env))
+			value, _ := envValue(env, "CAP_PROXY_BACKEND_URL")
+			assert.Equal(t, tt.want, value)
+		})
+	}
+}
+
+func TestMutatePodInvalidPublicPath(t *testing.T) {
+	wh := NewWebhook(testConfig(), nil)
+
+	pod := testPod(map[string]string{
+		InjectAnnotation:      "true",
+		BackendPortAnnotation: "8080",
+		PublicPathsAnnotation: "static",
+	})
+	assert.False(t, wh.Mutate(admissionRequest(t, "Pod", pod)).Allowed)
+}
+
+func TestMutatePodAllowedGroupsWithoutGroups(t *testing.T) {
+	pod := testPod(map[string]string{
+		InjectAnnotation:        "true",
+		BackendPortAnnotation:   "8080",
+		AllowedGroupsAnnotation: "admins",
+	})
+
+	for _, env := range []corev1.EnvVar{
+		{Name: "CAP_AUTH_AUTH_SOURCE", Value: "openshift"},
+		{Name: "CAP_AUTH_SOURCE", Value: "mtls"},
+		{Name: "CAP_AUTH_MTLS_ENABLED", Value: "true"},
+	} {
+		cfg := testConfig()
+		cfg.Env = append(cfg.Env, env)
+		assert.False(t, NewWebhook(cfg, nil).Mutate(admissionRequest(t, "Pod", pod)).Allowed, "%s=%s", env.Name, env.Value)
+	}
+}
+
+func TestMutateService(t *testing.T) {
+	wh := NewWebhook(testConfig(), nil)
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "app",
+			Annotations: map[string]string{
+				InjectAnnotation:      "true",
+				BackendPortAnnotation: "http",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstr.FromString("http")},
+				{Name: "metrics", Port: 9090},
+			},
+		},
+	}
+
+	patch := decodePatch(t, wh.Mutate(admissionRequest(t, "Service", service)))
+	require.Len(t, patch, 1)
+	assert.Equal(t, "/spec/ports/0/targetPort", patch[0].Path)
+	assert.Equal(t, DefaultContainerName, patch[0].Value)
+
+	// Only the annotated port is rewritten when matched by number
+	service.Annotations[BackendPortAnnotation] = "9090"
+	patch = decodePatch(t, wh.Mutate(admissionRequest(t, "Service", service)))
+	require.Len(t, patch, 1)
+	assert.Equal(t, "/spec/ports/1/targetPort", patch[0].Path)
+
+	// A service without a matching port is rejected
+	service.Annotations[BackendPortAnnotation] = "8443"
+	assert.False(t, wh.Mutate(admissionRequest(t, "Service", service)).Allowed)
+
+	// The backend port may be omitted for single port services
+	delete(service.Annotations, BackendPortAnnotation)
+	service.Spec.Ports = service.Spec.Ports[:1]
+	patch = decodePatch(t, wh.Mutate(admissionRequest(t, "Service", service)))
+	require.Len(t, patch, 1)
+
+	// Services already pointing at the sidecar are left alone
+	service.Spec.Ports[0].TargetPort = intstr.FromString(DefaultContainerName)
+	assert.Empty(t, decodePatch(t, wh.Mutate(admissionRequest(t, "Service", service))))
+}
+
+func TestMutateServiceNamedTargetPort(t *testing.T) {
+	pod := testPod(nil)
+	pod.Namespace = "notebooks"
+	pod.Labels = map[string]string{"app": "notebook"}
+	wh := NewWebhook(testConfig(), fake.NewSimpleClientset(pod).CoreV1())
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "notebooks",
+			Annotations: map[string]string{
+				InjectAnnotation:      "true",
+				BackendPortAnnotation: "8080",
+			},
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": "notebook"},
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstr.FromString("http")},
+				{Name: "metrics", Port: 9090},
+			},
+		},
+	}
+
+	// The named target port resolves to 8080 on the selected pod
+	patch := decodePatch(t, wh.Mutate(admissionRequest(t, "Service", service)))
+	require.Len(t, patch, 1)
+	assert.Equal(t, "/spec/ports/0/targetPort", patch[0].Path)
+
+	// Without selected pods the name can't be resolved
+	service.Spec.Selector = map[string]string{"app": "other"}
+	assert.False(t, wh.Mutate(admissionRequest(t, "Service", service)).Allowed)
+}
+
+func TestServeHTTP(t *testing.T) {
+	wh := NewWebhook(testConfig(), nil)
+
+	pod := testPod(map[string]string{InjectAnnotation: "true", BackendPortAnnotation: "8080"})
+	review := &admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request:  admissionRequest(t, "Pod", pod),
+	}
+	body, err := json.Marshal(review)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	wh.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	var response admissionv1.AdmissionReview
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, "AdmissionReview", response.Kind)
+	require.NotNil(t, response.Response)
+	assert.Equal(t, review.Request.UID, response.Response.UID)
+	assert.True(t, response.Response.Allowed)
+	assert.NotEmpty(t, response.Response.Patch)
+
+	// Non-JSON requests are rejected
+	req = httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(body))
+	rec = httptest.NewRecorder()
+	wh.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+}
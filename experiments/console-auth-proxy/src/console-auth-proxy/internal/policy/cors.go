@@ -0,0 +1,199 @@
+package policy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+)
+
+// corsRule is a compiled config.CORSRule
+type corsRule struct {
+	pathPrefix       string
+	anyOrigin        bool
+	origins          map[string]bool
+	wildcardOrigins  []wildcardOrigin
+	methods          map[string]bool
+	headers          map[string]bool
+	anyHeader        bool
+	allowMethods     string
+	allowHeaders     string
+	exposeHeaders    string
+	allowCredentials bool
+	maxAge           string
+}
+
+// wildcardOrigin matches origins like https://*.example.com
+type wildcardOrigin struct {
+	prefix string // "https://"
+	suffix string // ".example.com"
+}
+
+func newCORSRule(rule config.CORSRule) *corsRule {
+	c := &corsRule{
+		pathPrefix:       rule.PathPrefix,
+		origins:          make(map[string]bool),
+		methods:          make(map[string]bool),
+		headers:          make(map[string]bool),
+		allowMethods:     strings.Join(rule.AllowedMethods, ", "),
+		allowHeaders:     strings.Join(rule.AllowedHeaders, ", "),
+		exposeHeaders:    strings.Join(rule.ExposedHeaders, ", "),
+		allowCredentials: rule.AllowCredentials,
+	}
+
+	for _, origin := range rule.AllowedOrigins {
+		switch {
+		case origin == "*":
+			c.anyOrigin = true
+		case strings.Contains(origin, "://*."):
+			scheme, host, _ := strings.Cut(origin, "://*")
+			c.wildcardOrigins = append(c.wildcardOrigins, wildcardOrigin{
+				prefix: strings.ToLower(scheme + "://"),
+				suffix: strings.ToLower(host),
+			})
+		default:
+			c.origins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+		}
+	}
+
+	for _, method := range rule.AllowedMethods {
+		c.methods[strings.ToUpper(method)] = true
+	}
+
+	for _, header := range rule.AllowedHeaders {
+		if header == "*" {
+			c.anyHeader = true
+		}
+		c.headers[http.CanonicalHeaderKey(header)] = true
+	}
+
+	if rule.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(int(rule.MaxAge.Seconds()))
+	}
+
+	return c
+}
+
+func (c *corsRule) matchesPath(path string) bool {
+	return c.pathPrefix == "/" || path == c.pathPrefix ||
+		strings.HasPrefix(path, strings.TrimSuffix(c.pathPrefix, "/")+"/")
+}
+
+func (c *corsRule) allowsOrigin(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if c.origins[origin] {
+		return true
+	}
+
+	for _, w := range c.wildcardOrigins {
+		if strings.HasPrefix(origin, w.prefix) && strings.HasSuffix(origin, w.suffix) &&
+			len(origin) > len(w.prefix)+len(w.suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listsOrigin checks whether the origin is listed explicitly, not through "*"
+// or a wildcard
+func (c *corsRule) listsOrigin(origin string) bool {
+	return c.origins[strings.ToLower(origin)]
+}
+
+func (c *corsRule) allowsMethod(method string) bool {
+	return c.methods[strings.ToUpper(method)]
+}
+
+// allowsHeaders checks the comma separated Access-Control-Request-Headers value
+func (c *corsRule) allowsHeaders(requested string) bool {
+	if c.anyHeader || requested == "" {
+		return true
+	}
+
+	for _, header := range strings.Split(requested, ",") {
+		header = strings.TrimSpace(header)
+		if header != "" && !c.headers[http.CanonicalHeaderKey(header)] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isPreflight checks whether the request is a CORS preflight request
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// handlePreflight answers a preflight request without authentication, since
+// browsers never send credentials on preflights
+func (c *corsRule) handlePreflight(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	requestMethod := r.Header.Get("Access-Control-Request-Method")
+	requestHeaders := r.Header.Get("Access-Control-Request-Headers")
+
+	w.Header().Add("Vary", "Origin")
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	if !c.allowsOrigin(origin) || !c.allowsMethod(requestMethod) || !c.allowsHeaders(requestHeaders) {
+		klog.V(4).Infof("Rejected CORS preflight from %s for %s %s", origin, requestMethod, r.URL.Path)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	c.setOriginHeaders(w.Header(), origin)
+	w.Header().Set("Access-Control-Allow-Methods", c.allowMethods)
+	if c.anyHeader && requestHeaders != "" {
+		w.Header().Set("Access-Control-Allow-Headers", requestHeaders)
+	} else if c.allowHeaders != "" {
+		w.Header().Set("Access-Control-Allow-Headers", c.allowHeaders)
+	}
+	if c.maxAge != "" {
+		w.Header().Set("Access-Control-Max-Age", c.maxAge)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setOriginHeaders sets the headers shared by preflight and actual responses
+func (c *corsRule) setOriginHeaders(h http.Header, origin string) {
+	if c.anyOrigin && !c.allowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if c.allowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// applyResponseHeaders replaces any CORS headers set by the backend with the configured policy
+func (c *corsRule) applyResponseHeaders(h http.Header, origin string) {
+	for name := range h {
+		if strings.HasPrefix(name, "Access-Control-") {
+			h.Del(name)
+		}
+	}
+
+	h.Add("Vary", "Origin")
+	if !c.allowsOrigin(origin) {
+		return
+	}
+
+	c.setOriginHeaders(h, origin)
+	if c.exposeHeaders != "" {
+		h.Set("Access-Control-Expose-Headers", c.exposeHeaders)
+	}
+}
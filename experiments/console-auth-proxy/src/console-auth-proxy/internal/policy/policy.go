@@ -0,0 +1,179 @@
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+	"github.com/your-org/console-auth-proxy/pkg/auth/csrfverifier"
+)
+
+// Engine applies CORS and security response header policies
+type Engine struct {
+	corsRules []*corsRule
+	headers   map[string]string
+	hsts      string
+	override  bool
+	disabled  bool
+}
+
+// New creates a policy engine from configuration
+func New(cfg *config.PolicyConfig) *Engine {
+	e := &Engine{
+		headers:  make(map[string]string),
+		override: cfg.SecurityHeaders.Override,
+		disabled: cfg.SecurityHeaders.Disabled,
+	}
+
+	for _, rule := range cfg.CORS {
+		e.corsRules = append(e.corsRules, newCORSRule(rule))
+	}
+
+	sh := cfg.SecurityHeaders
+	setIfNotEmpty := func(name, value string) {
+		if value != "" {
+			e.headers[name] = value
+		}
+	}
+	setIfNotEmpty("Content-Security-Policy", sh.ContentSecurityPolicy)
+	setIfNotEmpty("X-Frame-Options", sh.FrameOptions)
+	setIfNotEmpty("Referrer-Policy", sh.ReferrerPolicy)
+	setIfNotEmpty("X-Content-Type-Options", sh.ContentTypeOptions)
+	for name, value := range sh.Custom {
+		setIfNotEmpty(http.CanonicalHeaderKey(name), value)
+	}
+
+	if !sh.HSTS.Disabled && sh.HSTS.MaxAge > 0 {
+		e.hsts = "max-age=" + strconv.Itoa(int(sh.HSTS.MaxAge.Seconds()))
+		if sh.HSTS.IncludeSubDomains {
+			e.hsts += "; includeSubDomains"
+		}
+		if sh.HSTS.Preload {
+			e.hsts += "; preload"
+		}
+	}
+
+	return e
+}
+
+// Handler wraps next with CORS handling and security response headers
+func (e *Engine) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		rule := e.matchCORS(r)
+
+		if rule != nil && isPreflight(r) {
+			e.setSecurityHeaders(w.Header(), r)
+			rule.handlePreflight(w, r)
+			return
+		}
+
+		pw := &policyResponseWriter{ResponseWriter: w}
+		pw.beforeWrite = func(h http.Header) {
+			if rule != nil && origin != "" {
+				rule.applyResponseHeaders(h, origin)
+			}
+			e.setSecurityHeaders(h, r)
+		}
+
+		next.ServeHTTP(pw, r)
+	})
+}
+
+// CSRFExemption exempts cross-origin requests from origins listed explicitly on
+// a credentialed CORS rule for the requested path and method. Those origins
+// can't read the CSRF cookie, so the Origin check takes the place of the token
+// check. Wildcard origins and rules without allow_credentials are never exempt:
+// SameSite cookies are still sent from sibling subdomains, which could forge
+// requests otherwise.
+func (e *Engine) CSRFExemption() csrfverifier.Exemption {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return false
+		}
+
+		rule := e.matchCORS(r)
+		return rule != nil && rule.allowCredentials && rule.listsOrigin(origin) && rule.allowsMethod(r.Method)
+	}
+}
+
+// matchCORS returns the first CORS rule matching the request path
+func (e *Engine) matchCORS(r *http.Request) *corsRule {
+	for _, rule := range e.corsRules {
+		if rule.matchesPath(r.URL.Path) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// setSecurityHeaders adds the configured security headers, keeping values set
+// by the backend unless override is enabled
+func (e *Engine) setSecurityHeaders(h http.Header, r *http.Request) {
+	if e.disabled {
+		return
+	}
+
+	set := func(name, value string) {
+		if e.override || h.Get(name) == "" {
+			h.Set(name, value)
+		}
+	}
+
+	for name, value := range e.headers {
+		set(name, value)
+	}
+
+	if e.hsts != "" && (r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https") {
+		set("Strict-Transport-Security", e.hsts)
+	}
+}
+
+// policyResponseWriter applies policy headers right before the response headers are written
+type policyResponseWriter struct {
+	http.ResponseWriter
+	beforeWrite func(http.Header)
+	wroteHeader bool
+}
+
+func (w *policyResponseWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.beforeWrite(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *policyResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *policyResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack supports WebSocket upgrades through the proxy
+func (w *policyResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("underlying response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer
+func (w *policyResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,147 @@
+package policy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+)
+
+func newTestEngine() *Engine {
+	cfg := &config.Config{
+		Policy: config.PolicyConfig{
+			CORS: []config.CORSRule{
+				{
+					PathPrefix:       "/api",
+					AllowedOrigins:   []string{"https://dashboard.example.com", "https://*.apps.example.com"},
+					AllowedMethods:   []string{"GET", "POST"},
+					ExposedHeaders:   []string{"X-Request-ID"},
+					AllowCredentials: true,
+					MaxAge:           10 * time.Minute,
+				},
+			},
+		},
+	}
+	cfg.SetDefaults()
+	return New(&cfg.Policy)
+}
+
+func TestPreflight(t *testing.T) {
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("preflight reached backend")
+	})
+	handler := newTestEngine().Handler(backend)
+
+	for _, tc := range []struct {
+		name     string
+		origin   string
+		method   string
+		headers  string
+		expected int
+	}{
+		{"allowed", "https://dashboard.example.com", "POST", "content-type", http.StatusNoContent},
+		{"wildcard", "https://grafana.apps.example.com", "GET", "", http.StatusNoContent},
+		{"bad origin", "https://evil.example.com", "POST", "", http.StatusForbidden},
+		{"bad wildcard", "https://apps.example.com", "POST", "", http.StatusForbidden},
+		{"bad method", "https://dashboard.example.com", "DELETE", "", http.StatusForbidden},
+		{"bad header", "https://dashboard.example.com", "POST", "X-Custom", http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodOptions, "/api/items", nil)
+			r.Header.Set("Origin", tc.origin)
+			r.Header.Set("Access-Control-Request-Method", tc.method)
+			if tc.headers != "" {
+				r.Header.Set("Access-Control-Request-Headers", tc.headers)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tc.expected, w.Code)
+			if tc.expected == http.StatusNoContent {
+				assert.Equal(t, tc.origin, w.Header().Get("Access-Control-Allow-Origin"))
+				assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+				assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+			} else {
+				assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+			}
+		})
+	}
+}
+
+func TestActualRequestHeaders(t *testing.T) {
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Write([]byte("ok"))
+	})
+	handler := newTestEngine().Handler(backend)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/items", nil)
+	r.Header.Set("Origin", "https://dashboard.example.com")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, "https://dashboard.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Request-ID", w.Header().Get("Access-Control-Expose-Headers"))
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"), "backend value is kept")
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "strict-origin-when-cross-origin", w.Header().Get("Referrer-Policy"))
+	assert.Equal(t, "frame-ancestors 'self'", w.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "max-age=31536000", w.Header().Get("Strict-Transport-Security"))
+
+	r = httptest.NewRequest(http.MethodGet, "/other", nil)
+	r.Header.Set("Origin", "https://dashboard.example.com")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"), "unmatched paths keep backend CORS headers")
+	assert.Empty(t, w.Header().Get("Strict-Transport-Security"), "HSTS is only sent over HTTPS")
+}
+
+func TestCSRFExemption(t *testing.T) {
+	exempt := newTestEngine().CSRFExemption()
+
+	for _, tc := range []struct {
+		method   string
+		path     string
+		origin   string
+		expected bool
+	}{
+		{"POST", "/api/items", "https://dashboard.example.com", true},
+		{"POST", "/api/items", "", false},
+		{"POST", "/api/items", "https://evil.example.com", false},
+		{"DELETE", "/api/items", "https://dashboard.example.com", false},
+		{"POST", "/admin", "https://dashboard.example.com", false},
+		{"POST", "/api/items", "https://grafana.apps.example.com", false},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.origin != "" {
+			r.Header.Set("Origin", tc.origin)
+		}
+		assert.Equal(t, tc.expected, exempt(r), "%s %s from %q", tc.method, tc.path, tc.origin)
+	}
+}
+
+func TestCSRFExemptionRequiresCredentialedOrigin(t *testing.T) {
+	cfg := &config.Config{
+		Policy: config.PolicyConfig{
+			CORS: []config.CORSRule{
+				{PathPrefix: "/public", AllowedOrigins: []string{"*"}, AllowedMethods: []string{"POST"}, AllowCredentials: true},
+				{PathPrefix: "/api", AllowedOrigins: []string{"https://dashboard.example.com"}, AllowedMethods: []string{"POST"}},
+			},
+		},
+	}
+	cfg.SetDefaults()
+	exempt := New(&cfg.Policy).CSRFExemption()
+
+	for _, path := range []string{"/public/items", "/api/items"} {
+		r := httptest.NewRequest(http.MethodPost, path, nil)
+		r.Header.Set("Origin", "https://dashboard.example.com")
+		assert.False(t, exempt(r), path)
+	}
+}
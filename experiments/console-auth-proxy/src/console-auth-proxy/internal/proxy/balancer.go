@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+)
+
+// defaultMaxEjectionTime caps the ejection time when max_ejection_time is unset
+const defaultMaxEjectionTime = 5 * time.Minute
+
+// endpoint is a single backend instance
+type endpoint struct {
+	url *url.URL
+
+	// Requests currently in flight, used for least-connections balancing
+	active atomic.Int64
+
+	mu                  sync.Mutex
+	consecutiveFailures int
+	ejections           int
+	ejectedUntil        time.Time
+}
+
+func (e *endpoint) isEjected(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return now.Before(e.ejectedUntil)
+}
+
+// balancer picks endpoints and passively ejects the ones that keep failing
+type balancer struct {
+	endpoints        []*endpoint
+	leastConnections bool
+	outlier          config.OutlierDetectionConfig
+	next             atomic.Uint64
+	now              func() time.Time
+}
+
+func newBalancer(backend *config.BackendConfig, outlier config.OutlierDetectionConfig) (*balancer, error) {
+	if outlier.MaxEjectionTime <= 0 {
+		outlier.MaxEjectionTime = defaultMaxEjectionTime
+	}
+
+	b := &balancer{
+		leastConnections: backend.LoadBalancing == "least_connections",
+		outlier:          outlier,
+		now:              time.Now,
+	}
+
+	endpoints := backend.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = []string{backend.URL}
+	}
+
+	for _, raw := range endpoints {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backend endpoint %s: %w", raw, err)
+		}
+		b.endpoints = append(b.endpoints, &endpoint{url: u})
+	}
+
+	return b, nil
+}
+
+// urls returns the URLs of all endpoints
+func (b *balancer) urls() []*url.URL {
+	urls := make([]*url.URL, 0, len(b.endpoints))
+	for _, e := range b.endpoints {
+		urls = append(urls, e.url)
+	}
+	return urls
+}
+
+// hasTLSEndpoints reports whether any endpoint is reached over HTTPS
+func (b *balancer) hasTLSEndpoints() bool {
+	for _, e := range b.endpoints {
+		if e.url.Scheme == "https" {
+			return true
+		}
+	}
+	return false
+}
+
+// pick returns an available endpoint that isn't in tried, or nil if there is none
+func (b *balancer) pick(tried map[*endpoint]bool) *endpoint {
+	now := b.now()
+	candidates := make([]*endpoint, 0, len(b.endpoints))
+	for _, e := range b.endpoints {
+		if !tried[e] && !e.isEjected(now) {
+			candidates = append(candidates, e)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	if b.leastConnections {
+		// Start from a rotating offset so ties are spread across endpoints
+		offset := int(b.next.Add(1) % uint64(len(candidates)))
+		best := candidates[offset]
+		for i := 1; i < len(candidates); i++ {
+			e := candidates[(offset+i)%len(candidates)]
+			if e.active.Load() < best.active.Load() {
+				best = e
+			}
+		}
+		return best
+	}
+
+	return candidates[int(b.next.Add(1)%uint64(len(candidates)))]
+}
+
+// recordSuccess resets the failure streak of an endpoint. Once an ejected
+// endpoint is back, each success also lowers its ejection count so the next
+// ejection is shorter.
+func (b *balancer) recordSuccess(e *endpoint) {
+	now := b.now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.consecutiveFailures = 0
+	if e.ejections > 0 && !now.Before(e.ejectedUntil) {
+		e.ejections--
+	}
+}
+
+// recordFailure counts a 5xx or connect error and ejects the endpoint once it
+// reaches the configured threshold, unless too many endpoints are ejected already
+func (b *balancer) recordFailure(e *endpoint) {
+	if b.outlier.ConsecutiveFailures <= 0 {
+		return
+	}
+
+	now := b.now()
+	e.mu.Lock()
+	e.consecutiveFailures++
+	shouldEject := e.consecutiveFailures >= b.outlier.ConsecutiveFailures && !now.Before(e.ejectedUntil)
+	e.mu.Unlock()
+
+	if !shouldEject || !b.canEject(now) {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ejections++
+	ejectionTime := time.Duration(e.ejections) * b.outlier.BaseEjectionTime
+	if ejectionTime > b.outlier.MaxEjectionTime {
+		ejectionTime = b.outlier.MaxEjectionTime
+	}
+	e.ejectedUntil = now.Add(ejectionTime)
+	e.consecutiveFailures = 0
+
+	klog.Warningf("Ejecting backend endpoint %s for %v after %d consecutive failures",
+		e.url.Host, ejectionTime, b.outlier.ConsecutiveFailures)
+}
+
+// canEject checks whether one more endpoint may be ejected without exceeding MaxEjectionPercent
+func (b *balancer) canEject(now time.Time) bool {
+	ejected := 0
+	for _, e := range b.endpoints {
+		if e.isEjected(now) {
+			ejected++
+		}
+	}
+	return (ejected+1)*100 <= b.outlier.MaxEjectionPercent*len(b.endpoints)
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2"
+
+	"github.com/your-org/console-auth-proxy/pkg/auth/csrfverifier"
+)
+
+// gRPC status codes (https://grpc.github.io/grpc/core/md_doc_statuscodes.html)
+// for requests the proxy rejects itself
+const (
+	grpcPermissionDenied = 7
+	grpcUnavailable      = 14
+	grpcUnauthenticated  = 16
+)
+
+// isGRPC reports whether a request is a gRPC or gRPC-Web call
+func isGRPC(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// writeGRPCStatus rejects a gRPC call with a trailers-only response, which
+// gRPC and gRPC-Web clients report as the given status instead of an HTTP error
+func writeGRPCStatus(w http.ResponseWriter, r *http.Request, code int, message string) {
+	contentType := "application/grpc"
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc-web") {
+		contentType = r.Header.Get("Content-Type")
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", encodeGRPCMessage(message))
+	w.WriteHeader(http.StatusOK)
+}
+
+// encodeGRPCMessage percent-encodes a status message as the gRPC protocol requires
+func encodeGRPCMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// grpcCSRFExemption exempts requests with the given content types from the
+// CSRF token check. Browsers only send them cross-origin after a CORS
+// preflight, so they can't be forged by another site.
+func grpcCSRFExemption(contentTypes []string) csrfverifier.Exemption {
+	return func(r *http.Request) bool {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			return false
+		}
+		for _, contentType := range contentTypes {
+			contentType = strings.ToLower(contentType)
+			if mediaType == contentType || strings.HasPrefix(mediaType, contentType+"+") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// newH2CTransport creates a transport speaking cleartext HTTP/2 with prior
+// knowledge, as gRPC servers without TLS expect
+func newH2CTransport(dialer *net.Dialer) *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
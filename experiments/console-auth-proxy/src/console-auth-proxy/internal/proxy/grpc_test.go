@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+	"github.com/your-org/console-auth-proxy/internal/ratelimit"
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+)
+
+// bearerAuthenticator accepts "Bearer <username>" for a fixed set of users
+type bearerAuthenticator struct {
+	auth.Authenticator
+	groups map[string][]string
+}
+
+func (a *bearerAuthenticator) Authenticate(w http.ResponseWriter, r *http.Request) (*auth.User, error) {
+	username := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	groups, ok := a.groups[username]
+	if !ok {
+		return nil, errors.New("unknown user")
+	}
+	return &auth.User{ID: username, Username: username, Groups: groups, Token: "upstream-" + username}, nil
+}
+
+// startGRPCBackend serves the health service over cleartext HTTP/2. The handler
+// echoes the identity header set by the proxy in a trailer.
+func startGRPCBackend(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := grpc.NewServer(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		if method != "/grpc.health.v1.Health/Check" {
+			return status.Errorf(codes.Unimplemented, "unknown method %s", method)
+		}
+
+		request := &healthpb.HealthCheckRequest{}
+		if err := stream.RecvMsg(request); err != nil {
+			return err
+		}
+
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		stream.SetTrailer(metadata.Pairs("x-backend-user", strings.Join(md.Get("x-forwarded-user"), ",")))
+		return stream.SendMsg(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING})
+	}))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	return listener.Addr().String()
+}
+
+func newGRPCTestProxy(t *testing.T, backendAddr string, modify func(*config.Config)) *httptest.Server {
+	cfg := &config.Config{}
+	cfg.Proxy.Backend.URL = "http://" + backendAddr
+	cfg.Proxy.Backend.Protocol = "h2c"
+	cfg.Proxy.Headers.UserHeader = "X-Forwarded-User"
+	cfg.Auth.RedirectURL = "https://proxy.example.com/auth/callback"
+	cfg.Auth.SecureCookies = true
+	cfg.Auth.CLI.Disabled = true
+	if modify != nil {
+		modify(cfg)
+	}
+	cfg.SetDefaults()
+
+	authenticator := &bearerAuthenticator{groups: map[string][]string{
+		"alice": {"ml-users"},
+		"bob":   {"guests"},
+	}}
+	limiter, err := ratelimit.New(&cfg.Proxy.RateLimit, ratelimit.NewMemoryStore())
+	require.NoError(t, err)
+
+	ap, err := NewAuthenticatedProxy(cfg, authenticator, nil, limiter, nil)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(h2c.NewHandler(ap, &http2.Server{}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func healthCheck(t *testing.T, proxyURL, username string) (*healthpb.HealthCheckResponse, metadata.MD, error) {
+	conn, err := grpc.NewClient(strings.TrimPrefix(proxyURL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx := context.Background()
+	if username != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+username)
+	}
+
+	var trailer metadata.MD
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Trailer(&trailer))
+	return resp, trailer, err
+}
+
+func TestGRPCProxy(t *testing.T) {
+	backend := startGRPCBackend(t)
+	server := newGRPCTestProxy(t, backend, func(cfg *config.Config) {
+		cfg.Auth.AllowedGroups = []string{"ml-users"}
+	})
+
+	// Authenticated calls reach the backend, which sees the injected identity.
+	// The backend's trailers make it back to the client.
+	resp, trailer, err := healthCheck(t, server.URL, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+	assert.Equal(t, []string{"alice"}, trailer.Get("x-backend-user"))
+
+	// Unauthenticated calls get a gRPC status instead of a login redirect
+	_, _, err = healthCheck(t, server.URL, "")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Users outside the allowed groups are denied
+	_, _, err = healthCheck(t, server.URL, "bob")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestGRPCProxyDenyRule(t *testing.T) {
+	backend := startGRPCBackend(t)
+	server := newGRPCTestProxy(t, backend, func(cfg *config.Config) {
+		cfg.Proxy.Rules = []config.AccessRule{{Path: "/grpc.health.v1.Health/*", Action: config.AccessDeny}}
+	})
+
+	_, _, err := healthCheck(t, server.URL, "alice")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestGRPCProxyCSRFExemptionDisabled(t *testing.T) {
+	backend := startGRPCBackend(t)
+	server := newGRPCTestProxy(t, backend, func(cfg *config.Config) {
+		cfg.Proxy.GRPC.CSRFExemptionDisabled = true
+	})
+
+	// Without the exemption gRPC calls fail the CSRF token check
+	_, _, err := healthCheck(t, server.URL, "alice")
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestGRPCCSRFExemption(t *testing.T) {
+	exempt := grpcCSRFExemption([]string{"application/grpc", "application/grpc-web"})
+
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"application/grpc", true},
+		{"application/grpc+proto", true},
+		{"application/grpc-web+proto", true},
+		{"Application/GRPC-Web; charset=utf-8", true},
+		{"application/grpc-web-text", false},
+		{"application/grpcx", false},
+		{"application/json", false},
+		{"text/plain", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/svc/Method", nil)
+		r.Header.Set("Content-Type", tt.contentType)
+		assert.Equal(t, tt.want, exempt(r), tt.contentType)
+	}
+}
+
+func TestWriteGRPCStatus(t *testing.T) {
+	r := httptest.NewRequest("POST", "/svc/Method", nil)
+	r.Header.Set("Content-Type", "application/grpc-web+proto")
+	rec := httptest.NewRecorder()
+	writeGRPCStatus(rec, r, grpcUnauthenticated, "100% über")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/grpc-web+proto", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "16", rec.Header().Get("Grpc-Status"))
+	assert.Equal(t, "100%25 %C3%BCber", rec.Header().Get("Grpc-Message"))
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -13,9 +14,15 @@ import (
 
 	"k8s.io/klog/v2"
 
+	"github.com/your-org/console-auth-proxy/internal/capture"
 	"github.com/your-org/console-auth-proxy/internal/config"
+	"github.com/your-org/console-auth-proxy/internal/policy"
+	"github.com/your-org/console-auth-proxy/internal/ratelimit"
 	"github.com/your-org/console-auth-proxy/pkg/auth"
+	"github.com/your-org/console-auth-proxy/pkg/auth/clitoken"
 	"github.com/your-org/console-auth-proxy/pkg/auth/csrfverifier"
+	"github.com/your-org/console-auth-proxy/pkg/auth/mtls"
+	"github.com/your-org/console-auth-proxy/pkg/tlsprofile"
 )
 
 // AuthenticatedProxy provides reverse proxy functionality with authentication
@@ -25,10 +32,18 @@ type AuthenticatedProxy struct {
 	config        *config.ProxyConfig
 	csrfVerifier  *csrfverifier.CSRFVerifier
 	backendURL    *url.URL
+	rewriter      *rewriter
+	stepUp        *stepUpEnforcer
+	limiter       *ratelimit.Limiter
+	rules         *accessRules
+	allowedGroups []string
+
+	// Header carrying verified client certificate details, empty if mTLS is disabled
+	clientCertHeader string
 }
 
 // NewAuthenticatedProxy creates a new authenticated reverse proxy
-func NewAuthenticatedProxy(cfg *config.Config, authenticator auth.Authenticator) (*AuthenticatedProxy, error) {
+func NewAuthenticatedProxy(cfg *config.Config, authenticator auth.Authenticator, policyEngine *policy.Engine, limiter *ratelimit.Limiter, tlsObserver *tlsprofile.Observer) (*AuthenticatedProxy, error) {
 	// Parse backend URL
 	backendURL, err := url.Parse(cfg.Proxy.Backend.URL)
 	if err != nil {
@@ -36,7 +51,13 @@ func NewAuthenticatedProxy(cfg *config.Config, authenticator auth.Authenticator)
 	}
 
 	// Create transport with custom timeouts and TLS settings
+	dialer := &net.Dialer{
+		Timeout:   cfg.Proxy.Timeouts.Dial,
+		KeepAlive: 30 * time.Second,
+	}
 	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
 		TLSHandshakeTimeout:   cfg.Proxy.Timeouts.TLSHandshake,
 		ResponseHeaderTimeout: cfg.Proxy.Timeouts.ResponseHeader,
 		ExpectContinueTimeout: cfg.Proxy.Timeouts.ExpectContinue,
@@ -45,12 +66,25 @@ func NewAuthenticatedProxy(cfg *config.Config, authenticator auth.Authenticator)
 		MaxIdleConnsPerHost:   cfg.Proxy.Timeouts.MaxIdleConnsPerHost,
 	}
 
+	// Create load balancer across backend endpoints
+	lb, err := newBalancer(&cfg.Proxy.Backend, cfg.Proxy.Timeouts.OutlierDetection)
+	if err != nil {
+		return nil, err
+	}
+
 	// Configure TLS if needed
-	if backendURL.Scheme == "https" {
+	if backendURL.Scheme == "https" || lb.hasTLSEndpoints() {
 		tlsConfig := &tls.Config{
 			InsecureSkipVerify: cfg.Proxy.TLS.InsecureSkipVerify,
 		}
 
+		// Restrict versions and algorithms to the configured profile
+		tlsProfile, err := tlsprofile.Get(cfg.TLSProfile)
+		if err != nil {
+			return nil, err
+		}
+		tlsProfile.Apply(tlsConfig)
+
 		// Set custom server name for SNI if provided
 		if cfg.Proxy.TLS.ServerName != "" {
 			tlsConfig.ServerName = cfg.Proxy.TLS.ServerName
@@ -90,17 +124,40 @@ func NewAuthenticatedProxy(cfg *config.Config, authenticator auth.Authenticator)
 		transport.TLSClientConfig = tlsConfig
 	}
 
+	// Create request/response rewriter for prefix-mounted backends
+	rw, err := newRewriter(&cfg.Proxy.Rewrite, append(lb.urls(), backendURL)...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Select the backend protocol, HTTP/2 carries gRPC and its trailers
+	var baseTransport http.RoundTripper = transport
+	switch cfg.Proxy.Backend.Protocol {
+	case "h2":
+		// A custom TLS config disables HTTP/2 unless it is forced
+		transport.ForceAttemptHTTP2 = true
+	case "h2c":
+		baseTransport = newH2CTransport(dialer)
+	}
+	baseTransport = tlsObserver.RoundTripper(tlsprofile.ComponentBackend, baseTransport)
+
 	// Create reverse proxy
+	balancedTransport := newBalancingTransport(baseTransport, lb, &cfg.Proxy.Timeouts)
 	proxy := httputil.NewSingleHostReverseProxy(backendURL)
-	proxy.Transport = transport
+	proxy.Transport = balancedTransport
+	proxy.ErrorHandler = balancedTransport.errorHandler
 
 	// Configure proxy director to modify requests
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
+		// Strip the public prefix before the original director joins the backend path
+		rw.rewriteRequest(req)
 		originalDirector(req)
+		capture.SetUpstreamHeaders(req)
 		// The original director already sets the target host and scheme
 		// We'll add additional modifications in the ServeHTTP method
 	}
+	proxy.ModifyResponse = rw.modifyResponse
 
 	// Create CSRF verifier
 	var csrfVerifier *csrfverifier.CSRFVerifier
@@ -111,6 +168,34 @@ func NewAuthenticatedProxy(cfg *config.Config, authenticator auth.Authenticator)
 			return nil, fmt.Errorf("invalid redirect URL for CSRF verifier: %w", err)
 		}
 		csrfVerifier = csrfverifier.NewCSRFVerifier(redirectURL, cfg.Auth.SecureCookies)
+
+		// Origins allowed by a CORS rule can't read the CSRF cookie
+		csrfVerifier.AddExemptions(policyEngine.CSRFExemption())
+
+		// Non-browser clients authenticating with a certificate have no CSRF cookie
+		if cfg.Auth.MTLSEnabled() {
+			csrfVerifier.AddExemptions(clientCertExemption)
+		}
+
+		// CLI clients send a bearer token instead of cookies
+		if !cfg.Auth.CLI.Disabled {
+			csrfVerifier.AddExemptions(clitoken.CSRFExempt)
+		}
+
+		// gRPC and gRPC-Web clients can't send the CSRF token
+		if !cfg.Proxy.GRPC.CSRFExemptionDisabled {
+			csrfVerifier.AddExemptions(grpcCSRFExemption(cfg.Proxy.GRPC.CSRFExemptContentTypes))
+		}
+	}
+
+	rules, err := newAccessRules(&cfg.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	var clientCertHeader string
+	if cfg.Auth.MTLSEnabled() {
+		clientCertHeader = cfg.Auth.MTLS.ForwardHeader
 	}
 
 	return &AuthenticatedProxy{
@@ -119,19 +204,43 @@ func NewAuthenticatedProxy(cfg *config.Config, authenticator auth.Authenticator)
 		config:        &cfg.Proxy,
 		csrfVerifier:  csrfVerifier,
 		backendURL:    backendURL,
+		rewriter:      rw,
+		stepUp:        newStepUpEnforcer(cfg.Auth.StepUp, cfg.Auth.SecureCookies),
+		limiter:       limiter,
+		rules:         rules,
+		allowedGroups: cfg.Auth.AllowedGroups,
+
+		clientCertHeader: clientCertHeader,
 	}, nil
 }
 
 // ServeHTTP implements the http.Handler interface
 func (ap *AuthenticatedProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Skip authentication for health checks and other special paths
-	if ap.shouldSkipAuth(r) {
-		ap.proxy.ServeHTTP(w, r)
-		return
+	// Apply the first matching access rule, requests are authenticated by default
+	if rule := ap.rules.match(r.Method, r.URL.Path); rule != nil {
+		switch rule.Action {
+		case config.AccessDeny:
+			klog.V(4).Infof("Denied %s %s by %s", r.Method, r.URL.Path, rule.name)
+			capture.SetDecision(r, "deny by %s", rule.name)
+			if isGRPC(r) {
+				writeGRPCStatus(w, r, grpcPermissionDenied, "access denied")
+				return
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		case config.AccessPublic:
+			capture.SetDecision(r, "public by %s", rule.name)
+			// Unauthenticated requests must not carry identity headers
+			ap.removeIdentityHeaders(r)
+			ap.proxy.ServeHTTP(w, r)
+			return
+		}
 	}
 
 	// CSRF verification for non-GET requests
 	if ap.csrfVerifier != nil && r.Method != "GET" && r.Method != "HEAD" && r.Method != "OPTIONS" {
+		// Replaced by the authentication decision if the CSRF check passes
+		capture.SetDecision(r, "rejected by CSRF check")
 		csrfHandler := ap.csrfVerifier.WithCSRFVerification(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// CSRF verification passed, continue with authentication
 			ap.handleWithAuth(w, r)
@@ -148,11 +257,41 @@ func (ap *AuthenticatedProxy) handleWithAuth(w http.ResponseWriter, r *http.Requ
 	user, err := ap.authenticator.Authenticate(w, r)
 	if err != nil {
 		klog.V(4).Infof("Authentication failed for %s %s: %v", r.Method, r.URL.Path, err)
+		capture.SetDecision(r, "unauthenticated: %v", err)
 		ap.redirectToLogin(w, r)
 		return
 	}
 
 	klog.V(6).Infof("Authenticated user %s for %s %s", user.Username, r.Method, r.URL.Path)
+	capture.SetUser(r, user.Username)
+
+	// Require a stronger authentication context for protected paths
+	if !ap.stepUp.enforce(w, r, user) {
+		capture.SetDecision(r, "step-up authentication required")
+		return
+	}
+
+	// Only members of the allowed groups may use the backend
+	if !ap.groupAllowed(user) {
+		klog.V(4).Infof("User %s is not in an allowed group for %s %s", user.Username, r.Method, r.URL.Path)
+		capture.SetDecision(r, "denied: not in an allowed group")
+		if isGRPC(r) {
+			writeGRPCStatus(w, r, grpcPermissionDenied, "not a member of an allowed group")
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(accessDeniedPage))
+		return
+	}
+
+	// Apply the per-user limit of the route
+	if !ap.limiter.AllowUser(w, r, user) {
+		capture.SetDecision(r, "rate limited")
+		return
+	}
+
+	capture.SetDecision(r, "authenticated")
 
 	// Set CSRF cookie if we have a verifier
 	if ap.csrfVerifier != nil {
@@ -169,8 +308,48 @@ func (ap *AuthenticatedProxy) handleWithAuth(w http.ResponseWriter, r *http.Requ
 	ap.proxy.ServeHTTP(w, r)
 }
 
+// groupAllowed reports whether the user is in one of the allowed groups
+func (ap *AuthenticatedProxy) groupAllowed(user *auth.User) bool {
+	if len(ap.allowedGroups) == 0 {
+		return true
+	}
+	for _, group := range user.Groups {
+		if containsString(ap.allowedGroups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeIdentityHeaders drops client-supplied values of the headers the proxy
+// sets for authenticated users
+func (ap *AuthenticatedProxy) removeIdentityHeaders(r *http.Request) {
+	for _, header := range []string{
+		ap.config.Headers.UserHeader,
+		ap.config.Headers.UserIDHeader,
+		ap.config.Headers.EmailHeader,
+		ap.config.Headers.GroupsHeader,
+		ap.clientCertHeader,
+	} {
+		if header != "" {
+			r.Header.Del(header)
+		}
+	}
+}
+
+// clientCertExemption exempts requests with a verified client certificate that
+// don't look like they come from a browser: no Origin header and no cookies
+func clientCertExemption(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0 &&
+		r.Header.Get("Origin") == "" && r.Header.Get("Cookie") == ""
+}
+
 // injectHeaders adds authentication and user identity headers to the request
 func (ap *AuthenticatedProxy) injectHeaders(r *http.Request, user *auth.User) {
+	// Identity headers only ever carry what the proxy sets below, client-supplied
+	// values are dropped even when the user has no value for a header
+	ap.removeIdentityHeaders(r)
+
 	// Add authorization header
 	if ap.config.Headers.AuthHeader != "" && user.Token != "" {
 		authValue := user.Token
@@ -189,6 +368,15 @@ func (ap *AuthenticatedProxy) injectHeaders(r *http.Request, user *auth.User) {
 		r.Header.Set(ap.config.Headers.UserIDHeader, user.ID)
 	}
 
+	if ap.config.Headers.GroupsHeader != "" && len(user.Groups) > 0 {
+		r.Header.Set(ap.config.Headers.GroupsHeader, strings.Join(user.Groups, ","))
+	}
+
+	// Forward verified client certificate details
+	if ap.clientCertHeader != "" && user.ClientCertificate != nil {
+		r.Header.Set(ap.clientCertHeader, mtls.FormatXFCC(user.ClientCertificate))
+	}
+
 	// Note: Email header would require extending the auth.User struct
 	// This is commented out to avoid modifying the console auth module
 	// if ap.config.Headers.EmailHeader != "" && user.Email != "" {
@@ -229,35 +417,19 @@ func (ap *AuthenticatedProxy) removeHeaders(r *http.Request) {
 	r.Header.Del("Cookie") // Let the backend handle its own cookies
 }
 
-// shouldSkipAuth determines if authentication should be skipped for a request
-func (ap *AuthenticatedProxy) shouldSkipAuth(r *http.Request) bool {
-	path := r.URL.Path
-
-	// Skip auth for health check paths
-	skipPaths := []string{
-		"/healthz",
-		"/readyz",
-		"/metrics",
-		"/version",
-		"/info",
-	}
-
-	for _, skipPath := range skipPaths {
-		if path == skipPath {
-			return true
-		}
-	}
-
-	// Skip auth for auth-related paths
-	if strings.HasPrefix(path, "/auth/") {
-		return true
-	}
-
-	return false
+// MatchRule describes the access rule applied to a request
+func (ap *AuthenticatedProxy) MatchRule(method, path string) RuleMatch {
+	return ap.rules.explain(method, path)
 }
 
 // redirectToLogin redirects the user to the login page
 func (ap *AuthenticatedProxy) redirectToLogin(w http.ResponseWriter, r *http.Request) {
+	// gRPC clients can't follow a login redirect
+	if isGRPC(r) {
+		writeGRPCStatus(w, r, grpcUnauthenticated, "authentication required")
+		return
+	}
+
 	// Store the original URL for redirect after login
 	originalURL := r.URL.String()
 	if originalURL != "/" {
@@ -299,4 +471,22 @@ func (ap *AuthenticatedProxy) HealthCheck() error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+const accessDeniedPage = `<!DOCTYPE html>
+<html>
+<head>
+    <title>Access Denied</title>
+    <style>
+        body { font-family: Arial, sans-serif; margin: 40px; }
+        .error { color: #d32f2f; background: #ffebee; padding: 20px; border-radius: 4px; }
+    </style>
+</head>
+<body>
+    <h1>Access Denied</h1>
+    <div class="error">
+        <p>You are signed in, but not a member of a group allowed to access this application.</p>
+        <p>Please contact your administrator if you believe you should have access.</p>
+    </div>
+</body>
+</html>`
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+)
+
+func TestRemoveIdentityHeaders(t *testing.T) {
+	ap := &AuthenticatedProxy{
+		config: &config.ProxyConfig{
+			Headers: config.HeaderConfig{UserHeader: "X-Forwarded-User", GroupsHeader: "X-Forwarded-Groups"},
+		},
+	}
+
+	r := httptest.NewRequest("GET", "/static/app.js", nil)
+	r.Header.Set("X-Forwarded-User", "admin")
+	r.Header.Set("X-Forwarded-Groups", "cluster-admins")
+	r.Header.Set("Accept", "text/html")
+	ap.removeIdentityHeaders(r)
+
+	assert.Empty(t, r.Header.Get("X-Forwarded-User"))
+	assert.Empty(t, r.Header.Get("X-Forwarded-Groups"))
+	assert.Equal(t, "text/html", r.Header.Get("Accept"))
+}
+
+func TestInjectHeadersDropsSpoofedIdentity(t *testing.T) {
+	ap := &AuthenticatedProxy{
+		config: &config.ProxyConfig{
+			Headers: config.HeaderConfig{
+				UserHeader:   "X-Forwarded-User",
+				UserIDHeader: "X-Forwarded-User-Id",
+				EmailHeader:  "X-Forwarded-Email",
+				GroupsHeader: "X-Forwarded-Groups",
+			},
+		},
+	}
+
+	r := httptest.NewRequest("GET", "/api/jobs", nil)
+	r.Header.Set("X-Forwarded-User", "admin")
+	r.Header.Set("X-Forwarded-User-Id", "admin-id")
+	r.Header.Set("X-Forwarded-Email", "admin@example.com")
+	r.Header.Set("X-Forwarded-Groups", "cluster-admins")
+
+	// The IdP gave no user ID, email or groups
+	ap.injectHeaders(r, &auth.User{Username: "alice"})
+
+	assert.Equal(t, "alice", r.Header.Get("X-Forwarded-User"))
+	assert.Empty(t, r.Header.Values("X-Forwarded-User-Id"))
+	assert.Empty(t, r.Header.Values("X-Forwarded-Email"))
+	assert.Empty(t, r.Header.Values("X-Forwarded-Groups"))
+}
+
+func TestGroupAllowed(t *testing.T) {
+	ap := &AuthenticatedProxy{}
+	assert.True(t, ap.groupAllowed(&auth.User{Username: "alice"}))
+
+	ap.allowedGroups = []string{"admins", "data-science"}
+	assert.True(t, ap.groupAllowed(&auth.User{Username: "alice", Groups: []string{"users", "data-science"}}))
+	assert.False(t, ap.groupAllowed(&auth.User{Username: "bob", Groups: []string{"users"}}))
+	assert.False(t, ap.groupAllowed(&auth.User{Username: "carol"}))
+}
@@ -0,0 +1,291 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+)
+
+var (
+	// Root-relative URLs in HTML attributes, e.g. href="/static/app.css"
+	htmlAttrPattern = regexp.MustCompile(`(?i)(\s(?:href|src|action|formaction|poster|data)\s*=\s*["'])(/[^"']*)`)
+	// Opening <head> tag, used to inject a <base> element
+	htmlHeadPattern = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+	htmlBasePattern = regexp.MustCompile(`(?i)<base\s`)
+)
+
+// rewriter adapts requests and responses for a backend mounted under a path prefix
+type rewriter struct {
+	config       *config.RewriteConfig
+	backendHosts map[string]bool
+	publicURL    *url.URL
+	contentTypes map[string]bool
+}
+
+// newRewriter creates a rewriter for the given configuration and backend
+// endpoints. Redirects to any of the endpoints are rewritten.
+func newRewriter(cfg *config.RewriteConfig, backendURLs ...*url.URL) (*rewriter, error) {
+	rw := &rewriter{
+		config:       cfg,
+		backendHosts: make(map[string]bool),
+		contentTypes: make(map[string]bool),
+	}
+
+	for _, backendURL := range backendURLs {
+		rw.backendHosts[backendURL.Host] = true
+	}
+
+	for _, host := range cfg.Location.InternalHosts {
+		rw.backendHosts[host] = true
+	}
+
+	if cfg.Location.PublicURL != "" {
+		publicURL, err := url.Parse(cfg.Location.PublicURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite public URL: %w", err)
+		}
+		rw.publicURL = publicURL
+	}
+
+	for _, contentType := range cfg.Body.ContentTypes {
+		rw.contentTypes[strings.ToLower(contentType)] = true
+	}
+
+	return rw, nil
+}
+
+// rewriteRequest strips the public path prefix before the request is sent to the backend
+func (rw *rewriter) rewriteRequest(req *http.Request) {
+	prefix := rw.config.PathPrefix
+	if prefix == "" {
+		return
+	}
+
+	if rw.config.StripPrefix && rw.hasPrefix(req.URL.Path) {
+		req.URL.Path = ensureLeadingSlash(strings.TrimPrefix(req.URL.Path, prefix))
+		if req.URL.RawPath != "" {
+			req.URL.RawPath = ensureLeadingSlash(strings.TrimPrefix(req.URL.RawPath, prefix))
+		}
+		req.Header.Set("X-Forwarded-Prefix", prefix)
+	}
+
+	// Bodies can only be rewritten when the backend doesn't compress them
+	if rw.config.Body.Enabled {
+		req.Header.Del("Accept-Encoding")
+	}
+}
+
+// modifyResponse rewrites Location, Set-Cookie and, optionally, the body of backend responses
+func (rw *rewriter) modifyResponse(resp *http.Response) error {
+	if rw.config.Location.Enabled {
+		if location := resp.Header.Get("Location"); location != "" {
+			if rewritten := rw.rewriteLocation(location); rewritten != location {
+				klog.V(4).Infof("Rewrote Location header from %s to %s", location, rewritten)
+				resp.Header.Set("Location", rewritten)
+			}
+		}
+	}
+
+	if rw.config.Cookies.RewritePath || rw.config.Cookies.Domain != "" {
+		cookies := resp.Header.Values("Set-Cookie")
+		if len(cookies) > 0 {
+			resp.Header.Del("Set-Cookie")
+			for _, cookie := range cookies {
+				resp.Header.Add("Set-Cookie", rw.rewriteSetCookie(cookie))
+			}
+		}
+	}
+
+	if rw.config.Body.Enabled && rw.shouldRewriteBody(resp) {
+		return rw.rewriteBody(resp)
+	}
+
+	return nil
+}
+
+// rewriteLocation maps redirects that point at the backend onto the public URL space
+func (rw *rewriter) rewriteLocation(location string) string {
+	locURL, err := url.Parse(location)
+	if err != nil {
+		return location // ignore unparseable URLs
+	}
+
+	if locURL.IsAbs() || strings.HasPrefix(location, "//") {
+		if !rw.backendHosts[locURL.Host] {
+			// Redirects to other hosts (e.g. the IdP) are left alone
+			return location
+		}
+		if rw.publicURL != nil {
+			locURL.Scheme = rw.publicURL.Scheme
+			locURL.Host = rw.publicURL.Host
+		} else {
+			// Convert absolute redirect to relative (preserves path/query)
+			locURL.Scheme = ""
+			locURL.Host = ""
+		}
+	} else if !strings.HasPrefix(locURL.Path, "/") {
+		// Document-relative redirects resolve correctly on their own
+		return location
+	}
+
+	locURL.Path = rw.prefixPath(locURL.Path)
+	if locURL.RawPath != "" {
+		locURL.RawPath = rw.prefixPath(locURL.RawPath)
+	}
+
+	return locURL.String()
+}
+
+// rewriteSetCookie adjusts the Path and Domain attributes of a Set-Cookie value.
+// The header is edited textually so attributes this proxy doesn't know about are kept.
+func (rw *rewriter) rewriteSetCookie(value string) string {
+	parts := strings.Split(value, ";")
+	result := []string{parts[0]}
+	hasPath := false
+
+	for _, part := range parts[1:] {
+		attr := strings.TrimSpace(part)
+		name, attrValue, _ := strings.Cut(attr, "=")
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "path":
+			hasPath = true
+			if rw.config.Cookies.RewritePath {
+				attr = "Path=" + rw.prefixPath(strings.TrimSpace(attrValue))
+			}
+		case "domain":
+			switch rw.config.Cookies.Domain {
+			case "":
+			case "-":
+				continue
+			default:
+				attr = "Domain=" + rw.config.Cookies.Domain
+			}
+		}
+
+		result = append(result, attr)
+	}
+
+	if rw.config.Cookies.RewritePath && !hasPath {
+		result = append(result, "Path="+rw.prefixPath("/"))
+	}
+
+	return strings.Join(result, "; ")
+}
+
+// shouldRewriteBody checks whether the response body is a rewritable, uncompressed document
+func (rw *rewriter) shouldRewriteBody(resp *http.Response) bool {
+	if resp.Body == nil || resp.Body == http.NoBody {
+		return false
+	}
+
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return false
+	}
+
+	if resp.ContentLength > rw.config.Body.MaxBodySize {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	return rw.contentTypes[strings.ToLower(mediaType)]
+}
+
+// rewriteBody buffers the response body and rewrites root-relative URLs in it
+func (rw *rewriter) rewriteBody(resp *http.Response) error {
+	limit := rw.config.Body.MaxBodySize
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return fmt.Errorf("failed to read response body for rewriting: %w", err)
+	}
+
+	if int64(len(body)) > limit {
+		// Too large to rewrite, pass it through untouched
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if strings.Contains(mediaType, "html") {
+		body = rw.rewriteHTML(body)
+	}
+	body = rw.rewriteJS(body)
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return nil
+}
+
+// rewriteHTML prefixes root-relative URLs in common attributes and optionally injects a <base> element
+func (rw *rewriter) rewriteHTML(body []byte) []byte {
+	body = htmlAttrPattern.ReplaceAllFunc(body, func(match []byte) []byte {
+		groups := htmlAttrPattern.FindSubmatch(match)
+		path := string(groups[2])
+		if strings.HasPrefix(path, "//") {
+			// Protocol-relative URL pointing to another host
+			return match
+		}
+		return append(append([]byte{}, groups[1]...), rw.prefixPath(path)...)
+	})
+
+	if rw.config.Body.InjectBaseHref && !htmlBasePattern.Match(body) {
+		if loc := htmlHeadPattern.FindIndex(body); loc != nil {
+			base := fmt.Sprintf(`<base href="%s/">`, rw.config.PathPrefix)
+			body = append(body[:loc[1]], append([]byte(base), body[loc[1]:]...)...)
+		}
+	}
+
+	return body
+}
+
+// rewriteJS prefixes quoted string literals that start with one of the configured path roots
+func (rw *rewriter) rewriteJS(body []byte) []byte {
+	for _, root := range rw.config.Body.JSPathRoots {
+		for _, quote := range []string{`"`, `'`, "`"} {
+			body = bytes.ReplaceAll(body, []byte(quote+root), []byte(quote+rw.config.PathPrefix+root))
+		}
+	}
+	return body
+}
+
+// prefixPath places a root-relative path under the configured path prefix
+func (rw *rewriter) prefixPath(path string) string {
+	prefix := rw.config.PathPrefix
+	if prefix == "" || !strings.HasPrefix(path, "/") || rw.hasPrefix(path) {
+		return path
+	}
+	return prefix + path
+}
+
+// hasPrefix checks whether a path is already under the configured path prefix
+func (rw *rewriter) hasPrefix(path string) bool {
+	prefix := rw.config.PathPrefix
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func ensureLeadingSlash(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return "/" + path
+	}
+	return path
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+)
+
+func newTestRewriter(t *testing.T, cfg config.RewriteConfig) *rewriter {
+	backendURL, _ := url.Parse("http://backend:5000")
+	rw, err := newRewriter(&cfg, backendURL)
+	require.NoError(t, err)
+	return rw
+}
+
+func TestRewriteRequest(t *testing.T) {
+	rw := newTestRewriter(t, config.RewriteConfig{PathPrefix: "/app", StripPrefix: true})
+
+	for _, tc := range []struct {
+		path     string
+		expected string
+	}{
+		{"/app", "/"},
+		{"/app/", "/"},
+		{"/app/api/v1", "/api/v1"},
+		{"/application", "/application"},
+		{"/other", "/other"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		rw.rewriteRequest(req)
+		assert.Equal(t, tc.expected, req.URL.Path, tc.path)
+	}
+}
+
+func TestRewriteLocation(t *testing.T) {
+	rw := newTestRewriter(t, config.RewriteConfig{
+		PathPrefix: "/app",
+		Location: config.LocationRewriteConfig{
+			Enabled:       true,
+			InternalHosts: []string{"localhost:5000"},
+		},
+	})
+
+	for _, tc := range []struct {
+		location string
+		expected string
+	}{
+		{"http://backend:5000/login?next=%2F", "/app/login?next=%2F"},
+		{"http://localhost:5000/", "/app/"},
+		{"/login", "/app/login"},
+		{"/app/login", "/app/login"},
+		{"login", "login"},
+		{"https://idp.example.com/authorize", "https://idp.example.com/authorize"},
+	} {
+		assert.Equal(t, tc.expected, rw.rewriteLocation(tc.location), tc.location)
+	}
+
+	public := newTestRewriter(t, config.RewriteConfig{
+		PathPrefix: "/app",
+		Location: config.LocationRewriteConfig{
+			Enabled:   true,
+			PublicURL: "https://apps.example.com",
+		},
+	})
+	assert.Equal(t, "https://apps.example.com/app/home", public.rewriteLocation("http://backend:5000/home"))
+}
+
+func TestRewriteSetCookie(t *testing.T) {
+	rw := newTestRewriter(t, config.RewriteConfig{
+		PathPrefix: "/app",
+		Cookies:    config.CookieRewriteConfig{RewritePath: true, Domain: "-"},
+	})
+
+	assert.Equal(t, "session=abc; Path=/app/; HttpOnly; SameSite=Lax",
+		rw.rewriteSetCookie("session=abc; Path=/; Domain=backend; HttpOnly; SameSite=Lax"))
+	assert.Equal(t, "session=abc; Path=/app/api",
+		rw.rewriteSetCookie("session=abc; path=/api"))
+	assert.Equal(t, "session=abc; Path=/app/",
+		rw.rewriteSetCookie("session=abc"))
+
+	domain := newTestRewriter(t, config.RewriteConfig{
+		Cookies: config.CookieRewriteConfig{Domain: "apps.example.com"},
+	})
+	assert.Equal(t, "session=abc; Path=/; Domain=apps.example.com",
+		domain.rewriteSetCookie("session=abc; Path=/; Domain=backend"))
+}
+
+func TestRewriteBody(t *testing.T) {
+	rw := newTestRewriter(t, config.RewriteConfig{
+		PathPrefix: "/app",
+		Body: config.BodyRewriteConfig{
+			Enabled:        true,
+			InjectBaseHref: true,
+			ContentTypes:   []string{"text/html", "application/javascript"},
+			JSPathRoots:    []string{"/api/"},
+			MaxBodySize:    1024,
+		},
+	})
+
+	html := `<html><head><title>t</title></head><body>` +
+		`<a href="/docs">d</a><img src='/static/x.png'><a href="//cdn.example.com/x">c</a>` +
+		`<script>fetch("/api/items")</script></body></html>`
+	resp := &http.Response{
+		Header: http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+		Body:   io.NopCloser(strings.NewReader(html)),
+	}
+
+	require.NoError(t, rw.modifyResponse(resp))
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, `<html><head><base href="/app/"><title>t</title></head><body>`+
+		`<a href="/app/docs">d</a><img src='/app/static/x.png'><a href="//cdn.example.com/x">c</a>`+
+		`<script>fetch("/app/api/items")</script></body></html>`, string(body))
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+
+	compressed := &http.Response{
+		Header: http.Header{
+			"Content-Type":     []string{"text/html"},
+			"Content-Encoding": []string{"gzip"},
+		},
+		Body: io.NopCloser(strings.NewReader(`<a href="/docs">`)),
+	}
+	require.NoError(t, rw.modifyResponse(compressed))
+	body, _ = io.ReadAll(compressed.Body)
+	assert.Equal(t, `<a href="/docs">`, string(body))
+
+	large := &http.Response{
+		Header:        http.Header{"Content-Type": []string{"text/html"}},
+		ContentLength: -1,
+		Body:          io.NopCloser(strings.NewReader(`<a href="/docs">` + strings.Repeat("x", 2048))),
+	}
+	require.NoError(t, rw.modifyResponse(large))
+	body, _ = io.ReadAll(large.Body)
+	assert.True(t, strings.HasPrefix(string(body), `<a href="/docs">`))
+	assert.Len(t, body, 16+2048)
+}
+
+func TestRewriteLocationFromEndpoints(t *testing.T) {
+	cfg := config.RewriteConfig{PathPrefix: "/app", Location: config.LocationRewriteConfig{Enabled: true}}
+	primary, _ := url.Parse("http://backend:5000")
+	second, _ := url.Parse("http://backend-1:5000")
+	rw, err := newRewriter(&cfg, primary, second)
+	require.NoError(t, err)
+
+	assert.Equal(t, "/app/home", rw.rewriteLocation("http://backend-1:5000/home"))
+	assert.Equal(t, "http://other:5000/home", rw.rewriteLocation("http://other:5000/home"))
+}
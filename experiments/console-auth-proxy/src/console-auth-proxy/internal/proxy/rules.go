@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+)
+
+// RuleMatch describes the access rule applied to a request
+type RuleMatch struct {
+	Path   string `json:"path"`
+	Method string `json:"method"`
+	Action string `json:"action"`
+
+	// Rule that matched, e.g. "rules[2]" or "public_paths[0]", or "default"
+	Rule       string             `json:"rule"`
+	Definition *config.AccessRule `json:"definition,omitempty"`
+}
+
+// accessRule is an access rule with its compiled path pattern
+type accessRule struct {
+	config.AccessRule
+	name    string
+	pattern *regexp.Regexp
+}
+
+// accessRules decides how proxied requests are handled, first match wins
+type accessRules struct {
+	rules []accessRule
+}
+
+func newAccessRules(cfg *config.ProxyConfig) (*accessRules, error) {
+	a := &accessRules{}
+
+	for i, rule := range cfg.Rules {
+		// Regular expressions match the whole path, with or without a
+		// trailing slash
+		expr := "^(?:" + rule.Regex + ")/?$"
+		if rule.Path != "" {
+			expr = globToRegexp(rule.Path)
+		}
+
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: invalid path pattern: %w", i, err)
+		}
+		a.rules = append(a.rules, accessRule{
+			AccessRule: rule,
+			name:       fmt.Sprintf("rules[%d]", i),
+			pattern:    pattern,
+		})
+	}
+
+	// Public paths are prefixes matched on a path segment boundary
+	for i, prefix := range cfg.PublicPaths {
+		prefix = strings.TrimSuffix(prefix, "/")
+		a.rules = append(a.rules, accessRule{
+			AccessRule: config.AccessRule{Path: prefix + "/**", Action: config.AccessPublic},
+			name:       fmt.Sprintf("public_paths[%d]", i),
+			pattern:    regexp.MustCompile("^" + regexp.QuoteMeta(prefix) + "(/.*)?$"),
+		})
+	}
+
+	return a, nil
+}
+
+// match returns the first rule matching the request, or nil if none does
+func (a *accessRules) match(method, urlPath string) *accessRule {
+	urlPath = cleanPath(urlPath)
+	for i := range a.rules {
+		rule := &a.rules[i]
+		if len(rule.Methods) > 0 && !containsString(rule.Methods, method) {
+			continue
+		}
+		if rule.pattern.MatchString(urlPath) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// explain describes the rule applied to a request
+func (a *accessRules) explain(method, urlPath string) RuleMatch {
+	result := RuleMatch{
+		Path:   urlPath,
+		Method: method,
+		Action: config.AccessAuthenticated,
+		Rule:   "default",
+	}
+
+	if rule := a.match(method, urlPath); rule != nil {
+		definition := rule.AccessRule
+		result.Action = rule.Action
+		result.Rule = rule.name
+		result.Definition = &definition
+	}
+	return result
+}
+
+// cleanPath removes dot segments and duplicate slashes, keeping a trailing slash
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// globToRegexp converts a path glob to an anchored regular expression. * and ?
+// match within a path segment, ** matches across segments and a trailing /**
+// also matches the directory itself. Other globs match the path with or
+// without a trailing slash, so a rule for /metrics also covers /metrics/.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+
+	optionalSlash := !strings.HasSuffix(glob, "/**")
+	if optionalSlash {
+		glob = strings.TrimSuffix(glob, "/")
+	}
+
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("(/.*)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			b.WriteString("[^/]*")
+		case glob[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	if optionalSlash {
+		b.WriteString("/?")
+	}
+	b.WriteString("$")
+	return b.String()
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+)
+
+func TestAccessRules(t *testing.T) {
+	rules, err := newAccessRules(&config.ProxyConfig{
+		Rules: []config.AccessRule{
+			{Path: "/metrics", Action: config.AccessDeny},
+			{Path: "/api/*/status", Methods: []string{"GET", "HEAD"}, Action: config.AccessPublic},
+			{Regex: `/assets/.+\.(css|js)`, Action: config.AccessPublic},
+			{Path: "/docs/**", Action: config.AccessPublic},
+			{Path: "/docs/internal/**", Action: config.AccessAuthenticated},
+		},
+		PublicPaths: []string{"/static/", "/favicon.ico"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		method string
+		path   string
+		action string
+		rule   string
+	}{
+		{"GET", "/metrics", config.AccessDeny, "rules[0]"},
+		{"GET", "/metrics/", config.AccessDeny, "rules[0]"},
+		{"GET", "/metrics//", config.AccessDeny, "rules[0]"},
+		{"GET", "/metrics/extra", config.AccessAuthenticated, "default"},
+		{"GET", "/api/jobs/status", config.AccessPublic, "rules[1]"},
+		{"POST", "/api/jobs/status", config.AccessAuthenticated, "default"},
+		{"GET", "/api/jobs/status/", config.AccessPublic, "rules[1]"},
+		{"GET", "/api/jobs/1/status", config.AccessAuthenticated, "default"},
+		{"GET", "/assets/app.js", config.AccessPublic, "rules[2]"},
+		{"GET", "/assets/app.js/", config.AccessPublic, "rules[2]"},
+		{"GET", "/assets/app.js.map", config.AccessAuthenticated, "default"},
+		{"GET", "/prefix/assets/app.js", config.AccessAuthenticated, "default"},
+		{"GET", "/docs", config.AccessPublic, "rules[3]"},
+		{"GET", "/docs/a/b.html", config.AccessPublic, "rules[3]"},
+		// First match wins
+		{"GET", "/docs/internal/x", config.AccessPublic, "rules[3]"},
+		{"GET", "/docsearch", config.AccessAuthenticated, "default"},
+		{"GET", "/static", config.AccessPublic, "public_paths[0]"},
+		{"GET", "/static/app.js", config.AccessPublic, "public_paths[0]"},
+		{"GET", "/staticfiles/app.js", config.AccessAuthenticated, "default"},
+		{"GET", "/favicon.ico", config.AccessPublic, "public_paths[1]"},
+		// Dot segments can't escape a public prefix
+		{"GET", "/static/../admin", config.AccessAuthenticated, "default"},
+		{"GET", "//metrics", config.AccessDeny, "rules[0]"},
+		{"GET", "/healthz", config.AccessAuthenticated, "default"},
+		{"GET", "/auth/unknown", config.AccessAuthenticated, "default"},
+	}
+
+	for _, tt := range tests {
+		match := rules.explain(tt.method, tt.path)
+		assert.Equal(t, tt.action, match.Action, "%s %s", tt.method, tt.path)
+		assert.Equal(t, tt.rule, match.Rule, "%s %s", tt.method, tt.path)
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := map[string]string{
+		"/metrics":   `^/metrics/?$`,
+		"/metrics/":  `^/metrics/?$`,
+		"/api/*":     `^/api/[^/]*/?$`,
+		"/file?.txt": `^/file[^/]\.txt/?$`,
+		"/a/**/b":    `^/a/.*/b/?$`,
+		"/static/**": `^/static(/.*)?$`,
+	}
+	for glob, expected := range tests {
+		assert.Equal(t, expected, globToRegexp(glob), glob)
+	}
+}
+
+func TestServeHTTPAccessRules(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Forwarded-User")))
+	}))
+	defer backend.Close()
+
+	cfg := &config.Config{
+		Proxy: config.ProxyConfig{
+			Backend: config.BackendConfig{URL: backend.URL},
+			Headers: config.HeaderConfig{UserHeader: "X-Forwarded-User"},
+			Rules: []config.AccessRule{
+				{Path: "/metrics", Action: config.AccessDeny},
+				{Path: "/public/**", Action: config.AccessPublic},
+			},
+		},
+	}
+	cfg.SetDefaults()
+	cfg.Auth.SecureCookies = false
+
+	ap, err := NewAuthenticatedProxy(cfg, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	ap.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	req := httptest.NewRequest("GET", "/public/page", nil)
+	req.Header.Set("X-Forwarded-User", "spoofed")
+	rec = httptest.NewRecorder()
+	ap.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Body.String())
+}
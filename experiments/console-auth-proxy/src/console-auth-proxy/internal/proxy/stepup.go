@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+)
+
+const (
+	// stepUpCookieName remembers a step-up login in progress so a provider that
+	// can't satisfy the rule doesn't send the user into a redirect loop
+	stepUpCookieName = "step-up-attempt"
+
+	// stepUpAttemptWindow is how long a step-up attempt is remembered
+	stepUpAttemptWindow = 2 * time.Minute
+)
+
+// stepUpEnforcer checks the authentication context of a user against path rules
+type stepUpEnforcer struct {
+	rules         []config.StepUpRule
+	secureCookies bool
+	now           func() time.Time
+}
+
+func newStepUpEnforcer(rules []config.StepUpRule, secureCookies bool) *stepUpEnforcer {
+	return &stepUpEnforcer{
+		rules:         rules,
+		secureCookies: secureCookies,
+		now:           time.Now,
+	}
+}
+
+// match returns the index of the first rule matching the path, or -1
+func (s *stepUpEnforcer) match(path string) int {
+	for i, rule := range s.rules {
+		prefix := strings.TrimSuffix(rule.PathPrefix, "/")
+		if prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return i
+		}
+	}
+	return -1
+}
+
+// satisfied reports whether the user's authentication context meets the rule
+func (s *stepUpEnforcer) satisfied(rule *config.StepUpRule, user *auth.User) bool {
+	if len(rule.RequiredACR) > 0 && !containsString(rule.RequiredACR, user.ACR) {
+		return false
+	}
+
+	for _, method := range rule.RequiredAMR {
+		if !containsString(user.AMR, method) {
+			return false
+		}
+	}
+
+	if rule.MaxAge > 0 {
+		if user.AuthTime.IsZero() || s.now().Sub(user.AuthTime) > rule.MaxAge {
+			return false
+		}
+	}
+
+	return true
+}
+
+// enforce returns true if the request may continue. Otherwise it has already
+// redirected the user to a step-up login or rendered an error page.
+func (s *stepUpEnforcer) enforce(w http.ResponseWriter, r *http.Request, user *auth.User) bool {
+	idx := s.match(r.URL.Path)
+	if idx < 0 {
+		return true
+	}
+
+	rule := &s.rules[idx]
+	if s.satisfied(rule, user) {
+		if _, err := r.Cookie(stepUpCookieName); err == nil {
+			s.clearAttempt(w)
+		}
+		return true
+	}
+
+	// gRPC clients can't follow the login redirect
+	if isGRPC(r) {
+		klog.V(4).Infof("Step-up authentication required for gRPC call by %s to %s", user.Username, r.URL.Path)
+		writeGRPCStatus(w, r, grpcUnauthenticated, "step-up authentication required")
+		return false
+	}
+
+	if s.recentAttempt(r, idx) {
+		klog.Warningf("User %s still doesn't satisfy step-up rule for %s after re-authentication (acr=%q amr=%v)",
+			user.Username, rule.PathPrefix, user.ACR, user.AMR)
+		s.clearAttempt(w)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, insufficientAuthenticationPage, html.EscapeString(rule.PathPrefix))
+		return false
+	}
+
+	klog.V(4).Infof("Step-up authentication required for user %s on %s %s", user.Username, r.Method, r.URL.Path)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stepUpCookieName,
+		Value:    fmt.Sprintf("%d:%d", idx, s.now().Unix()),
+		Path:     "/",
+		MaxAge:   int(stepUpAttemptWindow.Seconds()),
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	params := url.Values{}
+	params.Set("return_url", r.URL.String())
+	if len(rule.RequiredACR) > 0 {
+		params.Set("acr_values", strings.Join(rule.RequiredACR, " "))
+	}
+	if rule.MaxAge > 0 {
+		// Ask the provider to re-authenticate the user now
+		params.Set("max_age", "0")
+	}
+
+	http.Redirect(w, r, "/auth/login?"+params.Encode(), http.StatusSeeOther)
+	return false
+}
+
+// recentAttempt reports whether a step-up login for the rule was started recently
+func (s *stepUpEnforcer) recentAttempt(r *http.Request, idx int) bool {
+	cookie, err := r.Cookie(stepUpCookieName)
+	if err != nil {
+		return false
+	}
+
+	ruleIdx, startedAt, found := strings.Cut(cookie.Value, ":")
+	if !found || ruleIdx != strconv.Itoa(idx) {
+		return false
+	}
+
+	unix, err := strconv.ParseInt(startedAt, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	return s.now().Sub(time.Unix(unix, 0)) < stepUpAttemptWindow
+}
+
+func (s *stepUpEnforcer) clearAttempt(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     stepUpCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+	})
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+const insufficientAuthenticationPage = `<!DOCTYPE html>
+<html>
+<head>
+    <title>Insufficient Authentication</title>
+    <style>
+        body { font-family: Arial, sans-serif; margin: 40px; }
+        .error { color: #d32f2f; background: #ffebee; padding: 20px; border-radius: 4px; }
+    </style>
+</head>
+<body>
+    <h1>Insufficient Authentication</h1>
+    <div class="error">
+        <p>Access to %s requires a stronger authentication method than the one you signed in with.</p>
+        <p>Please contact your administrator if you believe you should have access.</p>
+    </div>
+</body>
+</html>`
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+)
+
+func newTestStepUp() *stepUpEnforcer {
+	return newStepUpEnforcer([]config.StepUpRule{
+		{PathPrefix: "/admin", RequiredACR: []string{"mfa", "phr"}, RequiredAMR: []string{"otp"}},
+		{PathPrefix: "/billing/", MaxAge: 5 * time.Minute},
+	}, true)
+}
+
+func TestStepUpSatisfied(t *testing.T) {
+	s := newTestStepUp()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	for _, tc := range []struct {
+		name     string
+		path     string
+		user     auth.User
+		expected bool
+	}{
+		{"unprotected path", "/projects", auth.User{}, true},
+		{"prefix is not a path segment", "/administrator", auth.User{}, true},
+		{"acr and amr", "/admin/users", auth.User{ACR: "phr", AMR: []string{"pwd", "otp"}}, true},
+		{"wrong acr", "/admin", auth.User{ACR: "pwd", AMR: []string{"otp"}}, false},
+		{"missing amr", "/admin", auth.User{ACR: "mfa", AMR: []string{"pwd"}}, false},
+		{"recent login", "/billing", auth.User{AuthTime: now.Add(-time.Minute)}, true},
+		{"stale login", "/billing/invoices", auth.User{AuthTime: now.Add(-10 * time.Minute)}, false},
+		{"no auth_time", "/billing", auth.User{}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			idx := s.match(tc.path)
+			ok := idx < 0 || s.satisfied(&s.rules[idx], &tc.user)
+			assert.Equal(t, tc.expected, ok)
+		})
+	}
+}
+
+func TestStepUpRedirectAndLoopGuard(t *testing.T) {
+	s := newTestStepUp()
+	user := &auth.User{Username: "alice", ACR: "pwd"}
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/users?page=2", nil)
+	w := httptest.NewRecorder()
+	require.False(t, s.enforce(w, r, user))
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "/auth/login", location.Path)
+	assert.Equal(t, "mfa phr", location.Query().Get("acr_values"))
+	assert.Equal(t, "/admin/users?page=2", location.Query().Get("return_url"))
+
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, stepUpCookieName, cookies[0].Name)
+
+	// Coming back from the provider without a stronger context shows an error instead of looping
+	r = httptest.NewRequest(http.MethodGet, "/admin/users?page=2", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	require.False(t, s.enforce(w, r, user))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "Insufficient Authentication")
+
+	// Once satisfied the attempt cookie is cleared
+	r = httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+	r.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	assert.True(t, s.enforce(w, r, &auth.User{ACR: "mfa", AMR: []string{"otp"}}))
+	require.Len(t, w.Result().Cookies(), 1)
+	assert.Equal(t, -1, w.Result().Cookies()[0].MaxAge)
+}
+
+func TestStepUpMaxAgeForcesReauthentication(t *testing.T) {
+	s := newTestStepUp()
+
+	r := httptest.NewRequest(http.MethodGet, "/billing", nil)
+	w := httptest.NewRecorder()
+	require.False(t, s.enforce(w, r, &auth.User{AuthTime: time.Now().Add(-time.Hour)}))
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "0", location.Query().Get("max_age"))
+	assert.Empty(t, location.Query().Get("acr_values"))
+}
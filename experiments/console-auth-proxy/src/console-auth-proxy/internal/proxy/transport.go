@@ -0,0 +1,378 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+)
+
+var (
+	// errCircuitOpen is returned while the circuit breaker rejects requests
+	errCircuitOpen = errors.New("backend circuit breaker is open")
+	// errNoEndpoints is returned when every backend endpoint is ejected
+	errNoEndpoints = errors.New("no healthy backend endpoints available")
+)
+
+// idempotentMethods are safe to send to another endpoint after a failure
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// balancingTransport spreads requests across backend endpoints, retrying
+// idempotent requests on other endpoints within a retry budget
+type balancingTransport struct {
+	base     http.RoundTripper
+	balancer *balancer
+	budget   *retryBudget
+	breaker  *circuitBreaker
+	retry    config.RetryConfig
+	retryOn  map[int]bool
+	perTry   time.Duration
+}
+
+func newBalancingTransport(base http.RoundTripper, b *balancer, timeouts *config.TimeoutConfig) *balancingTransport {
+	t := &balancingTransport{
+		base:     base,
+		balancer: b,
+		budget:   newRetryBudget(timeouts.Retry.BudgetRatio, timeouts.Retry.MinRetriesPerSecond),
+		breaker:  newCircuitBreaker(timeouts.CircuitBreaker),
+		retry:    timeouts.Retry,
+		retryOn:  make(map[int]bool),
+		perTry:   timeouts.PerTry,
+	}
+
+	for _, status := range timeouts.Retry.RetryOn {
+		t.retryOn[status] = true
+	}
+
+	return t
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *balancingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.breaker.allow() {
+		return nil, errCircuitOpen
+	}
+	t.budget.deposit()
+
+	canRetry := t.retry.MaxRetries > 0 && idempotentMethods[req.Method] &&
+		(req.Body == nil || req.Body == http.NoBody || req.GetBody != nil)
+
+	tried := make(map[*endpoint]bool)
+	e := t.balancer.pick(tried)
+	if e == nil {
+		t.breaker.release(false)
+		return nil, errNoEndpoints
+	}
+	for attempt := 0; ; attempt++ {
+		tried[e] = true
+
+		resp, err := t.tryEndpoint(req, e, attempt)
+		failed := err != nil || resp.StatusCode >= 500
+		if failed {
+			t.balancer.recordFailure(e)
+		} else {
+			t.balancer.recordSuccess(e)
+		}
+
+		var next *endpoint
+		retriable := err != nil || t.retryOn[resp.StatusCode]
+		if retriable && canRetry && attempt < t.retry.MaxRetries && req.Context().Err() == nil {
+			// Prefer an endpoint that wasn't tried yet, but fall back to the ones
+			// that were so backends with a single endpoint still retry
+			if next = t.balancer.pick(tried); next == nil {
+				next = t.balancer.pick(nil)
+			}
+		}
+		if next == nil || !t.budget.withdraw() {
+			t.breaker.release(!failed)
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		klog.V(4).Infof("Retrying %s %s after failure on %s (attempt %d)", req.Method, req.URL.Path, e.url.Host, attempt+1)
+
+		select {
+		case <-req.Context().Done():
+			t.breaker.release(false)
+			return nil, req.Context().Err()
+		case <-time.After(t.retry.Backoff * time.Duration(attempt+1)):
+		}
+		e = next
+	}
+}
+
+// tryEndpoint sends one attempt to the given endpoint
+func (t *balancingTransport) tryEndpoint(req *http.Request, e *endpoint, attempt int) (*http.Response, error) {
+	ctx := req.Context()
+	var cancel context.CancelFunc
+	if t.perTry > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t.perTry)
+	}
+
+	outReq := req.Clone(ctx)
+	outReq.URL.Scheme = e.url.Scheme
+	outReq.URL.Host = e.url.Host
+	if attempt > 0 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			if cancel != nil {
+				cancel()
+			}
+			return nil, err
+		}
+		outReq.Body = body
+	}
+
+	e.active.Add(1)
+	resp, err := t.base.RoundTrip(outReq)
+	if err != nil {
+		e.active.Add(-1)
+		if cancel != nil {
+			cancel()
+		}
+		return nil, err
+	}
+
+	// Keep the endpoint counted as active until the response body is consumed
+	resp.Body = &trackedBody{ReadCloser: resp.Body, done: func() {
+		e.active.Add(-1)
+		if cancel != nil {
+			cancel()
+		}
+	}}
+	return resp, nil
+}
+
+// errorHandler replaces httputil.ReverseProxy's default 502 with a 503 page
+// when the breaker is open or no endpoint is available. gRPC clients get an
+// UNAVAILABLE status instead, which they may retry.
+func (t *balancingTransport) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	unavailable := errors.Is(err, errCircuitOpen) || errors.Is(err, errNoEndpoints)
+	if !unavailable {
+		klog.Errorf("Backend request %s %s failed: %v", r.Method, r.URL.Path, err)
+	} else {
+		klog.V(4).Infof("Rejecting %s %s: %v", r.Method, r.URL.Path, err)
+	}
+
+	if isGRPC(r) {
+		writeGRPCStatus(w, r, grpcUnavailable, "backend unavailable")
+		return
+	}
+
+	if !unavailable {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	retryAfter := t.breaker.retryAfter()
+	if retryAfter <= 0 {
+		retryAfter = t.balancer.outlier.BaseEjectionTime
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	fmt.Fprintf(w, serviceUnavailablePage, err)
+}
+
+const serviceUnavailablePage = `<!DOCTYPE html>
+<html>
+<head>
+    <title>Service Unavailable</title>
+    <style>
+        body { font-family: Arial, sans-serif; margin: 40px; }
+        .error { color: #d32f2f; background: #ffebee; padding: 20px; border-radius: 4px; }
+    </style>
+</head>
+<body>
+    <h1>Service Unavailable</h1>
+    <div class="error">
+        <p>The application behind this proxy is temporarily unavailable (%v).</p>
+        <p>Please try again in a few moments.</p>
+    </div>
+</body>
+</html>`
+
+// trackedBody runs done once when the body is closed
+type trackedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+// Write supports protocol upgrades (e.g. WebSockets), where the body is a read-write stream
+func (b *trackedBody) Write(p []byte) (int, error) {
+	if w, ok := b.ReadCloser.(io.Writer); ok {
+		return w.Write(p)
+	}
+	return 0, errors.New("response body is not writable")
+}
+
+// retryBudget limits retries to a fraction of recent requests, so retries
+// can't multiply load on a backend that is already struggling
+type retryBudget struct {
+	mu           sync.Mutex
+	ratio        float64
+	minPerSecond float64
+	tokens       float64
+	maxTokens    float64
+	lastRefill   time.Time
+	now          func() time.Time
+}
+
+func newRetryBudget(ratio float64, minPerSecond int) *retryBudget {
+	return &retryBudget{
+		ratio:        ratio,
+		minPerSecond: float64(minPerSecond),
+		tokens:       float64(minPerSecond),
+		maxTokens:    math.Max(10, float64(minPerSecond)),
+		lastRefill:   time.Now(),
+		now:          time.Now,
+	}
+}
+
+// deposit earns retry tokens for an incoming request
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.maxTokens, b.tokens+b.ratio)
+}
+
+// withdraw spends a token for a retry, returning false if the budget is exhausted
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	elapsed := now.Sub(b.lastRefill).Seconds()
+	b.lastRefill = now
+	b.tokens = math.Min(b.maxTokens, b.tokens+elapsed*b.minPerSecond)
+
+	if b.tokens < 1 {
+		klog.V(4).Info("Retry budget exhausted, not retrying")
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker stops sending requests to the backend after repeated failures
+// and lets a single probe request through once the open duration has passed
+type circuitBreaker struct {
+	mu                  sync.Mutex
+	cfg                 config.CircuitBreakerConfig
+	state               breakerState
+	consecutiveFailures int
+	openedAt            time.Time
+	inFlight            int
+	probing             bool
+	now                 func() time.Time
+}
+
+func newCircuitBreaker(cfg config.CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{cfg: cfg, now: time.Now}
+}
+
+// allow reports whether a request may be sent; callers must call release afterwards
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.cfg.MaxRequests > 0 && cb.inFlight >= cb.cfg.MaxRequests {
+		return false
+	}
+
+	switch cb.state {
+	case breakerOpen:
+		if cb.now().Sub(cb.openedAt) < cb.cfg.OpenDuration {
+			return false
+		}
+		cb.state = breakerHalfOpen
+		cb.probing = true
+	case breakerHalfOpen:
+		if cb.probing {
+			return false
+		}
+		cb.probing = true
+	}
+
+	cb.inFlight++
+	return true
+}
+
+// release records the outcome of a request admitted by allow
+func (cb *circuitBreaker) release(success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.inFlight--
+	if cb.cfg.ConsecutiveFailures <= 0 {
+		return
+	}
+
+	if cb.state == breakerHalfOpen {
+		cb.probing = false
+	}
+
+	if success {
+		if cb.state != breakerClosed {
+			klog.Info("Backend circuit breaker closed")
+		}
+		cb.state = breakerClosed
+		cb.consecutiveFailures = 0
+		return
+	}
+
+	cb.consecutiveFailures++
+	if cb.state == breakerHalfOpen || cb.consecutiveFailures >= cb.cfg.ConsecutiveFailures {
+		if cb.state != breakerOpen {
+			klog.Warningf("Backend circuit breaker opened after %d consecutive failures", cb.consecutiveFailures)
+		}
+		cb.state = breakerOpen
+		cb.openedAt = cb.now()
+	}
+}
+
+// retryAfter returns how long until the breaker lets a probe request through
+func (cb *circuitBreaker) retryAfter() time.Duration {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state != breakerOpen {
+		return 0
+	}
+	return cb.cfg.OpenDuration - cb.now().Sub(cb.openedAt)
+}
@@ -0,0 +1,227 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+)
+
+type testBackend struct {
+	server *httptest.Server
+	hits   atomic.Int64
+	status atomic.Int64
+}
+
+func newTestBackend(t *testing.T, status int) *testBackend {
+	b := &testBackend{}
+	b.status.Store(int64(status))
+	b.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.hits.Add(1)
+		w.WriteHeader(int(b.status.Load()))
+	}))
+	t.Cleanup(b.server.Close)
+	return b
+}
+
+func newTestTransport(t *testing.T, timeouts config.TimeoutConfig, backends ...*testBackend) *balancingTransport {
+	cfg := &config.Config{}
+	for _, b := range backends {
+		cfg.Proxy.Backend.Endpoints = append(cfg.Proxy.Backend.Endpoints, b.server.URL)
+	}
+	cfg.Proxy.Timeouts = timeouts
+	cfg.SetDefaults()
+	cfg.Proxy.Timeouts.Retry.Backoff = time.Millisecond
+
+	lb, err := newBalancer(&cfg.Proxy.Backend, cfg.Proxy.Timeouts.OutlierDetection)
+	require.NoError(t, err)
+	return newBalancingTransport(http.DefaultTransport, lb, &cfg.Proxy.Timeouts)
+}
+
+func doRequest(t *testing.T, rt http.RoundTripper, method string) (int, error) {
+	req := httptest.NewRequest(method, "http://backend/", nil)
+	req.RequestURI = ""
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestRoundRobin(t *testing.T) {
+	a, b := newTestBackend(t, http.StatusOK), newTestBackend(t, http.StatusOK)
+	rt := newTestTransport(t, config.TimeoutConfig{}, a, b)
+
+	for i := 0; i < 10; i++ {
+		status, err := doRequest(t, rt, http.MethodGet)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+	}
+
+	assert.Equal(t, int64(5), a.hits.Load())
+	assert.Equal(t, int64(5), b.hits.Load())
+}
+
+func TestRetryIdempotentRequests(t *testing.T) {
+	failing, healthy := newTestBackend(t, http.StatusServiceUnavailable), newTestBackend(t, http.StatusOK)
+	rt := newTestTransport(t, config.TimeoutConfig{Retry: config.RetryConfig{MaxRetries: 1}}, failing, healthy)
+
+	for i := 0; i < 4; i++ {
+		status, err := doRequest(t, rt, http.MethodGet)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, status)
+	}
+	assert.Equal(t, int64(4), healthy.hits.Load())
+
+	// POST is not idempotent and must not be retried
+	failing.hits.Store(0)
+	healthy.hits.Store(0)
+	for i := 0; i < 2; i++ {
+		doRequest(t, rt, http.MethodPost)
+	}
+	assert.Equal(t, int64(1), failing.hits.Load())
+	assert.Equal(t, int64(1), healthy.hits.Load())
+}
+
+func TestRetrySingleEndpoint(t *testing.T) {
+	backend := newTestBackend(t, http.StatusServiceUnavailable)
+	rt := newTestTransport(t, config.TimeoutConfig{Retry: config.RetryConfig{MaxRetries: 2}}, backend)
+
+	// The only endpoint is retried after the backoff
+	status, err := doRequest(t, rt, http.MethodGet)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, int64(3), backend.hits.Load())
+
+	// A retry that succeeds ends the request
+	flaky := &testBackend{}
+	flaky.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if flaky.hits.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(flaky.server.Close)
+	rt = newTestTransport(t, config.TimeoutConfig{Retry: config.RetryConfig{MaxRetries: 2}}, flaky)
+
+	status, err = doRequest(t, rt, http.MethodGet)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, int64(2), flaky.hits.Load())
+}
+
+func TestOutlierEjection(t *testing.T) {
+	failing, healthy := newTestBackend(t, http.StatusInternalServerError), newTestBackend(t, http.StatusOK)
+	rt := newTestTransport(t, config.TimeoutConfig{
+		OutlierDetection: config.OutlierDetectionConfig{ConsecutiveFailures: 2},
+	}, failing, healthy)
+
+	for i := 0; i < 10; i++ {
+		doRequest(t, rt, http.MethodGet)
+	}
+
+	assert.Equal(t, int64(2), failing.hits.Load(), "endpoint is ejected after two failures")
+	assert.Equal(t, int64(8), healthy.hits.Load())
+
+	// With max_ejection_percent 50 the remaining endpoint is never ejected
+	healthy.status.Store(http.StatusInternalServerError)
+	for i := 0; i < 4; i++ {
+		status, err := doRequest(t, rt, http.MethodGet)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, status)
+	}
+}
+
+func TestEjectionTime(t *testing.T) {
+	// max_ejection_time is left unset so the default cap applies
+	lb, err := newBalancer(&config.BackendConfig{Endpoints: []string{"http://a", "http://b"}},
+		config.OutlierDetectionConfig{ConsecutiveFailures: 1, BaseEjectionTime: 2 * time.Minute, MaxEjectionPercent: 50})
+	require.NoError(t, err)
+
+	now := time.Now()
+	lb.now = func() time.Time { return now }
+	e := lb.endpoints[0]
+
+	ejectedFor := func() time.Duration {
+		lb.recordFailure(e)
+		d := e.ejectedUntil.Sub(now)
+		now = e.ejectedUntil
+		return d
+	}
+
+	assert.Equal(t, 2*time.Minute, ejectedFor())
+	assert.Equal(t, 4*time.Minute, ejectedFor())
+	assert.Equal(t, defaultMaxEjectionTime, ejectedFor())
+	assert.Equal(t, 3, e.ejections)
+
+	// Successes after the endpoint is back shorten the next ejection
+	lb.recordSuccess(e)
+	lb.recordSuccess(e)
+	assert.Equal(t, 1, e.ejections)
+	assert.Equal(t, 4*time.Minute, ejectedFor())
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	backend := newTestBackend(t, http.StatusBadGateway)
+	rt := newTestTransport(t, config.TimeoutConfig{
+		CircuitBreaker: config.CircuitBreakerConfig{ConsecutiveFailures: 2, OpenDuration: time.Minute},
+	}, backend)
+
+	now := time.Now()
+	rt.breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		status, err := doRequest(t, rt, http.MethodGet)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadGateway, status)
+	}
+
+	_, err := doRequest(t, rt, http.MethodGet)
+	assert.ErrorIs(t, err, errCircuitOpen)
+	assert.Equal(t, int64(2), backend.hits.Load())
+
+	w := httptest.NewRecorder()
+	rt.errorHandler(w, httptest.NewRequest(http.MethodGet, "/", nil), err)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "Service Unavailable")
+
+	// gRPC clients get a status they can retry instead of an HTML page
+	r := httptest.NewRequest(http.MethodPost, "/pkg.Service/Method", nil)
+	r.Header.Set("Content-Type", "application/grpc")
+	w = httptest.NewRecorder()
+	rt.errorHandler(w, r, err)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "14", w.Header().Get("Grpc-Status"))
+	assert.Empty(t, w.Body.String())
+
+	// After the open duration a single probe is let through and closes the breaker
+	now = now.Add(time.Minute)
+	backend.status.Store(http.StatusOK)
+	status, err := doRequest(t, rt, http.MethodGet)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+
+	status, err = doRequest(t, rt, http.MethodGet)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget(0.5, 0)
+	assert.False(t, budget.withdraw())
+
+	budget.deposit()
+	assert.False(t, budget.withdraw())
+	budget.deposit()
+	assert.True(t, budget.withdraw())
+	assert.False(t, budget.withdraw())
+}
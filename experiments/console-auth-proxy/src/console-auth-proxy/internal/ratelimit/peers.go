@@ -0,0 +1,224 @@
+package ratelimit
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+	"github.com/your-org/console-auth-proxy/internal/secrets"
+)
+
+// PeerTakePath is where replicas serve bucket requests from each other
+const PeerTakePath = "/ratelimit/take"
+
+const signatureHeader = "X-Rate-Limit-Signature"
+
+// maxTakeRequestSize bounds the body of a bucket request
+const maxTakeRequestSize = 4096
+
+type takeRequest struct {
+	Key   string           `json:"key"`
+	Limit config.RateLimit `json:"limit"`
+}
+
+type takeResponse struct {
+	Allowed    bool          `json:"allowed"`
+	RetryAfter time.Duration `json:"retry_after"`
+}
+
+// PeerStore shares buckets between replicas. Each bucket is owned by one
+// replica, chosen by rendezvous hashing over the addresses of a headless
+// Service, and the other replicas forward Take to it. Requests between
+// replicas are signed with a shared secret. When the owner can't be reached
+// the local bucket is used, so limits are enforced per replica until it is back.
+type PeerStore struct {
+	local   Store
+	service string
+	port    string
+	secret  *secrets.Source
+	client  *http.Client
+	lookup  func(host string) ([]string, error)
+
+	mu    sync.RWMutex
+	peers []string
+	// self holds this replica's addresses, it owns buckets hashed to them
+	self map[string]bool
+}
+
+// NewPeerStore creates a store sharing buckets with the replicas behind
+// cfg.Service, keeping the buckets this replica owns in local
+func NewPeerStore(cfg *config.RateLimitPeersConfig, secret *secrets.Source, local Store) (*PeerStore, error) {
+	s := &PeerStore{
+		local:   local,
+		service: cfg.Service,
+		port:    strconv.Itoa(cfg.Port),
+		secret:  secret,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		lookup:  net.LookupHost,
+		self:    make(map[string]bool),
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local addresses: %w", err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			s.self[net.JoinHostPort(ipNet.IP.String(), s.port)] = true
+		}
+	}
+
+	go wait.Forever(s.refresh, cfg.RefreshInterval)
+	return s, nil
+}
+
+// refresh resolves the Service to the current replicas, keeping the previous
+// list if the lookup fails
+func (s *PeerStore) refresh() {
+	hosts, err := s.lookup(s.service)
+	if err != nil {
+		klog.Warningf("Failed to resolve rate limit peers %s: %v", s.service, err)
+		return
+	}
+
+	peers := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		peers = append(peers, net.JoinHostPort(host, s.port))
+	}
+	sort.Strings(peers)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if fmt.Sprint(peers) != fmt.Sprint(s.peers) {
+		klog.V(2).Infof("Rate limit peers: %v", peers)
+	}
+	s.peers = peers
+}
+
+// owner returns the replica owning key, or "" if this replica owns it
+func (s *PeerStore) owner(key string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var owner string
+	var best uint64
+	for _, peer := range s.peers {
+		h := fnv.New64a()
+		h.Write([]byte(peer))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if score := h.Sum64(); owner == "" || score > best {
+			owner, best = peer, score
+		}
+	}
+
+	if s.self[owner] {
+		return ""
+	}
+	return owner
+}
+
+// Take implements Store
+func (s *PeerStore) Take(key string, limit config.RateLimit) (bool, time.Duration) {
+	owner := s.owner(key)
+	if owner == "" {
+		return s.local.Take(key, limit)
+	}
+
+	resp, err := s.forward(owner, takeRequest{Key: key, Limit: limit})
+	if err != nil {
+		klog.V(2).Infof("Rate limit peer %s unavailable, using the local bucket: %v", owner, err)
+		return s.local.Take(key, limit)
+	}
+	return resp.Allowed, resp.RetryAfter
+}
+
+// forward asks the owner of a bucket for a token
+func (s *PeerStore) forward(owner string, take takeRequest) (*takeResponse, error) {
+	body, err := json.Marshal(take)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+owner+PeerTakePath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signatureHeader, s.sign(body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var result takeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Handler serves bucket requests from the other replicas
+func (s *PeerStore) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PeerTakePath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxTakeRequestSize))
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		if !hmac.Equal([]byte(r.Header.Get(signatureHeader)), []byte(s.sign(body))) {
+			klog.Warningf("Rejected rate limit request with an invalid signature from %s", r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		var take takeRequest
+		if err := json.Unmarshal(body, &take); err != nil || !take.Limit.Enabled() {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
+		// Always answer from the local bucket, even if this replica's view of
+		// the owners differs, so requests are never forwarded twice
+		allowed, retryAfter := s.local.Take(take.Key, take.Limit)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(takeResponse{Allowed: allowed, RetryAfter: retryAfter})
+	})
+	return mux
+}
+
+// sign returns the HMAC of a request body under the shared secret
+func (s *PeerStore) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(s.secret.Value()))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
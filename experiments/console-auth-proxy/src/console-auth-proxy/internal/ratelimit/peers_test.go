@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+	"github.com/your-org/console-auth-proxy/internal/secrets"
+)
+
+// newTestPeers starts n replicas that know about each other
+func newTestPeers(t *testing.T, n int, secret string) ([]*PeerStore, []*httptest.Server) {
+	source, err := secrets.New("peers.secret", secret, "")
+	require.NoError(t, err)
+
+	stores := make([]*PeerStore, n)
+	servers := make([]*httptest.Server, n)
+	var peers []string
+	for i := range stores {
+		local, _ := newTestStore()
+		stores[i] = &PeerStore{
+			local:  local,
+			secret: source,
+			client: &http.Client{Timeout: time.Second},
+		}
+		servers[i] = httptest.NewServer(stores[i].Handler())
+		t.Cleanup(servers[i].Close)
+
+		addr := strings.TrimPrefix(servers[i].URL, "http://")
+		stores[i].self = map[string]bool{addr: true}
+		peers = append(peers, addr)
+	}
+	for _, s := range stores {
+		s.peers = peers
+	}
+	return stores, servers
+}
+
+func TestPeerStoreSharesBuckets(t *testing.T) {
+	stores, _ := newTestPeers(t, 3, "shared")
+	limit := config.RateLimit{RequestsPerSecond: 1, Burst: 4}
+
+	// One replica owns the bucket and the two others forward to it
+	owners := map[string]int{}
+	for _, s := range stores {
+		owners[s.owner("user:alice")]++
+	}
+	assert.Len(t, owners, 2)
+	assert.Equal(t, 1, owners[""])
+
+	// The burst holds across replicas
+	allowed := 0
+	for i := 0; i < 9; i++ {
+		if ok, _ := stores[i%3].Take("user:alice", limit); ok {
+			allowed++
+		}
+	}
+	assert.Equal(t, 4, allowed)
+
+	ok, retryAfter := stores[1].Take("user:alice", limit)
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, retryAfter)
+}
+
+func TestPeerStoreFallsBackToLocalBucket(t *testing.T) {
+	stores, servers := newTestPeers(t, 2, "shared")
+	limit := config.RateLimit{RequestsPerSecond: 1, Burst: 1}
+
+	// Find a key owned by the second replica and stop it
+	key := ""
+	for i := 0; key == ""; i++ {
+		if k := "user:" + string(rune('a'+i)); stores[0].owner(k) != "" {
+			key = k
+		}
+	}
+	servers[1].Close()
+
+	ok, _ := stores[0].Take(key, limit)
+	assert.True(t, ok)
+	ok, _ = stores[0].Take(key, limit)
+	assert.False(t, ok, "the local bucket still limits")
+}
+
+func TestPeerStoreRejectsUnsignedRequests(t *testing.T) {
+	stores, servers := newTestPeers(t, 1, "shared")
+	other, _ := newTestPeers(t, 1, "other")
+
+	_, err := other[0].forward(strings.TrimPrefix(servers[0].URL, "http://"),
+		takeRequest{Key: "auth:10.0.0.1", Limit: config.RateLimit{RequestsPerSecond: 1, Burst: 1}})
+	assert.ErrorContains(t, err, "unexpected status 403")
+
+	resp, err := http.Post(servers[0].URL+PeerTakePath, "application/json", strings.NewReader(`{"key":"auth:10.0.0.1"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	assert.Empty(t, stores[0].local.(*MemoryStore).buckets)
+}
@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+)
+
+// Limiter applies per-IP limits to auth endpoints and per-user limits to
+// proxied requests
+type Limiter struct {
+	store          Store
+	auth           config.RateLimit
+	proxy          config.RateLimit
+	routes         []config.RouteRateLimit
+	trustedProxies []*net.IPNet
+}
+
+// New creates a limiter keeping its buckets in store
+func New(cfg *config.RateLimitConfig, store Store) (*Limiter, error) {
+	l := &Limiter{
+		store: store,
+		auth:  cfg.Auth,
+		proxy: cfg.Proxy,
+	}
+
+	// Longest prefix first so the most specific route wins
+	l.routes = append(l.routes, cfg.Routes...)
+	sort.SliceStable(l.routes, func(i, j int) bool {
+		return len(l.routes[i].PathPrefix) > len(l.routes[j].PathPrefix)
+	})
+
+	for _, cidr := range cfg.TrustedProxies {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		l.trustedProxies = append(l.trustedProxies, ipNet)
+	}
+
+	return l, nil
+}
+
+// Handler wraps next with the per-IP limit for /auth/ endpoints
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	if !l.auth.Enabled() {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/auth/") {
+			ip := l.ClientIP(r)
+			if ok, retryAfter := l.store.Take("auth:"+ip, l.auth); !ok {
+				klog.V(4).Infof("Rate limited %s %s from %s", r.Method, r.URL.Path, ip)
+				tooManyRequests(w, retryAfter)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AllowIP applies a limit to the client IP in the bucket named key, writing a
+// 429 response and returning false when it is exceeded
+func (l *Limiter) AllowIP(w http.ResponseWriter, r *http.Request, key string, limit config.RateLimit) bool {
+	if !limit.Enabled() {
+		return true
+	}
+
+	ip := l.ClientIP(r)
+	if ok, retryAfter := l.store.Take(key+":"+ip, limit); !ok {
+		klog.V(4).Infof("Rate limited %s %s from %s", r.Method, r.URL.Path, ip)
+		tooManyRequests(w, retryAfter)
+		return false
+	}
+	return true
+}
+
+// AllowUser applies the limit of the request's route to the user, writing a
+// 429 response and returning false when it is exceeded
+func (l *Limiter) AllowUser(w http.ResponseWriter, r *http.Request, user *auth.User) bool {
+	limit, route := l.proxy, "/"
+	for _, rule := range l.routes {
+		if matchPrefix(rule.PathPrefix, r.URL.Path) {
+			limit, route = rule.RateLimit, rule.PathPrefix
+			break
+		}
+	}
+	if !limit.Enabled() {
+		return true
+	}
+
+	if ok, retryAfter := l.store.Take("user:"+user.Username+":"+route, limit); !ok {
+		klog.V(4).Infof("Rate limited user %s on route %s", user.Username, route)
+		tooManyRequests(w, retryAfter)
+		return false
+	}
+	return true
+}
+
+// ClientIP returns the address of the client. X-Forwarded-For is only
+// followed through trusted proxies, so clients can't pick their own bucket.
+func (l *Limiter) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !l.trusted(host) {
+		return host
+	}
+
+	// Walk the chain from the nearest hop, stopping at the first untrusted address
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		host = hop
+		if !l.trusted(hop) {
+			break
+		}
+	}
+	return host
+}
+
+func (l *Limiter) trusted(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range l.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPrefix matches a path prefix on a path segment boundary
+func matchPrefix(prefix, path string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+}
@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+)
+
+func newTestStore() (*MemoryStore, *time.Time) {
+	now := time.Now()
+	s := &MemoryStore{buckets: make(map[string]*bucket), now: func() time.Time { return now }}
+	return s, &now
+}
+
+func TestMemoryStore(t *testing.T) {
+	s, now := newTestStore()
+	limit := config.RateLimit{RequestsPerSecond: 2, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		ok, _ := s.Take("a", limit)
+		assert.True(t, ok, "request %d within burst", i)
+	}
+	ok, retryAfter := s.Take("a", limit)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	// Buckets are independent
+	ok, _ = s.Take("b", limit)
+	assert.True(t, ok)
+
+	// Tokens refill at the configured rate
+	*now = now.Add(500 * time.Millisecond)
+	ok, _ = s.Take("a", limit)
+	assert.True(t, ok)
+	ok, _ = s.Take("a", limit)
+	assert.False(t, ok)
+
+	// Refilled buckets are pruned
+	*now = now.Add(2 * time.Second)
+	s.prune()
+	assert.Empty(t, s.buckets)
+}
+
+func TestAuthHandler(t *testing.T) {
+	s, _ := newTestStore()
+	l, err := New(&config.RateLimitConfig{
+		Auth:           config.RateLimit{RequestsPerSecond: 1, Burst: 2},
+		TrustedProxies: []string{"10.0.0.0/8"},
+	}, s)
+	require.NoError(t, err)
+
+	handler := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(path, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, request("/auth/login", "192.0.2.1:1234", "").Code)
+	assert.Equal(t, http.StatusOK, request("/auth/callback", "192.0.2.1:1234", "").Code)
+	w := request("/auth/login", "192.0.2.1:1234", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// Other paths and other clients are not affected
+	assert.Equal(t, http.StatusOK, request("/api", "192.0.2.1:1234", "").Code)
+	assert.Equal(t, http.StatusOK, request("/auth/login", "192.0.2.2:1234", "").Code)
+
+	// A spoofed X-Forwarded-For from an untrusted client is ignored
+	assert.Equal(t, http.StatusTooManyRequests, request("/auth/login", "192.0.2.1:1234", "198.51.100.1").Code)
+
+	// Through a trusted proxy the forwarded client gets its own bucket
+	assert.Equal(t, http.StatusOK, request("/auth/login", "10.0.0.1:1234", "192.0.2.1, 198.51.100.1").Code)
+	assert.Equal(t, http.StatusOK, request("/auth/login", "10.0.0.1:1234", "198.51.100.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, request("/auth/login", "10.0.0.1:1234", "198.51.100.1").Code)
+}
+
+func TestClientIP(t *testing.T) {
+	l, err := New(&config.RateLimitConfig{TrustedProxies: []string{"10.0.0.0/8"}}, nil)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.1, 10.0.0.1")
+	assert.Equal(t, "198.51.100.1", l.ClientIP(r), "stops at the first untrusted hop")
+
+	r.Header.Set("X-Forwarded-For", "not-an-ip")
+	assert.Equal(t, "10.0.0.2", l.ClientIP(r))
+}
+
+func TestAllowUser(t *testing.T) {
+	s, _ := newTestStore()
+	l, err := New(&config.RateLimitConfig{
+		Proxy: config.RateLimit{RequestsPerSecond: 1, Burst: 1},
+		Routes: []config.RouteRateLimit{
+			{PathPrefix: "/api", RateLimit: config.RateLimit{RequestsPerSecond: 1, Burst: 2}},
+			{PathPrefix: "/api/export", RateLimit: config.RateLimit{RequestsPerSecond: 1, Burst: 1}},
+		},
+	}, s)
+	require.NoError(t, err)
+
+	alice := &auth.User{Username: "alice"}
+	allow := func(user *auth.User, path string) bool {
+		return l.AllowUser(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil), user)
+	}
+
+	assert.True(t, allow(alice, "/"))
+	assert.False(t, allow(alice, "/index.html"), "default route shares one bucket")
+
+	assert.True(t, allow(alice, "/api/items"))
+	assert.True(t, allow(alice, "/api"))
+	assert.False(t, allow(alice, "/api/items"))
+
+	// Longest prefix wins and has its own bucket
+	assert.True(t, allow(alice, "/api/export/csv"))
+	assert.False(t, allow(alice, "/api/export"))
+
+	// Prefixes match on path segments only
+	assert.False(t, allow(alice, "/apis"))
+
+	assert.True(t, allow(&auth.User{Username: "bob"}, "/api/items"))
+
+	w := httptest.NewRecorder()
+	assert.False(t, l.AllowUser(w, httptest.NewRequest("GET", "/api", nil), alice))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+}
@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+)
+
+var bucketPruningPeriod = time.Minute
+
+// Store holds token bucket state. MemoryStore enforces limits per replica,
+// PeerStore shares them between replicas.
+type Store interface {
+	// Take removes a token from the bucket at key. When the bucket is empty it
+	// returns false and the time until the next token is available.
+	Take(key string, limit config.RateLimit) (bool, time.Duration)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket is refilled and can be forgotten
+	full time.Time
+}
+
+// MemoryStore keeps token buckets in memory
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+// NewMemoryStore creates an in-memory bucket store that prunes refilled buckets
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+
+	go wait.Forever(s.prune, bucketPruningPeriod)
+	return s
+}
+
+// Take implements Store
+func (s *MemoryStore) Take(key string, limit config.RateLimit) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	burst := float64(limit.Burst)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.RequestsPerSecond)
+	b.last = now
+
+	if b.tokens < 1 {
+		retryAfter := time.Duration((1 - b.tokens) / limit.RequestsPerSecond * float64(time.Second))
+		return false, retryAfter
+	}
+
+	b.tokens--
+	b.full = now.Add(time.Duration((burst - b.tokens) / limit.RequestsPerSecond * float64(time.Second)))
+	return true, 0
+}
+
+func (s *MemoryStore) prune() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for key, b := range s.buckets {
+		if now.After(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
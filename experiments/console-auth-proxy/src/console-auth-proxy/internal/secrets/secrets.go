@@ -0,0 +1,196 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// RedactedValue replaces secret values in logs and diagnostics
+const RedactedValue = "<redacted>"
+
+// Kinds of secret sources
+const (
+	KindNone    = ""
+	KindLiteral = "literal"
+	KindEnv     = "env"
+	KindFile    = "file"
+)
+
+const filePrefix = "file://"
+
+var envReference = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// How often file-backed secrets are checked for changes
+var reloadCheckInterval = 10 * time.Second
+
+// Parse returns the kind of a config value and the environment variable or
+// file it references. Values that are not references are literals.
+func Parse(value string) (kind, target string) {
+	if value == "" {
+		return KindNone, ""
+	}
+	if strings.HasPrefix(value, filePrefix) {
+		return KindFile, strings.TrimPrefix(value, filePrefix)
+	}
+	if match := envReference.FindStringSubmatch(value); match != nil {
+		return KindEnv, match[1]
+	}
+	return KindLiteral, value
+}
+
+// IsReference reports whether value refers to an environment variable or file
+func IsReference(value string) bool {
+	kind, _ := Parse(value)
+	return kind == KindEnv || kind == KindFile
+}
+
+// Resolve returns the value a config value refers to, reading it once
+func Resolve(value string) (string, error) {
+	kind, target := Parse(value)
+	switch kind {
+	case KindEnv:
+		return lookupEnv(target)
+	case KindFile:
+		return readFile(target)
+	default:
+		return value, nil
+	}
+}
+
+// Source is a secret taken from a literal, an environment variable or a file.
+// File-backed secrets are re-read when the file changes, so mounted Kubernetes
+// Secrets can be rotated without a restart.
+type Source struct {
+	name   string
+	kind   string
+	target string
+
+	mu      sync.Mutex
+	value   string
+	modTime time.Time
+	size    int64
+	checked time.Time
+	now     func() time.Time
+}
+
+// New creates a secret source for the config key name from its value, which
+// may be a ${ENV} or file:// reference, and its *_file variant
+func New(name, value, file string) (*Source, error) {
+	s := &Source{name: name, now: time.Now}
+
+	if value != "" && file != "" {
+		return nil, fmt.Errorf("%s and %s_file are mutually exclusive", name, name)
+	}
+	if file != "" {
+		s.kind, s.target = KindFile, file
+	} else {
+		s.kind, s.target = Parse(value)
+	}
+
+	switch s.kind {
+	case KindLiteral:
+		s.value = s.target
+	case KindEnv:
+		v, err := lookupEnv(s.target)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		s.value = v
+	case KindFile:
+		if err := s.load(); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		klog.V(2).Infof("Loaded %s from file %s", name, s.target)
+	}
+
+	return s, nil
+}
+
+// Value returns the current secret, re-reading its file if it changed. If the
+// file can't be read the previous value is kept.
+func (s *Source) Value() string {
+	if s == nil {
+		return ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.kind == KindFile && s.now().Sub(s.checked) >= reloadCheckInterval {
+		if err := s.load(); err != nil {
+			klog.Warningf("Failed to reload %s, keeping the previous value: %v", s.name, err)
+		}
+	}
+	return s.value
+}
+
+// Kind returns where the secret comes from
+func (s *Source) Kind() string {
+	if s == nil {
+		return KindNone
+	}
+	return s.kind
+}
+
+// IsSet reports whether a secret was configured
+func (s *Source) IsSet() bool {
+	return s.Kind() != KindNone
+}
+
+// String redacts the secret so it can't leak through logging
+func (s *Source) String() string {
+	return RedactedValue
+}
+
+// GoString redacts the secret in %#v formatting
+func (s *Source) GoString() string {
+	return RedactedValue
+}
+
+// load reads the file if its modification time or size changed
+func (s *Source) load() error {
+	s.checked = s.now()
+
+	info, err := os.Stat(s.target)
+	if err != nil {
+		return err
+	}
+	if !s.modTime.IsZero() && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+
+	value, err := readFile(s.target)
+	if err != nil {
+		return err
+	}
+
+	if !s.modTime.IsZero() {
+		klog.Infof("Reloaded %s from %s", s.name, s.target)
+	}
+	s.value, s.modTime, s.size = value, info.ModTime(), info.Size()
+	return nil
+}
+
+func lookupEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// readFile reads a secret file, dropping the trailing newline editors and
+// `echo` add
+func readFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,87 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	for value, expected := range map[string][2]string{
+		"":                  {KindNone, ""},
+		"s3cr3t":            {KindLiteral, "s3cr3t"},
+		"${CLIENT_SECRET}":  {KindEnv, "CLIENT_SECRET"},
+		"prefix${SECRET}":   {KindLiteral, "prefix${SECRET}"},
+		"$SECRET":           {KindLiteral, "$SECRET"},
+		"file:///run/s/key": {KindFile, "/run/s/key"},
+	} {
+		kind, target := Parse(value)
+		assert.Equal(t, expected, [2]string{kind, target}, value)
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Setenv("CAP_TEST_SECRET", "from-env")
+	path := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(path, []byte("from-file\n"), 0600))
+
+	for _, tc := range []struct {
+		value, file, expected, kind string
+	}{
+		{"literal", "", "literal", KindLiteral},
+		{"${CAP_TEST_SECRET}", "", "from-env", KindEnv},
+		{"file://" + path, "", "from-file", KindFile},
+		{"", path, "from-file", KindFile},
+		{"", "", "", KindNone},
+	} {
+		s, err := New("auth.client_secret", tc.value, tc.file)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, s.Value())
+		assert.Equal(t, tc.kind, s.Kind())
+	}
+
+	_, err := New("auth.client_secret", "literal", path)
+	assert.EqualError(t, err, "auth.client_secret and auth.client_secret_file are mutually exclusive")
+
+	_, err = New("auth.client_secret", "${CAP_TEST_UNSET}", "")
+	assert.EqualError(t, err, "auth.client_secret: environment variable CAP_TEST_UNSET is not set")
+
+	_, err = New("auth.client_secret", "", filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(path, []byte("first"), 0600))
+
+	s, err := New("auth.client_secret", "", path)
+	require.NoError(t, err)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	// Changes are picked up after the check interval
+	require.NoError(t, os.WriteFile(path, []byte("second"), 0600))
+	os.Chtimes(path, now.Add(time.Second), now.Add(time.Second))
+	assert.Equal(t, "first", s.Value())
+	now = now.Add(reloadCheckInterval)
+	assert.Equal(t, "second", s.Value())
+
+	// A missing file keeps the previous value
+	require.NoError(t, os.Remove(path))
+	now = now.Add(reloadCheckInterval)
+	assert.Equal(t, "second", s.Value())
+}
+
+func TestRedaction(t *testing.T) {
+	s, err := New("auth.client_secret", "hunter2", "")
+	require.NoError(t, err)
+
+	for _, format := range []string{"%v", "%s", "%+v", "%#v"} {
+		assert.NotContains(t, fmt.Sprintf(format, s), "hunter2", format)
+	}
+}
@@ -4,15 +4,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"k8s.io/klog/v2"
 
+	"github.com/your-org/console-auth-proxy/internal/capture"
 	"github.com/your-org/console-auth-proxy/internal/config"
 	"github.com/your-org/console-auth-proxy/internal/proxy"
 	"github.com/your-org/console-auth-proxy/internal/version"
 	"github.com/your-org/console-auth-proxy/pkg/auth"
 	"github.com/your-org/console-auth-proxy/pkg/auth/sessions"
+	"github.com/your-org/console-auth-proxy/pkg/tlsprofile"
 )
 
 // setupRoutes configures all HTTP routes for the server
@@ -22,10 +25,22 @@ func setupRoutes(
 	authenticator auth.Authenticator,
 	proxyHandler *proxy.AuthenticatedProxy,
 	metrics *auth.Metrics,
+	serverSecrets *serverSecrets,
+	recorder *capture.Recorder,
+	tlsObserver *tlsprofile.Observer,
 ) {
 	// Authentication routes
 	setupAuthRoutes(mux, cfg, authenticator)
 
+	// Access rule debugging routes
+	setupDebugRoutes(mux, authenticator, proxyHandler)
+
+	// Traffic capture download for admins
+	if recorder.Enabled() {
+		captureCfg := cfg.Observability.Capture
+		mux.Handle("/auth/admin/capture", recorder.DownloadHandler(authenticator, captureCfg.AdminUsers, captureCfg.AdminGroups))
+	}
+
 	// Health check routes
 	if cfg.Observability.Health.Enabled {
 		setupHealthRoutes(mux, cfg)
@@ -37,7 +52,7 @@ func setupRoutes(
 	}
 
 	// Version/info routes
-	setupInfoRoutes(mux)
+	setupInfoRoutes(mux, cfg, serverSecrets, tlsObserver)
 
 	// Default route - proxy all other requests
 	mux.Handle("/", proxyHandler)
@@ -71,6 +86,14 @@ func setupAuthRoutes(mux *http.ServeMux, cfg *config.Config, authenticator auth.
 	})
 }
 
+// setupDebugRoutes configures routes explaining how requests are handled
+func setupDebugRoutes(mux *http.ServeMux, authenticator auth.Authenticator, proxyHandler *proxy.AuthenticatedProxy) {
+	// Shows the access rule applied to ?path= and optionally ?method=
+	mux.HandleFunc("/auth/debug/match", func(w http.ResponseWriter, r *http.Request) {
+		handleDebugMatch(w, r, mux, authenticator, proxyHandler)
+	})
+}
+
 // setupHealthRoutes configures health check routes
 func setupHealthRoutes(mux *http.ServeMux, cfg *config.Config) {
 	// Liveness probe - indicates if the application is running
@@ -93,7 +116,7 @@ func setupMetricsRoutes(mux *http.ServeMux, cfg *config.Config) {
 }
 
 // setupInfoRoutes configures version and information routes
-func setupInfoRoutes(mux *http.ServeMux) {
+func setupInfoRoutes(mux *http.ServeMux, cfg *config.Config, serverSecrets *serverSecrets, tlsObserver *tlsprofile.Observer) {
 	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
 		buildInfo := version.Get()
 		w.Header().Set("Content-Type", "application/json")
@@ -105,6 +128,8 @@ func setupInfoRoutes(mux *http.ServeMux) {
 			"service": "console-auth-proxy",
 			"version": version.Get(),
 			"status":  "running",
+			"secrets": serverSecrets.info(cfg),
+			"tls":     tlsObserver.Info(),
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(info)
@@ -151,6 +176,50 @@ func handleAuthInfo(w http.ResponseWriter, r *http.Request, authenticator auth.A
 	json.NewEncoder(w).Encode(userInfo)
 }
 
+// handleDebugMatch returns the access rule applied to a path, for authenticated users only
+func handleDebugMatch(w http.ResponseWriter, r *http.Request, mux *http.ServeMux, authenticator auth.Authenticator, proxyHandler *proxy.AuthenticatedProxy) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if _, err := authenticator.Authenticate(w, r); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"authenticated": false,
+			"error":         "Not authenticated",
+		})
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	if !strings.HasPrefix(path, "/") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "path query parameter must start with /",
+		})
+		return
+	}
+
+	method := strings.ToUpper(r.URL.Query().Get("method"))
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	response := struct {
+		proxy.RuleMatch
+		// Set when the path is served by the proxy itself and rules don't apply
+		Endpoint string `json:"endpoint,omitempty"`
+	}{
+		RuleMatch: proxyHandler.MatchRule(method, path),
+	}
+
+	if req, err := http.NewRequest(method, path, nil); err == nil {
+		if _, pattern := mux.Handler(req); pattern != "/" {
+			response.Endpoint = pattern
+		}
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
 // handleAuthError displays authentication errors
 func handleAuthError(w http.ResponseWriter, r *http.Request) {
 	errorType := r.URL.Query().Get("error_type")
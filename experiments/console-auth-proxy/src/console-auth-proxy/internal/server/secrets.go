@@ -0,0 +1,81 @@
+package server
+
+import (
+	"k8s.io/klog/v2"
+
+	"github.com/your-org/console-auth-proxy/internal/config"
+	"github.com/your-org/console-auth-proxy/internal/secrets"
+)
+
+// serverSecrets holds the secrets referenced by the configuration
+type serverSecrets struct {
+	clientSecret        *secrets.Source
+	cookieAuthKey       *secrets.Source
+	cookieEncryptionKey *secrets.Source
+	rateLimitPeers      *secrets.Source
+}
+
+// loadSecrets resolves literal, ${ENV}, file:// and *_file secrets
+func loadSecrets(cfg *config.Config) (*serverSecrets, error) {
+	s := &serverSecrets{}
+	var err error
+
+	if s.clientSecret, err = secrets.New("auth.client_secret", cfg.Auth.ClientSecret, cfg.Auth.ClientSecretFile); err != nil {
+		return nil, err
+	}
+	if s.cookieAuthKey, err = secrets.New("auth.cookie_authentication_key", cfg.Auth.CookieAuthenticationKey, cfg.Auth.CookieAuthenticationKeyFile); err != nil {
+		return nil, err
+	}
+	if s.cookieEncryptionKey, err = secrets.New("auth.cookie_encryption_key", cfg.Auth.CookieEncryptionKey, cfg.Auth.CookieEncryptionKeyFile); err != nil {
+		return nil, err
+	}
+	peers := cfg.Proxy.RateLimit.Peers
+	if s.rateLimitPeers, err = secrets.New("proxy.rate_limit.peers.secret", peers.Secret, peers.SecretFile); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// info describes where each secret comes from for the /info endpoint, never its value
+func (s *serverSecrets) info(cfg *config.Config) map[string]interface{} {
+	describe := func(source *secrets.Source) map[string]interface{} {
+		if !source.IsSet() {
+			return map[string]interface{}{"set": false}
+		}
+		return map[string]interface{}{"set": true, "source": source.Kind(), "value": secrets.RedactedValue}
+	}
+
+	bearerToken := map[string]interface{}{"set": false}
+	if cfg.Auth.KubeConfig.BearerTokenFile != "" {
+		bearerToken = map[string]interface{}{"set": true, "source": secrets.KindFile, "value": secrets.RedactedValue}
+	} else if kind, _ := secrets.Parse(cfg.Auth.KubeConfig.BearerToken); kind != secrets.KindNone {
+		bearerToken = map[string]interface{}{"set": true, "source": kind, "value": secrets.RedactedValue}
+	}
+
+	return map[string]interface{}{
+		"auth.client_secret":             describe(s.clientSecret),
+		"auth.cookie_authentication_key": describe(s.cookieAuthKey),
+		"auth.cookie_encryption_key":     describe(s.cookieEncryptionKey),
+		"auth.kube_config.bearer_token":  bearerToken,
+		"proxy.rate_limit.peers.secret":  describe(s.rateLimitPeers),
+	}
+}
+
+// cookieKeys returns the session cookie keys, generating them if not provided (development only)
+func (s *serverSecrets) cookieKeys() ([]byte, []byte) {
+	// Read once: sessions encrypted with previous keys could not be decoded anyway
+	cookieAuthKey := []byte(s.cookieAuthKey.Value())
+	cookieEncryptKey := []byte(s.cookieEncryptionKey.Value())
+
+	if len(cookieAuthKey) == 0 {
+		cookieAuthKey = generateRandomKey(64)
+		klog.Warning("No cookie authentication key provided, generated random key for development")
+	}
+	if len(cookieEncryptKey) == 0 {
+		cookieEncryptKey = generateRandomKey(32)
+		klog.Warning("No cookie encryption key provided, generated random key for development")
+	}
+
+	return cookieAuthKey, cookieEncryptKey
+}
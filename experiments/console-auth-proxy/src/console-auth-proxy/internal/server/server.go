@@ -2,28 +2,40 @@ package server
 
 import (
 	"context"
+	"crypto/fips140"
 	"crypto/tls"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"k8s.io/klog/v2"
 
+	"github.com/your-org/console-auth-proxy/internal/capture"
 	"github.com/your-org/console-auth-proxy/internal/config"
+	"github.com/your-org/console-auth-proxy/internal/policy"
 	"github.com/your-org/console-auth-proxy/internal/proxy"
+	"github.com/your-org/console-auth-proxy/internal/ratelimit"
 	"github.com/your-org/console-auth-proxy/pkg/auth"
+	"github.com/your-org/console-auth-proxy/pkg/auth/clitoken"
+	"github.com/your-org/console-auth-proxy/pkg/auth/local"
+	"github.com/your-org/console-auth-proxy/pkg/auth/mtls"
 	"github.com/your-org/console-auth-proxy/pkg/auth/oauth2"
 	"github.com/your-org/console-auth-proxy/pkg/auth/static"
+	"github.com/your-org/console-auth-proxy/pkg/tlsprofile"
 )
 
 // Server represents the HTTP server
 type Server struct {
 	config        *config.Config
 	httpServer    *http.Server
+	peerServer    *http.Server
 	authenticator auth.Authenticator
 	proxy         *proxy.AuthenticatedProxy
 	metrics       *auth.Metrics
+	capture       *capture.Recorder
 }
 
 // New creates a new server instance
@@ -43,27 +55,116 @@ func New(cfg *config.Config) (*Server, error) {
 		}
 	}
 
+	// Apply the same TLS profile to the listener, the backend and the auth provider
+	tlsProfile, err := tlsprofile.Get(cfg.TLSProfile)
+	if err != nil {
+		return nil, err
+	}
+	tlsObserver := tlsprofile.NewObserver(tlsProfile)
+	if cfg.Observability.Metrics.Enabled {
+		for _, collector := range tlsObserver.GetCollectors() {
+			if err := prometheus.Register(collector); err != nil {
+				klog.Warningf("Failed to register metric: %v", err)
+			}
+		}
+	}
+	if tlsProfile.Name == tlsprofile.FIPS && !fips140.Enabled() {
+		klog.Warningf("TLS profile %s is active but Go is not running in FIPS 140-3 mode (GODEBUG=fips140=on)", tlsProfile.Name)
+	}
+
+	// Initialize client certificate authenticator
+	var certAuthenticator *mtls.Authenticator
+	if cfg.Auth.MTLSEnabled() {
+		var err error
+		certAuthenticator, err = createMTLSAuthenticator(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create mTLS authenticator: %w", err)
+		}
+	}
+
+	// Resolve secrets from literals, environment variables and files
+	serverSecrets, err := loadSecrets(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secrets: %w", err)
+	}
+
 	// Initialize authenticator
-	authenticator, err := createAuthenticator(cfg, metrics)
+	authenticator, err := createAuthenticator(cfg, metrics, certAuthenticator, serverSecrets, tlsObserver)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create authenticator: %w", err)
 	}
 
+	// Initialize rate limits, kept in memory and shared with the other replicas if configured
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	var peerServer *http.Server
+	if peers := &cfg.Proxy.RateLimit.Peers; peers.Enabled() {
+		peerStore, err := ratelimit.NewPeerStore(peers, serverSecrets.rateLimitPeers, rateLimitStore)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create rate limit peer store: %w", err)
+		}
+		rateLimitStore = peerStore
+		peerServer = &http.Server{
+			Addr:         fmt.Sprintf(":%d", peers.Port),
+			Handler:      peerStore.Handler(),
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			IdleTimeout:  cfg.Server.IdleTimeout,
+		}
+	}
+	limiter, err := ratelimit.New(&cfg.Proxy.RateLimit, rateLimitStore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rate limiter: %w", err)
+	}
+
+	// Accept CLI tokens issued from browser sessions
+	var cliHandler *clitoken.Handler
+	if !cfg.Auth.CLI.Disabled {
+		cliTokens := clitoken.NewStore(cfg.Auth.CLI.TokenTTL, cfg.Auth.CLI.DeviceCodeTTL, cfg.Auth.CLI.PollInterval, cfg.Auth.CLI.MaxPendingDevices)
+		cliHandler = clitoken.NewHandler(cliTokens, authenticator, cfg.Auth.SecureCookies, cfg.Auth.CLI.PublicURL)
+		cliHandler.LimitDeviceAuthorization(func(w http.ResponseWriter, r *http.Request) bool {
+			return limiter.AllowIP(w, r, "device", cfg.Auth.CLI.DeviceRateLimit)
+		})
+		authenticator = clitoken.NewAuthenticator(cliTokens, authenticator)
+	}
+
+	// Initialize CORS and security header policies
+	policyEngine := policy.New(&cfg.Policy)
+
 	// Initialize proxy
-	proxyHandler, err := proxy.NewAuthenticatedProxy(cfg, authenticator)
+	proxyHandler, err := proxy.NewAuthenticatedProxy(cfg, authenticator, policyEngine, limiter, tlsObserver)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create proxy: %w", err)
 	}
 
+	// Initialize traffic capture for debugging, disabled unless configured
+	// Headers carrying credentials and identities are redacted whatever they are called
+	var clientCertHeader string
+	if cfg.Auth.MTLSEnabled() {
+		clientCertHeader = cfg.Auth.MTLS.ForwardHeader
+	}
+	headers := cfg.Proxy.Headers
+	recorder := capture.New(&cfg.Observability.Capture, headers.AuthHeader,
+		headers.UserHeader, headers.UserIDHeader, headers.EmailHeader, headers.GroupsHeader, clientCertHeader)
+
 	// Create HTTP server
 	mux := http.NewServeMux()
 	
 	// Setup routes
-	setupRoutes(mux, cfg, authenticator, proxyHandler, metrics)
+	setupRoutes(mux, cfg, authenticator, proxyHandler, metrics, serverSecrets, recorder, tlsObserver)
+	if cliHandler != nil {
+		cliHandler.Register(mux)
+	}
+
+	handler := recorder.Handler(policyEngine.Handler(limiter.Handler(mux)))
+
+	// Accept cleartext HTTP/2 for gRPC clients, TLS listeners negotiate it with ALPN
+	if cfg.Server.H2C {
+		handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: cfg.Server.IdleTimeout})
+	}
 
 	httpServer := &http.Server{
 		Addr:         cfg.Server.ListenAddress,
-		Handler:      mux,
+		Handler:      handler,
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
 		IdleTimeout:  cfg.Server.IdleTimeout,
@@ -78,21 +179,39 @@ func New(cfg *config.Config) (*Server, error) {
 
 		httpServer.TLSConfig = &tls.Config{
 			Certificates: []tls.Certificate{cert},
-			MinVersion:   tls.VersionTLS12,
+		}
+		tlsProfile.Apply(httpServer.TLSConfig)
+		tlsObserver.ObserveServer(httpServer.TLSConfig)
+
+		// Request client certificates without requiring them, so browsers and
+		// health probes can still connect. Revocation is checked by the authenticator.
+		if certAuthenticator != nil {
+			httpServer.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			httpServer.TLSConfig.ClientCAs = certAuthenticator.ClientCAs()
 		}
 	}
 
 	return &Server{
 		config:        cfg,
 		httpServer:    httpServer,
+		peerServer:    peerServer,
 		authenticator: authenticator,
 		proxy:         proxyHandler,
 		metrics:       metrics,
+		capture:       recorder,
 	}, nil
 }
 
 // ListenAndServe starts the HTTP server
 func (s *Server) ListenAndServe() error {
+	if s.peerServer != nil {
+		go func() {
+			if err := s.peerServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				klog.Errorf("Rate limit peer server failed: %v", err)
+			}
+		}()
+	}
+
 	if s.config.Server.TLS.Enabled {
 		return s.httpServer.ListenAndServeTLS("", "")
 	}
@@ -101,17 +220,48 @@ func (s *Server) ListenAndServe() error {
 
 // Shutdown gracefully shuts down the server
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.httpServer.Shutdown(ctx)
+	err := s.httpServer.Shutdown(ctx)
+	if s.peerServer != nil {
+		s.peerServer.Shutdown(ctx)
+	}
+
+	// Write requests captured since the last flush
+	if flushErr := s.capture.Flush(); flushErr != nil {
+		klog.Errorf("Failed to write capture file: %v", flushErr)
+	}
+	return err
 }
 
 // Close forcefully closes the server
 func (s *Server) Close() error {
+	if s.peerServer != nil {
+		s.peerServer.Close()
+	}
 	return s.httpServer.Close()
 }
 
 // createAuthenticator creates the appropriate authenticator based on configuration
-func createAuthenticator(cfg *config.Config, metrics *auth.Metrics) (auth.Authenticator, error) {
+func createAuthenticator(cfg *config.Config, metrics *auth.Metrics, certAuthenticator *mtls.Authenticator, serverSecrets *serverSecrets, tlsObserver *tlsprofile.Observer) (auth.Authenticator, error) {
 	switch cfg.Auth.AuthSource {
+	case "mtls":
+		return certAuthenticator, nil
+
+	case "local":
+		// Local users from an htpasswd file for development clusters
+		cookieAuthKey, cookieEncryptKey := serverSecrets.cookieKeys()
+		return local.NewAuthenticator(&local.Config{
+			HtpasswdFile:            cfg.Auth.Local.HtpasswdFile,
+			GroupsFile:              cfg.Auth.Local.GroupsFile,
+			ReloadInterval:          cfg.Auth.Local.ReloadInterval,
+			SessionTTL:              cfg.Auth.Local.SessionTTL,
+			SuccessURL:              cfg.Auth.SuccessURL,
+			CookiePath:              cfg.Auth.CookiePath,
+			SecureCookies:           cfg.Auth.SecureCookies,
+			CookieAuthenticationKey: cookieAuthKey,
+			CookieEncryptionKey:     cookieEncryptKey,
+			Metrics:                 metrics,
+		})
+
 	case "static":
 		// Static authenticator for development/testing
 		user := auth.User{
@@ -140,18 +290,7 @@ func createAuthenticator(cfg *config.Config, metrics *auth.Metrics) (auth.Authen
 		}
 
 		// Prepare cookie encryption keys
-		cookieAuthKey := []byte(cfg.Auth.CookieAuthenticationKey)
-		cookieEncryptKey := []byte(cfg.Auth.CookieEncryptionKey)
-
-		// Generate keys if not provided (development only)
-		if len(cookieAuthKey) == 0 {
-			cookieAuthKey = generateRandomKey(64)
-			klog.Warning("No cookie authentication key provided, generated random key for development")
-		}
-		if len(cookieEncryptKey) == 0 {
-			cookieEncryptKey = generateRandomKey(32)
-			klog.Warning("No cookie encryption key provided, generated random key for development")
-		}
+		cookieAuthKey, cookieEncryptKey := serverSecrets.cookieKeys()
 
 		// Create OAuth2 authenticator configuration
 		authConfig := &oauth2.Config{
@@ -161,7 +300,7 @@ func createAuthenticator(cfg *config.Config, metrics *auth.Metrics) (auth.Authen
 			IssuerCA:                   cfg.Auth.IssuerCA,
 			RedirectURL:                cfg.Auth.RedirectURL,
 			ClientID:                   cfg.Auth.ClientID,
-			ClientSecret:               cfg.Auth.ClientSecret,
+			ClientSecretFunc:           serverSecrets.clientSecret.Value,
 			Scope:                      cfg.Auth.Scope,
 			K8sCA:                      cfg.Auth.K8sCA,
 			SuccessURL:                 cfg.Auth.SuccessURL,
@@ -173,6 +312,8 @@ func createAuthenticator(cfg *config.Config, metrics *auth.Metrics) (auth.Authen
 			TLS: oauth2.TLSConfig{
 				InsecureSkipVerify: cfg.Auth.TLS.InsecureSkipVerify,
 				ServerName:         cfg.Auth.TLS.ServerName,
+				Profile:            tlsObserver.Profile(),
+				Observer:           tlsObserver,
 			},
 			K8sConfig:                  k8sConfig,
 			Metrics:                    metrics,
@@ -180,13 +321,42 @@ func createAuthenticator(cfg *config.Config, metrics *auth.Metrics) (auth.Authen
 		}
 
 		// Create OAuth2 authenticator
-		return oauth2.NewOAuth2Authenticator(context.Background(), authConfig)
+		oauth2Authenticator, err := oauth2.NewOAuth2Authenticator(context.Background(), authConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		// Combined mode: accept client certificates, fall back to OAuth sessions
+		if certAuthenticator != nil {
+			return mtls.NewCombinedAuthenticator(certAuthenticator, oauth2Authenticator), nil
+		}
+		return oauth2Authenticator, nil
 
 	default:
 		return nil, fmt.Errorf("unsupported auth source: %s", cfg.Auth.AuthSource)
 	}
 }
 
+// createMTLSAuthenticator creates the client certificate authenticator
+func createMTLSAuthenticator(cfg *config.Config) (*mtls.Authenticator, error) {
+	mtlsConfig := &mtls.Config{
+		CAFile:             cfg.Auth.MTLS.CAFile,
+		CRLFiles:           cfg.Auth.MTLS.CRLFiles,
+		CRLRefreshInterval: cfg.Auth.MTLS.CRLRefreshInterval,
+	}
+
+	for _, rule := range cfg.Auth.MTLS.UserMapping {
+		mtlsConfig.Rules = append(mtlsConfig.Rules, mtls.MappingRule{
+			Field:    rule.Field,
+			Match:    rule.Match,
+			Username: rule.Username,
+			ID:       rule.ID,
+		})
+	}
+
+	return mtls.NewAuthenticator(mtlsConfig)
+}
+
 // generateRandomKey generates a random key for development purposes
 func generateRandomKey(length int) []byte {
 	key := make([]byte, length)
@@ -0,0 +1,83 @@
+package clitoken
+
+import (
+	"net/http"
+	"strings"
+
+	"k8s.io/klog/v2"
+
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+)
+
+// Authenticator accepts CLI tokens from the Authorization header and
+// delegates everything else to the wrapped authenticator
+type Authenticator struct {
+	auth.Authenticator
+	store *Store
+}
+
+// NewAuthenticator wraps an authenticator with CLI token support
+func NewAuthenticator(store *Store, delegate auth.Authenticator) *Authenticator {
+	return &Authenticator{
+		Authenticator: delegate,
+		store:         store,
+	}
+}
+
+// Authenticate checks for a CLI bearer token before falling back to the
+// wrapped authenticator. A CLI token that is unknown or expired is rejected.
+// Browser sessions refresh the identity carried by the user's CLI tokens.
+func (a *Authenticator) Authenticate(w http.ResponseWriter, r *http.Request) (*auth.User, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		user, err := a.Authenticator.Authenticate(w, r)
+		if err == nil {
+			a.store.Refresh(user)
+		}
+		return user, err
+	}
+
+	user, ok := a.store.Lookup(token)
+	if !ok {
+		return nil, errInvalidToken
+	}
+
+	// The CLI token is only meant for the proxy, don't forward it to the backend
+	r.Header.Del("Authorization")
+	return user, nil
+}
+
+// LogoutFunc revokes the user's CLI tokens before the wrapped authenticator
+// ends the session
+func (a *Authenticator) LogoutFunc(w http.ResponseWriter, r *http.Request) {
+	var user *auth.User
+	if token, ok := bearerToken(r); ok {
+		user, _ = a.store.Lookup(token)
+	} else {
+		user, _ = a.Authenticator.Authenticate(w, r)
+	}
+
+	if user != nil {
+		if revoked := a.store.RevokeUser(user.Username); revoked > 0 {
+			klog.Infof("Revoked %d CLI tokens of user %s on logout", revoked, user.Username)
+		}
+	}
+
+	a.Authenticator.LogoutFunc(w, r)
+}
+
+// CSRFExempt exempts requests carrying a CLI token and no cookies. Browsers
+// can't attach the Authorization header to cross-site requests on their own.
+func CSRFExempt(r *http.Request) bool {
+	_, ok := bearerToken(r)
+	return ok && r.Header.Get("Cookie") == ""
+}
+
+// bearerToken returns the CLI token of the Authorization header, if any
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || !strings.HasPrefix(token, TokenPrefix) {
+		return "", false
+	}
+	return token, true
+}
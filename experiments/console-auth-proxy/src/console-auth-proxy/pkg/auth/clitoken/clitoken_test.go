@@ -0,0 +1,343 @@
+package clitoken
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+)
+
+// sessionAuthenticator authenticates requests carrying a "session" cookie
+type sessionAuthenticator struct {
+	auth.Authenticator
+}
+
+func (sessionAuthenticator) Authenticate(w http.ResponseWriter, r *http.Request) (*auth.User, error) {
+	cookie, err := r.Cookie("session")
+	if err != nil {
+		return nil, errors.New("no session")
+	}
+	return &auth.User{ID: cookie.Value, Username: cookie.Value, Token: "upstream-" + cookie.Value}, nil
+}
+
+func (sessionAuthenticator) LogoutFunc(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (sessionAuthenticator) GetOCLoginCommand() string            { return "" }
+func (sessionAuthenticator) GetSpecialURLs() auth.SpecialAuthURLs { return auth.SpecialAuthURLs{} }
+
+func TestStoreTokens(t *testing.T) {
+	s := NewStore(time.Hour, time.Minute, time.Second, 10)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	token, expires := s.IssueToken(&auth.User{Username: "alice"})
+	if !strings.HasPrefix(token, TokenPrefix) || !expires.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected token %q expiring %v", token, expires)
+	}
+	if _, ok := s.tokens[token]; ok {
+		t.Errorf("token stored in plain text")
+	}
+
+	user, ok := s.Lookup(token)
+	if !ok || user.Username != "alice" {
+		t.Errorf("unexpected lookup result %v %v", user, ok)
+	}
+	if _, ok := s.Lookup(TokenPrefix + "unknown"); ok {
+		t.Errorf("expected unknown token to be rejected")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, ok := s.Lookup(token); ok {
+		t.Errorf("expected expired token to be rejected")
+	}
+}
+
+func TestStoreDeviceFlow(t *testing.T) {
+	s := NewStore(time.Hour, time.Minute, time.Second, 10)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	deviceCode, userCode, _ := s.StartDeviceAuthorization()
+	if !regexp.MustCompile(`^[` + userCodeAlphabet + `]{4}-[` + userCodeAlphabet + `]{4}$`).MatchString(userCode) {
+		t.Errorf("unexpected user code %q", userCode)
+	}
+
+	if _, _, err := s.PollToken(deviceCode); err != errAuthorizationPending {
+		t.Errorf("expected authorization_pending, got %v", err)
+	}
+	// Polling faster than the interval slows the client down
+	if _, _, err := s.PollToken(deviceCode); err != errSlowDown {
+		t.Errorf("expected slow_down, got %v", err)
+	}
+	if _, _, err := s.PollToken("unknown"); err != errInvalidGrant {
+		t.Errorf("expected invalid_grant, got %v", err)
+	}
+
+	// Codes are accepted without the dash and in lowercase
+	if err := s.Approve(strings.ToLower(strings.ReplaceAll(userCode, "-", "")), &auth.User{Username: "alice"}, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Approve(userCode, &auth.User{Username: "mallory"}, true); err == nil {
+		t.Errorf("expected second approval to fail")
+	}
+
+	token, _, err := s.PollToken(deviceCode)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user, ok := s.Lookup(token); !ok || user.Username != "alice" {
+		t.Errorf("unexpected user %v", user)
+	}
+	// Device codes are single use
+	if _, _, err := s.PollToken(deviceCode); err != errInvalidGrant {
+		t.Errorf("expected invalid_grant after redemption, got %v", err)
+	}
+
+	// Denied and expired requests
+	deviceCode, userCode, _ = s.StartDeviceAuthorization()
+	s.Approve(userCode, nil, false)
+	if _, _, err := s.PollToken(deviceCode); err != errAccessDenied {
+		t.Errorf("expected access_denied, got %v", err)
+	}
+
+	deviceCode, userCode, _ = s.StartDeviceAuthorization()
+	now = now.Add(2 * time.Minute)
+	if err := s.Approve(userCode, &auth.User{Username: "alice"}, true); err != errExpiredToken {
+		t.Errorf("expected expired code to be rejected, got %v", err)
+	}
+	if _, _, err := s.PollToken(deviceCode); err != errExpiredToken {
+		t.Errorf("expected expired_token, got %v", err)
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	s := NewStore(time.Hour, time.Minute, time.Second, 10)
+	a := NewAuthenticator(s, sessionAuthenticator{})
+	token, _ := s.IssueToken(&auth.User{Username: "alice"})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	if !CSRFExempt(r) {
+		t.Errorf("expected CLI token request to be CSRF exempt")
+	}
+	user, err := a.Authenticate(httptest.NewRecorder(), r)
+	if err != nil || user.Username != "alice" {
+		t.Fatalf("unexpected result %v %v", user, err)
+	}
+	if r.Header.Get("Authorization") != "" {
+		t.Errorf("CLI token would be forwarded to the backend")
+	}
+
+	// Unknown CLI tokens don't fall back to the session
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+TokenPrefix+"unknown")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "bob"})
+	if _, err := a.Authenticate(httptest.NewRecorder(), r); err == nil {
+		t.Errorf("expected unknown CLI token to be rejected")
+	}
+	if CSRFExempt(r) {
+		t.Errorf("expected request with cookies not to be CSRF exempt")
+	}
+
+	// Other bearer tokens are left to the wrapped authenticator
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer sha256~upstream")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "bob"})
+	if user, err := a.Authenticate(httptest.NewRecorder(), r); err != nil || user.Username != "bob" {
+		t.Errorf("unexpected result %v %v", user, err)
+	}
+	if r.Header.Get("Authorization") == "" {
+		t.Errorf("upstream bearer token was stripped")
+	}
+}
+
+func TestAuthenticatorRefreshAndLogout(t *testing.T) {
+	s := NewStore(time.Hour, time.Minute, time.Second, 10)
+	a := NewAuthenticator(s, sessionAuthenticator{})
+	token, _ := s.IssueToken(&auth.User{Username: "alice", Token: "stale"})
+	other, _ := s.IssueToken(&auth.User{Username: "bob"})
+
+	// A browser request refreshes the identity carried by the CLI token
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "session", Value: "alice"})
+	if _, err := a.Authenticate(httptest.NewRecorder(), r); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if user, ok := s.Lookup(token); !ok || user.Token != "upstream-alice" {
+		t.Errorf("CLI token still carries %v", user)
+	}
+
+	// Logging out revokes the user's tokens only
+	r = httptest.NewRequest("POST", "/auth/logout", nil)
+	r.AddCookie(&http.Cookie{Name: "session", Value: "alice"})
+	a.LogoutFunc(httptest.NewRecorder(), r)
+	if _, ok := s.Lookup(token); ok {
+		t.Errorf("expected token to be revoked on logout")
+	}
+	if _, ok := s.Lookup(other); !ok {
+		t.Errorf("expected other users' tokens to be kept")
+	}
+
+	// So does logging out with a CLI token
+	r = httptest.NewRequest("POST", "/auth/logout", nil)
+	r.Header.Set("Authorization", "Bearer "+other)
+	a.LogoutFunc(httptest.NewRecorder(), r)
+	if _, ok := s.Lookup(other); ok {
+		t.Errorf("expected token to be revoked on logout")
+	}
+}
+
+func TestDeviceAuthorizationLimits(t *testing.T) {
+	s := NewStore(time.Hour, time.Minute, time.Second, 2)
+	h := NewHandler(s, sessionAuthenticator{}, false, "https://proxy.example.com/")
+
+	start := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "http://internal:8080/auth/device/code", nil)
+		r.Header.Set("X-Forwarded-Host", "evil.example.com")
+		w := httptest.NewRecorder()
+		h.DeviceAuthorization(w, r)
+		return w
+	}
+
+	w := start()
+	var device map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&device)
+	if device["verification_uri"] != "https://proxy.example.com/auth/device" {
+		t.Errorf("unexpected verification URI %v", device["verification_uri"])
+	}
+
+	start()
+	if w := start(); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected pending device authorizations to be capped, got %d", w.Code)
+	}
+
+	// A rejecting rate limit answers before a device code is created
+	h.LimitDeviceAuthorization(func(w http.ResponseWriter, r *http.Request) bool {
+		w.WriteHeader(http.StatusTooManyRequests)
+		return false
+	})
+	if w := start(); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected rate limited request, got %d", w.Code)
+	}
+}
+
+var stateRegexp = regexp.MustCompile(`name="state" value="([^"]+)"`)
+
+// submitForm loads a page and posts its form with the given values
+func submitForm(t *testing.T, handler http.HandlerFunc, path string, values url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	session := &http.Cookie{Name: "session", Value: "alice"}
+
+	r := httptest.NewRequest("GET", path, nil)
+	r.AddCookie(session)
+	page := httptest.NewRecorder()
+	handler(page, r)
+
+	match := stateRegexp.FindStringSubmatch(page.Body.String())
+	if match == nil {
+		t.Fatalf("no state in page: %s", page.Body.String())
+	}
+	values.Set("state", match[1])
+
+	r = httptest.NewRequest("POST", path, strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(session)
+	for _, c := range page.Result().Cookies() {
+		r.AddCookie(c)
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestTokenPage(t *testing.T) {
+	s := NewStore(time.Hour, time.Minute, time.Second, 10)
+	h := NewHandler(s, sessionAuthenticator{}, false, "")
+
+	// Without a session the user is sent to the login page
+	w := httptest.NewRecorder()
+	h.TokenPage(w, httptest.NewRequest("GET", "/auth/token", nil))
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/auth/login?return_url=%2Fauth%2Ftoken" {
+		t.Errorf("unexpected response %d to %s", w.Code, w.Header().Get("Location"))
+	}
+
+	w = submitForm(t, h.TokenPage, "/auth/token", url.Values{})
+	token := regexp.MustCompile(TokenPrefix + `[0-9a-f]+`).FindString(w.Body.String())
+	if user, ok := s.Lookup(token); !ok || user.Username != "alice" {
+		t.Errorf("token page did not issue a token: %s", w.Body.String())
+	}
+	// The CLI command shown doesn't put the token on the command line
+	if strings.Contains(w.Body.String(), "--token "+token) || !strings.Contains(w.Body.String(), "--token-stdin") {
+		t.Errorf("token page suggests passing the token as an argument: %s", w.Body.String())
+	}
+
+	// Posting without the form state is rejected
+	r := httptest.NewRequest("POST", "/auth/token", strings.NewReader("state=forged"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "alice"})
+	w = httptest.NewRecorder()
+	h.TokenPage(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected forged form to be rejected, got %d", w.Code)
+	}
+}
+
+func TestDeviceEndpoints(t *testing.T) {
+	s := NewStore(time.Hour, time.Minute, time.Second, 10)
+	h := NewHandler(s, sessionAuthenticator{}, false, "")
+
+	w := httptest.NewRecorder()
+	h.DeviceAuthorization(w, httptest.NewRequest("POST", "https://proxy.example.com/auth/device/code", nil))
+	var device map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&device); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	userCode := device["user_code"].(string)
+	if device["verification_uri"] != "https://proxy.example.com/auth/device" || device["interval"] != float64(1) {
+		t.Errorf("unexpected response %v", device)
+	}
+
+	poll := func(grantType string) (int, map[string]interface{}) {
+		form := url.Values{"grant_type": {grantType}, "device_code": {device["device_code"].(string)}}
+		r := httptest.NewRequest("POST", "/auth/device/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.DeviceToken(w, r)
+		var body map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&body)
+		return w.Code, body
+	}
+
+	if code, body := poll("password"); code != http.StatusBadRequest || body["error"] != "unsupported_grant_type" {
+		t.Errorf("unexpected response %d %v", code, body)
+	}
+	if code, body := poll(DeviceCodeGrantType); code != http.StatusBadRequest || body["error"] != "authorization_pending" {
+		t.Errorf("unexpected response %d %v", code, body)
+	}
+
+	w = submitForm(t, h.DeviceVerification, "/auth/device?user_code="+userCode, url.Values{
+		"user_code": {userCode},
+		"action":    {"approve"},
+	})
+	if !strings.Contains(w.Body.String(), "Device approved") {
+		t.Errorf("device was not approved: %s", w.Body.String())
+	}
+
+	code, body := poll(DeviceCodeGrantType)
+	if code != http.StatusOK || body["token_type"] != "Bearer" {
+		t.Fatalf("unexpected response %d %v", code, body)
+	}
+	if user, ok := s.Lookup(body["access_token"].(string)); !ok || user.Username != "alice" {
+		t.Errorf("unexpected user %v", user)
+	}
+}
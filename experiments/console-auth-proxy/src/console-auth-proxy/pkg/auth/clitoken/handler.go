@@ -0,0 +1,341 @@
+package clitoken
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+	"github.com/your-org/console-auth-proxy/pkg/auth/sessions"
+)
+
+const (
+	// DeviceCodeGrantType is the grant_type of device access token requests
+	DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+	formStateCookieName = "cli-form-state"
+)
+
+// Handler serves the token page and the device authorization grant endpoints
+type Handler struct {
+	store         *Store
+	authenticator auth.Authenticator
+	deviceTTL     time.Duration
+	pollInterval  time.Duration
+	secureCookies bool
+	publicURL     string
+
+	// allowDeviceAuthorization rate limits unauthenticated device logins
+	allowDeviceAuthorization func(w http.ResponseWriter, r *http.Request) bool
+}
+
+// NewHandler creates the CLI token HTTP handlers. The authenticator identifies
+// the browser session on the token and device verification pages; it must not
+// accept CLI tokens, or a token could be used to mint new ones. Verification
+// URIs point at publicURL, or at the requested host if it is empty.
+func NewHandler(store *Store, authenticator auth.Authenticator, secureCookies bool, publicURL string) *Handler {
+	return &Handler{
+		store:         store,
+		authenticator: authenticator,
+		deviceTTL:     store.deviceTTL,
+		pollInterval:  store.pollInterval,
+		secureCookies: secureCookies,
+		publicURL:     strings.TrimSuffix(publicURL, "/"),
+	}
+}
+
+// LimitDeviceAuthorization sets the rate limit of the device authorization
+// endpoint. allow writes the response and returns false to reject a request.
+func (h *Handler) LimitDeviceAuthorization(allow func(w http.ResponseWriter, r *http.Request) bool) {
+	h.allowDeviceAuthorization = allow
+}
+
+// Register adds the CLI token routes to the mux
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/auth/token", h.TokenPage)
+	mux.HandleFunc("/auth/device", h.DeviceVerification)
+	mux.HandleFunc("/auth/device/code", h.DeviceAuthorization)
+	mux.HandleFunc("/auth/device/token", h.DeviceToken)
+}
+
+// TokenPage shows a copyable CLI token for the current session. The token is
+// only issued on POST so a cross-site GET can't mint tokens.
+func (h *Handler) TokenPage(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	data := map[string]interface{}{
+		"Username": user.Username,
+		"URL":      h.baseURL(r),
+	}
+
+	if r.Method == http.MethodPost {
+		if !h.checkFormState(w, r) {
+			return
+		}
+
+		token, expires := h.store.IssueToken(user)
+		klog.Infof("Issued CLI token for user %s, expires %v", user.Username, expires.Format(time.RFC3339))
+
+		data["Token"] = token
+		data["Expires"] = expires.Format(time.RFC3339)
+		if cmd := h.authenticator.GetOCLoginCommand(); cmd != "" && user.Token != "" {
+			data["OCLoginCommand"] = cmd + " --token=" + user.Token
+		}
+	}
+
+	if requestToken := h.authenticator.GetSpecialURLs().RequestToken; requestToken != "" {
+		data["RequestTokenURL"] = requestToken
+	}
+
+	h.renderPage(w, tokenPageTemplate, data)
+}
+
+// DeviceAuthorization is the device authorization endpoint (RFC 8628 section 3.1)
+func (h *Handler) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeOAuthError(w, http.StatusMethodNotAllowed, "invalid_request", "device authorization requires POST")
+		return
+	}
+
+	if h.allowDeviceAuthorization != nil && !h.allowDeviceAuthorization(w, r) {
+		return
+	}
+
+	deviceCode, userCode, err := h.store.StartDeviceAuthorization()
+	if err != nil {
+		klog.Warningf("Refusing device authorization: %v", err)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(h.pollInterval.Seconds()))))
+		writeOAuthError(w, http.StatusServiceUnavailable, "temporarily_unavailable", err.Error())
+		return
+	}
+	verificationURI := h.baseURL(r) + "/auth/device"
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"device_code":               deviceCode,
+		"user_code":                 userCode,
+		"verification_uri":          verificationURI,
+		"verification_uri_complete": verificationURI + "?user_code=" + url.QueryEscape(userCode),
+		"expires_in":                int(h.deviceTTL.Seconds()),
+		"interval":                  int(math.Ceil(h.pollInterval.Seconds())),
+	})
+}
+
+// DeviceToken is the device access token endpoint (RFC 8628 section 3.4)
+func (h *Handler) DeviceToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeOAuthError(w, http.StatusMethodNotAllowed, "invalid_request", "token requests require POST")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "invalid form body")
+		return
+	}
+
+	if grantType := r.PostForm.Get("grant_type"); grantType != DeviceCodeGrantType {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "only the device_code grant is supported")
+		return
+	}
+
+	token, expires, err := h.store.PollToken(r.PostForm.Get("device_code"))
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, err.Error(), "")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(time.Until(expires).Seconds()),
+	})
+}
+
+// DeviceVerification lets a signed-in user approve or deny a device code
+func (h *Handler) DeviceVerification(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.requireSession(w, r)
+	if !ok {
+		return
+	}
+
+	data := map[string]interface{}{
+		"Username": user.Username,
+		"UserCode": NormalizeUserCode(r.FormValue("user_code")),
+	}
+
+	if r.Method == http.MethodPost {
+		if !h.checkFormState(w, r) {
+			return
+		}
+
+		approved := r.PostForm.Get("action") == "approve"
+		err := h.store.Approve(r.PostForm.Get("user_code"), user, approved)
+		switch {
+		case err != nil:
+			data["Error"] = "The code is invalid or has expired. Start the login again from your terminal."
+		case approved:
+			klog.Infof("User %s approved device login %s", user.Username, data["UserCode"])
+			data["Done"] = "Device approved. You can close this window and return to your terminal."
+		default:
+			data["Done"] = "Device login denied."
+		}
+	}
+
+	h.renderPage(w, devicePageTemplate, data)
+}
+
+// requireSession authenticates the browser session, redirecting to the login page if there is none
+func (h *Handler) requireSession(w http.ResponseWriter, r *http.Request) (*auth.User, bool) {
+	user, err := h.authenticator.Authenticate(w, r)
+	if err != nil {
+		klog.V(4).Infof("Redirecting %s to login: %v", r.URL.Path, err)
+		http.Redirect(w, r, "/auth/login?return_url="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return nil, false
+	}
+	return user, true
+}
+
+// checkFormState verifies the double-submit state of the page forms
+func (h *Handler) checkFormState(w http.ResponseWriter, r *http.Request) bool {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return false
+	}
+
+	cookie, err := r.Cookie(formStateCookieName)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostForm.Get("state"))) != 1 {
+		http.Error(w, "invalid form state, reload the page and try again", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) renderPage(w http.ResponseWriter, tmpl *template.Template, data map[string]interface{}) {
+	state := sessions.RandomString(32)
+	http.SetCookie(w, &http.Cookie{
+		Name:     formStateCookieName,
+		Value:    state,
+		Path:     "/auth/",
+		HttpOnly: true,
+		Secure:   h.secureCookies,
+		SameSite: http.SameSiteStrictMode,
+	})
+	data["State"] = state
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, data); err != nil {
+		klog.Errorf("Failed to render page: %v", err)
+	}
+}
+
+// baseURL returns the external base URL of the proxy. X-Forwarded-Host is
+// ignored, clients could otherwise point the verification URI at another site.
+func (h *Handler) baseURL(r *http.Request) string {
+	if h.publicURL != "" {
+		return h.publicURL
+	}
+
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	body := map[string]string{"error": code}
+	if description != "" {
+		body["error_description"] = description
+	}
+	writeJSON(w, status, body)
+}
+
+const pageStyle = `<style>
+        body { font-family: Arial, sans-serif; margin: 40px; }
+        pre { background: #f5f5f5; padding: 12px; border-radius: 4px; white-space: pre-wrap; word-break: break-all; }
+        input[type=text] { padding: 6px; font-size: 1.2em; letter-spacing: 2px; }
+        button { margin-top: 16px; margin-right: 8px; padding: 8px 16px; }
+        .error { color: #d32f2f; background: #ffebee; padding: 12px; border-radius: 4px; }
+        .done { color: #2e7d32; background: #e8f5e9; padding: 12px; border-radius: 4px; }
+    </style>`
+
+var tokenPageTemplate = template.Must(template.New("token").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <title>API Token</title>
+    ` + pageStyle + `
+</head>
+<body>
+    <h1>API Token</h1>
+    <p>Signed in as <strong>{{.Username}}</strong>.</p>
+    {{if .Token}}
+    <p>Your token expires at {{.Expires}}.</p>
+    <h2>Token</h2>
+    <pre>{{.Token}}</pre>
+    <h2>Log in with the CLI</h2>
+    <p>The CLI can log in without this token, confirming a code in the browser:</p>
+    <pre>console-auth-proxy login --url {{.URL}}</pre>
+    <p>To store this token instead, paste it on standard input so it stays out of your shell history:</p>
+    <pre>console-auth-proxy login --url {{.URL}} --token-stdin</pre>
+    <h2>Use with curl</h2>
+    <pre>curl -H "Authorization: Bearer {{.Token}}" {{.URL}}/</pre>
+    {{if .OCLoginCommand}}
+    <h2>Log in with oc</h2>
+    <pre>{{.OCLoginCommand}}</pre>
+    {{end}}
+    {{else}}
+    <form method="POST" action="/auth/token">
+        <input type="hidden" name="state" value="{{.State}}">
+        <button type="submit">Display Token</button>
+    </form>
+    {{end}}
+    {{if .RequestTokenURL}}
+    <p><a href="{{.RequestTokenURL}}">Request an OpenShift API token</a></p>
+    {{end}}
+</body>
+</html>`))
+
+var devicePageTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Device Login</title>
+    ` + pageStyle + `
+</head>
+<body>
+    <h1>Device Login</h1>
+    {{if .Done}}
+    <div class="done">{{.Done}}</div>
+    {{else}}
+    {{if .Error}}<div class="error">{{.Error}}</div>{{end}}
+    <p>Signed in as <strong>{{.Username}}</strong>. Enter the code shown in your terminal to log it in as you.</p>
+    <form method="POST" action="/auth/device">
+        <input type="hidden" name="state" value="{{.State}}">
+        <input type="text" name="user_code" value="{{.UserCode}}" placeholder="XXXX-XXXX" autocomplete="off" required>
+        <div>
+            <button type="submit" name="action" value="approve">Approve</button>
+            <button type="submit" name="action" value="deny">Deny</button>
+        </div>
+    </form>
+    {{end}}
+</body>
+</html>`))
@@ -0,0 +1,300 @@
+package clitoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+)
+
+// TokenPrefix marks bearer tokens issued by the proxy so they can be told
+// apart from upstream tokens
+const TokenPrefix = "cap_"
+
+// userCodeAlphabet avoids vowels and look-alike characters (RFC 8628 section 6.1)
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+var (
+	errInvalidToken         = errors.New("invalid or expired CLI token")
+	errAuthorizationPending = errors.New("authorization_pending")
+	errSlowDown             = errors.New("slow_down")
+	errExpiredToken         = errors.New("expired_token")
+	errAccessDenied         = errors.New("access_denied")
+	errInvalidGrant         = errors.New("invalid_grant")
+	errTooManyPending       = errors.New("too many pending device authorizations")
+)
+
+// issuedToken is a CLI token bound to a snapshot of the user that requested it
+type issuedToken struct {
+	user    auth.User
+	expires time.Time
+}
+
+// deviceAuthorization is a pending or completed device authorization request
+type deviceAuthorization struct {
+	userCode string
+	expires  time.Time
+	interval time.Duration
+	lastPoll time.Time
+	user     *auth.User
+	denied   bool
+}
+
+// Store keeps CLI tokens and device authorization requests in memory, like the
+// server-side session store. Tokens are stored by their SHA-256 hash.
+type Store struct {
+	tokenTTL          time.Duration
+	deviceTTL         time.Duration
+	pollInterval      time.Duration
+	maxPendingDevices int
+
+	mu         sync.Mutex
+	tokens     map[string]*issuedToken
+	byUser     map[string]map[string]bool      // username to token hashes
+	devices    map[string]*deviceAuthorization // by device code hash
+	byUserCode map[string]string               // user code to device code hash
+	now        func() time.Time
+}
+
+// NewStore creates a CLI token store. At most maxPendingDevices device
+// authorizations wait for approval at a time.
+func NewStore(tokenTTL, deviceTTL, pollInterval time.Duration, maxPendingDevices int) *Store {
+	return &Store{
+		tokenTTL:          tokenTTL,
+		deviceTTL:         deviceTTL,
+		pollInterval:      pollInterval,
+		maxPendingDevices: maxPendingDevices,
+		tokens:            make(map[string]*issuedToken),
+		byUser:            make(map[string]map[string]bool),
+		devices:           make(map[string]*deviceAuthorization),
+		byUserCode:        make(map[string]string),
+		now:               time.Now,
+	}
+}
+
+// IssueToken creates a CLI token for the user
+func (s *Store) IssueToken(user *auth.User) (string, time.Time) {
+	token := TokenPrefix + randomHex(32)
+	hash := hashToken(token)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked()
+
+	expires := s.now().Add(s.tokenTTL)
+	s.tokens[hash] = &issuedToken{user: *user, expires: expires}
+	if s.byUser[user.Username] == nil {
+		s.byUser[user.Username] = make(map[string]bool)
+	}
+	s.byUser[user.Username][hash] = true
+	return token, expires
+}
+
+// Refresh replaces the identity of the user's CLI tokens with the user of a
+// newer browser session, so tokens forward the current upstream token and
+// groups instead of those of the session that issued them
+func (s *Store) Refresh(user *auth.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash := range s.byUser[user.Username] {
+		if issued, ok := s.tokens[hash]; ok {
+			issued.user = *user
+		}
+	}
+}
+
+// RevokeUser removes all CLI tokens of the user and returns how many there were
+func (s *Store) RevokeUser(username string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hashes := s.byUser[username]
+	for hash := range hashes {
+		delete(s.tokens, hash)
+	}
+	delete(s.byUser, username)
+	return len(hashes)
+}
+
+// Lookup returns the user of a valid CLI token
+func (s *Store) Lookup(token string) (*auth.User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	issued, ok := s.tokens[hashToken(token)]
+	if !ok || s.now().After(issued.expires) {
+		return nil, false
+	}
+
+	user := issued.user
+	return &user, true
+}
+
+// StartDeviceAuthorization creates a device code and the user code shown to
+// the user. It fails when too many authorizations are pending.
+func (s *Store) StartDeviceAuthorization() (deviceCode, userCode string, err error) {
+	deviceCode = randomHex(32)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked()
+
+	if len(s.devices) >= s.maxPendingDevices {
+		return "", "", errTooManyPending
+	}
+
+	for {
+		userCode = randomUserCode()
+		if _, taken := s.byUserCode[userCode]; !taken {
+			break
+		}
+	}
+
+	hash := hashToken(deviceCode)
+	s.devices[hash] = &deviceAuthorization{
+		userCode: userCode,
+		expires:  s.now().Add(s.deviceTTL),
+		interval: s.pollInterval,
+	}
+	s.byUserCode[userCode] = hash
+	return deviceCode, userCode, nil
+}
+
+// Approve binds a pending device authorization to the user, or denies it
+func (s *Store) Approve(userCode string, user *auth.User, approved bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[s.byUserCode[NormalizeUserCode(userCode)]]
+	if !ok || s.now().After(device.expires) {
+		return errExpiredToken
+	}
+	if device.user != nil || device.denied {
+		return errInvalidGrant
+	}
+
+	if approved {
+		userCopy := *user
+		device.user = &userCopy
+	} else {
+		device.denied = true
+	}
+	return nil
+}
+
+// PollToken exchanges an approved device code for a CLI token. Pending,
+// throttled, expired and denied requests return the RFC 8628 error codes.
+func (s *Store) PollToken(deviceCode string) (string, time.Time, error) {
+	s.mu.Lock()
+	hash := hashToken(deviceCode)
+	device, ok := s.devices[hash]
+	if !ok {
+		s.mu.Unlock()
+		return "", time.Time{}, errInvalidGrant
+	}
+
+	now := s.now()
+	switch {
+	case now.After(device.expires):
+		s.removeDeviceLocked(hash)
+		s.mu.Unlock()
+		return "", time.Time{}, errExpiredToken
+	case device.denied:
+		s.removeDeviceLocked(hash)
+		s.mu.Unlock()
+		return "", time.Time{}, errAccessDenied
+	case device.user == nil:
+		if !device.lastPoll.IsZero() && now.Sub(device.lastPoll) < device.interval {
+			// Clients must add 5 seconds to their interval after slow_down
+			device.interval += 5 * time.Second
+			device.lastPoll = now
+			s.mu.Unlock()
+			return "", time.Time{}, errSlowDown
+		}
+		device.lastPoll = now
+		s.mu.Unlock()
+		return "", time.Time{}, errAuthorizationPending
+	}
+
+	user := device.user
+	s.removeDeviceLocked(hash)
+	s.mu.Unlock()
+
+	token, expires := s.IssueToken(user)
+	return token, expires, nil
+}
+
+func (s *Store) removeDeviceLocked(hash string) {
+	if device, ok := s.devices[hash]; ok {
+		delete(s.byUserCode, device.userCode)
+		delete(s.devices, hash)
+	}
+}
+
+func (s *Store) removeUserTokenLocked(username, hash string) {
+	delete(s.byUser[username], hash)
+	if len(s.byUser[username]) == 0 {
+		delete(s.byUser, username)
+	}
+}
+
+// pruneLocked drops expired tokens and device authorizations
+func (s *Store) pruneLocked() {
+	now := s.now()
+	for hash, token := range s.tokens {
+		if now.After(token.expires) {
+			delete(s.tokens, hash)
+			s.removeUserTokenLocked(token.user.Username, hash)
+		}
+	}
+	for hash, device := range s.devices {
+		if now.After(device.expires) {
+			s.removeDeviceLocked(hash)
+		}
+	}
+}
+
+// NormalizeUserCode uppercases a user code and restores its dash, so codes
+// typed without the dash or in lowercase still match
+func NormalizeUserCode(code string) string {
+	code = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	if len(code) == 8 {
+		return code[:4] + "-" + code[4:]
+	}
+	return code
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func randomUserCode() string {
+	code := make([]byte, 0, 9)
+	for i := 0; i < 8; i++ {
+		if i == 4 {
+			code = append(code, '-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			panic(err)
+		}
+		code = append(code, userCodeAlphabet[n.Int64()])
+	}
+	return string(code)
+}
@@ -107,3 +107,20 @@ func TestCSRF(t *testing.T) {
 	testCSRF(t, "", "b", false)
 	testCSRF(t, "", "", false)
 }
+
+func TestExemptions(t *testing.T) {
+	a := CSRFVerifier{secureCookies: false}
+	a.AddExemptions(func(r *http.Request) bool {
+		return r.Header.Get("Origin") == "https://dashboard.example.com"
+	})
+
+	r, err := http.NewRequest("POST", "/some-path", nil)
+	require.NoError(t, err)
+	require.Error(t, a.verifyCSRF(r))
+
+	r.Header.Set("Origin", "https://dashboard.example.com")
+	require.NoError(t, a.verifyCSRF(r))
+
+	r.Header.Set("Origin", "https://evil.example.com")
+	require.Error(t, a.verifyCSRF(r))
+}
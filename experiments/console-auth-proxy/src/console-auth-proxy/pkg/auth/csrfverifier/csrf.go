@@ -16,9 +16,14 @@ const (
 	CSRFHeader     = "X-CSRFToken"
 )
 
+// Exemption reports whether a request may skip the CSRF token check. Exemptions
+// must only return true for requests whose origin they have verified themselves.
+type Exemption func(r *http.Request) bool
+
 type CSRFVerifier struct {
 	refererURL    *url.URL
 	secureCookies bool
+	exemptions    []Exemption
 }
 
 func NewCSRFVerifier(refererURL *url.URL, secureCookies bool) *CSRFVerifier {
@@ -28,6 +33,12 @@ func NewCSRFVerifier(refererURL *url.URL, secureCookies bool) *CSRFVerifier {
 	}
 }
 
+// AddExemptions registers additional exemptions from the CSRF token check.
+// WebSocket upgrades are still subject to source origin verification.
+func (c *CSRFVerifier) AddExemptions(exemptions ...Exemption) {
+	c.exemptions = append(c.exemptions, exemptions...)
+}
+
 func (c *CSRFVerifier) SetCSRFCookie(path string, w http.ResponseWriter) {
 	cookie := http.Cookie{
 		Name:  CSRFCookieName,
@@ -70,6 +81,12 @@ func (c *CSRFVerifier) verifyCSRF(r *http.Request) error {
 		}
 	}
 
+	for _, exempt := range c.exemptions {
+		if exempt(r) {
+			return nil
+		}
+	}
+
 	if err := c.verifyCSRFToken(r); err != nil {
 		return fmt.Errorf("invalid CSRFToken: %v", err)
 	}
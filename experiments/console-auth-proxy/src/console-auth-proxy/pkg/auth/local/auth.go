@@ -0,0 +1,228 @@
+package local
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+	"github.com/your-org/console-auth-proxy/pkg/auth/sessions"
+)
+
+const (
+	stateCookieName = "login-state"
+	loginPath       = "/auth/login"
+)
+
+// Config configures the local users authenticator
+type Config struct {
+	// HtpasswdFile contains username:hash lines (bcrypt or PBKDF2)
+	HtpasswdFile string
+	// GroupsFile is an optional YAML map of username to list of groups
+	GroupsFile string
+	// ReloadInterval is how often the files are checked for changes
+	ReloadInterval time.Duration
+	// SessionTTL is how long a login session lasts
+	SessionTTL time.Duration
+
+	SuccessURL              string
+	CookiePath              string
+	SecureCookies           bool
+	CookieAuthenticationKey []byte
+	CookieEncryptionKey     []byte
+
+	Metrics *auth.Metrics
+}
+
+// Authenticator authenticates users from an htpasswd file with a built-in
+// login form. Meant for development clusters without an identity provider.
+type Authenticator struct {
+	users         *userStore
+	sessions      *sessions.CombinedSessionStore
+	sessionTTL    time.Duration
+	successURL    string
+	secureCookies bool
+	metrics       *auth.Metrics
+}
+
+// NewAuthenticator creates a local users authenticator
+func NewAuthenticator(cfg *Config) (*Authenticator, error) {
+	users, err := newUserStore(cfg.HtpasswdFile, cfg.GroupsFile, cfg.ReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Authenticator{
+		users:         users,
+		sessions:      sessions.NewSessionStore(cfg.CookieAuthenticationKey, cfg.CookieEncryptionKey, cfg.SecureCookies, cfg.CookiePath),
+		sessionTTL:    cfg.SessionTTL,
+		successURL:    cfg.SuccessURL,
+		secureCookies: cfg.SecureCookies,
+		metrics:       cfg.Metrics,
+	}, nil
+}
+
+// Authenticate returns the user of the session cookie. Users removed from the
+// htpasswd file lose access on the next reload.
+func (a *Authenticator) Authenticate(w http.ResponseWriter, r *http.Request) (*auth.User, error) {
+	ls, err := a.sessions.GetSession(w, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve login state: %v", err)
+	}
+
+	if ls == nil || ls.IsExpired() {
+		return nil, errors.New("a session was not found on server or is expired")
+	}
+
+	groups, ok := a.users.lookup(ls.UserID())
+	if !ok {
+		a.sessions.DeleteSession(w, r)
+		return nil, fmt.Errorf("user %s no longer exists", ls.UserID())
+	}
+
+	return &auth.User{
+		ID:       ls.UserID(),
+		Username: ls.Username(),
+		Groups:   groups,
+		AuthTime: ls.AuthTime(),
+	}, nil
+}
+
+// LoginFunc renders the login form on GET and checks the credentials on POST
+func (a *Authenticator) LoginFunc(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if a.metrics != nil {
+			a.metrics.LoginRequested()
+		}
+		a.renderLoginForm(w, r, http.StatusOK, "", "")
+	case http.MethodPost:
+		a.handleLogin(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (a *Authenticator) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	// The state cookie must match the form so other sites can't log users in
+	stateCookie, err := r.Cookie(stateCookieName)
+	if err != nil || subtle.ConstantTimeCompare([]byte(stateCookie.Value), []byte(r.PostForm.Get("state"))) != 1 {
+		klog.V(4).Infof("Local login from %s rejected: missing or invalid login state", r.RemoteAddr)
+		a.renderLoginForm(w, r, http.StatusBadRequest, "", "Your login form expired, please try again.")
+		return
+	}
+
+	username := r.PostForm.Get("username")
+	if username == "" || !a.users.verify(username, r.PostForm.Get("password")) {
+		klog.Warningf("Failed local login for user %q from %s", username, r.RemoteAddr)
+		if a.metrics != nil {
+			a.metrics.LoginFailed(auth.UnknownLoginFailureReason)
+		}
+		a.renderLoginForm(w, r, http.StatusUnauthorized, username, "Invalid username or password.")
+		return
+	}
+
+	if _, err := a.sessions.AddLocalSession(w, r, username, username, time.Now().Add(a.sessionTTL)); err != nil {
+		klog.Errorf("Failed to create session for local user %s: %v", username, err)
+		http.Error(w, "failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: stateCookieName, Path: loginPath, MaxAge: -1, HttpOnly: true, Secure: a.secureCookies})
+	klog.Infof("Local user %s logged in from %s", username, r.RemoteAddr)
+	http.Redirect(w, r, a.returnURL(r), http.StatusSeeOther)
+}
+
+// returnURL returns the requested return_url if it is a local path, otherwise the success URL
+func (a *Authenticator) returnURL(r *http.Request) string {
+	returnURL := r.FormValue("return_url")
+	if strings.HasPrefix(returnURL, "/") && !strings.HasPrefix(returnURL, "//") && !strings.HasPrefix(returnURL, "/\\") {
+		return returnURL
+	}
+	return a.successURL
+}
+
+func (a *Authenticator) renderLoginForm(w http.ResponseWriter, r *http.Request, status int, username, errorMessage string) {
+	state := sessions.RandomString(32)
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    state,
+		Path:     loginPath,
+		HttpOnly: true,
+		Secure:   a.secureCookies,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	err := loginFormTemplate.Execute(w, map[string]string{
+		"State":     state,
+		"ReturnURL": r.FormValue("return_url"),
+		"Username":  username,
+		"Error":     errorMessage,
+	})
+	if err != nil {
+		klog.Errorf("Failed to render login form: %v", err)
+	}
+}
+
+// LogoutFunc deletes the session
+func (a *Authenticator) LogoutFunc(w http.ResponseWriter, r *http.Request) {
+	if a.metrics != nil {
+		a.metrics.LogoutRequested(auth.UnknownLogoutReason)
+	}
+
+	a.sessions.DeleteSession(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Authenticator) CallbackFunc(fn func(loginInfo sessions.LoginJSON, successURL string, w http.ResponseWriter)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) }
+}
+
+func (a *Authenticator) GetOCLoginCommand() string            { return "" }
+func (a *Authenticator) LogoutRedirectURL() string            { return loginPath }
+func (a *Authenticator) GetSpecialURLs() auth.SpecialAuthURLs { return auth.SpecialAuthURLs{} }
+func (a *Authenticator) IsStatic() bool                       { return false }
+
+var loginFormTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Log in</title>
+    <style>
+        body { font-family: Arial, sans-serif; margin: 40px; }
+        form { max-width: 320px; }
+        label { display: block; margin-top: 12px; }
+        input[type=text], input[type=password] { width: 100%; padding: 6px; box-sizing: border-box; }
+        button { margin-top: 16px; padding: 8px 16px; }
+        .error { color: #d32f2f; background: #ffebee; padding: 12px; border-radius: 4px; }
+    </style>
+</head>
+<body>
+    <h1>Log in</h1>
+    {{if .Error}}<div class="error">{{.Error}}</div>{{end}}
+    <form method="POST" action="/auth/login">
+        <input type="hidden" name="state" value="{{.State}}">
+        <input type="hidden" name="return_url" value="{{.ReturnURL}}">
+        <label for="username">Username</label>
+        <input type="text" id="username" name="username" value="{{.Username}}" autocomplete="username" autofocus required>
+        <label for="password">Password</label>
+        <input type="password" id="password" name="password" autocomplete="current-password" required>
+        <button type="submit">Log in</button>
+    </form>
+</body>
+</html>`))
@@ -0,0 +1,225 @@
+package local
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func bcryptEntry(t *testing.T, username, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return username + ":" + string(hash)
+}
+
+func pbkdf2Entry(username, password string) string {
+	salt := []byte("0123456789abcdef")
+	key := pbkdf2.Key([]byte(password), salt, 1000, 32, sha256.New)
+	return username + ":$pbkdf2-sha256$1000$" + ab64.EncodeToString(salt) + "$" + ab64.EncodeToString(key)
+}
+
+func writeFile(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	users, err := parseHtpasswd([]byte(strings.Join([]string{
+		"# development users",
+		bcryptEntry(t, "alice", "wonderland"),
+		"",
+		pbkdf2Entry("bob", "builder"),
+	}, "\n")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !users["alice"].verify("wonderland") || users["alice"].verify("builder") {
+		t.Errorf("bcrypt verification mismatch")
+	}
+	if !users["bob"].verify("builder") || users["bob"].verify("wonderland") {
+		t.Errorf("PBKDF2 verification mismatch")
+	}
+
+	for _, invalid := range []string{
+		"alice",
+		"alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=",
+		"alice:$apr1$salt$hash",
+		"alice:$pbkdf2-md5$1000$salt$hash",
+		"alice:$pbkdf2-sha256$zero$salt$hash",
+		bcryptEntry(t, "alice", "a") + "\n" + bcryptEntry(t, "alice", "b"),
+	} {
+		if _, err := parseHtpasswd([]byte(invalid)); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func newTestAuthenticator(t *testing.T) (*Authenticator, string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	htpasswdFile := filepath.Join(dir, "htpasswd")
+	groupsFile := filepath.Join(dir, "groups.yaml")
+
+	writeFile(t, htpasswdFile, bcryptEntry(t, "alice", "wonderland"), pbkdf2Entry("bob", "builder"))
+	writeFile(t, groupsFile, "alice: [developers, admins]", "bob: [developers]")
+
+	a, err := NewAuthenticator(&Config{
+		HtpasswdFile:            htpasswdFile,
+		GroupsFile:              groupsFile,
+		ReloadInterval:          time.Second,
+		SessionTTL:              time.Hour,
+		SuccessURL:              "/",
+		CookiePath:              "/",
+		CookieAuthenticationKey: []byte(strings.Repeat("a", 64)),
+		CookieEncryptionKey:     []byte(strings.Repeat("e", 32)),
+	})
+	if err != nil {
+		t.Fatalf("failed to create authenticator: %v", err)
+	}
+	return a, htpasswdFile, groupsFile
+}
+
+var stateRegexp = regexp.MustCompile(`name="state" value="([^"]+)"`)
+
+// login fetches the form and posts credentials, returning the response
+func login(t *testing.T, a *Authenticator, username, password, returnURL string) *httptest.ResponseRecorder {
+	t.Helper()
+	form := httptest.NewRecorder()
+	a.LoginFunc(form, httptest.NewRequest("GET", "/auth/login?return_url="+url.QueryEscape(returnURL), nil))
+	if form.Code != http.StatusOK {
+		t.Fatalf("unexpected form status: %d", form.Code)
+	}
+
+	match := stateRegexp.FindStringSubmatch(form.Body.String())
+	if match == nil {
+		t.Fatalf("no state in login form")
+	}
+
+	values := url.Values{
+		"state":      {match[1]},
+		"username":   {username},
+		"password":   {password},
+		"return_url": {returnURL},
+	}
+	r := httptest.NewRequest("POST", "/auth/login", strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range form.Result().Cookies() {
+		r.AddCookie(c)
+	}
+
+	w := httptest.NewRecorder()
+	a.LoginFunc(w, r)
+	return w
+}
+
+func authenticatedRequest(resp *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, c := range resp.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			r.AddCookie(c)
+		}
+	}
+	return r
+}
+
+func TestLogin(t *testing.T) {
+	a, _, _ := newTestAuthenticator(t)
+
+	resp := login(t, a, "alice", "wonderland", "/notebooks/alice?tab=1")
+	if resp.Code != http.StatusSeeOther {
+		t.Fatalf("expected redirect, got %d: %s", resp.Code, resp.Body.String())
+	}
+	if location := resp.Header().Get("Location"); location != "/notebooks/alice?tab=1" {
+		t.Errorf("unexpected redirect: %s", location)
+	}
+
+	user, err := a.Authenticate(httptest.NewRecorder(), authenticatedRequest(resp))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.Username != "alice" || strings.Join(user.Groups, ",") != "admins,developers" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	// PBKDF2 user, open redirects fall back to the success URL
+	resp = login(t, a, "bob", "builder", "//evil.example.com")
+	if location := resp.Header().Get("Location"); resp.Code != http.StatusSeeOther || location != "/" {
+		t.Errorf("unexpected response %d to %s", resp.Code, location)
+	}
+
+	// Wrong password re-renders the form
+	resp = login(t, a, "alice", "builder", "/")
+	if resp.Code != http.StatusUnauthorized || !strings.Contains(resp.Body.String(), "Invalid username or password") {
+		t.Errorf("unexpected response %d", resp.Code)
+	}
+
+	// Posting without the state cookie is rejected
+	r := httptest.NewRequest("POST", "/auth/login", strings.NewReader("state=x&username=alice&password=wonderland"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	a.LoginFunc(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected login without state cookie to fail, got %d", w.Code)
+	}
+
+	// No session cookie
+	if _, err := a.Authenticate(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)); err == nil {
+		t.Errorf("expected error without session")
+	}
+}
+
+func TestHotReload(t *testing.T) {
+	a, htpasswdFile, groupsFile := newTestAuthenticator(t)
+	now := time.Now()
+	a.users.now = func() time.Time { return now }
+
+	resp := login(t, a, "bob", "builder", "/")
+	bobRequest := authenticatedRequest(resp)
+
+	writeFile(t, groupsFile, "bob: [developers, reviewers]")
+	// Make sure the modification time differs on coarse-grained filesystems
+	os.Chtimes(groupsFile, now.Add(time.Second), now.Add(time.Second))
+	now = now.Add(time.Second)
+
+	user, err := a.Authenticate(httptest.NewRecorder(), bobRequest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(user.Groups, ",") != "developers,reviewers" {
+		t.Errorf("groups were not reloaded: %v", user.Groups)
+	}
+
+	// Removing a user ends their sessions
+	writeFile(t, htpasswdFile, bcryptEntry(t, "alice", "wonderland"))
+	os.Chtimes(htpasswdFile, now.Add(time.Second), now.Add(time.Second))
+	now = now.Add(time.Second)
+
+	if _, err := a.Authenticate(httptest.NewRecorder(), bobRequest); err == nil {
+		t.Errorf("expected removed user to be rejected")
+	}
+
+	// A broken file keeps the previous users
+	writeFile(t, htpasswdFile, "not an htpasswd file")
+	os.Chtimes(htpasswdFile, now.Add(time.Second), now.Add(time.Second))
+	now = now.Add(time.Second)
+
+	if resp := login(t, a, "alice", "wonderland", "/"); resp.Code != http.StatusSeeOther {
+		t.Errorf("expected previous users to be kept, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,130 @@
+package local
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// ab64 is the adapted base64 alphabet passlib uses for PBKDF2 hashes
+var ab64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789./").WithPadding(base64.NoPadding)
+
+// passwordHash verifies a password against one htpasswd entry
+type passwordHash interface {
+	verify(password string) bool
+}
+
+type bcryptHash []byte
+
+func (h bcryptHash) verify(password string) bool {
+	return bcrypt.CompareHashAndPassword(h, []byte(password)) == nil
+}
+
+type pbkdf2Hash struct {
+	hash       func() hash.Hash
+	iterations int
+	salt       []byte
+	key        []byte
+}
+
+func (h *pbkdf2Hash) verify(password string) bool {
+	key := pbkdf2.Key([]byte(password), h.salt, h.iterations, len(h.key), h.hash)
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// parseHtpasswd parses an htpasswd file with bcrypt ($2a$, $2b$, $2y$) and
+// passlib-style PBKDF2 ($pbkdf2$, $pbkdf2-sha256$, $pbkdf2-sha512$) entries
+func parseHtpasswd(data []byte) (map[string]passwordHash, error) {
+	users := make(map[string]passwordHash)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		username, encoded, found := strings.Cut(line, ":")
+		if !found || username == "" {
+			return nil, fmt.Errorf("line %d: expected username:hash", lineNum)
+		}
+
+		h, err := parsePasswordHash(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: user %s: %w", lineNum, username, err)
+		}
+
+		if _, exists := users[username]; exists {
+			return nil, fmt.Errorf("line %d: duplicate user %s", lineNum, username)
+		}
+		users[username] = h
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func parsePasswordHash(encoded string) (passwordHash, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		if _, err := bcrypt.Cost([]byte(encoded)); err != nil {
+			return nil, fmt.Errorf("invalid bcrypt hash: %w", err)
+		}
+		return bcryptHash(encoded), nil
+
+	case strings.HasPrefix(encoded, "$pbkdf2"):
+		return parsePBKDF2(encoded)
+
+	default:
+		return nil, fmt.Errorf("unsupported hash format, only bcrypt and PBKDF2 are supported")
+	}
+}
+
+// parsePBKDF2 parses $pbkdf2[-digest]$rounds$salt$checksum
+func parsePBKDF2(encoded string) (passwordHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("invalid PBKDF2 hash")
+	}
+
+	h := &pbkdf2Hash{}
+	switch parts[1] {
+	case "pbkdf2":
+		h.hash = sha1.New
+	case "pbkdf2-sha256":
+		h.hash = sha256.New
+	case "pbkdf2-sha512":
+		h.hash = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 digest %s", parts[1])
+	}
+
+	iterations, err := strconv.Atoi(parts[2])
+	if err != nil || iterations < 1 {
+		return nil, fmt.Errorf("invalid PBKDF2 rounds %q", parts[2])
+	}
+	h.iterations = iterations
+
+	if h.salt, err = ab64.DecodeString(parts[3]); err != nil {
+		return nil, fmt.Errorf("invalid PBKDF2 salt: %w", err)
+	}
+	if h.key, err = ab64.DecodeString(parts[4]); err != nil || len(h.key) == 0 {
+		return nil, fmt.Errorf("invalid PBKDF2 checksum")
+	}
+
+	return h, nil
+}
@@ -0,0 +1,182 @@
+package local
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// fileStamp identifies a version of a file for change detection
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// userStore holds the users of an htpasswd file and their groups, reloading
+// both files when they change
+type userStore struct {
+	htpasswdFile   string
+	groupsFile     string
+	reloadInterval time.Duration
+
+	mu            sync.RWMutex
+	users         map[string]passwordHash
+	groups        map[string][]string
+	htpasswdStamp fileStamp
+	groupsStamp   fileStamp
+	lastCheck     time.Time
+	now           func() time.Time
+}
+
+func newUserStore(htpasswdFile, groupsFile string, reloadInterval time.Duration) (*userStore, error) {
+	s := &userStore{
+		htpasswdFile:   htpasswdFile,
+		groupsFile:     groupsFile,
+		reloadInterval: reloadInterval,
+		now:            time.Now,
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads both files and swaps them in only if they parse
+func (s *userStore) load() error {
+	htpasswdStamp, err := statFile(s.htpasswdFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat htpasswd file: %w", err)
+	}
+
+	data, err := os.ReadFile(s.htpasswdFile)
+	if err != nil {
+		return fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+
+	users, err := parseHtpasswd(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse htpasswd file %s: %w", s.htpasswdFile, err)
+	}
+
+	groups := make(map[string][]string)
+	var groupsStamp fileStamp
+	if s.groupsFile != "" {
+		if groupsStamp, err = statFile(s.groupsFile); err != nil {
+			return fmt.Errorf("failed to stat groups file: %w", err)
+		}
+
+		data, err := os.ReadFile(s.groupsFile)
+		if err != nil {
+			return fmt.Errorf("failed to read groups file: %w", err)
+		}
+
+		if err := yaml.Unmarshal(data, &groups); err != nil {
+			return fmt.Errorf("failed to parse groups file %s: %w", s.groupsFile, err)
+		}
+
+		for user, userGroups := range groups {
+			sort.Strings(userGroups)
+			if _, ok := users[user]; !ok {
+				klog.Warningf("Groups file %s lists unknown user %s", s.groupsFile, user)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = users
+	s.groups = groups
+	s.htpasswdStamp = htpasswdStamp
+	s.groupsStamp = groupsStamp
+	s.lastCheck = s.now()
+
+	klog.Infof("Loaded %d local users from %s", len(users), s.htpasswdFile)
+	return nil
+}
+
+// maybeReload reloads the files if they changed since the last check. Checks are
+// rate limited by the reload interval and a broken file keeps the previous users.
+func (s *userStore) maybeReload() {
+	if s.reloadInterval <= 0 {
+		return
+	}
+
+	s.mu.RLock()
+	due := s.now().Sub(s.lastCheck) >= s.reloadInterval
+	htpasswdStamp, groupsStamp := s.htpasswdStamp, s.groupsStamp
+	s.mu.RUnlock()
+
+	if !due {
+		return
+	}
+
+	changed := false
+	if stamp, err := statFile(s.htpasswdFile); err != nil || stamp != htpasswdStamp {
+		changed = true
+	}
+	if s.groupsFile != "" {
+		if stamp, err := statFile(s.groupsFile); err != nil || stamp != groupsStamp {
+			changed = true
+		}
+	}
+
+	if !changed {
+		s.mu.Lock()
+		s.lastCheck = s.now()
+		s.mu.Unlock()
+		return
+	}
+
+	if err := s.load(); err != nil {
+		klog.Errorf("Failed to reload local users, keeping previous users: %v", err)
+		s.mu.Lock()
+		s.lastCheck = s.now()
+		s.mu.Unlock()
+	}
+}
+
+// verify checks a password, returning false for unknown users
+func (s *userStore) verify(username, password string) bool {
+	s.maybeReload()
+
+	s.mu.RLock()
+	h, ok := s.users[username]
+	s.mu.RUnlock()
+
+	if !ok {
+		// Spend comparable time on unknown users to not reveal which users exist
+		bcryptHash(dummyBcryptHash).verify(password)
+		return false
+	}
+	return h.verify(password)
+}
+
+// lookup reports whether the user still exists and returns its groups
+func (s *userStore) lookup(username string) ([]string, bool) {
+	s.maybeReload()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.users[username]; !ok {
+		return nil, false
+	}
+	return append([]string(nil), s.groups[username]...), true
+}
+
+// dummyBcryptHash is the bcrypt hash of a random password at the default cost
+const dummyBcryptHash = "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
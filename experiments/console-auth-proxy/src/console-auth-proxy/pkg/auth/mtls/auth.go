@@ -0,0 +1,374 @@
+package mtls
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+	"github.com/your-org/console-auth-proxy/pkg/auth/sessions"
+)
+
+var (
+	// ErrNoClientCertificate is returned when the request carries no client certificate
+	ErrNoClientCertificate = errors.New("no client certificate presented")
+	// ErrCertificateRevoked is returned when a certificate in the chain is listed in a CRL
+	ErrCertificateRevoked = errors.New("client certificate has been revoked")
+)
+
+// Certificate fields that can be mapped to a user
+const (
+	FieldSubjectCN = "subject.cn"
+	FieldSubjectDN = "subject.dn"
+	FieldSubjectO  = "subject.o"
+	FieldSubjectOU = "subject.ou"
+	FieldSANDNS    = "san.dns"
+	FieldSANEmail  = "san.email"
+	FieldSANURI    = "san.uri"
+)
+
+// Config configures client certificate authentication
+type Config struct {
+	// CAFile is the PEM bundle client certificates are verified against
+	CAFile string
+	// CRLFiles are PEM or DER certificate revocation lists
+	CRLFiles []string
+	// CRLRefreshInterval is how often CRL files are re-read
+	CRLRefreshInterval time.Duration
+	// Rules map certificate fields to users, the first matching rule wins
+	Rules []MappingRule
+}
+
+// MappingRule maps a certificate field to a user. Username and ID are
+// regexp templates expanded against Match, e.g. "system:serviceaccount:$1:$2".
+type MappingRule struct {
+	Field    string
+	Match    string
+	Username string
+	ID       string
+}
+
+type mappingRule struct {
+	field    string
+	match    *regexp.Regexp
+	username string
+	id       string
+}
+
+// Authenticator authenticates requests by their verified TLS client certificate
+type Authenticator struct {
+	roots *x509.CertPool
+	rules []mappingRule
+
+	crlFiles           []string
+	crlRefreshInterval time.Duration
+
+	mu         sync.RWMutex
+	crls       []*x509.RevocationList
+	crlsLoaded time.Time
+	now        func() time.Time
+}
+
+// NewAuthenticator creates a client certificate authenticator
+func NewAuthenticator(cfg *Config) (*Authenticator, error) {
+	caData, err := os.ReadFile(cfg.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file %s: %w", cfg.CAFile, err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caData) {
+		return nil, fmt.Errorf("failed to parse client CA certificates from %s", cfg.CAFile)
+	}
+
+	a := &Authenticator{
+		roots:              roots,
+		crlFiles:           cfg.CRLFiles,
+		crlRefreshInterval: cfg.CRLRefreshInterval,
+		now:                time.Now,
+	}
+
+	rules := cfg.Rules
+	if len(rules) == 0 {
+		rules = []MappingRule{{Field: FieldSubjectCN}}
+	}
+
+	for i, rule := range rules {
+		if _, err := certField(&x509.Certificate{}, rule.Field); err != nil {
+			return nil, fmt.Errorf("user mapping rule %d: %w", i, err)
+		}
+
+		match := rule.Match
+		if match == "" {
+			match = "^.+$"
+		}
+		re, err := regexp.Compile(match)
+		if err != nil {
+			return nil, fmt.Errorf("user mapping rule %d: invalid match: %w", i, err)
+		}
+
+		username := rule.Username
+		if username == "" {
+			username = "$0"
+		}
+
+		a.rules = append(a.rules, mappingRule{field: rule.Field, match: re, username: username, id: rule.ID})
+	}
+
+	if err := a.loadCRLs(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// ClientCAs returns the pool used to verify client certificates, for the TLS server config
+func (a *Authenticator) ClientCAs() *x509.CertPool {
+	return a.roots
+}
+
+// Authenticate verifies the client certificate of the request and maps it to a user
+func (a *Authenticator) Authenticate(w http.ResponseWriter, r *http.Request) (*auth.User, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, ErrNoClientCertificate
+	}
+
+	cert, err := a.Verify(r.TLS.PeerCertificates)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := a.mapUser(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	klog.V(6).Infof("Authenticated client certificate %q as user %s", cert.Subject.String(), user.Username)
+	return user, nil
+}
+
+// Verify checks the presented chain against the client CA bundle and CRLs,
+// returning the leaf certificate
+func (a *Authenticator) Verify(peerCerts []*x509.Certificate) (*x509.Certificate, error) {
+	leaf := peerCerts[0]
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range peerCerts[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         a.roots,
+		Intermediates: intermediates,
+		CurrentTime:   a.now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("client certificate verification failed: %w", err)
+	}
+
+	a.refreshCRLs()
+	for _, chain := range chains {
+		if err := a.checkRevocation(chain); err != nil {
+			return nil, err
+		}
+	}
+
+	return leaf, nil
+}
+
+// checkRevocation checks every non-root certificate of a chain against the CRLs of its issuer
+func (a *Authenticator) checkRevocation(chain []*x509.Certificate) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for i := 0; i < len(chain)-1; i++ {
+		cert, issuer := chain[i], chain[i+1]
+		for _, crl := range a.crls {
+			if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) || crl.CheckSignatureFrom(issuer) != nil {
+				continue
+			}
+			for _, revoked := range crl.RevokedCertificateEntries {
+				if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+					klog.Warningf("Rejected revoked client certificate %q (serial %s)", cert.Subject.String(), cert.SerialNumber)
+					return ErrCertificateRevoked
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// mapUser applies the first matching mapping rule to the certificate
+func (a *Authenticator) mapUser(cert *x509.Certificate) (*auth.User, error) {
+	for _, rule := range a.rules {
+		values, _ := certField(cert, rule.field)
+		for _, value := range values {
+			match := rule.match.FindStringSubmatchIndex(value)
+			if match == nil {
+				continue
+			}
+
+			username := string(rule.match.ExpandString(nil, rule.username, value, match))
+			if username == "" {
+				continue
+			}
+
+			id := cert.Subject.String()
+			if rule.id != "" {
+				id = string(rule.match.ExpandString(nil, rule.id, value, match))
+			}
+
+			return &auth.User{
+				ID:                id,
+				Username:          username,
+				ClientCertificate: cert,
+			}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no user mapping rule matched client certificate %q", cert.Subject.String())
+}
+
+// certField returns the values of a certificate field
+func certField(cert *x509.Certificate, field string) ([]string, error) {
+	switch strings.ToLower(field) {
+	case FieldSubjectCN:
+		if cert.Subject.CommonName == "" {
+			return nil, nil
+		}
+		return []string{cert.Subject.CommonName}, nil
+	case FieldSubjectDN:
+		return []string{cert.Subject.String()}, nil
+	case FieldSubjectO:
+		return cert.Subject.Organization, nil
+	case FieldSubjectOU:
+		return cert.Subject.OrganizationalUnit, nil
+	case FieldSANDNS:
+		return cert.DNSNames, nil
+	case FieldSANEmail:
+		return cert.EmailAddresses, nil
+	case FieldSANURI:
+		values := make([]string, 0, len(cert.URIs))
+		for _, u := range cert.URIs {
+			values = append(values, u.String())
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unsupported certificate field %q", field)
+	}
+}
+
+// refreshCRLs re-reads the CRL files once the refresh interval has passed.
+// A failed reload keeps the previously loaded lists.
+func (a *Authenticator) refreshCRLs() {
+	if len(a.crlFiles) == 0 || a.crlRefreshInterval <= 0 {
+		return
+	}
+
+	a.mu.RLock()
+	stale := a.now().Sub(a.crlsLoaded) >= a.crlRefreshInterval
+	a.mu.RUnlock()
+
+	if stale {
+		if err := a.loadCRLs(); err != nil {
+			klog.Errorf("Failed to reload CRLs, keeping previous lists: %v", err)
+		}
+	}
+}
+
+func (a *Authenticator) loadCRLs() error {
+	var crls []*x509.RevocationList
+	for _, file := range a.crlFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read CRL file %s: %w", file, err)
+		}
+
+		ders := [][]byte{data}
+		if bytes.Contains(data, []byte("-----BEGIN")) {
+			ders = nil
+			for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+				if block.Type == "X509 CRL" {
+					ders = append(ders, block.Bytes)
+				}
+			}
+		}
+
+		for _, der := range ders {
+			crl, err := x509.ParseRevocationList(der)
+			if err != nil {
+				return fmt.Errorf("failed to parse CRL file %s: %w", file, err)
+			}
+			if !crl.NextUpdate.IsZero() && a.now().After(crl.NextUpdate) {
+				klog.Warningf("CRL %s from %q is past its next update time %v", file, crl.Issuer.String(), crl.NextUpdate)
+			}
+			crls = append(crls, crl)
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.crls = crls
+	a.crlsLoaded = a.now()
+	klog.V(4).Infof("Loaded %d certificate revocation lists", len(crls))
+	return nil
+}
+
+// LoginFunc explains that a client certificate is required, there is no interactive login
+func (a *Authenticator) LoginFunc(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "A valid client certificate is required", http.StatusUnauthorized)
+}
+
+// LogoutFunc does nothing, client certificate authentication has no session
+func (a *Authenticator) LogoutFunc(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Authenticator) CallbackFunc(fn func(loginInfo sessions.LoginJSON, successURL string, w http.ResponseWriter)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) }
+}
+
+func (a *Authenticator) GetOCLoginCommand() string            { return "" }
+func (a *Authenticator) LogoutRedirectURL() string            { return "" }
+func (a *Authenticator) GetSpecialURLs() auth.SpecialAuthURLs { return auth.SpecialAuthURLs{} }
+func (a *Authenticator) IsStatic() bool                       { return false }
+
+// FormatXFCC formats verified certificate details as an Envoy-style
+// x-forwarded-client-cert header value. Every value is quoted so SANs
+// containing ';', ',' or '=' can't inject fields.
+func FormatXFCC(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	parts := []string{
+		"Hash=" + quoteXFCC(hex.EncodeToString(hash[:])),
+		"Subject=" + quoteXFCC(cert.Subject.String()),
+	}
+	for _, u := range cert.URIs {
+		parts = append(parts, "URI="+quoteXFCC(u.String()))
+	}
+	for _, name := range cert.DNSNames {
+		parts = append(parts, "DNS="+quoteXFCC(name))
+	}
+	return strings.Join(parts, ";")
+}
+
+// xfccEscaper escapes the characters that would end a quoted XFCC value
+var xfccEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// quoteXFCC quotes an XFCC value, escaping backslashes and double quotes
+func quoteXFCC(value string) string {
+	return `"` + xfccEscaper.Replace(value) + `"`
+}
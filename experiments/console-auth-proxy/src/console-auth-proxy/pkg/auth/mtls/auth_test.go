@@ -0,0 +1,279 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+	"github.com/your-org/console-auth-proxy/pkg/auth/static"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	ca.writePEM(t, "ca.crt", "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(ca.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64, subject pkix.Name, uris ...string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, raw := range uris {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.URIs = append(template.URIs, u)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func (ca *testCA) revoke(t *testing.T, serials ...int64) string {
+	t.Helper()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, serial := range serials {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now(),
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca.writePEM(t, "ca.crl", "X509 CRL", der)
+}
+
+func requestWithCert(certs ...*x509.Certificate) *http.Request {
+	r := httptest.NewRequest("GET", "https://example.com/", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: certs}
+	return r
+}
+
+func TestAuthenticate(t *testing.T) {
+	ca := newTestCA(t)
+	crlFile := ca.revoke(t, 3)
+
+	a, err := NewAuthenticator(&Config{
+		CAFile:   filepath.Join(ca.dir, "ca.crt"),
+		CRLFiles: []string{crlFile},
+		Rules: []MappingRule{
+			{
+				Field:    FieldSANURI,
+				Match:    `^spiffe://cluster\.local/ns/([^/]+)/sa/([^/]+)$`,
+				Username: "system:serviceaccount:$1:$2",
+				ID:       "$0",
+			},
+			{Field: FieldSubjectCN},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create authenticator: %v", err)
+	}
+
+	// SAN URI rule takes precedence
+	cert := ca.issue(t, 2, pkix.Name{CommonName: "runner"}, "spiffe://cluster.local/ns/ci/sa/builder")
+	user, err := a.Authenticate(nil, requestWithCert(cert))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.Username != "system:serviceaccount:ci:builder" {
+		t.Errorf("unexpected username: %s", user.Username)
+	}
+	if user.ID != "spiffe://cluster.local/ns/ci/sa/builder" {
+		t.Errorf("unexpected ID: %s", user.ID)
+	}
+	if user.ClientCertificate != cert {
+		t.Errorf("client certificate not set on user")
+	}
+
+	// Falls through to the subject CN rule
+	user, err = a.Authenticate(nil, requestWithCert(ca.issue(t, 4, pkix.Name{CommonName: "model-caller", Organization: []string{"odh"}})))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.Username != "model-caller" || user.ID != "CN=model-caller,O=odh" {
+		t.Errorf("unexpected user: %+v", user)
+	}
+
+	// Revoked certificates are rejected
+	_, err = a.Authenticate(nil, requestWithCert(ca.issue(t, 3, pkix.Name{CommonName: "revoked"})))
+	if !errors.Is(err, ErrCertificateRevoked) {
+		t.Errorf("expected revocation error, got: %v", err)
+	}
+
+	// Certificates from another CA are rejected
+	other := newTestCA(t)
+	if _, err := a.Authenticate(nil, requestWithCert(other.issue(t, 2, pkix.Name{CommonName: "stranger"}))); err == nil {
+		t.Errorf("expected verification error for untrusted certificate")
+	}
+
+	// No certificate
+	if _, err := a.Authenticate(nil, httptest.NewRequest("GET", "/", nil)); !errors.Is(err, ErrNoClientCertificate) {
+		t.Errorf("expected no certificate error, got: %v", err)
+	}
+
+	// No rule matches a certificate without CN or URI SAN
+	if _, err := a.Authenticate(nil, requestWithCert(ca.issue(t, 5, pkix.Name{Organization: []string{"odh"}}))); err == nil {
+		t.Errorf("expected mapping error")
+	}
+}
+
+func TestCRLRefresh(t *testing.T) {
+	ca := newTestCA(t)
+	crlFile := ca.revoke(t)
+
+	a, err := NewAuthenticator(&Config{
+		CAFile:             filepath.Join(ca.dir, "ca.crt"),
+		CRLFiles:           []string{crlFile},
+		CRLRefreshInterval: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("failed to create authenticator: %v", err)
+	}
+
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	cert := ca.issue(t, 7, pkix.Name{CommonName: "ci"})
+
+	if _, err := a.Authenticate(nil, requestWithCert(cert)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ca.revoke(t, 7)
+	if _, err := a.Authenticate(nil, requestWithCert(cert)); err != nil {
+		t.Fatalf("CRL should not be reloaded before the refresh interval: %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := a.Authenticate(nil, requestWithCert(cert)); !errors.Is(err, ErrCertificateRevoked) {
+		t.Errorf("expected revocation error after refresh, got: %v", err)
+	}
+}
+
+func TestCombinedAuthenticator(t *testing.T) {
+	ca := newTestCA(t)
+	certs, err := NewAuthenticator(&Config{CAFile: filepath.Join(ca.dir, "ca.crt")})
+	if err != nil {
+		t.Fatalf("failed to create authenticator: %v", err)
+	}
+
+	c := NewCombinedAuthenticator(certs, static.NewStaticAuthenticator(auth.User{Username: "session-user"}))
+
+	user, err := c.Authenticate(nil, requestWithCert(ca.issue(t, 2, pkix.Name{CommonName: "ci"})))
+	if err != nil || user.Username != "ci" {
+		t.Errorf("expected certificate user, got: %+v, %v", user, err)
+	}
+
+	user, err = c.Authenticate(nil, httptest.NewRequest("GET", "/", nil))
+	if err != nil || user.Username != "session-user" {
+		t.Errorf("expected fallback user, got: %+v, %v", user, err)
+	}
+
+	other := newTestCA(t)
+	if _, err := c.Authenticate(nil, requestWithCert(other.issue(t, 2, pkix.Name{CommonName: "stranger"}))); err == nil {
+		t.Errorf("invalid certificate must not fall back to the session")
+	}
+}
+
+func TestFormatXFCC(t *testing.T) {
+	ca := newTestCA(t)
+	cert := ca.issue(t, 2, pkix.Name{CommonName: "ci"}, "spiffe://cluster.local/ns/ci/sa/builder")
+
+	xfcc := FormatXFCC(cert)
+	for _, part := range []string{`Hash="`, `Subject="CN=ci"`, `URI="spiffe://cluster.local/ns/ci/sa/builder"`} {
+		if !strings.Contains(xfcc, part) {
+			t.Errorf("expected %q in %q", part, xfcc)
+		}
+	}
+
+	// Separators and quotes in SANs stay inside their value
+	cert = ca.issue(t, 3, pkix.Name{CommonName: `a"b`}, `spiffe://cluster.local/ns/ci;DNS=admin,x=y`)
+	xfcc = FormatXFCC(cert)
+	for _, part := range []string{`Subject="CN=a\\\"b"`, `URI="spiffe://cluster.local/ns/ci;DNS=admin,x=y"`} {
+		if !strings.Contains(xfcc, part) {
+			t.Errorf("expected %q in %q", part, xfcc)
+		}
+	}
+	if strings.Count(xfcc, `";`) != 2 {
+		t.Errorf("expected three fields in %q", xfcc)
+	}
+}
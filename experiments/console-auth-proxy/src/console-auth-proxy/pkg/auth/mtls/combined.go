@@ -0,0 +1,32 @@
+package mtls
+
+import (
+	"net/http"
+
+	"github.com/your-org/console-auth-proxy/pkg/auth"
+)
+
+// CombinedAuthenticator accepts a verified client certificate and falls back
+// to another authenticator (e.g. OIDC) for requests without one
+type CombinedAuthenticator struct {
+	auth.Authenticator
+	certs *Authenticator
+}
+
+// NewCombinedAuthenticator creates an authenticator that tries client
+// certificates first. Login, logout and callbacks go to the fallback.
+func NewCombinedAuthenticator(certs *Authenticator, fallback auth.Authenticator) *CombinedAuthenticator {
+	return &CombinedAuthenticator{
+		Authenticator: fallback,
+		certs:         certs,
+	}
+}
+
+// Authenticate uses the client certificate if one was presented. An invalid
+// certificate is rejected rather than silently falling back to a session.
+func (c *CombinedAuthenticator) Authenticate(w http.ResponseWriter, r *http.Request) (*auth.User, error) {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return c.certs.Authenticate(w, r)
+	}
+	return c.Authenticator.Authenticate(w, r)
+}
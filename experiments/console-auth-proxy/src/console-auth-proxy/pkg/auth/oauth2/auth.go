@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 
@@ -19,6 +20,7 @@ import (
 
 	"github.com/your-org/console-auth-proxy/pkg/auth"
 	"github.com/your-org/console-auth-proxy/pkg/auth/sessions"
+	"github.com/your-org/console-auth-proxy/pkg/tlsprofile"
 	oscrypto "github.com/openshift/library-go/pkg/crypto"
 
 	"k8s.io/client-go/rest"
@@ -49,9 +51,10 @@ var (
 type OAuth2Authenticator struct {
 	clientFunc func() *http.Client
 
-	clientID     string
-	clientSecret string
-	scopes       []string
+	clientID         string
+	clientSecret     string
+	clientSecretFunc func() string
+	scopes           []string
 
 	loginMethod
 
@@ -109,7 +112,10 @@ type Config struct {
 	RedirectURL            string
 	ClientID               string
 	ClientSecret           string
-	Scope                  []string
+	// ClientSecretFunc, if set, is called for the client secret on every token
+	// request so a rotated secret is picked up. It takes precedence over ClientSecret.
+	ClientSecretFunc func() string
+	Scope            []string
 
 	// K8sCA is required for OpenShift OAuth metadata discovery. This is the CA
 	// used to talk to the master, which might be different than the issuer CA.
@@ -137,6 +143,11 @@ type Config struct {
 type TLSConfig struct {
 	InsecureSkipVerify bool
 	ServerName         string
+
+	// Profile restricts the negotiated versions and algorithms, Go's defaults if nil
+	Profile *tlsprofile.Profile
+	// Observer records the negotiated key exchange groups
+	Observer *tlsprofile.Observer
 }
 
 type completedConfig struct {
@@ -145,16 +156,26 @@ type completedConfig struct {
 	clientFunc func() *http.Client
 }
 
-func newHTTPClient(issuerCA string, includeSystemRoots bool) (*http.Client, error) {
-	if issuerCA == "" {
+func newHTTPClient(issuerCA string, includeSystemRoots bool, tlsSettings TLSConfig) (*http.Client, error) {
+	if issuerCA == "" && !tlsSettings.InsecureSkipVerify && tlsSettings.ServerName == "" && tlsSettings.Profile == nil {
 		return http.DefaultClient, nil
 	}
-	data, err := os.ReadFile(issuerCA)
-	if err != nil {
-		return nil, fmt.Errorf("load issuer CA file %s: %v", issuerCA, err)
+
+	var data []byte
+	if issuerCA != "" {
+		var err error
+		data, err = os.ReadFile(issuerCA)
+		if err != nil {
+			return nil, fmt.Errorf("load issuer CA file %s: %v", issuerCA, err)
+		}
 	}
 
-	caKey := string(data)
+	profileName := ""
+	if tlsSettings.Profile != nil {
+		profileName = tlsSettings.Profile.Name
+	}
+	caKey := fmt.Sprintf("%s|%t|%s|%s", data, tlsSettings.InsecureSkipVerify, tlsSettings.ServerName, profileName)
+	var err error
 	var certPool *x509.CertPool
 	if includeSystemRoots {
 		if httpClient, ok := httpClientCacheSystemRoots.Load(caKey); ok {
@@ -171,17 +192,25 @@ func newHTTPClient(issuerCA string, includeSystemRoots bool) (*http.Client, erro
 		}
 		certPool = x509.NewCertPool()
 	}
-	if !certPool.AppendCertsFromPEM(data) {
+	if len(data) > 0 && !certPool.AppendCertsFromPEM(data) {
 		return nil, fmt.Errorf("file %s contained no CA data", issuerCA)
 	}
 
+	// OpenShift's defaults first, so the profile's versions and algorithms win
+	tlsConfig := oscrypto.SecureTLSConfig(&tls.Config{
+		RootCAs:            certPool,
+		InsecureSkipVerify: tlsSettings.InsecureSkipVerify,
+		ServerName:         tlsSettings.ServerName,
+	})
+	if tlsSettings.Profile != nil {
+		tlsSettings.Profile.Apply(tlsConfig)
+	}
+
 	httpClient := &http.Client{
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			TLSClientConfig: oscrypto.SecureTLSConfig(&tls.Config{
-				RootCAs: certPool,
-			}),
-		},
+		Transport: tlsSettings.Observer.RoundTripper(tlsprofile.ComponentIdP, &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		}),
 		Timeout: time.Second * 5,
 	}
 
@@ -222,7 +251,7 @@ func NewOAuth2Authenticator(ctx context.Context, config *Config) (*OAuth2Authent
 		// add the c.K8SCA to it and use the roundtripper created from that config
 		//
 		// Use the k8s CA for OAuth metadata discovery.
-		k8sClient, errK8Client := newHTTPClient(c.K8sCA, true, c.TLS.InsecureSkipVerify, c.TLS.ServerName)
+		k8sClient, errK8Client := newHTTPClient(c.K8sCA, true, c.TLS)
 		if errK8Client != nil {
 			return nil, errK8Client
 		}
@@ -254,9 +283,13 @@ func (a *OAuth2Authenticator) oauth2ConfigConstructor(endpointConfig oauth2.Endp
 	// rebuild non-pointer struct each time to prevent any mutation
 	scopesCopy := make([]string, len(a.scopes))
 	copy(scopesCopy, a.scopes)
+	clientSecret := a.clientSecret
+	if a.clientSecretFunc != nil {
+		clientSecret = a.clientSecretFunc()
+	}
 	baseOAuth2Config := oauth2.Config{
 		ClientID:     a.clientID,
-		ClientSecret: a.clientSecret,
+		ClientSecret: clientSecret,
 		RedirectURL:  a.redirectURL,
 		Scopes:       scopesCopy,
 		Endpoint:     endpointConfig,
@@ -269,9 +302,10 @@ func newUnstartedAuthenticator(c *completedConfig) *OAuth2Authenticator {
 	return &OAuth2Authenticator{
 		clientFunc: c.clientFunc,
 
-		clientID:     c.ClientID,
-		clientSecret: c.ClientSecret,
-		scopes:       c.Scope,
+		clientID:         c.ClientID,
+		clientSecret:     c.ClientSecret,
+		clientSecretFunc: c.ClientSecretFunc,
+		scopes:           c.Scope,
 
 		redirectURL:    c.RedirectURL,
 		errorURL:       c.ErrorURL,
@@ -284,6 +318,8 @@ func newUnstartedAuthenticator(c *completedConfig) *OAuth2Authenticator {
 }
 
 // LoginFunc redirects to the OIDC provider for user login.
+// The acr_values and max_age query parameters are passed on to the provider
+// to request step-up authentication.
 func (a *OAuth2Authenticator) LoginFunc(w http.ResponseWriter, r *http.Request) {
 	if a.metrics != nil {
 		a.metrics.LoginRequested()
@@ -302,7 +338,27 @@ func (a *OAuth2Authenticator) LoginFunc(w http.ResponseWriter, r *http.Request)
 		Secure:   a.secureCookies,
 	}
 	http.SetCookie(w, &cookie)
-	http.Redirect(w, r, a.oauth2Config().AuthCodeURL(state), http.StatusSeeOther)
+	http.Redirect(w, r, a.oauth2Config().AuthCodeURL(state, stepUpParams(r)...), http.StatusSeeOther)
+}
+
+// stepUpParams returns the OIDC authentication request parameters for step-up
+// authentication. The resulting ID token is checked by the caller, so these are
+// only a hint to the provider and don't need to be trusted.
+func stepUpParams(r *http.Request) []oauth2.AuthCodeOption {
+	var opts []oauth2.AuthCodeOption
+	q := r.URL.Query()
+
+	if acrValues := q.Get("acr_values"); acrValues != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("acr_values", acrValues))
+	}
+
+	if maxAge := q.Get("max_age"); maxAge != "" {
+		if _, err := strconv.ParseUint(maxAge, 10, 32); err == nil {
+			opts = append(opts, oauth2.SetAuthURLParam("max_age", maxAge))
+		}
+	}
+
+	return opts
 }
 
 // LogoutFunc cleans up session cookies.
@@ -405,13 +461,13 @@ func (c *Config) Complete() (*completedConfig, error) {
 	}
 
 	// make sure we get a valid starting client
-	fallbackClient, err := newHTTPClient(c.IssuerCA, true, c.TLS.InsecureSkipVerify, c.TLS.ServerName)
+	fallbackClient, err := newHTTPClient(c.IssuerCA, true, c.TLS)
 	if err != nil {
 		return nil, err
 	}
 
 	clientFunc := func() *http.Client {
-		currentClient, err := newHTTPClient(c.IssuerCA, true, c.TLS.InsecureSkipVerify, c.TLS.ServerName)
+		currentClient, err := newHTTPClient(c.IssuerCA, true, c.TLS)
 		if err != nil {
 			klog.Errorf("failed to get latest http client: %v", err)
 			return fallbackClient
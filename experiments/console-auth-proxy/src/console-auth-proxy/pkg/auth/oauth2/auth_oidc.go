@@ -153,6 +153,10 @@ func (o *oidcAuth) Authenticate(w http.ResponseWriter, r *http.Request) (*auth.U
 		ID:       ls.UserID(),
 		Username: ls.Username(),
 		Token:    ls.AccessToken(),
+		ACR:      ls.ACR(),
+		AMR:      ls.AMR(),
+		AuthTime: ls.AuthTime(),
+		Groups:   ls.Groups(),
 	}, nil
 }
 
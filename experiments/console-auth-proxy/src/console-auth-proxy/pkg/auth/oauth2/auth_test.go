@@ -6,9 +6,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
 	"testing"
 
 	"k8s.io/client-go/rest"
+
+	"github.com/your-org/console-auth-proxy/pkg/tlsprofile"
 )
 
 // mockOpenShiftProvider is test OpenShift provider that only supports discovery
@@ -33,97 +36,124 @@ func (m *mockOpenShiftProvider) handleDiscovery(w http.ResponseWriter, r *http.R
 }
 
 func TestNewAuthenticator(t *testing.T) {
-	errURL := "http://example.com/error"
-	sucURL := "http://example.com/success"
-
-	p := &mockOIDCProvider{}
-
-	s := httptest.NewServer(http.HandlerFunc(p.handleDiscovery))
-	defer s.Close()
-	p.issuer = s.URL
-
-	ccfg := &Config{
-		ClientID:      "fake-client-id",
-		ClientSecret:  "fake-secret",
-		Scope:         []string{"foo", "bar"},
-		RedirectURL:   "http://example.com/callback",
-		IssuerURL:     p.issuer,
-		ErrorURL:      errURL,
-		SuccessURL:    sucURL,
-		CookiePath:    "/",
-		SecureCookies: true,
-		K8sConfig:     &rest.Config{},
-	}
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	a, err := NewOAuth2Authenticator(ctx, ccfg)
+	fips, err := tlsprofile.Get(tlsprofile.FIPS)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	rr := httptest.NewRecorder()
-	req := httptest.NewRequest("GET", "http://example.com/", nil)
-
-	a.LoginFunc(rr, req)
-
-	u, err := url.Parse(rr.HeaderMap.Get("Location"))
-	if err != nil {
-		t.Fatalf("failed to parse location header: %v", err)
-	}
-
-	got := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
-	if got != p.issuer+"/auth" {
-		t.Errorf("redirect didn't go to %s/auth, got %s", p.issuer+"/auth", u)
-	}
-}
-
-func TestNewOpenShiftAuthenticator(t *testing.T) {
-	errURL := "http://example.com/error"
-	sucURL := "http://example.com/success"
-
-	p := &mockOpenShiftProvider{}
-
-	s := httptest.NewServer(http.HandlerFunc(p.handleDiscovery))
-	defer s.Close()
-	p.issuer = s.URL
-
-	ccfg := &Config{
-		AuthSource:    AuthSourceOpenShift,
-		ClientID:      "fake-client-id",
-		ClientSecret:  "fake-secret",
-		Scope:         []string{"foo", "bar"},
-		RedirectURL:   "http://example.com/callback",
-		IssuerURL:     p.issuer,
-		ErrorURL:      errURL,
-		SuccessURL:    sucURL,
-		CookiePath:    "/",
-		SecureCookies: true,
-		K8sConfig:     &rest.Config{},
-	}
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	a, err := NewOAuth2Authenticator(ctx, ccfg)
+	pqHybrid, err := tlsprofile.Get(tlsprofile.PQHybrid)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	rr := httptest.NewRecorder()
-	req := httptest.NewRequest("GET", "http://example.com/", nil)
-
-	a.LoginFunc(rr, req)
-
-	u, err := url.Parse(rr.HeaderMap.Get("Location"))
-	if err != nil {
-		t.Fatalf("failed to parse location header: %v", err)
-	}
-
-	got := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
-	if got != p.issuer+"/auth" {
-		t.Errorf("redirect didn't go to %s/auth, got %s", p.issuer+"/auth", u)
+	tests := []struct {
+		name       string
+		authSource AuthSource
+		profile    *tlsprofile.Profile
+	}{
+		{name: "oidc"},
+		{
+			name:    "oidc with fips profile",
+			profile: fips,
+		},
+		{
+			name:       "openshift",
+			authSource: AuthSourceOpenShift,
+		},
+		{
+			name:       "openshift with pq-hybrid profile",
+			authSource: AuthSourceOpenShift,
+			profile:    pqHybrid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oidc, openshift := &mockOIDCProvider{}, &mockOpenShiftProvider{}
+			discovery := oidc.handleDiscovery
+			if tt.authSource == AuthSourceOpenShift {
+				discovery = openshift.handleDiscovery
+			}
+
+			s := httptest.NewServer(http.HandlerFunc(discovery))
+			defer s.Close()
+			issuer := s.URL
+			oidc.issuer, openshift.issuer = issuer, issuer
+
+			ccfg := &Config{
+				AuthSource:    tt.authSource,
+				ClientID:      "fake-client-id",
+				ClientSecret:  "fake-secret",
+				Scope:         []string{"foo", "bar"},
+				RedirectURL:   "http://example.com/callback",
+				IssuerURL:     issuer,
+				ErrorURL:      "http://example.com/error",
+				SuccessURL:    "http://example.com/success",
+				CookiePath:    "/",
+				SecureCookies: true,
+				K8sConfig:     &rest.Config{},
+				TLS:           TLSConfig{Profile: tt.profile},
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			a, err := NewOAuth2Authenticator(ctx, ccfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+
+			a.LoginFunc(rr, req)
+
+			u, err := url.Parse(rr.Header().Get("Location"))
+			if err != nil {
+				t.Fatalf("failed to parse location header: %v", err)
+			}
+
+			got := (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+			if got != issuer+"/auth" {
+				t.Errorf("redirect didn't go to %s/auth, got %s", issuer, u)
+			}
+
+			// step-up parameters are passed on to the provider
+			rr = httptest.NewRecorder()
+			req = httptest.NewRequest("GET", "http://example.com/auth/login?acr_values=mfa&max_age=0&return_url=%2Fadmin", nil)
+
+			a.LoginFunc(rr, req)
+
+			u, err = url.Parse(rr.Header().Get("Location"))
+			if err != nil {
+				t.Fatalf("failed to parse location header: %v", err)
+			}
+			if acr := u.Query().Get("acr_values"); acr != "mfa" {
+				t.Errorf("expected acr_values=mfa, got %q", acr)
+			}
+			if maxAge := u.Query().Get("max_age"); maxAge != "0" {
+				t.Errorf("expected max_age=0, got %q", maxAge)
+			}
+
+			if tt.profile == nil {
+				return
+			}
+
+			// The profile is applied over OpenShift's defaults for the provider client
+			c, err := ccfg.Complete()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tlsConfig := c.clientFunc().Transport.(*http.Transport).TLSClientConfig
+			if tlsConfig.MinVersion != tt.profile.MinVersion {
+				t.Errorf("expected min version %x, got %x", tt.profile.MinVersion, tlsConfig.MinVersion)
+			}
+			if !slices.Equal(tlsConfig.CurvePreferences, tt.profile.CurvePreferences) {
+				t.Errorf("expected groups %v, got %v", tt.profile.CurvePreferences, tlsConfig.CurvePreferences)
+			}
+			if tt.profile.CipherSuites != nil && !slices.Equal(tlsConfig.CipherSuites, tt.profile.CipherSuites) {
+				t.Errorf("expected cipher suites %v, got %v", tt.profile.CipherSuites, tlsConfig.CipherSuites)
+			}
+		})
 	}
 }
 
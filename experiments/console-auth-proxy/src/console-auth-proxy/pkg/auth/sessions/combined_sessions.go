@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	gorilla "github.com/gorilla/sessions"
 	"golang.org/x/oauth2"
@@ -59,6 +60,26 @@ func (cs *CombinedSessionStore) AddSession(w http.ResponseWriter, r *http.Reques
 	return ls, clientSession.save(r, w)
 }
 
+// AddLocalSession stores a session for a user authenticated by the proxy itself.
+// Local sessions have no refresh token, only the session token cookie is set.
+func (cs *CombinedSessionStore) AddLocalSession(w http.ResponseWriter, r *http.Request, userID, name string, expiry time.Time) (*LoginState, error) {
+	cs.sessionLock.Lock()
+	defer cs.sessionLock.Unlock()
+
+	ls, err := cs.serverStore.addLoginState(NewLocalLoginState(userID, name, expiry))
+	if err != nil {
+		return nil, fmt.Errorf("failed to add session to server store: %w", err)
+	}
+
+	clientSession := cs.getCookieSession(r)
+	clientSession.sessionToken.Values["session-token"] = ls.sessionToken
+	if err := clientSession.sessionToken.Save(r, w); err != nil {
+		return nil, fmt.Errorf("failed to save session token cookie: %w", err)
+	}
+
+	return ls, nil
+}
+
 func (cs *CombinedSessionStore) getCookieSession(r *http.Request) *session {
 	clientSession, _ := cs.clientStore.Get(r, SessionCookieName())
 	refreshSession, _ := cs.clientStore.Get(r, openshiftRefreshTokenCookieName)
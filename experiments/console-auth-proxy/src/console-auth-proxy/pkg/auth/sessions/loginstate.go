@@ -30,6 +30,14 @@ type LoginState struct {
 	sessionToken string
 	rawToken     string
 	refreshToken string
+
+	// Authentication context from the ID token, used for step-up authentication
+	acr      string
+	amr      []string
+	authTime time.Time
+
+	// Groups from the ID token's groups claim
+	groups []string
 }
 
 type LoginJSON struct {
@@ -40,10 +48,14 @@ type LoginJSON struct {
 }
 
 type interestingClaims struct {
-	Subject string   `json:"sub"`
-	Expiry  jsonTime `json:"exp"`
-	Email   string   `json:"email"`
-	Name    string   `json:"name"`
+	Subject  string   `json:"sub"`
+	Expiry   jsonTime `json:"exp"`
+	Email    string   `json:"email"`
+	Name     string   `json:"name"`
+	ACR      string   `json:"acr"`
+	AMR      []string `json:"amr"`
+	AuthTime jsonTime `json:"auth_time"`
+	Groups   []string `json:"groups"`
 }
 
 // NewRawLoginState creates a new login state in cases where the access token
@@ -54,6 +66,20 @@ func NewRawLoginState(accessToken string) *LoginState {
 	}
 }
 
+// NewLocalLoginState creates a login state for a user authenticated by the
+// proxy itself, without an identity provider or tokens.
+func NewLocalLoginState(userID, name string, expiry time.Time) *LoginState {
+	ls := &LoginState{
+		now:          time.Now,
+		sessionToken: RandomString(256),
+		userID:       userID,
+		name:         name,
+		authTime:     time.Now(),
+	}
+	ls.updateExpiry(jsonTime(expiry))
+	return ls
+}
+
 // newLoginState unpacks a token and generates a new loginState from it.
 func newLoginState(tokenVerifier IDTokenVerifier, token *oauth2.Token) (*LoginState, error) {
 	if token == nil {
@@ -91,6 +117,8 @@ func newLoginState(tokenVerifier IDTokenVerifier, token *oauth2.Token) (*LoginSt
 		name:         tokenClaims.Name,
 	}
 	ls.updateExpiry(tokenClaims.Expiry)
+	ls.updateAuthContext(tokenClaims)
+	ls.updateGroups(tokenClaims)
 
 	return ls, nil
 }
@@ -129,10 +157,33 @@ func (ls *LoginState) UpdateTokens(verifier IDTokenVerifier, tokenResponse *oaut
 	ls.rawToken = rawIDToken
 	ls.refreshToken = tokenResponse.RefreshToken
 	ls.updateExpiry(tokenClaims.Expiry)
+	ls.updateAuthContext(tokenClaims)
+	ls.updateGroups(tokenClaims)
 
 	return nil
 }
 
+// updateAuthContext records the authentication context of an ID token. Refreshed
+// ID tokens may omit acr/amr, so existing values are only replaced, never cleared.
+func (ls *LoginState) updateAuthContext(claims *interestingClaims) {
+	if claims.ACR != "" {
+		ls.acr = claims.ACR
+	}
+	if len(claims.AMR) > 0 {
+		ls.amr = append([]string(nil), claims.AMR...)
+	}
+	if !time.Time(claims.AuthTime).IsZero() {
+		ls.authTime = time.Time(claims.AuthTime)
+	}
+}
+
+// updateGroups records the groups claim. Unlike acr/amr, the groups of every
+// refreshed ID token replace the previous ones, and a token without the claim
+// clears them, so revoked memberships don't outlive the refresh.
+func (ls *LoginState) updateGroups(claims *interestingClaims) {
+	ls.groups = append([]string(nil), claims.Groups...)
+}
+
 func (ls *LoginState) updateExpiry(expiry jsonTime) {
 	now := ls.now()
 	ls.exp = time.Time(expiry)
@@ -152,6 +203,26 @@ func (ls *LoginState) CompareExpiry(other *LoginState) int {
 	return 1
 }
 
+// ACR returns the authentication context class reference of the ID token
+func (ls *LoginState) ACR() string {
+	return ls.acr
+}
+
+// AMR returns the authentication methods references of the ID token
+func (ls *LoginState) AMR() []string {
+	return append([]string(nil), ls.amr...)
+}
+
+// Groups returns the groups claim of the ID token
+func (ls *LoginState) Groups() []string {
+	return append([]string(nil), ls.groups...)
+}
+
+// AuthTime returns when the user last actively authenticated with the IdP
+func (ls *LoginState) AuthTime() time.Time {
+	return ls.authTime
+}
+
 func (ls *LoginState) AccessToken() string {
 	return ls.rawToken
 }
@@ -110,3 +110,55 @@ func createTestIDToken(payload string) string {
 		"." + base64.RawStdEncoding.EncodeToString([]byte(payload)) +
 		"." + base64.RawStdEncoding.EncodeToString([]byte("whoopsie"))
 }
+
+func TestLoginStateAuthContext(t *testing.T) {
+	authTime := time.Now().Add(-time.Minute).Unix()
+	claims := fmt.Sprintf(`{
+		"sub": "user-id",
+		"exp": %d,
+		"acr": "urn:mace:incommon:iap:silver",
+		"amr": ["pwd", "otp"],
+		"auth_time": %d,
+		"groups": ["developers"]
+	}`, time.Now().Add(time.Hour).Unix(), authTime)
+
+	tokenResp := (&oauth2.Token{RefreshToken: "refresh"}).WithExtra(map[string]interface{}{"id_token": createTestIDToken(claims)})
+	ls, err := newLoginState(newTestVerifier(claims), tokenResp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ls.ACR() != "urn:mace:incommon:iap:silver" {
+		t.Errorf("acr mismatch, got: %s", ls.ACR())
+	}
+	if amr := ls.AMR(); len(amr) != 2 || amr[0] != "pwd" || amr[1] != "otp" {
+		t.Errorf("amr mismatch, got: %v", amr)
+	}
+	if ls.AuthTime().Unix() != authTime {
+		t.Errorf("auth_time mismatch, want: %d, got: %d", authTime, ls.AuthTime().Unix())
+	}
+
+	// a refreshed ID token without acr/amr keeps the stronger authentication context
+	refreshed := fmt.Sprintf(`{"sub": "user-id", "exp": %d}`, time.Now().Add(2*time.Hour).Unix())
+	refreshResp := (&oauth2.Token{RefreshToken: "refresh2"}).WithExtra(map[string]interface{}{"id_token": createTestIDToken(refreshed)})
+	if err := ls.UpdateTokens(newTestVerifier(refreshed), refreshResp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ls.ACR() != "urn:mace:incommon:iap:silver" || len(ls.AMR()) != 2 || ls.AuthTime().Unix() != authTime {
+		t.Errorf("auth context was lost on refresh: acr=%s amr=%v auth_time=%v", ls.ACR(), ls.AMR(), ls.AuthTime())
+	}
+	if groups := ls.Groups(); len(groups) != 0 {
+		t.Errorf("groups were kept on refresh without the claim: %v", groups)
+	}
+
+	// the groups of a refreshed ID token replace the previous ones
+	refreshed = fmt.Sprintf(`{"sub": "user-id", "exp": %d, "groups": ["analysts"]}`, time.Now().Add(3*time.Hour).Unix())
+	refreshResp = (&oauth2.Token{RefreshToken: "refresh3"}).WithExtra(map[string]interface{}{"id_token": createTestIDToken(refreshed)})
+	if err := ls.UpdateTokens(newTestVerifier(refreshed), refreshResp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if groups := ls.Groups(); len(groups) != 1 || groups[0] != "analysts" {
+		t.Errorf("groups were not replaced on refresh: %v", groups)
+	}
+}
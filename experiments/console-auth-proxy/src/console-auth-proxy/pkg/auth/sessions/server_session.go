@@ -50,6 +50,11 @@ func (ss *SessionStore) AddSession(tokenVerifier IDTokenVerifier, token *oauth2.
 		return nil, fmt.Errorf("failed to create new session: %w", err)
 	}
 
+	return ss.addLoginState(ls)
+}
+
+// addLoginState adds an already created loginState to session data structures
+func (ss *SessionStore) addLoginState(ls *LoginState) (*LoginState, error) {
 	sessionToken := ls.sessionToken
 	if ss.byToken[sessionToken] != nil {
 		ss.DeleteSession(sessionToken)
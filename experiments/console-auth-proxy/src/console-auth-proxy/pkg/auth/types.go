@@ -1,7 +1,9 @@
 package auth
 
 import (
+	"crypto/x509"
 	"net/http"
+	"time"
 
 	"github.com/your-org/console-auth-proxy/pkg/auth/sessions"
 )
@@ -31,4 +33,15 @@ type User struct {
 	ID       string
 	Username string
 	Token    string
+
+	// Groups of the user. Populated for local users and from the OIDC groups claim.
+	Groups []string
+
+	// Authentication context from the ID token. Only populated for OIDC sessions.
+	ACR      string
+	AMR      []string
+	AuthTime time.Time
+
+	// Verified client certificate. Only populated for mTLS authentication.
+	ClientCertificate *x509.Certificate
 }
@@ -20,7 +20,7 @@ func RemoveComments(input string) string {
 		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
 			continue
 		}
-		result = append(result, line)
+		result = append(result, trimmed)
 	}
 	
 	return strings.Join(result, "\n")
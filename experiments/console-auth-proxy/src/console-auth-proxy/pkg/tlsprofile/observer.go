@@ -0,0 +1,165 @@
+package tlsprofile
+
+import (
+	"crypto/fips140"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Components whose handshakes are observed
+const (
+	ComponentServer  = "server"
+	ComponentBackend = "backend"
+	ComponentIdP     = "idp"
+)
+
+// Observer counts the protocol versions and key exchange groups negotiated by
+// TLS handshakes. A nil Observer observes nothing.
+type Observer struct {
+	profile *Profile
+
+	profileInfo *prometheus.GaugeVec
+	handshakes  *prometheus.CounterVec
+
+	mu sync.Mutex
+	// component -> group -> handshakes
+	groups map[string]map[string]int64
+}
+
+// Info describes the active profile and the groups negotiated so far
+type Info struct {
+	Profile    string                      `json:"profile"`
+	FIPS140    bool                        `json:"fips140"`
+	MinVersion string                      `json:"min_version"`
+	Groups     []string                    `json:"groups,omitempty"`
+	Negotiated map[string]map[string]int64 `json:"negotiated"`
+}
+
+// NewObserver creates an observer for connections using profile
+func NewObserver(profile *Profile) *Observer {
+	o := &Observer{
+		profile: profile,
+		groups:  make(map[string]map[string]int64),
+	}
+
+	o.profileInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "console_auth_proxy",
+		Subsystem: "tls",
+		Name:      "profile_info",
+		Help:      "Active TLS profile, always 1.",
+	}, []string{"profile"})
+	o.profileInfo.WithLabelValues(profile.Name).Set(1)
+
+	o.handshakes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "console_auth_proxy",
+		Subsystem: "tls",
+		Name:      "handshakes_total",
+		Help:      "Total number of completed TLS handshakes by component, protocol version and key exchange group.",
+	}, []string{"component", "version", "group"})
+
+	return o
+}
+
+// Profile returns the observed profile
+func (o *Observer) Profile() *Profile {
+	return o.profile
+}
+
+// GetCollectors returns the observer's Prometheus collectors
+func (o *Observer) GetCollectors() []prometheus.Collector {
+	return []prometheus.Collector{o.profileInfo, o.handshakes}
+}
+
+// Record counts a completed handshake
+func (o *Observer) Record(component string, state tls.ConnectionState) {
+	if o == nil {
+		return
+	}
+	group := state.CurveID.String()
+	o.handshakes.WithLabelValues(component, tls.VersionName(state.Version), group).Inc()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.groups[component] == nil {
+		o.groups[component] = make(map[string]int64)
+	}
+	o.groups[component][group]++
+}
+
+// ObserveServer records the handshakes of a server config
+func (o *Observer) ObserveServer(config *tls.Config) {
+	if o == nil {
+		return
+	}
+	verify := config.VerifyConnection
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(state); err != nil {
+				return err
+			}
+		}
+		o.Record(ComponentServer, state)
+		return nil
+	}
+}
+
+// RoundTripper records the handshakes of new connections made by next
+func (o *Observer) RoundTripper(component string, next http.RoundTripper) http.RoundTripper {
+	if o == nil {
+		return next
+	}
+	return &observingTransport{observer: o, component: component, next: next}
+}
+
+// Info returns the active profile and the groups negotiated so far
+func (o *Observer) Info() Info {
+	info := Info{
+		Profile:    o.profile.Name,
+		FIPS140:    fips140.Enabled(),
+		MinVersion: tls.VersionName(o.profile.MinVersion),
+		Groups:     o.profile.Groups(),
+		Negotiated: make(map[string]map[string]int64),
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for component, groups := range o.groups {
+		info.Negotiated[component] = make(map[string]int64, len(groups))
+		for group, count := range groups {
+			info.Negotiated[component][group] = count
+		}
+	}
+	return info
+}
+
+type observingTransport struct {
+	observer  *Observer
+	component string
+	next      http.RoundTripper
+}
+
+func (t *observingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The connection state is only known from the response, count it once per connection
+	var reused bool
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	resp, err := t.next.RoundTrip(req)
+	if err == nil && resp.TLS != nil && !reused {
+		t.observer.Record(t.component, *resp.TLS)
+	}
+	return resp, err
+}
+
+// CloseIdleConnections closes the idle connections of the wrapped transport
+func (t *observingTransport) CloseIdleConnections() {
+	if closer, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
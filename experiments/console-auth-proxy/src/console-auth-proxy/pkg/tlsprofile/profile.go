@@ -0,0 +1,103 @@
+// Package tlsprofile defines named TLS profiles shared by the proxy's listener
+// and its connections to the backend and the identity provider
+package tlsprofile
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// Profile names
+const (
+	Default  = "default"
+	FIPS     = "fips"
+	PQHybrid = "pq-hybrid"
+	Modern   = "modern"
+)
+
+// Profile restricts the TLS versions, cipher suites and key exchange groups a
+// connection may negotiate. Empty fields keep Go's defaults.
+type Profile struct {
+	Name       string
+	MinVersion uint16
+	// TLS 1.2 cipher suites, TLS 1.3 suites are not configurable in Go
+	CipherSuites []uint16
+	// Key exchange groups in order of preference
+	CurvePreferences []tls.CurveID
+}
+
+var profiles = map[string]*Profile{
+	// Go's defaults: TLS 1.2 and later, X25519MLKEM768 preferred when the peer
+	// supports it with classical groups as a fallback
+	Default: {
+		Name:       Default,
+		MinVersion: tls.VersionTLS12,
+	},
+
+	// FIPS 140-3 approved algorithms only. X25519 isn't approved by the
+	// OpenSSL-backed FIPS modules on RHEL, so neither is the X25519MLKEM768
+	// hybrid; see rfc/fips-pqc.
+	FIPS: {
+		Name:       FIPS,
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		},
+		CurvePreferences: []tls.CurveID{tls.CurveP256, tls.CurveP384},
+	},
+
+	// Post-quantum key exchange required: TLS 1.3 with X25519MLKEM768 only,
+	// peers without ML-KEM support can't connect
+	PQHybrid: {
+		Name:             PQHybrid,
+		MinVersion:       tls.VersionTLS13,
+		CurvePreferences: []tls.CurveID{tls.X25519MLKEM768},
+	},
+
+	// TLS 1.3 only with Go's default groups
+	Modern: {
+		Name:       Modern,
+		MinVersion: tls.VersionTLS13,
+	},
+}
+
+// Names returns the profile names
+func Names() []string {
+	return []string{Default, FIPS, PQHybrid, Modern}
+}
+
+// Get returns the named profile, the default profile if name is empty
+func Get(name string) (*Profile, error) {
+	if name == "" {
+		name = Default
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS profile %q, must be one of: %s", name, strings.Join(Names(), ", "))
+	}
+	return profile, nil
+}
+
+// Apply sets the profile's versions, cipher suites and groups on config
+func (p *Profile) Apply(config *tls.Config) {
+	config.MinVersion = p.MinVersion
+	if p.CipherSuites != nil {
+		config.CipherSuites = append([]uint16(nil), p.CipherSuites...)
+	}
+	if p.CurvePreferences != nil {
+		config.CurvePreferences = append([]tls.CurveID(nil), p.CurvePreferences...)
+	}
+}
+
+// Groups returns the names of the profile's key exchange groups, nil for Go's defaults
+func (p *Profile) Groups() []string {
+	var groups []string
+	for _, curve := range p.CurvePreferences {
+		groups = append(groups, curve.String())
+	}
+	return groups
+}
@@ -0,0 +1,141 @@
+package tlsprofile
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGet(t *testing.T) {
+	for _, name := range Names() {
+		profile, err := Get(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, profile.Name)
+	}
+
+	profile, err := Get("")
+	require.NoError(t, err)
+	assert.Equal(t, Default, profile.Name)
+
+	_, err = Get("legacy")
+	assert.Error(t, err)
+}
+
+func TestApply(t *testing.T) {
+	fips, _ := Get(FIPS)
+	config := &tls.Config{ServerName: "backend"}
+	fips.Apply(config)
+
+	assert.Equal(t, uint16(tls.VersionTLS12), config.MinVersion)
+	assert.Equal(t, []tls.CurveID{tls.CurveP256, tls.CurveP384}, config.CurvePreferences)
+	assert.NotContains(t, config.CipherSuites, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256)
+	assert.Equal(t, "backend", config.ServerName)
+
+	// The profile's slices are not shared with the config
+	config.CurvePreferences[0] = tls.X25519
+	assert.Equal(t, tls.CurveP256, fips.CurvePreferences[0])
+}
+
+// startServer serves TLS with the server profile and returns a client using the client profile
+func startServer(t *testing.T, serverProfile, clientProfile string, observer *Observer) (*httptest.Server, *http.Client) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	server.TLS = &tls.Config{}
+	profile, err := Get(serverProfile)
+	require.NoError(t, err)
+	profile.Apply(server.TLS)
+	observer.ObserveServer(server.TLS)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	profile, err = Get(clientProfile)
+	require.NoError(t, err)
+	profile.Apply(transport.TLSClientConfig)
+
+	return server, &http.Client{Transport: observer.RoundTripper(ComponentBackend, transport)}
+}
+
+func get(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+func TestNegotiatedGroups(t *testing.T) {
+	tests := []struct {
+		server, client string
+		group          string
+		version        uint16
+	}{
+		{Default, Default, "X25519MLKEM768", tls.VersionTLS13},
+		{PQHybrid, Default, "X25519MLKEM768", tls.VersionTLS13},
+		{Modern, Modern, "X25519MLKEM768", tls.VersionTLS13},
+		{FIPS, FIPS, "CurveP256", tls.VersionTLS13},
+		{Default, FIPS, "CurveP256", tls.VersionTLS13},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.server+"/"+tt.client, func(t *testing.T) {
+			profile, _ := Get(tt.server)
+			observer := NewObserver(profile)
+			server, client := startServer(t, tt.server, tt.client, observer)
+
+			require.NoError(t, get(client, server.URL))
+			info := observer.Info()
+			assert.Equal(t, map[string]int64{tt.group: 1}, info.Negotiated[ComponentServer])
+			assert.Equal(t, map[string]int64{tt.group: 1}, info.Negotiated[ComponentBackend])
+		})
+	}
+}
+
+func TestIncompatibleProfiles(t *testing.T) {
+	// A FIPS peer can't negotiate the hybrid group
+	server, client := startServer(t, PQHybrid, FIPS, nil)
+	assert.Error(t, get(client, server.URL))
+
+	// A TLS 1.2 only peer can't connect to a TLS 1.3 profile
+	server, client = startServer(t, Modern, Default, nil)
+	client.Transport.(*http.Transport).TLSClientConfig.MaxVersion = tls.VersionTLS12
+	assert.Error(t, get(client, server.URL))
+}
+
+func TestObserverCountsConnections(t *testing.T) {
+	profile, _ := Get(Default)
+	observer := NewObserver(profile)
+	server, client := startServer(t, Default, Default, observer)
+
+	// Requests on a kept-alive connection are one handshake
+	for i := 0; i < 3; i++ {
+		require.NoError(t, get(client, server.URL))
+	}
+	assert.Equal(t, int64(1), observer.Info().Negotiated[ComponentBackend]["X25519MLKEM768"])
+
+	client.CloseIdleConnections()
+	require.NoError(t, get(client, server.URL))
+	assert.Equal(t, int64(2), observer.Info().Negotiated[ComponentBackend]["X25519MLKEM768"])
+	assert.Equal(t, int64(2), observer.Info().Negotiated[ComponentServer]["X25519MLKEM768"])
+}
+
+func TestInfo(t *testing.T) {
+	profile, _ := Get(PQHybrid)
+	info := NewObserver(profile).Info()
+	assert.Equal(t, PQHybrid, info.Profile)
+	assert.Equal(t, "TLS 1.3", info.MinVersion)
+	assert.Equal(t, []string{"X25519MLKEM768"}, info.Groups)
+	assert.Empty(t, info.Negotiated)
+
+	// A nil observer passes transports through
+	var observer *Observer
+	transport := http.DefaultTransport
+	assert.Equal(t, transport, observer.RoundTripper(ComponentIdP, transport))
+}
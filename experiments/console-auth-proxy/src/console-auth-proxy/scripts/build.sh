@@ -57,6 +57,14 @@ if ! go build \
     error "Build failed"
 fi
 
+# Build the sidecar injector webhook
+if ! go build \
+    -ldflags "${LDFLAGS}" \
+    -o "${BUILD_DIR}/auth-proxy-injector" \
+    ./cmd/auth-proxy-injector; then
+    error "Injector build failed"
+fi
+
 log "Build completed successfully!"
 
 # Make binary executable
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -19,6 +20,9 @@ var (
 	tlsCertFile string
 	tlsKeyFile  string
 
+	// Metrics configuration
+	metricsPort string
+
 	// OIDC configuration
 	oidcIssuerURL    string
 	oidcClientID     string
@@ -30,6 +34,28 @@ var (
 	openshiftClientSecret string
 	openshiftCABundle     string
 	openshiftScope        string
+
+	// OpenShift token validation cache
+	openshiftTokenCacheTTL         time.Duration
+	openshiftTokenCacheNegativeTTL time.Duration
+	openshiftTokenCacheSize        int
+	openshiftTokenCacheDisabled    bool
+	openshiftGroupInformer         bool
+
+	// Session configuration
+	sessionSecret        string
+	sessionTTL           time.Duration
+	sessionRefreshWindow time.Duration
+
+	// Identity assertion configuration
+	identityAssertion        bool
+	identityAssertionKeyFile string
+	identityAssertionTTL     time.Duration
+
+	// Kubernetes route source configuration
+	kubernetesServices          bool
+	kubernetesGatewayClass      string
+	kubernetesNamespaceSelector string
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -59,6 +85,9 @@ func init() {
 	rootCmd.Flags().StringVar(&tlsCertFile, "tls-cert-file", "", "Path to TLS certificate file (enables HTTPS)")
 	rootCmd.Flags().StringVar(&tlsKeyFile, "tls-key-file", "", "Path to TLS private key file (enables HTTPS)")
 
+	// Metrics flags
+	rootCmd.Flags().StringVar(&metricsPort, "metrics-port", "", "Port serving Prometheus metrics on /metrics (disabled if empty)")
+
 	// OIDC flags
 	rootCmd.Flags().StringVar(&oidcIssuerURL, "oidc-issuer-url", "", "OIDC issuer URL")
 	rootCmd.Flags().StringVar(&oidcClientID, "oidc-client-id", "", "OIDC client ID")
@@ -71,9 +100,32 @@ func init() {
 	rootCmd.Flags().StringVar(&openshiftCABundle, "openshift-ca-bundle", "", "OpenShift CA bundle (PEM format)")
 	rootCmd.Flags().StringVar(&openshiftScope, "openshift-scope", "", "OpenShift OAuth scope (default: user:info)")
 
+	rootCmd.Flags().DurationVar(&openshiftTokenCacheTTL, "openshift-token-cache-ttl", 30*time.Second, "How long successful OpenShift token validations are cached")
+	rootCmd.Flags().DurationVar(&openshiftTokenCacheNegativeTTL, "openshift-token-cache-negative-ttl", 5*time.Second, "How long rejected OpenShift tokens are cached")
+	rootCmd.Flags().IntVar(&openshiftTokenCacheSize, "openshift-token-cache-size", 1000, "Maximum number of cached OpenShift token validations")
+	rootCmd.Flags().BoolVar(&openshiftTokenCacheDisabled, "openshift-token-cache-disabled", false, "Validate OpenShift tokens against the API on every request")
+
+	rootCmd.Flags().BoolVar(&openshiftGroupInformer, "openshift-group-informer", false, "Resolve OpenShift groups from an in-memory index watched with the gateway's service account")
+
+	// Session flags
+	rootCmd.Flags().StringVar(&sessionSecret, "session-secret", "", "Secret used to encrypt session cookies (default: random per process)")
+	rootCmd.Flags().DurationVar(&sessionTTL, "session-ttl", 24*time.Hour, "Maximum lifetime of a login session")
+	rootCmd.Flags().DurationVar(&sessionRefreshWindow, "session-refresh-window", time.Minute, "Refresh session tokens this long before they expire")
+
+	// Identity assertion flags
+	rootCmd.Flags().BoolVar(&identityAssertion, "identity-assertion", false, "Send upstreams a signed JWT asserting the user's identity")
+	rootCmd.Flags().StringVar(&identityAssertionKeyFile, "identity-assertion-key-file", "", "PEM encoded ECDSA P-256 key to sign identity assertions with (default: random per process)")
+	rootCmd.Flags().DurationVar(&identityAssertionTTL, "identity-assertion-ttl", time.Minute, "Lifetime of identity assertions")
+
+	// Kubernetes route source flags
+	rootCmd.Flags().BoolVar(&kubernetesServices, "kubernetes-services", false, "Route to Services annotated with odhgateway.opendatahub.io/enabled, watched directly")
+	rootCmd.Flags().StringVar(&kubernetesGatewayClass, "kubernetes-gateway-class", "", "Route HTTPRoutes attached to Gateways of this GatewayClass")
+	rootCmd.Flags().StringVar(&kubernetesNamespaceSelector, "kubernetes-namespace-selector", "", "Label selector for the namespaces watched for routes (default: all namespaces)")
+
 	// Bind flags to viper for environment variable support
 	viper.BindPFlag("tls.cert-file", rootCmd.Flags().Lookup("tls-cert-file"))
 	viper.BindPFlag("tls.key-file", rootCmd.Flags().Lookup("tls-key-file"))
+	viper.BindPFlag("metrics.port", rootCmd.Flags().Lookup("metrics-port"))
 	viper.BindPFlag("oidc.issuer-url", rootCmd.Flags().Lookup("oidc-issuer-url"))
 	viper.BindPFlag("oidc.client-id", rootCmd.Flags().Lookup("oidc-client-id"))
 	viper.BindPFlag("oidc.client-secret", rootCmd.Flags().Lookup("oidc-client-secret"))
@@ -82,6 +134,20 @@ func init() {
 	viper.BindPFlag("openshift.client-secret", rootCmd.Flags().Lookup("openshift-client-secret"))
 	viper.BindPFlag("openshift.ca-bundle", rootCmd.Flags().Lookup("openshift-ca-bundle"))
 	viper.BindPFlag("openshift.scope", rootCmd.Flags().Lookup("openshift-scope"))
+	viper.BindPFlag("openshift.token-cache-ttl", rootCmd.Flags().Lookup("openshift-token-cache-ttl"))
+	viper.BindPFlag("openshift.token-cache-negative-ttl", rootCmd.Flags().Lookup("openshift-token-cache-negative-ttl"))
+	viper.BindPFlag("openshift.token-cache-size", rootCmd.Flags().Lookup("openshift-token-cache-size"))
+	viper.BindPFlag("openshift.token-cache-disabled", rootCmd.Flags().Lookup("openshift-token-cache-disabled"))
+	viper.BindPFlag("openshift.group-informer", rootCmd.Flags().Lookup("openshift-group-informer"))
+	viper.BindPFlag("session.secret", rootCmd.Flags().Lookup("session-secret"))
+	viper.BindPFlag("session.ttl", rootCmd.Flags().Lookup("session-ttl"))
+	viper.BindPFlag("session.refresh-window", rootCmd.Flags().Lookup("session-refresh-window"))
+	viper.BindPFlag("identity-assertion.enabled", rootCmd.Flags().Lookup("identity-assertion"))
+	viper.BindPFlag("identity-assertion.key-file", rootCmd.Flags().Lookup("identity-assertion-key-file"))
+	viper.BindPFlag("identity-assertion.ttl", rootCmd.Flags().Lookup("identity-assertion-ttl"))
+	viper.BindPFlag("kubernetes.services", rootCmd.Flags().Lookup("kubernetes-services"))
+	viper.BindPFlag("kubernetes.gateway-class", rootCmd.Flags().Lookup("kubernetes-gateway-class"))
+	viper.BindPFlag("kubernetes.namespace-selector", rootCmd.Flags().Lookup("kubernetes-namespace-selector"))
 
 	// Set environment variable prefix
 	viper.SetEnvPrefix("GATEWAY")
@@ -90,6 +156,7 @@ func init() {
 	// Bind additional environment variables for backward compatibility
 	viper.BindEnv("tls.cert-file", "TLS_CERT_FILE")
 	viper.BindEnv("tls.key-file", "TLS_KEY_FILE")
+	viper.BindEnv("metrics.port", "GATEWAY_METRICS_PORT")
 	viper.BindEnv("oidc.issuer-url", "OIDC_ISSUER_URL")
 	viper.BindEnv("oidc.client-id", "OIDC_CLIENT_ID")
 	viper.BindEnv("oidc.client-secret", "OIDC_CLIENT_SECRET")
@@ -98,6 +165,20 @@ func init() {
 	viper.BindEnv("openshift.client-secret", "OPENSHIFT_CLIENT_SECRET")
 	viper.BindEnv("openshift.ca-bundle", "OPENSHIFT_CA_BUNDLE")
 	viper.BindEnv("openshift.scope", "OPENSHIFT_SCOPE")
+	viper.BindEnv("openshift.token-cache-ttl", "OPENSHIFT_TOKEN_CACHE_TTL")
+	viper.BindEnv("openshift.token-cache-negative-ttl", "OPENSHIFT_TOKEN_CACHE_NEGATIVE_TTL")
+	viper.BindEnv("openshift.token-cache-size", "OPENSHIFT_TOKEN_CACHE_SIZE")
+	viper.BindEnv("openshift.token-cache-disabled", "OPENSHIFT_TOKEN_CACHE_DISABLED")
+	viper.BindEnv("openshift.group-informer", "OPENSHIFT_GROUP_INFORMER")
+	viper.BindEnv("session.secret", "GATEWAY_SESSION_SECRET", "SESSION_SECRET")
+	viper.BindEnv("session.ttl", "GATEWAY_SESSION_TTL", "SESSION_TTL")
+	viper.BindEnv("session.refresh-window", "GATEWAY_SESSION_REFRESH_WINDOW", "SESSION_REFRESH_WINDOW")
+	viper.BindEnv("identity-assertion.enabled", "GATEWAY_IDENTITY_ASSERTION")
+	viper.BindEnv("identity-assertion.key-file", "GATEWAY_IDENTITY_ASSERTION_KEY_FILE")
+	viper.BindEnv("identity-assertion.ttl", "GATEWAY_IDENTITY_ASSERTION_TTL")
+	viper.BindEnv("kubernetes.services", "GATEWAY_KUBERNETES_SERVICES")
+	viper.BindEnv("kubernetes.gateway-class", "GATEWAY_KUBERNETES_GATEWAY_CLASS")
+	viper.BindEnv("kubernetes.namespace-selector", "GATEWAY_KUBERNETES_NAMESPACE_SELECTOR")
 }
 
 // initConfig reads in config file and ENV variables if set
@@ -159,6 +240,13 @@ func runGateway(cmd *cobra.Command, args []string) {
 				ClientSecret: openshiftClientSecretValue,
 				CABundle:     openshiftCABundleValue,
 				Scope:        openshiftScopeValue,
+				TokenCache: config.TokenCacheConfig{
+					TTL:         viper.GetDuration("openshift.token-cache-ttl"),
+					NegativeTTL: viper.GetDuration("openshift.token-cache-negative-ttl"),
+					MaxEntries:  viper.GetInt("openshift.token-cache-size"),
+					Disabled:    viper.GetBool("openshift.token-cache-disabled"),
+				},
+				GroupInformer: viper.GetBool("openshift.group-informer"),
 			},
 		}
 		log.Printf("OpenShift OAuth provider configured")
@@ -189,8 +277,29 @@ func runGateway(cmd *cobra.Command, args []string) {
 		log.Printf("No authentication provider configured - running without authentication")
 	}
 
+	// Build session configuration
+	sessionConfig := config.SessionConfig{
+		Secret:        viper.GetString("session.secret"),
+		TTL:           viper.GetDuration("session.ttl"),
+		RefreshWindow: viper.GetDuration("session.refresh-window"),
+	}
+
+	// Build identity assertion configuration
+	identityConfig := config.IdentityAssertionConfig{
+		Enabled: viper.GetBool("identity-assertion.enabled"),
+		KeyFile: viper.GetString("identity-assertion.key-file"),
+		TTL:     viper.GetDuration("identity-assertion.ttl"),
+	}
+
+	// Build Kubernetes route source configuration
+	sourceConfig := config.KubernetesSourceConfig{
+		Services:          viper.GetBool("kubernetes.services"),
+		GatewayClassName:  viper.GetString("kubernetes.gateway-class"),
+		NamespaceSelector: viper.GetString("kubernetes.namespace-selector"),
+	}
+
 	// Start the gateway server
-	if err := proxy.StartServer(certFile, keyFile, providerConfig); err != nil {
+	if err := proxy.StartServer(certFile, keyFile, viper.GetString("metrics.port"), providerConfig, sessionConfig, identityConfig, sourceConfig); err != nil {
 		log.Fatalf("Failed to start gateway: %v", err)
 	}
 }
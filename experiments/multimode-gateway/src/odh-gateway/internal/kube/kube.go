@@ -0,0 +1,31 @@
+// Package kube provides the Kubernetes client configuration shared by the
+// gateway's informers and API checks
+package kube
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// RestConfig returns the in-cluster service account configuration, or the
+// kubeconfig named by KUBECONFIG when running outside a cluster
+func RestConfig() (*rest.Config, error) {
+	config, err := rest.InClusterConfig()
+	if err == nil {
+		return config, nil
+	}
+
+	kubeconfig := os.Getenv("KUBECONFIG")
+	if kubeconfig == "" {
+		return nil, fmt.Errorf("failed to load in-cluster config and KUBECONFIG is not set: %w", err)
+	}
+
+	config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig %s: %w", kubeconfig, err)
+	}
+	return config, nil
+}
@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jctanner/odh-gateway/internal/kube"
+	"github.com/jctanner/odh-gateway/internal/proxy/providers"
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+const (
+	accessReviewTTL        = 30 * time.Second
+	accessReviewMaxEntries = 1000
+)
+
+// routeAuthorizer decides whether an authenticated user may use a route
+type routeAuthorizer struct {
+	route config.Route
+	// ownerPrefix is the part of the route path before ${user}, empty if the
+	// route isn't per-user
+	ownerPrefix string
+}
+
+// newRouteAuthorizer returns the authorizer for a route, nil if the route
+// doesn't restrict its users
+func newRouteAuthorizer(route config.Route) *routeAuthorizer {
+	if !route.HasAuthorization() {
+		return nil
+	}
+
+	a := &routeAuthorizer{route: route}
+	if i := strings.Index(route.PathPrefix, config.UserPlaceholder); i >= 0 {
+		a.ownerPrefix = route.PathPrefix[:i]
+	}
+	return a
+}
+
+// authorize returns why user may not use the route, empty if they may
+func (a *routeAuthorizer) authorize(r *http.Request, user *providers.UserInfo) string {
+	// Per-user paths belong to the user named in the path
+	if a.ownerPrefix != "" {
+		owner, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, a.ownerPrefix), "/")
+		if owner != user.Username {
+			return fmt.Sprintf("path belongs to %q", owner)
+		}
+	}
+
+	if len(a.route.AllowedUsers) > 0 || len(a.route.AllowedGroups) > 0 {
+		allowed := slices.Contains(a.route.AllowedUsers, user.Username)
+		for _, group := range user.Groups {
+			if allowed {
+				break
+			}
+			allowed = slices.Contains(a.route.AllowedGroups, group)
+		}
+		if !allowed {
+			return "user is not in allowedUsers or allowedGroups"
+		}
+	}
+
+	if a.route.SubjectAccessReview != nil {
+		allowed, reason, err := reviewAccess(user, a.route.SubjectAccessReview)
+		if err != nil {
+			// Fail closed
+			log.Printf("SubjectAccessReview for %s failed: %v", user.Username, err)
+			return "access review failed"
+		}
+		if !allowed {
+			return "denied by SubjectAccessReview: " + reason
+		}
+	}
+
+	return ""
+}
+
+// accessReviewer checks resource attributes with SubjectAccessReviews and
+// caches the results briefly
+type accessReviewer struct {
+	client kubernetes.Interface
+
+	mu      sync.Mutex
+	results map[string]accessReviewResult
+}
+
+type accessReviewResult struct {
+	allowed bool
+	reason  string
+	expires time.Time
+}
+
+var (
+	sharedAccessReviewer    *accessReviewer
+	sharedAccessReviewerErr error
+	sharedAccessReviewerMu  sync.Mutex
+)
+
+// reviewAccess checks whether user may perform the configured action
+func reviewAccess(user *providers.UserInfo, cfg *config.SubjectAccessReviewConfig) (bool, string, error) {
+	sharedAccessReviewerMu.Lock()
+	if sharedAccessReviewer == nil && sharedAccessReviewerErr == nil {
+		sharedAccessReviewer, sharedAccessReviewerErr = newAccessReviewer()
+	}
+	reviewer, err := sharedAccessReviewer, sharedAccessReviewerErr
+	sharedAccessReviewerMu.Unlock()
+	if err != nil {
+		return false, "", err
+	}
+
+	return reviewer.review(user, cfg)
+}
+
+func newAccessReviewer() (*accessReviewer, error) {
+	restConfig, err := kube.RestConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+
+	return &accessReviewer{
+		client:  client,
+		results: make(map[string]accessReviewResult),
+	}, nil
+}
+
+func (a *accessReviewer) review(user *providers.UserInfo, cfg *config.SubjectAccessReviewConfig) (bool, string, error) {
+	// Namespaces are DNS labels, names are single path segments
+	namespace, name := cfg.Namespace, cfg.Name
+	if strings.Contains(namespace, config.UserPlaceholder) {
+		if err := validateDNSLabelUsername(user.Username); err != nil {
+			return false, "", err
+		}
+		namespace = strings.ReplaceAll(namespace, config.UserPlaceholder, user.Username)
+	}
+	name, err := expandUserPath(name, user.Username)
+	if err != nil {
+		return false, "", err
+	}
+
+	attributes := &authorizationv1.ResourceAttributes{
+		Namespace:   namespace,
+		Verb:        cfg.Verb,
+		Group:       cfg.Group,
+		Resource:    cfg.Resource,
+		Subresource: cfg.Subresource,
+		Name:        name,
+	}
+	if attributes.Verb == "" {
+		attributes.Verb = "get"
+	}
+
+	key := strings.Join([]string{
+		user.Username, strings.Join(user.Groups, ","),
+		attributes.Namespace, attributes.Verb, attributes.Group, attributes.Resource, attributes.Subresource, attributes.Name,
+	}, "\x00")
+
+	a.mu.Lock()
+	if result, ok := a.results[key]; ok && time.Now().Before(result.expires) {
+		a.mu.Unlock()
+		return result.allowed, result.reason, nil
+	}
+	a.mu.Unlock()
+
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:               user.Username,
+			Groups:             user.Groups,
+			UID:                user.Sub,
+			ResourceAttributes: attributes,
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	review, err = a.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", fmt.Errorf("failed to create SubjectAccessReview: %w", err)
+	}
+
+	result := accessReviewResult{
+		allowed: review.Status.Allowed && !review.Status.Denied,
+		reason:  review.Status.Reason,
+		expires: time.Now().Add(accessReviewTTL),
+	}
+
+	a.mu.Lock()
+	if len(a.results) >= accessReviewMaxEntries {
+		a.results = make(map[string]accessReviewResult)
+	}
+	a.results[key] = result
+	a.mu.Unlock()
+
+	return result.allowed, result.reason, nil
+}
+
+var forbiddenPage = template.Must(template.New("forbidden").Parse(`<!DOCTYPE html>
+<html>
+<head><title>403 Forbidden</title></head>
+<body>
+<h1>Access denied</h1>
+<p>{{if .User}}You are signed in as <strong>{{.User}}</strong>, which{{else}}Your account{{end}} is not allowed to access <code>{{.Path}}</code>.</p>
+<p>Ask the owner of this resource for access, or <a href="/auth/logout">sign out</a> and sign in as a different user.</p>
+</body>
+</html>
+`))
+
+// writeForbidden renders the 403 page
+func writeForbidden(w http.ResponseWriter, r *http.Request, user *providers.UserInfo) {
+	data := struct{ User, Path string }{Path: r.URL.Path}
+	if user != nil {
+		data.User = user.Username
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	if err := forbiddenPage.Execute(w, data); err != nil {
+		log.Printf("Failed to render forbidden page: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jctanner/odh-gateway/internal/proxy/providers"
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+// fakeProvider accepts bearer tokens naming a user: "token-<username>"
+type fakeProvider struct{}
+
+func (fakeProvider) GetLoginURL(state, redirectURL string) string { return "/login" }
+
+func (fakeProvider) HandleCallback(w http.ResponseWriter, r *http.Request) (*providers.UserInfo, *providers.Tokens, error) {
+	return nil, nil, errors.New("not implemented")
+}
+
+func (fakeProvider) RefreshTokens(refreshToken string) (*providers.Tokens, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (fakeProvider) ValidateToken(token string) (*providers.UserInfo, error) {
+	if username, ok := strings.CutPrefix(token, "token-"); ok {
+		return &providers.UserInfo{Username: username, Groups: []string{"users"}}, nil
+	}
+	return nil, errors.New("invalid token")
+}
+
+func (fakeProvider) GetLogoutURL(redirectURL string) string { return "" }
+func (fakeProvider) IsEnabled() bool                        { return true }
+func (fakeProvider) Name() string                           { return "fake" }
+
+func TestOwnerCheck(t *testing.T) {
+	sessions, err := NewSessionManager(config.SessionConfig{Secret: "test"}, NewMemorySessionStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewAuthMiddleware(fakeProvider{}, sessions, nil)
+	handler := m.Middleware(config.Route{PathPrefix: "/notebooks/${user}/", Upstream: "http://notebooks"})(named("notebook"))
+
+	tests := []struct {
+		username string
+		path     string
+		want     int
+	}{
+		{"alice", "/notebooks/alice/", http.StatusOK},
+		{"alice", "/notebooks/alice/lab/tree", http.StatusOK},
+		{"alice", "/notebooks/bob/lab", http.StatusForbidden},
+		{"alice", "/notebooks/alice2/lab", http.StatusForbidden},
+		{"alice", "/notebooks/ALICE/lab", http.StatusForbidden},
+		{"kube:admin", "/notebooks/kube:admin/lab", http.StatusOK},
+		{"kube:admin", "/notebooks/kube/lab", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("Authorization", "Bearer token-"+tt.username)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.want {
+			t.Errorf("%s GET %s: got %d, want %d", tt.username, tt.path, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusForbidden && !strings.Contains(rec.Body.String(), "Access denied") {
+			t.Errorf("%s GET %s: expected the access denied page, got %q", tt.username, tt.path, rec.Body.String())
+		}
+	}
+
+	// Without a user the request is sent to log in instead
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/notebooks/alice/", nil))
+	if rec.Code != http.StatusFound {
+		t.Errorf("anonymous request: got %d, want a redirect to log in", rec.Code)
+	}
+}
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+// validateHeaderModifier rejects modifiers of the identity headers, which only
+// the auth middleware may set
+func validateHeaderModifier(m *config.HeaderModifier) error {
+	if m == nil {
+		return nil
+	}
+
+	var names []string
+	for _, header := range m.Set {
+		names = append(names, header.Name)
+	}
+	for _, header := range m.Add {
+		names = append(names, header.Name)
+	}
+	names = append(names, m.Remove...)
+
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		if name == "" {
+			return fmt.Errorf("header name is required")
+		}
+		if slices.Contains(identityHeaders, name) {
+			return fmt.Errorf("header %s is reserved for the gateway", name)
+		}
+	}
+	return nil
+}
+
+// applyHeaderModifier sets, adds and then removes headers
+func applyHeaderModifier(h http.Header, m *config.HeaderModifier) {
+	if m == nil {
+		return
+	}
+	for _, header := range m.Set {
+		h.Set(header.Name, header.Value)
+	}
+	for _, header := range m.Add {
+		h.Add(header.Name, header.Value)
+	}
+	for _, name := range m.Remove {
+		h.Del(name)
+	}
+}
+
+// withRequestHeaders modifies request headers before calling next
+func withRequestHeaders(m *config.HeaderModifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.Clone(r.Context())
+		applyHeaderModifier(r.Header, m)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withHost replaces the Host header sent upstream
+func withHost(host string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.Clone(r.Context())
+		r.Host = host
+		next.ServeHTTP(w, r)
+	})
+}
+
+// responseModifier returns the reverse proxy's ModifyResponse for a route,
+// nil if responses are passed through untouched
+func responseModifier(rewritePaths bool, headers *config.HeaderModifier) func(*http.Response) error {
+	if !rewritePaths && headers == nil {
+		return nil
+	}
+	return func(resp *http.Response) error {
+		if rewritePaths {
+			if err := rewriteResponse(resp); err != nil {
+				return err
+			}
+		}
+		applyHeaderModifier(resp.Header, headers)
+		return nil
+	}
+}
+
+// newRedirectHandler returns the handler answering a route with its redirect
+func newRedirectHandler(route config.Route) (http.Handler, error) {
+	rd := route.Redirect
+
+	code := rd.StatusCode
+	if code == 0 {
+		code = http.StatusFound
+	}
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, fmt.Errorf("unsupported redirect status code %d", code)
+	}
+
+	if rd.ReplacePath != "" && rd.ReplacePrefix != "" {
+		return nil, fmt.Errorf("redirect can't replace both the path and its prefix")
+	}
+	if rd.ReplacePrefix != "" && route.PathType != "" && route.PathType != config.PathTypePrefix {
+		return nil, fmt.Errorf("redirect replacePrefix requires pathType Prefix")
+	}
+	if rd.Scheme != "" && rd.Scheme != "http" && rd.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported redirect scheme %q", rd.Scheme)
+	}
+	matchedPrefix := strings.TrimSuffix(route.PathPrefix, "/")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme := requestScheme(r)
+		hostname, port, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			hostname, port = r.Host, ""
+		}
+
+		if rd.Scheme != "" && rd.Scheme != scheme {
+			// The request's port belongs to the old scheme
+			scheme, port = rd.Scheme, ""
+		}
+		if rd.Hostname != "" {
+			hostname = rd.Hostname
+		}
+		if rd.Port != 0 {
+			port = strconv.Itoa(rd.Port)
+		}
+		if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+			port = ""
+		}
+
+		u := url.URL{Scheme: scheme, Host: hostname, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		if port != "" {
+			u.Host = net.JoinHostPort(hostname, port)
+		}
+		switch {
+		case rd.ReplacePath != "":
+			u.Path = rd.ReplacePath
+		case rd.ReplacePrefix != "":
+			u.Path = strings.TrimSuffix(rd.ReplacePrefix, "/") + strings.TrimPrefix(r.URL.Path, matchedPrefix)
+			if u.Path == "" {
+				u.Path = "/"
+			}
+		}
+
+		http.Redirect(w, r, u.String(), code)
+	}), nil
+}
+
+// requestScheme returns the scheme the client used, trusting X-Forwarded-Proto
+// from a TLS terminating proxy in front of the gateway
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "https" || proto == "http" {
+		return proto
+	}
+	return "http"
+}
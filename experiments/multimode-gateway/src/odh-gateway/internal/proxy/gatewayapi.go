@@ -0,0 +1,554 @@
+package proxy
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+// The subset of the Gateway API v1 types the gateway source reads, decoded
+// from unstructured objects
+
+type gateway struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              gatewaySpec `json:"spec"`
+}
+
+type gatewaySpec struct {
+	GatewayClassName string            `json:"gatewayClassName"`
+	Listeners        []gatewayListener `json:"listeners"`
+}
+
+type gatewayListener struct {
+	Name          string         `json:"name"`
+	Hostname      *string        `json:"hostname,omitempty"`
+	Port          int32          `json:"port"`
+	Protocol      string         `json:"protocol"`
+	AllowedRoutes *allowedRoutes `json:"allowedRoutes,omitempty"`
+}
+
+type allowedRoutes struct {
+	Namespaces *routeNamespaces `json:"namespaces,omitempty"`
+	Kinds      []routeGroupKind `json:"kinds,omitempty"`
+}
+
+type routeNamespaces struct {
+	From *string `json:"from,omitempty"`
+}
+
+type routeGroupKind struct {
+	Group *string `json:"group,omitempty"`
+	Kind  string  `json:"kind"`
+}
+
+type httpRoute struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              httpRouteSpec   `json:"spec"`
+	Status            httpRouteStatus `json:"status"`
+}
+
+type httpRouteSpec struct {
+	ParentRefs []parentReference `json:"parentRefs,omitempty"`
+	Hostnames  []string          `json:"hostnames,omitempty"`
+	Rules      []httpRouteRule   `json:"rules,omitempty"`
+}
+
+// parentReference keeps unset fields unset, it's echoed back in the status
+type parentReference struct {
+	Group       *string `json:"group,omitempty"`
+	Kind        *string `json:"kind,omitempty"`
+	Namespace   *string `json:"namespace,omitempty"`
+	Name        string  `json:"name"`
+	SectionName *string `json:"sectionName,omitempty"`
+	Port        *int32  `json:"port,omitempty"`
+}
+
+type httpRouteRule struct {
+	Matches     []httpRouteMatch  `json:"matches,omitempty"`
+	Filters     []httpRouteFilter `json:"filters,omitempty"`
+	BackendRefs []httpBackendRef  `json:"backendRefs,omitempty"`
+}
+
+type httpRouteMatch struct {
+	Path        *httpPathMatch   `json:"path,omitempty"`
+	Headers     []httpValueMatch `json:"headers,omitempty"`
+	QueryParams []httpValueMatch `json:"queryParams,omitempty"`
+	Method      *string          `json:"method,omitempty"`
+}
+
+type httpPathMatch struct {
+	Type  *string `json:"type,omitempty"`
+	Value *string `json:"value,omitempty"`
+}
+
+type httpValueMatch struct {
+	Type  *string `json:"type,omitempty"`
+	Name  string  `json:"name"`
+	Value string  `json:"value"`
+}
+
+type httpRouteFilter struct {
+	Type                   string               `json:"type"`
+	RequestHeaderModifier  *httpHeaderFilter    `json:"requestHeaderModifier,omitempty"`
+	ResponseHeaderModifier *httpHeaderFilter    `json:"responseHeaderModifier,omitempty"`
+	URLRewrite             *httpURLRewrite      `json:"urlRewrite,omitempty"`
+	RequestRedirect        *httpRequestRedirect `json:"requestRedirect,omitempty"`
+}
+
+type httpHeaderFilter struct {
+	Set    []httpHeader `json:"set,omitempty"`
+	Add    []httpHeader `json:"add,omitempty"`
+	Remove []string     `json:"remove,omitempty"`
+}
+
+type httpHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type httpURLRewrite struct {
+	Hostname *string           `json:"hostname,omitempty"`
+	Path     *httpPathModifier `json:"path,omitempty"`
+}
+
+type httpPathModifier struct {
+	Type               string  `json:"type"`
+	ReplaceFullPath    *string `json:"replaceFullPath,omitempty"`
+	ReplacePrefixMatch *string `json:"replacePrefixMatch,omitempty"`
+}
+
+type httpRequestRedirect struct {
+	Scheme     *string           `json:"scheme,omitempty"`
+	Hostname   *string           `json:"hostname,omitempty"`
+	Path       *httpPathModifier `json:"path,omitempty"`
+	Port       *int32            `json:"port,omitempty"`
+	StatusCode *int              `json:"statusCode,omitempty"`
+}
+
+type httpBackendRef struct {
+	Group     *string           `json:"group,omitempty"`
+	Kind      *string           `json:"kind,omitempty"`
+	Name      string            `json:"name"`
+	Namespace *string           `json:"namespace,omitempty"`
+	Port      *int32            `json:"port,omitempty"`
+	Weight    *int32            `json:"weight,omitempty"`
+	Filters   []httpRouteFilter `json:"filters,omitempty"`
+}
+
+type httpRouteStatus struct {
+	Parents []routeParentStatus `json:"parents,omitempty"`
+}
+
+type routeParentStatus struct {
+	ParentRef      parentReference    `json:"parentRef"`
+	ControllerName string             `json:"controllerName"`
+	Conditions     []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	gatewayAPIGroup = "gateway.networking.k8s.io"
+
+	// Route condition types and reasons
+	conditionAccepted            = "Accepted"
+	conditionResolvedRefs        = "ResolvedRefs"
+	reasonAccepted               = "Accepted"
+	reasonResolvedRefs           = "ResolvedRefs"
+	reasonNotAllowedByListeners  = "NotAllowedByListeners"
+	reasonNoMatchingListenerHost = "NoMatchingListenerHostname"
+	reasonNoMatchingParent       = "NoMatchingParent"
+	reasonUnsupportedValue       = "UnsupportedValue"
+	reasonBackendNotFound        = "BackendNotFound"
+	reasonInvalidKind            = "InvalidKind"
+	reasonRefNotPermitted        = "RefNotPermitted"
+)
+
+// valueOr dereferences p, returning def if p is nil
+func valueOr[T any](p *T, def T) T {
+	if p == nil {
+		return def
+	}
+	return *p
+}
+
+// translateHTTPRoute translates an HTTPRoute into gateway routes. It returns
+// the status of each parentRef naming one of gateways, which only holds
+// Gateways of the configured class. Routes not attached to any of them
+// translate to nothing.
+func translateHTTPRoute(route *httpRoute, gateways map[types.NamespacedName]*gateway, lookupService func(namespace, name string) *corev1.Service) ([]config.Route, []routeParentStatus) {
+	var parents []routeParentStatus
+	var hosts []string
+	accepted, anyHost := false, false
+
+	for _, ref := range route.Spec.ParentRefs {
+		if valueOr(ref.Group, gatewayAPIGroup) != gatewayAPIGroup || valueOr(ref.Kind, "Gateway") != "Gateway" {
+			continue
+		}
+		gw := gateways[types.NamespacedName{Namespace: valueOr(ref.Namespace, route.Namespace), Name: ref.Name}]
+		if gw == nil {
+			continue
+		}
+
+		condition, refHosts, refAnyHost := attachRoute(route, ref, gw)
+		if condition.Status == metav1.ConditionTrue {
+			accepted = true
+			anyHost = anyHost || refAnyHost
+			for _, host := range refHosts {
+				if !slices.Contains(hosts, host) {
+					hosts = append(hosts, host)
+				}
+			}
+		}
+		parents = append(parents, routeParentStatus{
+			ParentRef:      ref,
+			ControllerName: gatewayControllerName,
+			Conditions:     []metav1.Condition{condition},
+		})
+	}
+	if len(parents) == 0 {
+		return nil, nil
+	}
+
+	// Hosts of a listener without hostname win over the others
+	if anyHost {
+		hosts = nil
+	}
+	routes, resolved, err := translateRules(route, hosts, lookupService)
+	if err != nil {
+		// Invalid rules make the route unacceptable to every parent
+		for i := range parents {
+			parents[i].Conditions[0] = routeCondition(conditionAccepted, false, reasonUnsupportedValue, err.Error())
+		}
+		accepted = false
+	}
+	for i := range parents {
+		parents[i].Conditions = append(parents[i].Conditions, resolved)
+	}
+
+	if !accepted {
+		return nil, parents
+	}
+	return routes, parents
+}
+
+// attachRoute checks whether a Gateway accepts a route through a parentRef
+// and returns the hostnames the route serves through it. anyHost is true if
+// it serves every hostname.
+func attachRoute(route *httpRoute, ref parentReference, gw *gateway) (condition metav1.Condition, hosts []string, anyHost bool) {
+	var listeners []gatewayListener
+	for _, listener := range gw.Spec.Listeners {
+		if ref.SectionName != nil && *ref.SectionName != listener.Name {
+			continue
+		}
+		if ref.Port != nil && *ref.Port != listener.Port {
+			continue
+		}
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		return routeCondition(conditionAccepted, false, reasonNoMatchingParent, "no listener matches the parentRef's sectionName and port"), nil, false
+	}
+
+	allowed := false
+	for _, listener := range listeners {
+		if !listenerAllowsRoute(listener, route, gw) {
+			continue
+		}
+		allowed = true
+
+		listenerHosts, listenerAnyHost := intersectHostnames(valueOr(listener.Hostname, ""), route.Spec.Hostnames)
+		anyHost = anyHost || listenerAnyHost
+		for _, host := range listenerHosts {
+			if !slices.Contains(hosts, host) {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	if !allowed {
+		return routeCondition(conditionAccepted, false, reasonNotAllowedByListeners, "no listener allows HTTPRoutes from this namespace"), nil, false
+	}
+	if !anyHost && len(hosts) == 0 {
+		return routeCondition(conditionAccepted, false, reasonNoMatchingListenerHost, "no listener hostname matches the route's hostnames"), nil, false
+	}
+	return routeCondition(conditionAccepted, true, reasonAccepted, "Route is accepted"), hosts, anyHost
+}
+
+// listenerAllowsRoute checks a listener's protocol and allowedRoutes. Namespace
+// selectors aren't supported and allow no routes.
+func listenerAllowsRoute(listener gatewayListener, route *httpRoute, gw *gateway) bool {
+	if listener.Protocol != "HTTP" && listener.Protocol != "HTTPS" {
+		return false
+	}
+
+	from := "Same"
+	var kinds []routeGroupKind
+	if listener.AllowedRoutes != nil {
+		if listener.AllowedRoutes.Namespaces != nil {
+			from = valueOr(listener.AllowedRoutes.Namespaces.From, from)
+		}
+		kinds = listener.AllowedRoutes.Kinds
+	}
+
+	switch from {
+	case "All":
+	case "Same":
+		if route.Namespace != gw.Namespace {
+			return false
+		}
+	default:
+		return false
+	}
+
+	return len(kinds) == 0 || slices.ContainsFunc(kinds, func(kind routeGroupKind) bool {
+		return valueOr(kind.Group, gatewayAPIGroup) == gatewayAPIGroup && kind.Kind == "HTTPRoute"
+	})
+}
+
+// intersectHostnames returns the hostnames matched by both a listener and a
+// route. anyHost is true if neither restricts the hostname.
+func intersectHostnames(listenerHost string, routeHosts []string) (hosts []string, anyHost bool) {
+	listenerHost = strings.ToLower(listenerHost)
+	if listenerHost == "" {
+		if len(routeHosts) == 0 {
+			return nil, true
+		}
+		for _, host := range routeHosts {
+			hosts = append(hosts, strings.ToLower(host))
+		}
+		return hosts, false
+	}
+	if len(routeHosts) == 0 {
+		return []string{listenerHost}, false
+	}
+
+	for _, host := range routeHosts {
+		host = strings.ToLower(host)
+		switch {
+		case host == listenerHost || matchHost(listenerHost, host):
+			hosts = append(hosts, host)
+		case matchHost(host, listenerHost):
+			hosts = append(hosts, listenerHost)
+		}
+	}
+	return hosts, false
+}
+
+// translateRules translates the rules of a route served on hosts, nil for any
+// host. resolved is the route's ResolvedRefs condition. Unresolved backends
+// are kept with an empty URL so their share of requests fails.
+func translateRules(route *httpRoute, hosts []string, lookupService func(namespace, name string) *corev1.Service) (routes []config.Route, resolved metav1.Condition, err error) {
+	resolved = routeCondition(conditionResolvedRefs, true, reasonResolvedRefs, "All references are resolved")
+	authRequired := authRequiredFromAnnotations(route.Annotations)
+
+	for i, rule := range route.Spec.Rules {
+		base := config.Route{Hosts: hosts, AuthRequired: authRequired}
+
+		var upstreams []config.WeightedUpstream
+		for _, ref := range rule.BackendRefs {
+			if len(ref.Filters) > 0 {
+				return nil, resolved, fmt.Errorf("rule %d: backendRef filters are not supported", i)
+			}
+
+			weight := int(valueOr(ref.Weight, 1))
+			upstream, reason, message := resolveBackend(route.Namespace, ref, lookupService)
+			if reason != "" && resolved.Status == metav1.ConditionTrue {
+				resolved = routeCondition(conditionResolvedRefs, false, reason, message)
+			}
+			upstreams = append(upstreams, config.WeightedUpstream{URL: upstream, Weight: &weight})
+		}
+		base.Upstreams = upstreams
+
+		matches := rule.Matches
+		if len(matches) == 0 {
+			matches = []httpRouteMatch{{}}
+		}
+		for _, match := range matches {
+			r, err := translateMatch(base, match)
+			if err != nil {
+				return nil, resolved, fmt.Errorf("rule %d: %w", i, err)
+			}
+			if err := translateFilters(&r, rule.Filters); err != nil {
+				return nil, resolved, fmt.Errorf("rule %d: %w", i, err)
+			}
+
+			// Catch what the router would reject so it shows in the status
+			if err := validateRoute(r); err != nil {
+				return nil, resolved, fmt.Errorf("rule %d: %w", i, err)
+			}
+			if err := NewRouter().Add(r, nil); err != nil {
+				return nil, resolved, fmt.Errorf("rule %d: %w", i, err)
+			}
+			routes = append(routes, r)
+		}
+	}
+	return routes, resolved, nil
+}
+
+// resolveBackend returns the URL of a backendRef, or the reason and message
+// of the ResolvedRefs condition if it can't be resolved
+func resolveBackend(namespace string, ref httpBackendRef, lookupService func(namespace, name string) *corev1.Service) (upstream, reason, message string) {
+	if valueOr(ref.Group, "") != "" || valueOr(ref.Kind, "Service") != "Service" {
+		return "", reasonInvalidKind, fmt.Sprintf("backendRef %s is not a Service", ref.Name)
+	}
+	if ref.Namespace != nil && *ref.Namespace != namespace {
+		return "", reasonRefNotPermitted, fmt.Sprintf("backendRef %s/%s is in another namespace", *ref.Namespace, ref.Name)
+	}
+	if ref.Port == nil {
+		return "", reasonUnsupportedValue, fmt.Sprintf("backendRef %s has no port", ref.Name)
+	}
+
+	svc := lookupService(namespace, ref.Name)
+	if svc == nil {
+		return "", reasonBackendNotFound, fmt.Sprintf("Service %s not found", ref.Name)
+	}
+	if !slices.ContainsFunc(svc.Spec.Ports, func(p corev1.ServicePort) bool { return p.Port == *ref.Port }) {
+		return "", reasonBackendNotFound, fmt.Sprintf("Service %s has no port %d", ref.Name, *ref.Port)
+	}
+	return serviceURL(namespace, ref.Name, *ref.Port), "", ""
+}
+
+// translateMatch applies an HTTPRoute match to a copy of base
+func translateMatch(base config.Route, match httpRouteMatch) (config.Route, error) {
+	r := base
+	r.PathType = config.PathTypePrefix
+	r.PathPrefix = "/"
+	if match.Path != nil {
+		r.PathPrefix = valueOr(match.Path.Value, "/")
+		switch pathType := valueOr(match.Path.Type, "PathPrefix"); pathType {
+		case "PathPrefix":
+		case "Exact":
+			r.PathType = config.PathTypeExact
+		case "RegularExpression":
+			r.PathType = config.PathTypeRegex
+		default:
+			return r, fmt.Errorf("unsupported path match type %q", pathType)
+		}
+	}
+
+	var err error
+	if r.Headers, err = translateValueMatches(match.Headers); err != nil {
+		return r, fmt.Errorf("header match: %w", err)
+	}
+	if r.QueryParams, err = translateValueMatches(match.QueryParams); err != nil {
+		return r, fmt.Errorf("query parameter match: %w", err)
+	}
+	if match.Method != nil {
+		r.Methods = []string{*match.Method}
+	}
+	return r, nil
+}
+
+func translateValueMatches(matches []httpValueMatch) ([]config.ValueMatcher, error) {
+	var matchers []config.ValueMatcher
+	for _, m := range matches {
+		matcher := config.ValueMatcher{Name: m.Name, Value: m.Value}
+		switch matchType := valueOr(m.Type, "Exact"); matchType {
+		case "Exact":
+			matcher.Type = config.MatchExact
+		case "RegularExpression":
+			matcher.Type = config.MatchRegex
+		default:
+			return nil, fmt.Errorf("%s: unsupported type %q", m.Name, matchType)
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
+
+// translateFilters applies a rule's filters to a route
+func translateFilters(r *config.Route, filters []httpRouteFilter) error {
+	for _, filter := range filters {
+		switch filter.Type {
+		case "RequestHeaderModifier":
+			r.RequestHeaders = translateHeaderFilter(filter.RequestHeaderModifier)
+
+		case "ResponseHeaderModifier":
+			r.ResponseHeaders = translateHeaderFilter(filter.ResponseHeaderModifier)
+
+		case "URLRewrite":
+			if filter.URLRewrite == nil {
+				return fmt.Errorf("URLRewrite filter has no urlRewrite")
+			}
+			r.RewriteHost = valueOr(filter.URLRewrite.Hostname, "")
+			if path := filter.URLRewrite.Path; path != nil {
+				switch path.Type {
+				case "ReplaceFullPath":
+					// Replace the whole path, escaping $ so it isn't read as a capture group
+					r.RewriteRegex = &config.RegexRewrite{
+						Pattern:     "^.*$",
+						Replacement: strings.ReplaceAll(valueOr(path.ReplaceFullPath, "/"), "$", "$$"),
+					}
+				case "ReplacePrefixMatch":
+					r.RewritePrefix = valueOr(path.ReplacePrefixMatch, "/")
+					if r.RewritePrefix == "" {
+						r.RewritePrefix = "/"
+					}
+				default:
+					return fmt.Errorf("unsupported URLRewrite path type %q", path.Type)
+				}
+			}
+
+		case "RequestRedirect":
+			rd := filter.RequestRedirect
+			if rd == nil {
+				return fmt.Errorf("RequestRedirect filter has no requestRedirect")
+			}
+			r.Redirect = &config.Redirect{
+				Scheme:     valueOr(rd.Scheme, ""),
+				Hostname:   valueOr(rd.Hostname, ""),
+				Port:       int(valueOr(rd.Port, 0)),
+				StatusCode: valueOr(rd.StatusCode, 0),
+			}
+			if path := rd.Path; path != nil {
+				switch path.Type {
+				case "ReplaceFullPath":
+					r.Redirect.ReplacePath = valueOr(path.ReplaceFullPath, "/")
+				case "ReplacePrefixMatch":
+					r.Redirect.ReplacePrefix = valueOr(path.ReplacePrefixMatch, "/")
+					if r.Redirect.ReplacePrefix == "" {
+						r.Redirect.ReplacePrefix = "/"
+					}
+				default:
+					return fmt.Errorf("unsupported RequestRedirect path type %q", path.Type)
+				}
+			}
+
+		default:
+			return fmt.Errorf("unsupported filter type %q", filter.Type)
+		}
+	}
+
+	if r.Redirect != nil && (r.RewriteHost != "" || r.RewritePrefix != "" || r.RewriteRegex != nil) {
+		return fmt.Errorf("RequestRedirect can't be combined with URLRewrite")
+	}
+	return nil
+}
+
+func translateHeaderFilter(filter *httpHeaderFilter) *config.HeaderModifier {
+	if filter == nil {
+		return nil
+	}
+	m := &config.HeaderModifier{Remove: filter.Remove}
+	for _, header := range filter.Set {
+		m.Set = append(m.Set, config.HeaderValue{Name: header.Name, Value: header.Value})
+	}
+	for _, header := range filter.Add {
+		m.Add = append(m.Add, config.HeaderValue{Name: header.Name, Value: header.Value})
+	}
+	return m
+}
+
+// routeCondition returns a route condition, its transition time and
+// generation are set when the status is written
+func routeCondition(conditionType string, status bool, reason, message string) metav1.Condition {
+	condition := metav1.Condition{Type: conditionType, Status: metav1.ConditionFalse, Reason: reason, Message: message}
+	if status {
+		condition.Status = metav1.ConditionTrue
+	}
+	return condition
+}
@@ -0,0 +1,279 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+// testGateways holds a single Gateway with one HTTP listener for any host
+func testGateways(t *testing.T) map[types.NamespacedName]*gateway {
+	gw := &gateway{}
+	if !decodeUnstructured(&unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "Gateway",
+		"metadata":   map[string]interface{}{"namespace": "opendatahub", "name": "odh"},
+		"spec": map[string]interface{}{
+			"gatewayClassName": "odh",
+			"listeners": []interface{}{
+				map[string]interface{}{"name": "http", "port": int64(80), "protocol": "HTTP"},
+			},
+		},
+	}}, gw) {
+		t.Fatal("failed to decode the Gateway")
+	}
+	return map[types.NamespacedName]*gateway{{Namespace: "opendatahub", Name: "odh"}: gw}
+}
+
+// testHTTPRoute decodes an HTTPRoute attached to the test Gateway
+func testHTTPRoute(t *testing.T, rules ...interface{}) *httpRoute {
+	route := &httpRoute{}
+	if !decodeUnstructured(&unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "HTTPRoute",
+		"metadata":   map[string]interface{}{"namespace": "opendatahub", "name": "route"},
+		"spec": map[string]interface{}{
+			"parentRefs": []interface{}{map[string]interface{}{"name": "odh"}},
+			"rules":      rules,
+		},
+	}}, route) {
+		t.Fatal("failed to decode the HTTPRoute")
+	}
+	return route
+}
+
+func lookupTestService(namespace, name string) *corev1.Service {
+	ports := map[string]int32{"dashboard": 8080, "mlflow": 5000}
+	port, ok := ports[name]
+	if !ok {
+		return nil
+	}
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: port}}},
+	}
+}
+
+func backendRef(name string, port int64) map[string]interface{} {
+	return map[string]interface{}{"name": name, "port": port}
+}
+
+func weightedUpstreams(upstreams ...interface{}) []config.WeightedUpstream {
+	var weighted []config.WeightedUpstream
+	for i := 0; i < len(upstreams); i += 2 {
+		weight := upstreams[i+1].(int)
+		weighted = append(weighted, config.WeightedUpstream{URL: upstreams[i].(string), Weight: &weight})
+	}
+	return weighted
+}
+
+func TestTranslateHTTPRoute(t *testing.T) {
+	const (
+		dashboard = "http://dashboard.opendatahub.svc.cluster.local:8080"
+		mlflow    = "http://mlflow.opendatahub.svc.cluster.local:5000"
+	)
+
+	tests := []struct {
+		name string
+		rule map[string]interface{}
+		want []config.Route
+	}{
+		{
+			name: "no match",
+			rule: map[string]interface{}{"backendRefs": []interface{}{backendRef("dashboard", 8080)}},
+			want: []config.Route{{PathPrefix: "/", PathType: config.PathTypePrefix, Upstreams: weightedUpstreams(dashboard, 1)}},
+		},
+		{
+			name: "path prefix",
+			rule: map[string]interface{}{
+				"matches":     []interface{}{map[string]interface{}{"path": map[string]interface{}{"type": "PathPrefix", "value": "/mlflow"}}},
+				"backendRefs": []interface{}{backendRef("mlflow", 5000)},
+			},
+			want: []config.Route{{PathPrefix: "/mlflow", PathType: config.PathTypePrefix, Upstreams: weightedUpstreams(mlflow, 1)}},
+		},
+		{
+			name: "exact and regular expression paths",
+			rule: map[string]interface{}{
+				"matches": []interface{}{
+					map[string]interface{}{"path": map[string]interface{}{"type": "Exact", "value": "/healthz"}},
+					map[string]interface{}{"path": map[string]interface{}{"type": "RegularExpression", "value": "^/api/v[0-9]+/"}},
+				},
+				"backendRefs": []interface{}{backendRef("dashboard", 8080)},
+			},
+			want: []config.Route{
+				{PathPrefix: "/healthz", PathType: config.PathTypeExact, Upstreams: weightedUpstreams(dashboard, 1)},
+				{PathPrefix: "^/api/v[0-9]+/", PathType: config.PathTypeRegex, Upstreams: weightedUpstreams(dashboard, 1)},
+			},
+		},
+		{
+			name: "header, query and method matches",
+			rule: map[string]interface{}{
+				"matches": []interface{}{map[string]interface{}{
+					"headers": []interface{}{
+						map[string]interface{}{"name": "X-Canary", "value": "true"},
+						map[string]interface{}{"type": "RegularExpression", "name": "User-Agent", "value": "^curl/"},
+					},
+					"queryParams": []interface{}{map[string]interface{}{"type": "Exact", "name": "version", "value": "2"}},
+					"method":      "POST",
+				}},
+				"backendRefs": []interface{}{backendRef("dashboard", 8080)},
+			},
+			want: []config.Route{{
+				PathPrefix: "/",
+				PathType:   config.PathTypePrefix,
+				Headers: []config.ValueMatcher{
+					{Name: "X-Canary", Value: "true", Type: config.MatchExact},
+					{Name: "User-Agent", Value: "^curl/", Type: config.MatchRegex},
+				},
+				QueryParams: []config.ValueMatcher{{Name: "version", Value: "2", Type: config.MatchExact}},
+				Methods:     []string{"POST"},
+				Upstreams:   weightedUpstreams(dashboard, 1),
+			}},
+		},
+		{
+			name: "weighted backends",
+			rule: map[string]interface{}{
+				"backendRefs": []interface{}{
+					map[string]interface{}{"name": "dashboard", "port": int64(8080), "weight": int64(90)},
+					map[string]interface{}{"name": "mlflow", "port": int64(5000), "weight": int64(10)},
+					map[string]interface{}{"name": "mlflow", "port": int64(5000), "weight": int64(0)},
+				},
+			},
+			want: []config.Route{{PathPrefix: "/", PathType: config.PathTypePrefix, Upstreams: weightedUpstreams(dashboard, 90, mlflow, 10, mlflow, 0)}},
+		},
+		{
+			name: "prefix rewrite",
+			rule: map[string]interface{}{
+				"matches": []interface{}{map[string]interface{}{"path": map[string]interface{}{"value": "/mlflow/"}}},
+				"filters": []interface{}{map[string]interface{}{
+					"type":       "URLRewrite",
+					"urlRewrite": map[string]interface{}{"path": map[string]interface{}{"type": "ReplacePrefixMatch", "replacePrefixMatch": ""}},
+				}},
+				"backendRefs": []interface{}{backendRef("mlflow", 5000)},
+			},
+			want: []config.Route{{PathPrefix: "/mlflow/", PathType: config.PathTypePrefix, RewritePrefix: "/", Upstreams: weightedUpstreams(mlflow, 1)}},
+		},
+	}
+
+	for _, tt := range tests {
+		routes, parents := translateHTTPRoute(testHTTPRoute(t, tt.rule), testGateways(t), lookupTestService)
+
+		if len(parents) != 1 || len(parents[0].Conditions) != 2 {
+			t.Fatalf("%s: got parents %+v, want one with Accepted and ResolvedRefs", tt.name, parents)
+		}
+		for _, condition := range parents[0].Conditions {
+			if condition.Status != metav1.ConditionTrue {
+				t.Errorf("%s: %s is %s: %s", tt.name, condition.Type, condition.Status, condition.Message)
+			}
+		}
+		if !reflect.DeepEqual(routes, tt.want) {
+			t.Errorf("%s: got routes\n%+v\nwant\n%+v", tt.name, routes, tt.want)
+		}
+	}
+}
+
+func TestTranslateHTTPRouteInvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule map[string]interface{}
+	}{
+		{
+			name: "unsupported path match type",
+			rule: map[string]interface{}{
+				"matches":     []interface{}{map[string]interface{}{"path": map[string]interface{}{"type": "Suffix", "value": "/x"}}},
+				"backendRefs": []interface{}{backendRef("dashboard", 8080)},
+			},
+		},
+		{
+			name: "invalid regular expression",
+			rule: map[string]interface{}{
+				"matches":     []interface{}{map[string]interface{}{"path": map[string]interface{}{"type": "RegularExpression", "value": "^/api/(v1"}}},
+				"backendRefs": []interface{}{backendRef("dashboard", 8080)},
+			},
+		},
+		{
+			name: "unsupported header match type",
+			rule: map[string]interface{}{
+				"matches":     []interface{}{map[string]interface{}{"headers": []interface{}{map[string]interface{}{"type": "Prefix", "name": "X-Canary", "value": "t"}}}},
+				"backendRefs": []interface{}{backendRef("dashboard", 8080)},
+			},
+		},
+		{
+			name: "backendRef filters",
+			rule: map[string]interface{}{
+				"backendRefs": []interface{}{map[string]interface{}{
+					"name": "dashboard", "port": int64(8080),
+					"filters": []interface{}{map[string]interface{}{"type": "RequestHeaderModifier"}},
+				}},
+			},
+		},
+		{
+			name: "redirect with rewrite",
+			rule: map[string]interface{}{
+				"filters": []interface{}{
+					map[string]interface{}{"type": "RequestRedirect", "requestRedirect": map[string]interface{}{"scheme": "https"}},
+					map[string]interface{}{"type": "URLRewrite", "urlRewrite": map[string]interface{}{"hostname": "example.com"}},
+				},
+			},
+		},
+	}
+
+	valid := map[string]interface{}{"backendRefs": []interface{}{backendRef("dashboard", 8080)}}
+	for _, tt := range tests {
+		// One invalid rule rejects the whole route
+		routes, parents := translateHTTPRoute(testHTTPRoute(t, valid, tt.rule), testGateways(t), lookupTestService)
+
+		if len(routes) != 0 {
+			t.Errorf("%s: got routes %+v, want none", tt.name, routes)
+		}
+		if len(parents) != 1 {
+			t.Fatalf("%s: got parents %+v, want one", tt.name, parents)
+		}
+		accepted := parents[0].Conditions[0]
+		if accepted.Type != conditionAccepted || accepted.Status != metav1.ConditionFalse || accepted.Reason != reasonUnsupportedValue {
+			t.Errorf("%s: got condition %+v, want Accepted False with reason %s", tt.name, accepted, reasonUnsupportedValue)
+		}
+		if accepted.Message == "" {
+			t.Errorf("%s: the condition doesn't say what's wrong", tt.name)
+		}
+	}
+}
+
+func TestTranslateHTTPRouteUnresolvedBackends(t *testing.T) {
+	tests := []struct {
+		ref    map[string]interface{}
+		reason string
+	}{
+		{backendRef("missing", 8080), reasonBackendNotFound},
+		{backendRef("dashboard", 9999), reasonBackendNotFound},
+		{map[string]interface{}{"name": "dashboard", "port": int64(8080), "namespace": "other"}, reasonRefNotPermitted},
+		{map[string]interface{}{"name": "dashboard", "port": int64(8080), "kind": "ServiceImport", "group": "multicluster.x-k8s.io"}, reasonInvalidKind},
+		{map[string]interface{}{"name": "dashboard"}, reasonUnsupportedValue},
+	}
+
+	for _, tt := range tests {
+		rule := map[string]interface{}{"backendRefs": []interface{}{backendRef("mlflow", 5000), tt.ref}}
+		routes, parents := translateHTTPRoute(testHTTPRoute(t, rule), testGateways(t), lookupTestService)
+
+		// The route is still served, the unresolved backend's share fails
+		if len(routes) != 1 || len(routes[0].Upstreams) != 2 || routes[0].Upstreams[1].URL != "" {
+			t.Errorf("%v: got routes %+v, want one with an empty upstream URL", tt.ref, routes)
+		}
+		if len(parents) != 1 || len(parents[0].Conditions) != 2 {
+			t.Fatalf("%v: got parents %+v", tt.ref, parents)
+		}
+		if accepted := parents[0].Conditions[0]; accepted.Status != metav1.ConditionTrue {
+			t.Errorf("%v: route not accepted: %s", tt.ref, accepted.Message)
+		}
+		resolved := parents[0].Conditions[1]
+		if resolved.Type != conditionResolvedRefs || resolved.Status != metav1.ConditionFalse || resolved.Reason != tt.reason {
+			t.Errorf("%v: got condition %+v, want ResolvedRefs False with reason %s", tt.ref, resolved, tt.reason)
+		}
+	}
+}
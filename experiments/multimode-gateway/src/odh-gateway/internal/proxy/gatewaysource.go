@@ -0,0 +1,321 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/jctanner/odh-gateway/internal/kube"
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+// gatewayControllerName identifies the gateway in HTTPRoute status
+const gatewayControllerName = "opendatahub.io/odh-gateway"
+
+var (
+	gatewaysResource   = schema.GroupVersionResource{Group: gatewayAPIGroup, Version: "v1", Resource: "gateways"}
+	httpRoutesResource = schema.GroupVersionResource{Group: gatewayAPIGroup, Version: "v1", Resource: "httproutes"}
+)
+
+// GatewaySource routes HTTPRoutes attached to Gateways of the configured
+// GatewayClass and reports their status
+type GatewaySource struct {
+	className  string
+	client     dynamic.Interface
+	gateways   cache.Store
+	routes     cache.Store
+	services   cache.Store
+	namespaces *namespaceFilter
+	synced     []cache.InformerSynced
+	notify     func()
+
+	mu      sync.RWMutex
+	current []config.Route
+}
+
+// StartGatewaySource starts watching Gateways, HTTPRoutes and Services and
+// calls onChange, debounced, after the routes have been translated again
+func StartGatewaySource(cfg config.KubernetesSourceConfig, onChange func()) (*GatewaySource, error) {
+	restConfig, err := kube.RestConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+
+	s := &GatewaySource{className: cfg.GatewayClassName, client: client}
+	s.notify = debounce(func() {
+		if s.sync() {
+			onChange()
+		}
+	})
+
+	stop := make(chan struct{})
+	namespaces, namespacesSynced, err := startNamespaceFilter(clientset, cfg.NamespaceSelector, s.notify, stop)
+	if err != nil {
+		return nil, err
+	}
+	s.namespaces = namespaces
+
+	// Status writes don't change the generation, so they don't trigger a resync
+	specChanged := func(oldObj, obj interface{}) bool {
+		oldMeta, ok1 := oldObj.(metav1.Object)
+		meta, ok2 := obj.(metav1.Object)
+		return !ok1 || !ok2 || oldMeta.GetGeneration() != meta.GetGeneration() ||
+			!reflect.DeepEqual(oldMeta.GetAnnotations(), meta.GetAnnotations())
+	}
+
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, 0)
+	gatewayInformer := factory.ForResource(gatewaysResource).Informer()
+	routeInformer := factory.ForResource(httpRoutesResource).Informer()
+	for _, informer := range []cache.SharedIndexInformer{gatewayInformer, routeInformer} {
+		_, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(interface{}) { s.notify() },
+			UpdateFunc: func(oldObj, obj interface{}) {
+				if specChanged(oldObj, obj) {
+					s.notify()
+				}
+			},
+			DeleteFunc: func(interface{}) { s.notify() },
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to add Gateway API event handler: %w", err)
+		}
+	}
+
+	serviceFactory := informers.NewSharedInformerFactory(clientset, 0)
+	serviceInformer := serviceFactory.Core().V1().Services().Informer()
+	_, err = serviceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) { s.notify() },
+		UpdateFunc: func(oldObj, obj interface{}) {
+			oldSvc, ok1 := oldObj.(*corev1.Service)
+			svc, ok2 := obj.(*corev1.Service)
+			if !ok1 || !ok2 || !reflect.DeepEqual(oldSvc.Spec.Ports, svc.Spec.Ports) {
+				s.notify()
+			}
+		},
+		DeleteFunc: func(interface{}) { s.notify() },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add service event handler: %w", err)
+	}
+
+	factory.Start(stop)
+	serviceFactory.Start(stop)
+
+	s.gateways = gatewayInformer.GetStore()
+	s.routes = routeInformer.GetStore()
+	s.services = serviceInformer.GetStore()
+	s.synced = append(namespacesSynced, gatewayInformer.HasSynced, routeInformer.HasSynced, serviceInformer.HasSynced)
+
+	go func() {
+		if !cache.WaitForCacheSync(stop, s.synced...) {
+			return
+		}
+		log.Printf("Gateway API source synced")
+		s.notify()
+	}()
+
+	log.Printf("Started Gateway API source for GatewayClass %s", cfg.GatewayClassName)
+	return s, nil
+}
+
+// Routes returns the routes translated from HTTPRoutes
+func (s *GatewaySource) Routes() []config.Route {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// sync translates the HTTPRoutes and writes their status. It returns false
+// until the informers have synced.
+func (s *GatewaySource) sync() bool {
+	for _, synced := range s.synced {
+		if !synced() {
+			return false
+		}
+	}
+
+	gateways := make(map[types.NamespacedName]*gateway)
+	for _, obj := range s.gateways.List() {
+		gw := &gateway{}
+		if !decodeUnstructured(obj, gw) || gw.Spec.GatewayClassName != s.className {
+			continue
+		}
+		gateways[types.NamespacedName{Namespace: gw.Namespace, Name: gw.Name}] = gw
+	}
+
+	var routes []*httpRoute
+	for _, obj := range s.routes.List() {
+		route := &httpRoute{}
+		if !decodeUnstructured(obj, route) || !s.namespaces.selected(route.Namespace) {
+			continue
+		}
+		routes = append(routes, route)
+	}
+
+	// Older routes win ties, as in the Gateway API's precedence rules
+	sort.Slice(routes, func(i, j int) bool {
+		ti, tj := routes[i].CreationTimestamp, routes[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		if routes[i].Namespace != routes[j].Namespace {
+			return routes[i].Namespace < routes[j].Namespace
+		}
+		return routes[i].Name < routes[j].Name
+	})
+
+	var translated []config.Route
+	for _, route := range routes {
+		routeConfigs, parents := translateHTTPRoute(route, gateways, s.lookupService)
+		translated = append(translated, routeConfigs...)
+		if err := s.updateStatus(route, parents); err != nil {
+			log.Printf("Failed to update status of HTTPRoute %s/%s: %v", route.Namespace, route.Name, err)
+			if apierrors.IsConflict(err) {
+				time.AfterFunc(5*time.Second, s.notify)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.current = translated
+	s.mu.Unlock()
+	return true
+}
+
+func (s *GatewaySource) lookupService(namespace, name string) *corev1.Service {
+	obj, exists, err := s.services.GetByKey(namespace + "/" + name)
+	if err != nil || !exists {
+		return nil
+	}
+	svc, _ := obj.(*corev1.Service)
+	return svc
+}
+
+// updateStatus replaces the gateway's entries in the route's status.parents,
+// keeping those of other controllers. It writes nothing if they're unchanged.
+func (s *GatewaySource) updateStatus(route *httpRoute, parents []routeParentStatus) error {
+	var existing []routeParentStatus
+	var others []routeParentStatus
+	for _, parent := range route.Status.Parents {
+		if parent.ControllerName == gatewayControllerName {
+			existing = append(existing, parent)
+		} else {
+			others = append(others, parent)
+		}
+	}
+
+	now := metav1.Now()
+	for i := range parents {
+		for j := range parents[i].Conditions {
+			condition := &parents[i].Conditions[j]
+			condition.ObservedGeneration = route.Generation
+			condition.LastTransitionTime = now
+			// Keep the transition time of conditions whose status didn't change
+			if previous := findCondition(existing, parents[i].ParentRef, condition.Type); previous != nil && previous.Status == condition.Status {
+				condition.LastTransitionTime = previous.LastTransitionTime
+			}
+		}
+	}
+
+	if statusEqual(existing, parents) {
+		return nil
+	}
+
+	obj, exists, err := s.routes.GetByKey(route.Namespace + "/" + route.Name)
+	if err != nil || !exists {
+		return err
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("unexpected object %T", obj)
+	}
+	u = u.DeepCopy()
+
+	all, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&httpRouteStatus{Parents: append(others, parents...)})
+	if err != nil {
+		return err
+	}
+	statusParents, _, _ := unstructured.NestedSlice(all, "parents")
+	if err := unstructured.SetNestedSlice(u.Object, statusParents, "status", "parents"); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = s.client.Resource(httpRoutesResource).Namespace(route.Namespace).UpdateStatus(ctx, u, metav1.UpdateOptions{})
+	return err
+}
+
+func findCondition(parents []routeParentStatus, ref parentReference, conditionType string) *metav1.Condition {
+	for i := range parents {
+		if !reflect.DeepEqual(parents[i].ParentRef, ref) {
+			continue
+		}
+		for j := range parents[i].Conditions {
+			if parents[i].Conditions[j].Type == conditionType {
+				return &parents[i].Conditions[j]
+			}
+		}
+	}
+	return nil
+}
+
+// statusEqual compares parent statuses, ignoring the API server's rounding
+// of transition times
+func statusEqual(a, b []routeParentStatus) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !reflect.DeepEqual(a[i].ParentRef, b[i].ParentRef) || len(a[i].Conditions) != len(b[i].Conditions) {
+			return false
+		}
+		for j := range a[i].Conditions {
+			ca, cb := a[i].Conditions[j], b[i].Conditions[j]
+			ca.LastTransitionTime, cb.LastTransitionTime = metav1.Time{}, metav1.Time{}
+			if ca != cb {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// decodeUnstructured decodes an informer object into one of the Gateway API types
+func decodeUnstructured(obj interface{}, into interface{}) bool {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return false
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, into); err != nil {
+		log.Printf("Ignoring malformed %s %s/%s: %v", u.GetKind(), u.GetNamespace(), u.GetName(), err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,290 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+	defaultHealthyThreshold    = 2
+	defaultUnhealthyThreshold  = 3
+	defaultConsecutiveFailures = 5
+	defaultEjectionTime        = 30 * time.Second
+)
+
+var (
+	upstreamHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "odh_gateway",
+		Subsystem: "upstream",
+		Name:      "healthy",
+		Help:      "Whether an upstream receives requests: 1 if it passes its health checks and isn't ejected, 0 otherwise.",
+	}, []string{"upstream"})
+
+	upstreamEjections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "odh_gateway",
+		Subsystem: "upstream",
+		Name:      "ejections_total",
+		Help:      "Number of times an upstream was ejected after consecutive failed requests.",
+	}, []string{"upstream"})
+)
+
+// healthStates keeps upstream health across router rebuilds, which happen on
+// every config or route source change
+var healthStates = &healthRegistry{states: make(map[string]*upstreamHealth)}
+
+// upstreamHealth tracks whether an upstream should receive requests
+type upstreamHealth struct {
+	url     string
+	active  *config.HealthCheckConfig
+	passive *config.PassiveHealthCheckConfig
+	stop    chan struct{}
+	// generation of the last router that used it
+	generation int
+
+	mu        sync.Mutex
+	unhealthy bool
+	// consecutive probe results counting towards a transition
+	probeStreak int
+	// consecutive failed requests
+	failures     int
+	ejectedUntil time.Time
+}
+
+// healthRegistry shares health state between routers. States no longer used
+// by the current router are stopped by prune.
+type healthRegistry struct {
+	mu         sync.Mutex
+	generation int
+	states     map[string]*upstreamHealth
+}
+
+// withHealthDefaults validates a route's health checks and fills in defaults
+func withHealthDefaults(route config.Route) (*config.HealthCheckConfig, *config.PassiveHealthCheckConfig, error) {
+	var active *config.HealthCheckConfig
+	if route.HealthCheck != nil {
+		hc := *route.HealthCheck
+		if hc.Path == "" || hc.Path[0] != '/' {
+			return nil, nil, fmt.Errorf("healthCheck path must start with /")
+		}
+		if hc.Interval <= 0 {
+			hc.Interval = defaultHealthCheckInterval
+		}
+		if hc.Timeout <= 0 {
+			hc.Timeout = defaultHealthCheckTimeout
+		}
+		if hc.HealthyThreshold <= 0 {
+			hc.HealthyThreshold = defaultHealthyThreshold
+		}
+		if hc.UnhealthyThreshold <= 0 {
+			hc.UnhealthyThreshold = defaultUnhealthyThreshold
+		}
+		active = &hc
+	}
+
+	var passive *config.PassiveHealthCheckConfig
+	if route.PassiveHealthCheck != nil {
+		phc := *route.PassiveHealthCheck
+		if phc.ConsecutiveFailures <= 0 {
+			phc.ConsecutiveFailures = defaultConsecutiveFailures
+		}
+		if phc.EjectionTime <= 0 {
+			phc.EjectionTime = defaultEjectionTime
+		}
+		passive = &phc
+	}
+	return active, passive, nil
+}
+
+// nextGeneration starts building a new router
+func (reg *healthRegistry) nextGeneration() {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.generation++
+}
+
+// get returns the health state of an upstream, starting its probes if it's new.
+// Routes with the same upstream and checks share a state.
+func (reg *healthRegistry) get(upstream string, active *config.HealthCheckConfig, passive *config.PassiveHealthCheckConfig) *upstreamHealth {
+	key := upstream
+	if active != nil {
+		key += fmt.Sprintf(" active=%+v", *active)
+	}
+	if passive != nil {
+		key += fmt.Sprintf(" passive=%+v", *passive)
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	h, ok := reg.states[key]
+	if !ok {
+		h = &upstreamHealth{url: upstream, active: active, passive: passive, stop: make(chan struct{})}
+		reg.states[key] = h
+		upstreamHealthy.WithLabelValues(upstream).Set(1)
+		if active != nil {
+			go h.probe()
+		}
+	}
+	h.generation = reg.generation
+	return h
+}
+
+// prune stops the health states the current router doesn't use
+func (reg *healthRegistry) prune() {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	inUse := make(map[string]bool)
+	var stopped []*upstreamHealth
+	for key, h := range reg.states {
+		if h.generation == reg.generation {
+			inUse[h.url] = true
+			continue
+		}
+		close(h.stop)
+		delete(reg.states, key)
+		stopped = append(stopped, h)
+	}
+
+	// Several states may share an upstream's metrics label
+	for _, h := range stopped {
+		if !inUse[h.url] {
+			upstreamHealthy.DeleteLabelValues(h.url)
+		}
+	}
+}
+
+// available returns whether the upstream should receive requests
+func (h *upstreamHealth) available() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.unhealthy && !time.Now().Before(h.ejectedUntil)
+}
+
+// probe runs the active health check until stopped
+func (h *upstreamHealth) probe() {
+	target, err := url.Parse(h.url)
+	if err != nil {
+		return
+	}
+	checkURL := (&url.URL{Scheme: target.Scheme, Host: target.Host, Path: h.active.Path}).String()
+	client := &http.Client{
+		Timeout: h.active.Timeout,
+		// A redirect is an answer, don't follow it
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	ticker := time.NewTicker(h.active.Interval)
+	defer ticker.Stop()
+	for {
+		h.recordProbe(checkProbe(client, checkURL))
+
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkProbe returns nil if the upstream answers with a 2xx or 3xx status
+func checkProbe(client *http.Client, checkURL string) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, checkURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "odh-gateway-health-check")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// recordProbe counts a probe result towards the thresholds
+func (h *upstreamHealth) recordProbe(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// A probe agreeing with the current state resets the streak
+	if (err == nil) != h.unhealthy {
+		h.probeStreak = 0
+		return
+	}
+
+	h.probeStreak++
+	switch {
+	case h.unhealthy && h.probeStreak >= h.active.HealthyThreshold:
+		h.unhealthy, h.probeStreak = false, 0
+		log.Printf("Upstream %s is healthy", h.url)
+	case !h.unhealthy && h.probeStreak >= h.active.UnhealthyThreshold:
+		h.unhealthy, h.probeStreak = true, 0
+		log.Printf("Upstream %s is unhealthy: %v", h.url, err)
+	}
+	h.updateMetric()
+}
+
+// recordResponse counts a proxied response for passive ejection. Connection
+// failures are answered with 502 by the reverse proxy.
+func (h *upstreamHealth) recordResponse(status int) {
+	if h.passive == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if status != http.StatusBadGateway && status != http.StatusServiceUnavailable && status != http.StatusGatewayTimeout {
+		h.failures = 0
+		return
+	}
+
+	h.failures++
+	if h.failures >= h.passive.ConsecutiveFailures {
+		h.failures = 0
+		h.ejectedUntil = time.Now().Add(h.passive.EjectionTime)
+		upstreamEjections.WithLabelValues(h.url).Inc()
+		log.Printf("Ejecting upstream %s for %s after %d failed requests", h.url, h.passive.EjectionTime, h.passive.ConsecutiveFailures)
+
+		upstreamHealthy.WithLabelValues(h.url).Set(0)
+		time.AfterFunc(h.passive.EjectionTime, func() {
+			select {
+			case <-h.stop:
+				return
+			default:
+			}
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			h.updateMetric()
+		})
+	}
+}
+
+// updateMetric publishes the state, callers must hold h.mu
+func (h *upstreamHealth) updateMetric() {
+	healthy := 1.0
+	if h.unhealthy || time.Now().Before(h.ejectedUntil) {
+		healthy = 0
+	}
+	upstreamHealthy.WithLabelValues(h.url).Set(healthy)
+}
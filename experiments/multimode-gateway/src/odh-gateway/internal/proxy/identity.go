@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+
+	"github.com/jctanner/odh-gateway/internal/proxy/providers"
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+const (
+	// identityHeader carries the signed identity assertion to upstreams
+	identityHeader = "X-Forwarded-Identity"
+
+	// jwksPath serves the keys upstreams verify identity assertions with
+	jwksPath = "/.well-known/jwks.json"
+
+	defaultIdentityAssertionTTL = time.Minute
+)
+
+// identityHeaders are set by the gateway for upstreams and never accepted from clients
+var identityHeaders = []string{
+	"X-Forwarded-User",
+	"X-Forwarded-Groups",
+	"X-Forwarded-Email",
+	"X-Forwarded-Access-Token",
+	identityHeader,
+}
+
+// stripIdentityHeaders removes client-supplied identity headers so upstreams
+// only ever see identities asserted by the gateway
+func stripIdentityHeaders(r *http.Request) {
+	for _, header := range identityHeaders {
+		r.Header.Del(header)
+	}
+}
+
+// IdentitySigner mints short-lived JWTs asserting the authenticated user
+type IdentitySigner struct {
+	issuer string
+	ttl    time.Duration
+	signer jose.Signer
+	jwks   jose.JSONWebKeySet
+}
+
+// identityClaims are the claims of an identity assertion besides the registered ones
+type identityClaims struct {
+	Groups []string `json:"groups,omitempty"`
+	Email  string   `json:"email,omitempty"`
+}
+
+// NewIdentitySigner creates a signer from cfg, nil if assertions are disabled
+func NewIdentitySigner(cfg config.IdentityAssertionConfig, issuer string) (*IdentitySigner, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	key, err := loadIdentityKey(cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	jwk := jose.JSONWebKey{Key: key, Algorithm: string(jose.ES256), Use: "sig"}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute key ID: %w", err)
+	}
+	jwk.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jwk}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create identity signer: %w", err)
+	}
+
+	s := &IdentitySigner{
+		issuer: issuer,
+		ttl:    cfg.TTL,
+		signer: signer,
+		jwks:   jose.JSONWebKeySet{Keys: []jose.JSONWebKey{jwk.Public()}},
+	}
+	if s.ttl <= 0 {
+		s.ttl = defaultIdentityAssertionTTL
+	}
+
+	log.Printf("Identity assertions enabled, key ID %s", jwk.KeyID)
+	return s, nil
+}
+
+// loadIdentityKey reads a PEM encoded P-256 private key, generating one if path is empty
+func loadIdentityKey(path string) (*ecdsa.PrivateKey, error) {
+	if path == "" {
+		log.Printf("No identity assertion key file configured, using a random key. Replicas won't share keys.")
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity assertion key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity assertion key: %w", err)
+	}
+
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok || key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("identity assertion key must be an ECDSA P-256 key")
+	}
+	return key, nil
+}
+
+// Sign returns an assertion of user's identity for the upstreams in audience
+func (s *IdentitySigner) Sign(user *providers.UserInfo, audience []string) (string, error) {
+	now := time.Now()
+	claims := jwt.Claims{
+		Issuer:    s.issuer,
+		Subject:   user.Username,
+		Audience:  jwt.Audience(audience),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(now.Add(s.ttl)),
+	}
+
+	return jwt.Signed(s.signer).Claims(claims).Claims(identityClaims{Groups: user.Groups, Email: user.Email}).Serialize()
+}
+
+// HandleJWKS serves the public keys assertions are signed with
+func (s *IdentitySigner) HandleJWKS() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		if err := json.NewEncoder(w).Encode(s.jwks); err != nil {
+			log.Printf("Failed to write JWKS: %v", err)
+		}
+	})
+}
@@ -0,0 +1,249 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/jctanner/odh-gateway/internal/kube"
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+// Service annotations read by the service source, the same ones the operator
+// turns into the routes ConfigMap. HTTPRoutes may set auth-required too.
+const (
+	enabledAnnotation      = "odhgateway.opendatahub.io/enabled"
+	routePathAnnotation    = "odhgateway.opendatahub.io/route-path"
+	authRequiredAnnotation = "odhgateway.opendatahub.io/auth-required"
+)
+
+// sourceDebounce coalesces bursts of watch events into one router rebuild
+const sourceDebounce = 500 * time.Millisecond
+
+// routeSource provides routes in addition to the config file
+type routeSource interface {
+	// Routes returns the source's current routes
+	Routes() []config.Route
+}
+
+// ServiceSource routes to annotated Services, watched directly instead of
+// waiting for the operator's ConfigMap to be synced to the pod
+type ServiceSource struct {
+	services   cache.Store
+	namespaces *namespaceFilter
+	synced     []cache.InformerSynced
+}
+
+// namespaceFilter selects namespaces by label, all namespaces if there's no selector
+type namespaceFilter struct {
+	// namespaces holds the selected namespaces, nil selects all of them
+	namespaces cache.Store
+}
+
+// StartServiceSource starts watching Services and calls onChange, debounced,
+// whenever the routes they define may have changed
+func StartServiceSource(cfg config.KubernetesSourceConfig, onChange func()) (*ServiceSource, error) {
+	restConfig, err := kube.RestConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+
+	stop := make(chan struct{})
+	notify := debounce(onChange)
+
+	namespaces, namespacesSynced, err := startNamespaceFilter(client, cfg.NamespaceSelector, notify, stop)
+	if err != nil {
+		return nil, err
+	}
+
+	factory := informers.NewSharedInformerFactory(client, 0)
+	informer := factory.Core().V1().Services().Informer()
+	_, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if routedService(obj) {
+				notify()
+			}
+		},
+		UpdateFunc: func(oldObj, obj interface{}) {
+			if routedService(oldObj) || routedService(obj) {
+				notify()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if routedService(obj) {
+				notify()
+			}
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add service event handler: %w", err)
+	}
+	factory.Start(stop)
+
+	s := &ServiceSource{
+		services:   informer.GetStore(),
+		namespaces: namespaces,
+		synced:     append(namespacesSynced, informer.HasSynced),
+	}
+
+	go func() {
+		if !cache.WaitForCacheSync(stop, s.synced...) {
+			return
+		}
+		log.Printf("Service source synced")
+		notify()
+	}()
+
+	if cfg.NamespaceSelector != "" {
+		log.Printf("Started service source for namespaces matching %q", cfg.NamespaceSelector)
+	} else {
+		log.Printf("Started service source for all namespaces")
+	}
+	return s, nil
+}
+
+// debounce returns a function that schedules fn, running it once for a burst
+// of calls
+func debounce(fn func()) func() {
+	changed := make(chan struct{}, 1)
+	go func() {
+		for range changed {
+			time.Sleep(sourceDebounce)
+			fn()
+		}
+	}()
+
+	return func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// startNamespaceFilter watches the namespaces matching selector
+func startNamespaceFilter(client kubernetes.Interface, selector string, notify func(), stop <-chan struct{}) (*namespaceFilter, []cache.InformerSynced, error) {
+	if selector == "" {
+		return &namespaceFilter{}, nil, nil
+	}
+
+	if _, err := labels.Parse(selector); err != nil {
+		return nil, nil, fmt.Errorf("invalid namespace selector %q: %w", selector, err)
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = selector
+		}))
+	informer := factory.Core().V1().Namespaces().Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to add namespace event handler: %w", err)
+	}
+	factory.Start(stop)
+
+	return &namespaceFilter{namespaces: informer.GetStore()}, []cache.InformerSynced{informer.HasSynced}, nil
+}
+
+// selected returns whether a namespace matches the selector
+func (f *namespaceFilter) selected(namespace string) bool {
+	if f.namespaces == nil {
+		return true
+	}
+	_, exists, _ := f.namespaces.GetByKey(namespace)
+	return exists
+}
+
+// routedService returns whether obj is a Service enabled for the gateway
+func routedService(obj interface{}) bool {
+	svc, ok := obj.(*corev1.Service)
+	return ok && svc.Annotations[enabledAnnotation] == "true"
+}
+
+// Routes returns a route for each enabled Service in a selected namespace,
+// none until the informers have synced
+func (s *ServiceSource) Routes() []config.Route {
+	for _, synced := range s.synced {
+		if !synced() {
+			return nil
+		}
+	}
+
+	var services []*corev1.Service
+	for _, obj := range s.services.List() {
+		svc, ok := obj.(*corev1.Service)
+		if !ok || !routedService(svc) || !s.namespaces.selected(svc.Namespace) {
+			continue
+		}
+		services = append(services, svc)
+	}
+
+	// Keep the order stable so equally specific routes don't swap between rebuilds
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Namespace != services[j].Namespace {
+			return services[i].Namespace < services[j].Namespace
+		}
+		return services[i].Name < services[j].Name
+	})
+
+	routes := make([]config.Route, 0, len(services))
+	for _, svc := range services {
+		routes = append(routes, serviceRoute(svc))
+	}
+	return routes
+}
+
+// serviceRoute builds the route for a Service the same way the operator does
+func serviceRoute(svc *corev1.Service) config.Route {
+	var port int32 = 80
+	for _, p := range svc.Spec.Ports {
+		if p.Port > 0 {
+			port = p.Port
+			break
+		}
+	}
+
+	return config.Route{
+		PathPrefix:   svc.Annotations[routePathAnnotation],
+		Upstream:     serviceURL(svc.Namespace, svc.Name, port),
+		AuthRequired: authRequiredFromAnnotations(svc.Annotations),
+	}
+}
+
+// serviceURL returns the cluster URL of a Service port
+func serviceURL(namespace, name string, port int32) string {
+	return fmt.Sprintf("http://%s.%s.svc.cluster.local:%d", name, namespace, port)
+}
+
+// authRequiredFromAnnotations reads the auth-required annotation, nil if unset
+func authRequiredFromAnnotations(annotations map[string]string) *bool {
+	var authRequired bool
+	switch annotations[authRequiredAnnotation] {
+	case "true":
+		authRequired = true
+	case "false":
+		authRequired = false
+	default:
+		return nil
+	}
+	return &authRequired
+}
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"log"
@@ -8,8 +9,22 @@ import (
 	"strings"
 
 	"github.com/jctanner/odh-gateway/internal/proxy/providers"
+	"github.com/jctanner/odh-gateway/pkg/config"
 )
 
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	prefixMappingContextKey
+)
+
+// userFromRequest returns the user authenticated by the middleware, nil if none
+func userFromRequest(r *http.Request) *providers.UserInfo {
+	user, _ := r.Context().Value(userContextKey).(*providers.UserInfo)
+	return user
+}
+
 // generateRandomString generates a random string of the specified length
 func generateRandomString(length int) (string, error) {
 	bytes := make([]byte, length)
@@ -22,27 +37,46 @@ func generateRandomString(length int) (string, error) {
 // AuthMiddleware handles authentication using providers
 type AuthMiddleware struct {
 	provider providers.AuthProvider
+	sessions *SessionManager
+	identity *IdentitySigner
 }
 
-// NewAuthMiddleware creates a new auth middleware
-func NewAuthMiddleware(provider providers.AuthProvider) *AuthMiddleware {
+// NewAuthMiddleware creates a new auth middleware. identity may be nil to
+// disable identity assertions.
+func NewAuthMiddleware(provider providers.AuthProvider, sessions *SessionManager, identity *IdentitySigner) *AuthMiddleware {
 	return &AuthMiddleware{
 		provider: provider,
+		sessions: sessions,
+		identity: identity,
 	}
 }
 
-// Middleware returns an HTTP middleware that handles authentication
-func (m *AuthMiddleware) Middleware(authRequired *bool) func(http.Handler) http.Handler {
+// Middleware returns an HTTP middleware that handles authentication and the
+// route's authorization rules
+func (m *AuthMiddleware) Middleware(route config.Route) func(http.Handler) http.Handler {
+	authorizer := newRouteAuthorizer(route)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Determine if auth is required for this route
-			requireAuth := false
-			if authRequired != nil {
-				requireAuth = *authRequired
+			// Determine if auth is required for this route, authorization rules imply it
+			requireAuth := authorizer != nil
+			if route.AuthRequired != nil && *route.AuthRequired {
+				requireAuth = true
+			}
+
+			// Skip auth if not required
+			if !requireAuth {
+				next.ServeHTTP(w, r)
+				return
 			}
 
-			// Skip auth if not required or provider not enabled
-			if !requireAuth || !m.provider.IsEnabled() {
+			// Skip auth if provider not enabled, unless there are rules that need a user
+			if !m.provider.IsEnabled() {
+				if authorizer != nil {
+					log.Printf("Denying %s: route has authorization rules but no auth provider is enabled", r.URL.Path)
+					writeForbidden(w, r, nil)
+					return
+				}
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -61,6 +95,40 @@ func (m *AuthMiddleware) Middleware(authRequired *bool) func(http.Handler) http.
 				}
 
 				log.Printf("Authenticated user: %s, groups: %v", userInfo.Username, userInfo.Groups)
+
+				if authorizer != nil {
+					if reason := authorizer.authorize(r, userInfo); reason != "" {
+						log.Printf("Denying %s access to %s: %s", userInfo.Username, r.URL.Path, reason)
+						writeForbidden(w, r, userInfo)
+						return
+					}
+				}
+
+				// Assert the identity to the upstream with a signed token
+				if m.identity != nil {
+					var audience []string
+					for _, upstream := range route.UpstreamURLs() {
+						if strings.Contains(upstream, config.UserPlaceholder) {
+							target, err := expandUserURL(upstream, userInfo.Username)
+							if err != nil {
+								log.Printf("Denying %s access to %s: %v", userInfo.Username, r.URL.Path, err)
+								writeForbidden(w, r, userInfo)
+								return
+							}
+							upstream = target.String()
+						}
+						audience = append(audience, upstream)
+					}
+					assertion, err := m.identity.Sign(userInfo, audience)
+					if err != nil {
+						log.Printf("Failed to sign identity assertion: %v", err)
+						http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+						return
+					}
+					r.Header.Set(identityHeader, assertion)
+				}
+
+				r = r.WithContext(context.WithValue(r.Context(), userContextKey, userInfo))
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -71,19 +139,30 @@ func (m *AuthMiddleware) Middleware(authRequired *bool) func(http.Handler) http.
 	}
 }
 
-// validateRequest checks for valid authentication and returns token string and user info
+// validateRequest checks for valid authentication and returns the access token and user info
 func (m *AuthMiddleware) validateRequest(r *http.Request) (string, *providers.UserInfo) {
-	// Try to get token from cookie first, then Authorization header
-	var tokenString string
+	// Try the session cookie first, then the Authorization header
+	var tokenString, accessToken string
 
-	// Check auth cookie
-	if cookie, err := r.Cookie("auth_token"); err == nil {
-		tokenString = cookie.Value
-	} else {
+	if session := m.sessions.Load(r); session != nil {
+		tokens, err := m.sessions.Tokens(session, m.provider)
+		if err != nil {
+			log.Printf("Session ended: %v", err)
+		} else if tokens.User != nil {
+			// The identity was verified when the tokens were issued
+			userInfo := *tokens.User
+			return tokens.AccessToken, &userInfo
+		} else {
+			tokenString, accessToken = tokens.Token, tokens.AccessToken
+		}
+	}
+
+	if tokenString == "" {
 		// Check Authorization header
 		authHeader := r.Header.Get("Authorization")
 		if strings.HasPrefix(authHeader, "Bearer ") {
 			tokenString = strings.TrimPrefix(authHeader, "Bearer ")
+			accessToken = tokenString
 		}
 	}
 
@@ -98,7 +177,7 @@ func (m *AuthMiddleware) validateRequest(r *http.Request) (string, *providers.Us
 		return "", nil
 	}
 
-	return tokenString, userInfo
+	return accessToken, userInfo
 }
 
 // redirectToAuth redirects the user to the authentication provider
@@ -147,13 +226,20 @@ func (m *AuthMiddleware) HandleCallback() http.Handler {
 		}
 
 		// Process callback with provider
-		userInfo, err := m.provider.HandleCallback(w, r)
+		userInfo, tokens, err := m.provider.HandleCallback(w, r)
 		if err != nil {
 			log.Printf("Callback processing failed: %v", err)
 			http.Error(w, "Authentication failed", http.StatusInternalServerError)
 			return
 		}
 
+		// Keep the tokens server-side, the browser only gets the session cookie
+		if err := m.sessions.Create(w, r, tokens); err != nil {
+			log.Printf("Failed to create session: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
 		log.Printf("User authenticated: %s", userInfo.Username)
 
 		// Get original redirect URL
@@ -185,13 +271,8 @@ func (m *AuthMiddleware) HandleCallback() http.Handler {
 // HandleLogout handles user logout
 func (m *AuthMiddleware) HandleLogout() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Clear auth cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:   "auth_token",
-			Value:  "",
-			Path:   "/",
-			MaxAge: -1,
-		})
+		// Invalidate the session server-side and clear its cookie
+		m.sessions.Destroy(w, r)
 
 		// Get logout URL from provider
 		logoutURL := m.provider.GetLogoutURL(r.URL.Query().Get("redirect_uri"))
@@ -0,0 +1,177 @@
+package providers
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/jctanner/odh-gateway/internal/kube"
+)
+
+var groupsResource = schema.GroupVersionResource{Group: "user.openshift.io", Version: "v1", Resource: "groups"}
+
+// groupIndexSyncTimeout is how long the informer may take to sync before it is
+// reported as failing
+const groupIndexSyncTimeout = time.Minute
+
+var groupInformerSynced = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "odh_gateway",
+	Subsystem: "group_informer",
+	Name:      "synced",
+	Help:      "1 once the OpenShift group informer has synced and group lookups use its index, 0 before.",
+})
+
+var (
+	sharedGroupIndex    *groupIndex
+	sharedGroupIndexErr error
+	sharedGroupIndexMu  sync.Mutex
+)
+
+// groupIndex keeps a user -> groups index of OpenShift Groups, fed by an
+// informer running as the gateway's service account
+type groupIndex struct {
+	synced cache.InformerSynced
+
+	mu sync.RWMutex
+	// group -> users
+	members map[string][]string
+	// user -> groups
+	groups map[string]map[string]struct{}
+}
+
+// getGroupIndex returns the process-wide group index, starting its informer on
+// first use. Providers are recreated on every config reload, the informer is not.
+func getGroupIndex() (*groupIndex, error) {
+	sharedGroupIndexMu.Lock()
+	defer sharedGroupIndexMu.Unlock()
+
+	if sharedGroupIndex == nil && sharedGroupIndexErr == nil {
+		sharedGroupIndex, sharedGroupIndexErr = startGroupIndex()
+	}
+	return sharedGroupIndex, sharedGroupIndexErr
+}
+
+// startGroupIndex starts a Group informer using the gateway's service account
+func startGroupIndex() (*groupIndex, error) {
+	restConfig, err := kube.RestConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
+	idx := &groupIndex{
+		members: make(map[string][]string),
+		groups:  make(map[string]map[string]struct{}),
+	}
+
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(client, 10*time.Minute)
+	informer := factory.ForResource(groupsResource).Informer()
+	_, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { idx.update(obj) },
+		UpdateFunc: func(_, obj interface{}) { idx.update(obj) },
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if group, ok := obj.(*unstructured.Unstructured); ok {
+				idx.set(group.GetName(), nil)
+			}
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to add group event handler: %w", err)
+	}
+	idx.synced = informer.HasSynced
+
+	factory.Start(make(chan struct{}))
+	log.Printf("Started OpenShift group informer")
+	go idx.waitForSync(groupIndexSyncTimeout)
+	return idx, nil
+}
+
+// waitForSync reports when the informer has synced, and logs an error if it
+// hasn't within timeout, e.g. because the service account can't list groups.
+// Lookups fall back to the API until then.
+func (idx *groupIndex) waitForSync(timeout time.Duration) {
+	stop := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(stop) })
+	defer timer.Stop()
+
+	if !cache.WaitForCacheSync(stop, idx.synced) {
+		log.Printf("Error: OpenShift group informer has not synced after %s, group lookups fall back to the API. Check that the service account can list and watch groups.", timeout)
+		cache.WaitForCacheSync(make(chan struct{}), idx.synced)
+	}
+
+	groupInformerSynced.Set(1)
+	log.Printf("OpenShift group informer synced")
+}
+
+// update indexes a Group object
+func (idx *groupIndex) update(obj interface{}) {
+	group, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	users, _, err := unstructured.NestedStringSlice(group.Object, "users")
+	if err != nil {
+		log.Printf("Ignoring malformed group %s: %v", group.GetName(), err)
+		return
+	}
+	idx.set(group.GetName(), users)
+}
+
+// set replaces the members of a group, nil users removes it
+func (idx *groupIndex) set(group string, users []string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, user := range idx.members[group] {
+		delete(idx.groups[user], group)
+		if len(idx.groups[user]) == 0 {
+			delete(idx.groups, user)
+		}
+	}
+
+	if users == nil {
+		delete(idx.members, group)
+		return
+	}
+	idx.members[group] = users
+	for _, user := range users {
+		if idx.groups[user] == nil {
+			idx.groups[user] = make(map[string]struct{})
+		}
+		idx.groups[user][group] = struct{}{}
+	}
+}
+
+// Groups returns the groups of a user, false until the informer has synced
+func (idx *groupIndex) Groups(username string) ([]string, bool) {
+	if !idx.synced() {
+		return nil, false
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	groups := make([]string, 0, len(idx.groups[username]))
+	for group := range idx.groups[username] {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	return groups, true
+}
@@ -69,9 +69,9 @@ func (p *OIDCProvider) GetLoginURL(state, redirectURL string) string {
 }
 
 // HandleCallback processes the authentication callback and returns user info
-func (p *OIDCProvider) HandleCallback(w http.ResponseWriter, r *http.Request) (*UserInfo, error) {
+func (p *OIDCProvider) HandleCallback(w http.ResponseWriter, r *http.Request) (*UserInfo, *Tokens, error) {
 	if err := p.initializeProvider(); err != nil {
-		return nil, fmt.Errorf("failed to initialize OIDC provider: %w", err)
+		return nil, nil, fmt.Errorf("failed to initialize OIDC provider: %w", err)
 	}
 
 	ctx := context.Background()
@@ -80,39 +80,78 @@ func (p *OIDCProvider) HandleCallback(w http.ResponseWriter, r *http.Request) (*
 	code := r.URL.Query().Get("code")
 	token, err := p.oauth2Config.Exchange(ctx, code)
 	if err != nil {
-		return nil, fmt.Errorf("failed to exchange code: %w", err)
+		return nil, nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+
+	userInfo, tokens, err := p.verifyToken(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return userInfo, tokens, nil
+}
+
+// RefreshTokens exchanges a refresh token for new tokens
+func (p *OIDCProvider) RefreshTokens(refreshToken string) (*Tokens, error) {
+	if err := p.initializeProvider(); err != nil {
+		return nil, fmt.Errorf("failed to initialize OIDC provider: %w", err)
+	}
+
+	ctx := context.Background()
+	token, err := p.oauth2Config.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh token: %w", err)
+	}
+
+	// Providers may omit the ID token from refresh responses, the session
+	// then keeps its identity and only the other tokens are replaced
+	if _, ok := token.Extra("id_token").(string); !ok {
+		return &Tokens{
+			AccessToken:  token.AccessToken,
+			RefreshToken: token.RefreshToken,
+			Expiry:       token.Expiry,
+		}, nil
+	}
+
+	_, tokens, err := p.verifyToken(ctx, token)
+	if err != nil {
+		return nil, err
 	}
 
-	// Extract and verify ID token
+	return tokens, nil
+}
+
+// verifyToken verifies the ID token in a token response
+func (p *OIDCProvider) verifyToken(ctx context.Context, token *oauth2.Token) (*UserInfo, *Tokens, error) {
 	rawIDToken, ok := token.Extra("id_token").(string)
 	if !ok {
-		return nil, fmt.Errorf("no id_token in response")
+		return nil, nil, fmt.Errorf("no id_token in response")
 	}
 
 	idToken, err := p.verifier.Verify(ctx, rawIDToken)
 	if err != nil {
-		return nil, fmt.Errorf("failed to verify ID token: %w", err)
+		return nil, nil, fmt.Errorf("failed to verify ID token: %w", err)
 	}
 
 	// Extract claims and create UserInfo
 	var claims map[string]interface{}
 	if err := idToken.Claims(&claims); err != nil {
-		return nil, fmt.Errorf("failed to extract claims: %w", err)
+		return nil, nil, fmt.Errorf("failed to extract claims: %w", err)
 	}
 
 	userInfo := p.extractUserInfo(claims)
+	tokens := &Tokens{
+		Token:        rawIDToken,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		Expiry:       idToken.Expiry,
+		User:         userInfo,
+	}
+	if !token.Expiry.IsZero() && token.Expiry.Before(tokens.Expiry) {
+		tokens.Expiry = token.Expiry
+	}
 
-	// Store the ID token in a secure cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "auth_token",
-		Value:    rawIDToken,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   r.TLS != nil,
-		MaxAge:   3600, // 1 hour
-	})
-
-	return userInfo, nil
+	return userInfo, tokens, nil
 }
 
 // ValidateToken validates a token and returns user info
@@ -23,6 +23,8 @@ type OpenShiftProvider struct {
 	config          config.OpenShiftProviderConfig
 	oauth2Config    oauth2.Config
 	httpClient      *http.Client
+	tokenCache      *tokenCache
+	groupIndex      *groupIndex
 	baseURL         string
 	groupsPermLoggedOnce bool
 }
@@ -107,10 +109,21 @@ func NewOpenShiftProvider(config config.OpenShiftProviderConfig, baseURL string)
 		}
 	}
 
+	// Resolve groups from an in-memory index instead of the user's token
+	var groups *groupIndex
+	if config.GroupInformer {
+		groups, err = getGroupIndex()
+		if err != nil {
+			log.Printf("Warning: group informer unavailable, listing groups with the user's token: %v", err)
+		}
+	}
+
 	return &OpenShiftProvider{
 		config:       config,
 		oauth2Config: oauth2Config,
 		httpClient:   httpClient,
+		tokenCache:   newTokenCache(config.TokenCache),
+		groupIndex:   groups,
 		baseURL:      baseURL,
 	}, nil
 }
@@ -121,7 +134,7 @@ func (p *OpenShiftProvider) GetLoginURL(state, redirectURL string) string {
 }
 
 // HandleCallback processes the authentication callback and returns user info
-func (p *OpenShiftProvider) HandleCallback(w http.ResponseWriter, r *http.Request) (*UserInfo, error) {
+func (p *OpenShiftProvider) HandleCallback(w http.ResponseWriter, r *http.Request) (*UserInfo, *Tokens, error) {
 	// Exchange authorization code for tokens
 	code := r.URL.Query().Get("code")
 	
@@ -129,31 +142,45 @@ func (p *OpenShiftProvider) HandleCallback(w http.ResponseWriter, r *http.Reques
 	ctx := context.WithValue(r.Context(), oauth2.HTTPClient, p.httpClient)
 	token, err := p.oauth2Config.Exchange(ctx, code)
 	if err != nil {
-		return nil, fmt.Errorf("failed to exchange code: %w", err)
+		return nil, nil, fmt.Errorf("failed to exchange code: %w", err)
 	}
 
 	// Get user info from OpenShift API
 	userInfo, err := p.getUserInfo(token.AccessToken)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user info: %w", err)
+		return nil, nil, fmt.Errorf("failed to get user info: %w", err)
 	}
 
-	// Store the access token in a secure cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:     "auth_token",
-		Value:    token.AccessToken,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   r.TLS != nil,
-		MaxAge:   3600, // 1 hour
-	})
+	return userInfo, openShiftTokens(token), nil
+}
+
+// RefreshTokens exchanges a refresh token for new tokens
+func (p *OpenShiftProvider) RefreshTokens(refreshToken string) (*Tokens, error) {
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, p.httpClient)
+	token, err := p.oauth2Config.TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh token: %w", err)
+	}
 
-	return userInfo, nil
+	return openShiftTokens(token), nil
+}
+
+// openShiftTokens converts an OAuth token response, OpenShift validates the access token itself
+func openShiftTokens(token *oauth2.Token) *Tokens {
+	return &Tokens{
+		Token:        token.AccessToken,
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		Expiry:       token.Expiry,
+	}
 }
 
 // ValidateToken validates a token and returns user info
 func (p *OpenShiftProvider) ValidateToken(tokenString string) (*UserInfo, error) {
-	return p.getUserInfo(tokenString)
+	if p.tokenCache == nil {
+		return p.getUserInfo(tokenString)
+	}
+	return p.tokenCache.Get(tokenString, p.getUserInfo)
 }
 
 // GetLogoutURL returns the URL for logging out
@@ -202,6 +229,9 @@ func (p *OpenShiftProvider) getUserInfo(accessToken string) (*UserInfo, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return nil, fmt.Errorf("%w: API request failed with status %d: %s", errTokenRejected, resp.StatusCode, string(body))
+		}
 		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
@@ -211,11 +241,18 @@ func (p *OpenShiftProvider) getUserInfo(accessToken string) (*UserInfo, error) {
 		return nil, fmt.Errorf("failed to decode user info: %w", err)
 	}
 
-	// Get user's groups
-	groups, err := p.getUserGroups(accessToken, osUser.Metadata.Name)
-	if err != nil {
-		log.Printf("Warning: failed to get user groups: %v", err)
-		// Continue without groups rather than failing
+	// Get user's groups, from the informer index once it has synced
+	var groups []string
+	synced := false
+	if p.groupIndex != nil {
+		groups, synced = p.groupIndex.Groups(osUser.Metadata.Name)
+	}
+	if !synced {
+		groups, err = p.getUserGroups(accessToken, osUser.Metadata.Name)
+		if err != nil {
+			log.Printf("Warning: failed to get user groups: %v", err)
+			// Continue without groups rather than failing
+		}
 	}
 
 	return &UserInfo{
@@ -2,7 +2,8 @@ package providers
 
 import (
 	"net/http"
-	
+	"time"
+
 	"github.com/jctanner/odh-gateway/pkg/config"
 )
 
@@ -12,7 +13,11 @@ type AuthProvider interface {
 	GetLoginURL(state, redirectURL string) string
 
 	// HandleCallback processes the authentication callback and returns user info
-	HandleCallback(w http.ResponseWriter, r *http.Request) (*UserInfo, error)
+	// and the tokens issued by the provider
+	HandleCallback(w http.ResponseWriter, r *http.Request) (*UserInfo, *Tokens, error)
+
+	// RefreshTokens exchanges a refresh token for new tokens
+	RefreshTokens(refreshToken string) (*Tokens, error)
 
 	// ValidateToken validates a token and returns user info
 	ValidateToken(tokenString string) (*UserInfo, error)
@@ -35,6 +40,22 @@ type UserInfo struct {
 	Sub      string   `json:"sub,omitempty"`
 }
 
+// Tokens holds the tokens issued by a provider for a session
+type Tokens struct {
+	// Token is the token passed to ValidateToken: the ID token for OIDC, the
+	// access token for OpenShift
+	Token        string
+	AccessToken  string
+	RefreshToken string
+	// Expiry is when Token or AccessToken expires, whichever is first. Zero
+	// if the provider didn't say.
+	Expiry time.Time
+	// User is the identity verified when the tokens were issued, for
+	// providers that don't need Token validated on every request. Nil
+	// otherwise, and in refresh responses that didn't carry a new identity.
+	User *UserInfo
+}
+
 // ProviderConfig holds configuration for authentication providers
 type ProviderConfig struct {
 	// Provider type (oidc, openshift, etc.)
@@ -90,7 +111,11 @@ func (p *DisabledProvider) GetLoginURL(state, redirectURL string) string {
 	return ""
 }
 
-func (p *DisabledProvider) HandleCallback(w http.ResponseWriter, r *http.Request) (*UserInfo, error) {
+func (p *DisabledProvider) HandleCallback(w http.ResponseWriter, r *http.Request) (*UserInfo, *Tokens, error) {
+	return nil, nil, nil
+}
+
+func (p *DisabledProvider) RefreshTokens(refreshToken string) (*Tokens, error) {
 	return nil, nil
 }
 
@@ -0,0 +1,177 @@
+package providers
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+const (
+	defaultTokenCacheTTL         = 30 * time.Second
+	defaultTokenCacheNegativeTTL = 5 * time.Second
+	defaultTokenCacheMaxEntries  = 1000
+)
+
+// errTokenRejected marks validation failures caused by the token itself,
+// these are cached. Other failures such as network errors are not.
+var errTokenRejected = errors.New("token rejected")
+
+var (
+	tokenCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "odh_gateway",
+		Subsystem: "token_cache",
+		Name:      "requests_total",
+		Help:      "Token validations by cache result: hit, negative_hit, shared (joined an in-flight lookup) or miss.",
+	}, []string{"result"})
+
+	tokenCacheEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "odh_gateway",
+		Subsystem: "token_cache",
+		Name:      "entries",
+		Help:      "Number of cached token validation results.",
+	})
+
+	tokenCacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "odh_gateway",
+		Subsystem: "token_cache",
+		Name:      "evictions_total",
+		Help:      "Number of entries evicted because the cache was full.",
+	})
+)
+
+// tokenCache is a bounded LRU cache of token validation results keyed by a
+// hash of the token. Concurrent lookups of the same token share one call.
+type tokenCache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxEntries  int
+	now         func() time.Time
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	inflight map[string]*tokenLookup
+}
+
+type tokenCacheEntry struct {
+	key      string
+	userInfo *UserInfo
+	err      error
+	expires  time.Time
+}
+
+type tokenLookup struct {
+	done     chan struct{}
+	userInfo *UserInfo
+	err      error
+}
+
+// newTokenCache creates a cache from cfg, nil if caching is disabled
+func newTokenCache(cfg config.TokenCacheConfig) *tokenCache {
+	if cfg.Disabled {
+		return nil
+	}
+
+	c := &tokenCache{
+		ttl:         cfg.TTL,
+		negativeTTL: cfg.NegativeTTL,
+		maxEntries:  cfg.MaxEntries,
+		now:         time.Now,
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		inflight:    make(map[string]*tokenLookup),
+	}
+	if c.ttl <= 0 {
+		c.ttl = defaultTokenCacheTTL
+	}
+	if c.negativeTTL <= 0 {
+		c.negativeTTL = defaultTokenCacheNegativeTTL
+	}
+	if c.maxEntries <= 0 {
+		c.maxEntries = defaultTokenCacheMaxEntries
+	}
+	tokenCacheEntries.Set(0)
+	return c
+}
+
+// Get returns the cached result for token, calling lookup on a miss
+func (c *tokenCache) Get(token string, lookup func(string) (*UserInfo, error)) (*UserInfo, error) {
+	key := tokenCacheKey(token)
+
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*tokenCacheEntry)
+		if c.now().Before(entry.expires) {
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			if entry.err != nil {
+				tokenCacheRequests.WithLabelValues("negative_hit").Inc()
+			} else {
+				tokenCacheRequests.WithLabelValues("hit").Inc()
+			}
+			return entry.userInfo, entry.err
+		}
+		c.remove(elem)
+	}
+
+	if inflight, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		tokenCacheRequests.WithLabelValues("shared").Inc()
+		<-inflight.done
+		return inflight.userInfo, inflight.err
+	}
+
+	inflight := &tokenLookup{done: make(chan struct{})}
+	c.inflight[key] = inflight
+	c.mu.Unlock()
+	tokenCacheRequests.WithLabelValues("miss").Inc()
+
+	inflight.userInfo, inflight.err = lookup(token)
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	switch {
+	case inflight.err == nil:
+		c.add(key, inflight.userInfo, nil, c.ttl)
+	case errors.Is(inflight.err, errTokenRejected):
+		c.add(key, nil, inflight.err, c.negativeTTL)
+	}
+	c.mu.Unlock()
+	close(inflight.done)
+
+	return inflight.userInfo, inflight.err
+}
+
+// tokenCacheKey hashes a token so the cache never holds it in clear
+func tokenCacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// add stores a result, evicting the least recently used entries when full.
+// Must be called with c.mu held.
+func (c *tokenCache) add(key string, userInfo *UserInfo, err error, ttl time.Duration) {
+	entry := &tokenCacheEntry{key: key, userInfo: userInfo, err: err, expires: c.now().Add(ttl)}
+	c.entries[key] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+		tokenCacheEvictions.Inc()
+	}
+	tokenCacheEntries.Set(float64(c.lru.Len()))
+}
+
+// remove drops an entry. Must be called with c.mu held.
+func (c *tokenCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*tokenCacheEntry).key)
+	tokenCacheEntries.Set(float64(c.lru.Len()))
+}
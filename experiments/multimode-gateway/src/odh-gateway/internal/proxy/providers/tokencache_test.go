@@ -0,0 +1,167 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+// countingProvider validates tokens of the form "valid-<user>" and counts the
+// calls that reach it. When block is set, calls wait for it to be closed.
+type countingProvider struct {
+	calls atomic.Int64
+	block chan struct{}
+}
+
+func (p *countingProvider) ValidateToken(token string) (*UserInfo, error) {
+	p.calls.Add(1)
+	if p.block != nil {
+		<-p.block
+	}
+	if user, ok := strings.CutPrefix(token, "valid-"); ok {
+		return &UserInfo{Username: user}, nil
+	}
+	if token == "unreachable" {
+		return nil, errors.New("connection refused")
+	}
+	return nil, fmt.Errorf("%w: status 401", errTokenRejected)
+}
+
+// newTestTokenCache returns a cache with a clock advanced by the returned function
+func newTestTokenCache(cfg config.TokenCacheConfig) (*tokenCache, func(time.Duration)) {
+	c := newTokenCache(cfg)
+	now := time.Now()
+	var mu sync.Mutex
+	c.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	return c, func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+}
+
+func TestTokenCacheSharesConcurrentValidations(t *testing.T) {
+	c, _ := newTestTokenCache(config.TokenCacheConfig{})
+	p := &countingProvider{block: make(chan struct{})}
+
+	const n = 10
+	results := make(chan *UserInfo, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userInfo, err := c.Get("valid-alice", p.ValidateToken)
+			if err != nil {
+				t.Errorf("validation failed: %v", err)
+			}
+			results <- userInfo
+		}()
+	}
+
+	// Let every caller join the first lookup before it completes
+	for {
+		c.mu.Lock()
+		_, inflight := c.inflight[tokenCacheKey("valid-alice")]
+		c.mu.Unlock()
+		if inflight {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(p.block)
+	wg.Wait()
+	close(results)
+
+	if calls := p.calls.Load(); calls != 1 {
+		t.Errorf("provider called %d times, want 1", calls)
+	}
+	for userInfo := range results {
+		if userInfo == nil || userInfo.Username != "alice" {
+			t.Errorf("got user %+v, want alice", userInfo)
+		}
+	}
+}
+
+func TestTokenCacheTTL(t *testing.T) {
+	c, advance := newTestTokenCache(config.TokenCacheConfig{TTL: time.Minute})
+	p := &countingProvider{}
+
+	for i := 0; i < 3; i++ {
+		if userInfo, err := c.Get("valid-alice", p.ValidateToken); err != nil || userInfo.Username != "alice" {
+			t.Fatalf("got %+v, %v", userInfo, err)
+		}
+	}
+	if calls := p.calls.Load(); calls != 1 {
+		t.Errorf("provider called %d times within the TTL, want 1", calls)
+	}
+
+	advance(time.Minute)
+	if _, err := c.Get("valid-alice", p.ValidateToken); err != nil {
+		t.Fatal(err)
+	}
+	if calls := p.calls.Load(); calls != 2 {
+		t.Errorf("provider called %d times after the TTL, want 2", calls)
+	}
+}
+
+func TestTokenCacheNegativeCaching(t *testing.T) {
+	c, advance := newTestTokenCache(config.TokenCacheConfig{TTL: time.Minute, NegativeTTL: 5 * time.Second})
+	p := &countingProvider{}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get("revoked", p.ValidateToken); !errors.Is(err, errTokenRejected) {
+			t.Fatalf("got %v, want a rejected token", err)
+		}
+	}
+	if calls := p.calls.Load(); calls != 1 {
+		t.Errorf("provider called %d times for a rejected token, want 1", calls)
+	}
+
+	// Rejections expire after the shorter negative TTL
+	advance(5 * time.Second)
+	c.Get("revoked", p.ValidateToken)
+	if calls := p.calls.Load(); calls != 2 {
+		t.Errorf("provider called %d times after the negative TTL, want 2", calls)
+	}
+
+	// Other failures, such as network errors, are not cached
+	p.calls.Store(0)
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get("unreachable", p.ValidateToken); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	if calls := p.calls.Load(); calls != 2 {
+		t.Errorf("provider called %d times for a failing lookup, want 2", calls)
+	}
+}
+
+func TestTokenCacheEviction(t *testing.T) {
+	c, _ := newTestTokenCache(config.TokenCacheConfig{MaxEntries: 2})
+	p := &countingProvider{}
+
+	c.Get("valid-alice", p.ValidateToken)
+	c.Get("valid-bob", p.ValidateToken)
+	c.Get("valid-alice", p.ValidateToken)
+	c.Get("valid-carol", p.ValidateToken)
+
+	// bob was the least recently used and is looked up again
+	p.calls.Store(0)
+	c.Get("valid-alice", p.ValidateToken)
+	c.Get("valid-bob", p.ValidateToken)
+	if calls := p.calls.Load(); calls != 1 {
+		t.Errorf("provider called %d times, want 1 for the evicted token", calls)
+	}
+}
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+// pathRewriter rewrites the request path sent upstream. Prefix rewrites are
+// reversible, so they're also undone on Location and Set-Cookie paths.
+type pathRewriter struct {
+	// publicPrefix is the route's path prefix, may contain ${user}
+	publicPrefix string
+	// upstreamPrefix replaces publicPrefix, empty to strip it
+	upstreamPrefix string
+	prefix         bool
+
+	regex       *regexp.Regexp
+	replacement string
+}
+
+// prefixMapping maps one request's public prefix to its upstream prefix.
+// Neither has a trailing slash.
+type prefixMapping struct {
+	public   string
+	upstream string
+}
+
+// newPathRewriter returns the rewriter for a route, nil if it doesn't rewrite
+func newPathRewriter(route config.Route) (*pathRewriter, error) {
+	prefix := route.StripPrefix || route.RewritePrefix != ""
+	if !prefix && route.RewriteRegex == nil {
+		return nil, nil
+	}
+
+	if prefix && route.RewriteRegex != nil {
+		return nil, fmt.Errorf("rewriteRegex can't be combined with stripPrefix or rewritePrefix")
+	}
+
+	pr := &pathRewriter{}
+	if prefix {
+		if route.PathType != "" && route.PathType != config.PathTypePrefix {
+			return nil, fmt.Errorf("stripPrefix and rewritePrefix require pathType Prefix")
+		}
+		if route.RewritePrefix != "" && !strings.HasPrefix(route.RewritePrefix, "/") {
+			return nil, fmt.Errorf("rewritePrefix must start with /")
+		}
+		pr.prefix = true
+		pr.publicPrefix = strings.TrimSuffix(route.PathPrefix, "/")
+		pr.upstreamPrefix = strings.TrimSuffix(route.RewritePrefix, "/")
+		return pr, nil
+	}
+
+	re, err := regexp.Compile(route.RewriteRegex.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rewriteRegex pattern: %w", err)
+	}
+	pr.regex = re
+	pr.replacement = route.RewriteRegex.Replacement
+	return pr, nil
+}
+
+// Wrap rewrites the request path before calling next
+func (pr *pathRewriter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.Clone(r.Context())
+
+		if pr.regex != nil {
+			r.URL.Path = pr.regex.ReplaceAllString(r.URL.Path, pr.replacement)
+			r.URL.RawPath = ""
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		publicPrefix := pr.publicPrefix
+		if user := userFromRequest(r); user != nil {
+			var err error
+			if publicPrefix, err = expandUserPath(publicPrefix, user.Username); err != nil {
+				log.Printf("Denying %s access to %s: %v", user.Username, r.URL.Path, err)
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+		}
+
+		// Work on the escaped path so encoded characters such as %2F survive
+		escapedPrefix := (&url.URL{Path: publicPrefix}).EscapedPath()
+		rest, ok := strings.CutPrefix(r.URL.EscapedPath(), escapedPrefix)
+		if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+			http.NotFound(w, r)
+			return
+		}
+
+		escaped := (&url.URL{Path: pr.upstreamPrefix}).EscapedPath() + rest
+		if escaped == "" {
+			escaped = "/"
+		}
+		path, err := url.PathUnescape(escaped)
+		if err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		r.URL.Path, r.URL.RawPath = path, escaped
+
+		// Tell upstreams where they're mounted
+		r.Header.Set("X-Forwarded-Prefix", publicPrefix)
+
+		mapping := prefixMapping{public: publicPrefix, upstream: pr.upstreamPrefix}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), prefixMappingContextKey, mapping)))
+	})
+}
+
+// rewriteResponse maps upstream paths in Location and Set-Cookie back to
+// public paths. Used as the reverse proxy's ModifyResponse.
+func rewriteResponse(resp *http.Response) error {
+	mapping, ok := resp.Request.Context().Value(prefixMappingContextKey).(prefixMapping)
+	if !ok {
+		return nil
+	}
+
+	if location := resp.Header.Get("Location"); location != "" {
+		resp.Header.Set("Location", mapping.rewriteLocation(location, resp.Request.URL.Host, resp.Request.Host))
+	}
+
+	if cookies := resp.Header.Values("Set-Cookie"); len(cookies) > 0 {
+		resp.Header.Del("Set-Cookie")
+		for _, cookie := range cookies {
+			resp.Header.Add("Set-Cookie", mapping.rewriteCookiePath(cookie))
+		}
+	}
+	return nil
+}
+
+// toPublic maps an upstream path to its public path
+func (m prefixMapping) toPublic(path string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, m.upstream)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return "", false
+	}
+	return m.public + rest, true
+}
+
+// rewriteLocation rewrites redirects to the upstream's own paths. Absolute
+// redirects to the upstream, by its address or the forwarded Host header,
+// become relative so they stay on the gateway.
+func (m prefixMapping) rewriteLocation(location string, upstreamHosts ...string) string {
+	u, err := url.Parse(location)
+	if err != nil || (u.Host != "" && !slices.Contains(upstreamHosts, u.Host)) {
+		return location
+	}
+
+	// Relative paths resolve against the public URL already
+	if u.Host == "" && !strings.HasPrefix(u.Path, "/") {
+		return location
+	}
+
+	path, ok := m.toPublic(u.Path)
+	if !ok {
+		return location
+	}
+	if path == "" {
+		path = "/"
+	}
+	u.Scheme, u.Host, u.Path, u.RawPath = "", "", path, ""
+	return u.String()
+}
+
+// rewriteCookiePath rewrites the Path attribute of a Set-Cookie header,
+// leaving every other attribute untouched
+func (m prefixMapping) rewriteCookiePath(cookie string) string {
+	attrs := strings.Split(cookie, ";")
+	// attrs[0] is the cookie's name and value
+	for i := 1; i < len(attrs); i++ {
+		name, value, ok := strings.Cut(strings.TrimSpace(attrs[i]), "=")
+		if !ok || !strings.EqualFold(name, "path") {
+			continue
+		}
+
+		path, ok := m.toPublic(value)
+		if !ok {
+			continue
+		}
+		// A cookie for the upstream's root covers the whole public prefix
+		if value == m.upstream+"/" || value == m.upstream {
+			path = m.public
+		}
+		if path == "" {
+			path = "/"
+		}
+		attrs[i] = " " + name + "=" + path
+	}
+	return strings.Join(attrs, ";")
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jctanner/odh-gateway/internal/proxy/providers"
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+func TestPathRewriterPrefix(t *testing.T) {
+	tests := []struct {
+		route    config.Route
+		user     string
+		target   string
+		path     string // path seen by the upstream, escaped
+		prefix   string // X-Forwarded-Prefix
+		notFound bool
+	}{
+		{route: config.Route{PathPrefix: "/mlflow/", StripPrefix: true}, target: "/mlflow/api/runs", path: "/api/runs", prefix: "/mlflow"},
+		{route: config.Route{PathPrefix: "/mlflow/", StripPrefix: true}, target: "/mlflow", path: "/", prefix: "/mlflow"},
+		{route: config.Route{PathPrefix: "/mlflow/", StripPrefix: true}, target: "/mlflow/a%2Fb?x=1", path: "/a%2Fb", prefix: "/mlflow"},
+		{route: config.Route{PathPrefix: "/mlflow", StripPrefix: true}, target: "/mlflowx/api", notFound: true},
+		{route: config.Route{PathPrefix: "/models/", RewritePrefix: "/v2/models/"}, target: "/models/iris/infer", path: "/v2/models/iris/infer", prefix: "/models"},
+		{route: config.Route{PathPrefix: "/models/", RewritePrefix: "/v2/"}, target: "/models", path: "/v2", prefix: "/models"},
+		{route: config.Route{PathPrefix: "/notebooks/${user}/", StripPrefix: true}, user: "alice", target: "/notebooks/alice/lab", path: "/lab", prefix: "/notebooks/alice"},
+		{route: config.Route{PathPrefix: "/notebooks/${user}/", StripPrefix: true}, user: "alice", target: "/notebooks/bob/lab", notFound: true},
+	}
+
+	for _, tt := range tests {
+		rewriter, err := newPathRewriter(tt.route)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.route.PathPrefix, err)
+		}
+
+		var upstream *http.Request
+		handler := rewriter.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { upstream = r }))
+
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.user != "" {
+			req = req.WithContext(context.WithValue(req.Context(), userContextKey, &providers.UserInfo{Username: tt.user}))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if tt.notFound {
+			if rec.Code != http.StatusNotFound || upstream != nil {
+				t.Errorf("%s: got %d, want 404", tt.target, rec.Code)
+			}
+			continue
+		}
+		if upstream == nil {
+			t.Errorf("%s: not forwarded, got %d", tt.target, rec.Code)
+			continue
+		}
+		if path := upstream.URL.EscapedPath(); path != tt.path {
+			t.Errorf("%s: forwarded as %q, want %q", tt.target, path, tt.path)
+		}
+		if prefix := upstream.Header.Get("X-Forwarded-Prefix"); prefix != tt.prefix {
+			t.Errorf("%s: X-Forwarded-Prefix %q, want %q", tt.target, prefix, tt.prefix)
+		}
+	}
+}
+
+func TestRewriteLocation(t *testing.T) {
+	stripped := prefixMapping{public: "/mlflow", upstream: ""}
+	rewritten := prefixMapping{public: "/models", upstream: "/v2"}
+
+	tests := []struct {
+		mapping  prefixMapping
+		location string
+		want     string
+	}{
+		// Absolute paths on the upstream get the public prefix back
+		{stripped, "/login?next=%2F", "/mlflow/login?next=%2F"},
+		{stripped, "/", "/mlflow/"},
+		{rewritten, "/v2/iris", "/models/iris"},
+		{rewritten, "/v2", "/models"},
+		// Paths outside the upstream prefix are left alone
+		{rewritten, "/v3/iris", "/v3/iris"},
+		{rewritten, "/v2x", "/v2x"},
+		// Relative paths already resolve against the public URL
+		{stripped, "login", "login"},
+		{stripped, "../runs#latest", "../runs#latest"},
+		// Absolute URLs to the upstream, by address or forwarded host, stay on the gateway
+		{stripped, "http://mlflow.svc:5000/runs?id=1", "/mlflow/runs?id=1"},
+		{stripped, "https://gateway.example.com/runs", "/mlflow/runs"},
+		// Other hosts are left alone
+		{stripped, "https://idp.example.com/authorize", "https://idp.example.com/authorize"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.mapping.rewriteLocation(tt.location, "mlflow.svc:5000", "gateway.example.com"); got != tt.want {
+			t.Errorf("%+v: Location %q rewritten to %q, want %q", tt.mapping, tt.location, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteCookiePath(t *testing.T) {
+	stripped := prefixMapping{public: "/mlflow", upstream: ""}
+	rewritten := prefixMapping{public: "/models", upstream: "/v2"}
+
+	tests := []struct {
+		mapping prefixMapping
+		cookie  string
+		want    string
+	}{
+		{stripped, "session=abc; Path=/; HttpOnly", "session=abc; Path=/mlflow; HttpOnly"},
+		{stripped, "session=abc; Path=/api; Secure", "session=abc; Path=/mlflow/api; Secure"},
+		{stripped, "session=abc; path=/api", "session=abc; path=/mlflow/api"},
+		{rewritten, "token=x; Path=/v2/", "token=x; Path=/models"},
+		{rewritten, "token=x; Path=/v2/iris; SameSite=Lax", "token=x; Path=/models/iris; SameSite=Lax"},
+		// Paths outside the upstream prefix and cookies without a path are left alone
+		{rewritten, "token=x; Path=/other", "token=x; Path=/other"},
+		{rewritten, "token=x; Max-Age=60", "token=x; Max-Age=60"},
+		// The cookie value isn't mistaken for an attribute
+		{stripped, "path=/; Path=/", "path=/; Path=/mlflow"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.mapping.rewriteCookiePath(tt.cookie); got != tt.want {
+			t.Errorf("%+v: Set-Cookie %q rewritten to %q, want %q", tt.mapping, tt.cookie, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteRoundTrip(t *testing.T) {
+	var upstreamPath string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+		http.Redirect(w, r, "/login", http.StatusFound)
+	}))
+	defer backend.Close()
+
+	handler, err := newRouteHandler(config.Route{PathPrefix: "/mlflow/", StripPrefix: true, Upstream: backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/mlflow/experiments", nil))
+
+	if upstreamPath != "/experiments" {
+		t.Errorf("upstream got %q, want /experiments", upstreamPath)
+	}
+	if location := rec.Header().Get("Location"); location != "/mlflow/login" {
+		t.Errorf("Location %q, want /mlflow/login", location)
+	}
+	if cookie := rec.Header().Get("Set-Cookie"); cookie != "session=abc; Path=/mlflow" {
+		t.Errorf("Set-Cookie %q, want the path under /mlflow", cookie)
+	}
+}
@@ -0,0 +1,349 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+// Router dispatches requests to the first matching route. Routes are ordered by
+// priority, then specificity (host, path, other matchers), then the order they
+// were added in.
+type Router struct {
+	// Gateway endpoints such as /auth/callback, matched by exact path before any route
+	endpoints map[string]http.Handler
+	routes    []*compiledRoute
+}
+
+type compiledRoute struct {
+	route   config.Route
+	handler http.Handler
+	order   int
+
+	pathType string
+	// Prefix without trailing slash for Prefix routes, the full path for Exact routes
+	path string
+	// redirectSlash redirects requests for path to path + "/", as the
+	// ServeMux did for prefixes configured with a trailing slash
+	redirectSlash bool
+	pathRegex     *regexp.Regexp
+
+	hosts       []string
+	methods     []string
+	headers     []valueMatcher
+	queryParams []valueMatcher
+}
+
+type valueMatcher struct {
+	name      string
+	matchType string
+	value     string
+	regex     *regexp.Regexp
+}
+
+// NewRouter creates an empty router
+func NewRouter() *Router {
+	return &Router{endpoints: make(map[string]http.Handler)}
+}
+
+// HandleEndpoint registers a gateway endpoint at an exact path
+func (rt *Router) HandleEndpoint(path string, handler http.Handler) {
+	rt.endpoints[path] = handler
+}
+
+// Add compiles a route's matchers and adds it to the router
+func (rt *Router) Add(route config.Route, handler http.Handler) error {
+	cr := &compiledRoute{
+		route:    route,
+		handler:  handler,
+		order:    len(rt.routes),
+		pathType: route.PathType,
+	}
+	if cr.pathType == "" {
+		cr.pathType = config.PathTypePrefix
+	}
+
+	switch cr.pathType {
+	case config.PathTypePrefix:
+		prefix := route.PathPrefix
+		// Per-user paths are matched up to ${user}, the middleware checks the rest
+		if i := strings.Index(prefix, config.UserPlaceholder); i >= 0 {
+			if !strings.HasSuffix(prefix[:i], "/") || !strings.HasPrefix(prefix[i+len(config.UserPlaceholder):]+"/", "/") {
+				return fmt.Errorf("%s must be a whole path segment", config.UserPlaceholder)
+			}
+			prefix = prefix[:i]
+		}
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("path must start with /")
+		}
+		cr.redirectSlash = strings.HasSuffix(prefix, "/") && prefix != "/"
+		cr.path = strings.TrimSuffix(prefix, "/")
+
+	case config.PathTypeExact:
+		if !strings.HasPrefix(route.PathPrefix, "/") {
+			return fmt.Errorf("path must start with /")
+		}
+		cr.path = route.PathPrefix
+
+	case config.PathTypeRegex:
+		re, err := regexp.Compile("^(?:" + route.PathPrefix + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid path regex: %w", err)
+		}
+		cr.pathRegex = re
+
+	default:
+		return fmt.Errorf("unknown pathType %q, must be Prefix, Exact or Regex", route.PathType)
+	}
+
+	if cr.pathType != config.PathTypePrefix && strings.Contains(route.PathPrefix, config.UserPlaceholder) {
+		return fmt.Errorf("%s is only supported in Prefix paths", config.UserPlaceholder)
+	}
+
+	for _, host := range route.Hosts {
+		host = strings.ToLower(host)
+		if strings.Contains(host, "*") && (!strings.HasPrefix(host, "*.") || strings.Count(host, "*") > 1) {
+			return fmt.Errorf("invalid host %q, wildcards must be a leading *.", host)
+		}
+		cr.hosts = append(cr.hosts, host)
+	}
+
+	for _, method := range route.Methods {
+		cr.methods = append(cr.methods, strings.ToUpper(method))
+	}
+
+	var err error
+	if cr.headers, err = compileValueMatchers(route.Headers, true); err != nil {
+		return fmt.Errorf("invalid header matcher: %w", err)
+	}
+	if cr.queryParams, err = compileValueMatchers(route.QueryParams, false); err != nil {
+		return fmt.Errorf("invalid query parameter matcher: %w", err)
+	}
+
+	rt.routes = append(rt.routes, cr)
+	sort.SliceStable(rt.routes, func(i, j int) bool {
+		return rt.routes[i].precedes(rt.routes[j])
+	})
+	return nil
+}
+
+func compileValueMatchers(matchers []config.ValueMatcher, canonicalHeader bool) ([]valueMatcher, error) {
+	var compiled []valueMatcher
+	for _, m := range matchers {
+		if m.Name == "" {
+			return nil, fmt.Errorf("name is required")
+		}
+
+		vm := valueMatcher{name: m.Name, matchType: m.Type, value: m.Value}
+		if canonicalHeader {
+			vm.name = http.CanonicalHeaderKey(m.Name)
+		}
+		if vm.matchType == "" {
+			vm.matchType = config.MatchExact
+		}
+
+		switch vm.matchType {
+		case config.MatchExact, config.MatchPresent:
+		case config.MatchRegex:
+			re, err := regexp.Compile("^(?:" + m.Value + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", m.Name, err)
+			}
+			vm.regex = re
+		default:
+			return nil, fmt.Errorf("%s: unknown type %q, must be Exact, Regex or Present", m.Name, m.Type)
+		}
+		compiled = append(compiled, vm)
+	}
+	return compiled, nil
+}
+
+// precedes orders routes by priority, then specificity, then insertion order
+func (cr *compiledRoute) precedes(other *compiledRoute) bool {
+	if cr.route.Priority != other.route.Priority {
+		return cr.route.Priority > other.route.Priority
+	}
+
+	// Exact hosts before wildcards before any host
+	if rank, otherRank := cr.hostRank(), other.hostRank(); rank != otherRank {
+		return rank > otherRank
+	}
+
+	// Exact paths before regexes before prefixes, longer prefixes first
+	if rank, otherRank := pathTypeRank(cr.pathType), pathTypeRank(other.pathType); rank != otherRank {
+		return rank > otherRank
+	}
+	if cr.pathType == config.PathTypePrefix && len(cr.path) != len(other.path) {
+		return len(cr.path) > len(other.path)
+	}
+
+	if n, otherN := cr.matcherCount(), other.matcherCount(); n != otherN {
+		return n > otherN
+	}
+
+	return cr.order < other.order
+}
+
+func pathTypeRank(pathType string) int {
+	switch pathType {
+	case config.PathTypeExact:
+		return 2
+	case config.PathTypeRegex:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (cr *compiledRoute) hostRank() int {
+	rank := 0
+	for _, host := range cr.hosts {
+		if strings.HasPrefix(host, "*.") {
+			rank = max(rank, 1)
+		} else {
+			rank = 2
+		}
+	}
+	return rank
+}
+
+func (cr *compiledRoute) matcherCount() int {
+	n := len(cr.headers) + len(cr.queryParams)
+	if len(cr.methods) > 0 {
+		n++
+	}
+	return n
+}
+
+// ServeHTTP dispatches to the first matching route
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Redirect to the clean path like the ServeMux did, so dot segments and
+	// duplicate slashes can't get around endpoints, routes or owner checks
+	if r.Method != http.MethodConnect {
+		if clean := cleanPath(r.URL.Path); clean != r.URL.Path {
+			u := url.URL{Path: clean, RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+			return
+		}
+	}
+
+	if handler, ok := rt.endpoints[r.URL.Path]; ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	host := requestHost(r)
+	for _, cr := range rt.routes {
+		if !cr.matchesRequest(r, host) {
+			continue
+		}
+
+		if cr.redirectSlash && r.URL.Path == cr.path {
+			u := url.URL{Path: r.URL.Path + "/", RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+			return
+		}
+
+		cr.handler.ServeHTTP(w, r)
+		return
+	}
+
+	http.NotFound(w, r)
+}
+
+// cleanPath returns the canonical path: rooted, without dot segments or
+// duplicate slashes, keeping a trailing slash
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// requestHost returns the lowercased request host without port
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func (cr *compiledRoute) matchesRequest(r *http.Request, host string) bool {
+	if !cr.matchesPath(r.URL.Path) {
+		return false
+	}
+
+	if len(cr.hosts) > 0 && !slices.ContainsFunc(cr.hosts, func(pattern string) bool { return matchHost(pattern, host) }) {
+		return false
+	}
+
+	if len(cr.methods) > 0 && !slices.Contains(cr.methods, r.Method) {
+		return false
+	}
+
+	for _, m := range cr.headers {
+		values, ok := r.Header[m.name]
+		if !ok || !m.matches(strings.Join(values, ",")) {
+			return false
+		}
+	}
+
+	if len(cr.queryParams) > 0 {
+		query := r.URL.Query()
+		for _, m := range cr.queryParams {
+			if !query.Has(m.name) || !m.matches(query.Get(m.name)) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (cr *compiledRoute) matchesPath(path string) bool {
+	switch cr.pathType {
+	case config.PathTypeExact:
+		return path == cr.path
+	case config.PathTypeRegex:
+		return cr.pathRegex.MatchString(path)
+	default:
+		// Prefixes match whole path segments: /api matches /api and /api/v1, not /apis
+		return cr.path == "" || path == cr.path || strings.HasPrefix(path, cr.path+"/")
+	}
+}
+
+// matchHost matches a host against an exact hostname or a *.domain wildcard,
+// which matches one or more labels in front of domain
+func matchHost(pattern, host string) bool {
+	if domain, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, domain) && len(host) > len(domain)
+	}
+	return host == pattern
+}
+
+func (m valueMatcher) matches(value string) bool {
+	switch m.matchType {
+	case config.MatchPresent:
+		return true
+	case config.MatchRegex:
+		return m.regex.MatchString(value)
+	default:
+		return value == m.value
+	}
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+// named returns a handler that writes name, to tell which route matched
+func named(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	})
+}
+
+func newTestRouter(t *testing.T, routes ...config.Route) *Router {
+	t.Helper()
+	rt := NewRouter()
+	rt.HandleEndpoint("/auth/callback", named("callback"))
+	for _, route := range routes {
+		if err := rt.Add(route, named(route.Upstream)); err != nil {
+			t.Fatalf("adding route %s: %v", route.PathPrefix, err)
+		}
+	}
+	return rt
+}
+
+func serve(rt *Router, method, host, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.Host = host
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRouterCleansPaths(t *testing.T) {
+	rt := newTestRouter(t,
+		config.Route{PathPrefix: "/api/health", Upstream: "health"},
+		config.Route{PathPrefix: "/notebooks/${user}/", Upstream: "notebooks"},
+		config.Route{PathPrefix: "/", Upstream: "fallback"},
+	)
+
+	tests := []struct {
+		target   string
+		location string
+	}{
+		{"/api/health/../secret", "/api/secret"},
+		{"/notebooks/alice/../bob/", "/notebooks/bob/"},
+		{"/notebooks/alice/%2E%2E/bob/lab", "/notebooks/bob/lab"},
+		{"//api/health", "/api/health"},
+		{"/api/./health?verbose=1", "/api/health?verbose=1"},
+		{"/auth/x/../callback", "/auth/callback"},
+	}
+
+	for _, tt := range tests {
+		rec := serve(rt, http.MethodGet, "gateway.example.com", tt.target)
+		if rec.Code != http.StatusMovedPermanently {
+			t.Errorf("%s: got status %d, want a redirect", tt.target, rec.Code)
+			continue
+		}
+		if location := rec.Header().Get("Location"); location != tt.location {
+			t.Errorf("%s: redirected to %q, want %q", tt.target, location, tt.location)
+		}
+	}
+
+	// Clean paths are routed, keeping their trailing slash
+	if body := serve(rt, http.MethodGet, "gateway.example.com", "/notebooks/bob/").Body.String(); body != "notebooks" {
+		t.Errorf("/notebooks/bob/: routed to %q", body)
+	}
+}
+
+func TestRouterPriority(t *testing.T) {
+	rt := newTestRouter(t,
+		config.Route{PathPrefix: "/", Upstream: "fallback"},
+		config.Route{PathPrefix: "/api", Upstream: "api"},
+		config.Route{PathPrefix: "/api/v1", Upstream: "v1"},
+		config.Route{PathPrefix: "/api/v1/status", PathType: config.PathTypeExact, Upstream: "status"},
+		config.Route{PathPrefix: "/api/v[0-9]+/jobs", PathType: config.PathTypeRegex, Upstream: "jobs"},
+		config.Route{PathPrefix: "/api", Methods: []string{"post"}, Upstream: "api-post"},
+		config.Route{PathPrefix: "/", Priority: 10, Headers: []config.ValueMatcher{{Name: "x-canary", Value: "true"}}, Upstream: "canary"},
+	)
+
+	tests := []struct {
+		method string
+		target string
+		want   string
+	}{
+		{http.MethodGet, "/", "fallback"},
+		{http.MethodGet, "/apis", "fallback"},
+		{http.MethodGet, "/api", "api"},
+		{http.MethodGet, "/api/v2", "api"},
+		{http.MethodPost, "/api/v2", "api-post"},
+		{http.MethodGet, "/api/v1/models", "v1"},
+		{http.MethodGet, "/api/v1/status", "status"},
+		{http.MethodGet, "/api/v1/jobs", "jobs"},
+		{http.MethodGet, "/auth/callback", "callback"},
+	}
+
+	for _, tt := range tests {
+		if body := serve(rt, tt.method, "gateway.example.com", tt.target).Body.String(); body != tt.want {
+			t.Errorf("%s %s: routed to %q, want %q", tt.method, tt.target, body, tt.want)
+		}
+	}
+
+	// Higher priority wins over more specific routes
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/status", nil)
+	req.Header.Set("X-Canary", "true")
+	rec := httptest.NewRecorder()
+	rt.ServeHTTP(rec, req)
+	if body := rec.Body.String(); body != "canary" {
+		t.Errorf("canary request routed to %q", body)
+	}
+}
+
+func TestRouterHosts(t *testing.T) {
+	rt := newTestRouter(t,
+		config.Route{PathPrefix: "/", Upstream: "any"},
+		config.Route{PathPrefix: "/", Hosts: []string{"*.apps.example.com"}, Upstream: "wildcard"},
+		config.Route{PathPrefix: "/", Hosts: []string{"Dashboard.apps.example.com"}, Upstream: "dashboard"},
+	)
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"dashboard.apps.example.com", "dashboard"},
+		{"DASHBOARD.apps.example.com:8443", "dashboard"},
+		{"dashboard.apps.example.com.", "dashboard"},
+		{"notebooks.apps.example.com", "wildcard"},
+		{"a.b.apps.example.com", "wildcard"},
+		{"apps.example.com", "any"},
+		{"evilapps.example.com", "any"},
+	}
+
+	for _, tt := range tests {
+		if body := serve(rt, http.MethodGet, tt.host, "/").Body.String(); body != tt.want {
+			t.Errorf("%s: routed to %q, want %q", tt.host, body, tt.want)
+		}
+	}
+
+	if err := NewRouter().Add(config.Route{PathPrefix: "/", Hosts: []string{"apps.*.com"}}, named("")); err == nil {
+		t.Error("wildcard outside the first label was accepted")
+	}
+}
+
+func TestRouterSlashRedirect(t *testing.T) {
+	rt := newTestRouter(t,
+		config.Route{PathPrefix: "/jupyter/", Upstream: "jupyter"},
+		config.Route{PathPrefix: "/mlflow", Upstream: "mlflow"},
+	)
+
+	rec := serve(rt, http.MethodGet, "gateway.example.com", "/jupyter?token=x")
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/jupyter/?token=x" {
+		t.Errorf("/jupyter: got %d to %q, want a redirect to /jupyter/?token=x", rec.Code, rec.Header().Get("Location"))
+	}
+
+	if body := serve(rt, http.MethodGet, "gateway.example.com", "/jupyter/lab").Body.String(); body != "jupyter" {
+		t.Errorf("/jupyter/lab: routed to %q", body)
+	}
+
+	// Prefixes configured without a trailing slash match without redirecting
+	if body := serve(rt, http.MethodGet, "gateway.example.com", "/mlflow").Body.String(); body != "mlflow" {
+		t.Errorf("/mlflow: routed to %q", body)
+	}
+
+	if rec := serve(rt, http.MethodGet, "gateway.example.com", "/jupyterlab"); rec.Code != http.StatusNotFound {
+		t.Errorf("/jupyterlab: got %d, want 404", rec.Code)
+	}
+}
@@ -9,11 +9,12 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
 	"github.com/jctanner/odh-gateway/internal/proxy/providers"
 	"github.com/jctanner/odh-gateway/pkg/config"
 )
@@ -23,10 +24,19 @@ var (
 	router         http.Handler
 	authProvider   providers.AuthProvider
 	authMiddleware *AuthMiddleware
+	sessions       *SessionManager
+	identitySigner *IdentitySigner
+
+	// reloadMu serializes config reloads and router rebuilds
+	reloadMu sync.Mutex
+	// fileRoutes are the config file's routes, kept to rebuild the router
+	// when a route source changes
+	fileRoutes   []config.Route
+	routeSources []routeSource
 )
 
 // StartServer starts the reverse proxy with hot-reload and request logging
-func StartServer(tlsCertFile, tlsKeyFile string, providerConfig providers.ProviderConfig) error {
+func StartServer(tlsCertFile, tlsKeyFile, metricsPort string, providerConfig providers.ProviderConfig, sessionConfig config.SessionConfig, identityConfig config.IdentityAssertionConfig, sourceConfig config.KubernetesSourceConfig) error {
 	cfgPath := os.Getenv("GATEWAY_CONFIG")
 	if cfgPath == "" {
 		cfgPath = "/etc/odh-gateway/config.yaml"
@@ -42,15 +52,41 @@ func StartServer(tlsCertFile, tlsKeyFile string, providerConfig providers.Provid
 		}
 	}
 
-	// Initialize authentication provider
+	// Sessions outlive provider reloads
 	var err error
+	sessions, err = NewSessionManager(sessionConfig, NewMemorySessionStore())
+	if err != nil {
+		return fmt.Errorf("failed to create session manager: %w", err)
+	}
+
+	identitySigner, err = NewIdentitySigner(identityConfig, getBaseURL())
+	if err != nil {
+		return fmt.Errorf("failed to create identity signer: %w", err)
+	}
+
+	// Initialize authentication provider
 	authProvider, err = providers.CreateProvider(providerConfig, getBaseURL())
 	if err != nil {
 		return fmt.Errorf("failed to create auth provider: %w", err)
 	}
 
 	// Initialize auth middleware
-	authMiddleware = NewAuthMiddleware(authProvider)
+	authMiddleware = NewAuthMiddleware(authProvider, sessions, identitySigner)
+
+	if sourceConfig.Services {
+		source, err := StartServiceSource(sourceConfig, rebuildRouter)
+		if err != nil {
+			return fmt.Errorf("failed to start service source: %w", err)
+		}
+		routeSources = append(routeSources, source)
+	}
+	if sourceConfig.GatewayClassName != "" {
+		source, err := StartGatewaySource(sourceConfig, rebuildRouter)
+		if err != nil {
+			return fmt.Errorf("failed to start Gateway API source: %w", err)
+		}
+		routeSources = append(routeSources, source)
+	}
 
 	if err := reloadConfig(cfgPath, providerConfig); err != nil {
 		return err
@@ -59,8 +95,15 @@ func StartServer(tlsCertFile, tlsKeyFile string, providerConfig providers.Provid
 	go watchConfig(cfgPath, providerConfig)
 	go pollConfig(cfgPath, providerConfig)
 
+	// Serve metrics on a separate port so they can't shadow an upstream route
+	if metricsPort != "" {
+		go serveMetrics(metricsPort)
+	}
+
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("%s %s %s", r.RemoteAddr, r.Method, r.URL)
+		// Never pass on identities claimed by the client, on any route
+		stripIdentityHeaders(r)
 		mu.RLock()
 		defer mu.RUnlock()
 		router.ServeHTTP(w, r)
@@ -84,8 +127,22 @@ func StartServer(tlsCertFile, tlsKeyFile string, providerConfig providers.Provid
 	}
 }
 
-// reloadConfig builds the routing mux and updates the global router
+// serveMetrics serves Prometheus metrics on /metrics
+func serveMetrics(port string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	log.Printf("Serving metrics on :%s/metrics", port)
+	if err := http.ListenAndServe(":"+port, mux); err != nil {
+		log.Printf("Metrics server failed: %v", err)
+	}
+}
+
+// reloadConfig reads the config file, updates the auth provider and rebuilds the router
 func reloadConfig(path string, fallbackProviderConfig providers.ProviderConfig) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
 	// Force a fresh read by resolving the symlink
 	resolvedPath, err := filepath.EvalSymlinks(path)
 	if err != nil {
@@ -116,60 +173,84 @@ func reloadConfig(path string, fallbackProviderConfig providers.ProviderConfig)
 			log.Printf("Failed to create new provider: %v", err)
 		} else {
 			authProvider = newProvider
-			authMiddleware = NewAuthMiddleware(authProvider)
+			authMiddleware = NewAuthMiddleware(authProvider, sessions, identitySigner)
 			log.Printf("Auth provider updated: %s (enabled: %v)", authProvider.Name(), authProvider.IsEnabled())
 		}
 	}
 
-	mux := http.NewServeMux()
+	fileRoutes = cfg.Routes
+	buildRouter()
+	return nil
+}
+
+// rebuildRouter rebuilds the router after a route source changed
+func rebuildRouter() {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	buildRouter()
+}
+
+// buildRouter builds the router from the config file and route sources and
+// updates the global router. Callers must hold reloadMu.
+func buildRouter() {
+	healthStates.nextGeneration()
+	routes := NewRouter()
 
 	// Register auth endpoints if provider is enabled
 	if authProvider != nil && authProvider.IsEnabled() {
-		mux.Handle("/auth/callback", authMiddleware.HandleCallback())
-		mux.Handle("/auth/logout", authMiddleware.HandleLogout())
-		mux.Handle("/auth/login", authMiddleware.HandleLogin())
+		routes.HandleEndpoint("/auth/callback", authMiddleware.HandleCallback())
+		routes.HandleEndpoint("/auth/logout", authMiddleware.HandleLogout())
+		routes.HandleEndpoint("/auth/login", authMiddleware.HandleLogin())
+	}
+
+	if identitySigner != nil {
+		routes.HandleEndpoint(jwksPath, identitySigner.HandleJWKS())
 	}
 
-	for _, route := range cfg.Routes {
+	// The config file overlays the route sources: its routes replace source
+	// routes with the same path and hosts
+	routeList := fileRoutes
+	fileKeys := make(map[string]bool, len(fileRoutes))
+	for _, route := range fileRoutes {
+		fileKeys[overlayKey(route)] = true
+	}
+	for _, source := range routeSources {
+		for _, route := range source.Routes() {
+			if fileKeys[overlayKey(route)] {
+				continue
+			}
+			routeList = append(routeList, route)
+		}
+	}
+
+	for _, route := range routeList {
 		if route.PathPrefix == "" {
-			log.Printf("Skipping route with empty path prefix")
+			log.Printf("Skipping route with empty path")
 			continue
 		}
 
-		target, err := url.Parse(route.Upstream)
+		routeHandler, err := newRouteHandler(route)
 		if err != nil {
-			log.Printf("Invalid upstream URL %q: %v", route.Upstream, err)
+			log.Printf("Skipping route %q: %v", route.PathPrefix, err)
 			continue
 		}
 
-		prefix := route.PathPrefix
-		// Ensure the prefix ends with a slash so subpaths are matched
-		if !strings.HasSuffix(prefix, "/") {
-			prefix += "/"
-		}
-
-		proxy := httputil.NewSingleHostReverseProxy(target)
-
-		// Create the handler for this route
-		routeHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if strings.HasPrefix(r.URL.Path, prefix) {
-				proxy.ServeHTTP(w, r)
-			} else {
-				http.NotFound(w, r)
-			}
-		})
-
 		// Wrap with auth middleware if available
 		var finalHandler http.Handler = routeHandler
 		if authMiddleware != nil {
-			finalHandler = authMiddleware.Middleware(route.AuthRequired)(routeHandler)
+			finalHandler = authMiddleware.Middleware(route)(routeHandler)
 		}
 
-		mux.Handle(prefix, finalHandler)
+		if err := routes.Add(route, finalHandler); err != nil {
+			log.Printf("Skipping route %q: %v", route.PathPrefix, err)
+			continue
+		}
 
 		authStatus := "no auth"
 		if authProvider != nil && authProvider.IsEnabled() {
-			if route.AuthRequired != nil {
+			if route.HasAuthorization() {
+				authStatus = "auth required, restricted"
+			} else if route.AuthRequired != nil {
 				if *route.AuthRequired {
 					authStatus = "auth required"
 				} else {
@@ -180,13 +261,103 @@ func reloadConfig(path string, fallbackProviderConfig providers.ProviderConfig)
 			}
 		}
 
-		log.Printf("Routing %s -> %s (%s)", prefix, route.Upstream, authStatus)
+		log.Printf("Routing %s -> %s (%s)", describeMatch(route), describeTarget(route), authStatus)
 	}
 
 	mu.Lock()
-	router = mux
+	router = routes
 	mu.Unlock()
-	return nil
+
+	// Stop checking upstreams the new router doesn't use
+	healthStates.prune()
+}
+
+// validateRoute returns the error newRouteHandler would, without building
+// handlers or touching health state, so routes can be checked outside a reload
+func validateRoute(route config.Route) error {
+	if err := validateHeaderModifier(route.RequestHeaders); err != nil {
+		return fmt.Errorf("invalid requestHeaders: %w", err)
+	}
+	if err := validateHeaderModifier(route.ResponseHeaders); err != nil {
+		return fmt.Errorf("invalid responseHeaders: %w", err)
+	}
+
+	if route.Redirect != nil {
+		_, err := newRedirectHandler(route)
+		return err
+	}
+	if _, err := newPathRewriter(route); err != nil {
+		return err
+	}
+	_, _, _, err := validateUpstreams(route)
+	return err
+}
+
+// newRouteHandler builds the handler serving a route, before authentication
+func newRouteHandler(route config.Route) (http.Handler, error) {
+	if err := validateRoute(route); err != nil {
+		return nil, err
+	}
+
+	var handler http.Handler
+	if route.Redirect != nil {
+		redirect, err := newRedirectHandler(route)
+		if err != nil {
+			return nil, err
+		}
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			applyHeaderModifier(w.Header(), route.ResponseHeaders)
+			redirect.ServeHTTP(w, r)
+		})
+	} else {
+		rewriter, err := newPathRewriter(route)
+		if err != nil {
+			return nil, err
+		}
+
+		handler, err = newUpstreamHandler(route, responseModifier(rewriter != nil && rewriter.prefix, route.ResponseHeaders))
+		if err != nil {
+			return nil, err
+		}
+		if route.RewriteHost != "" {
+			handler = withHost(route.RewriteHost, handler)
+		}
+		if rewriter != nil {
+			handler = rewriter.Wrap(handler)
+		}
+	}
+
+	if route.RequestHeaders != nil {
+		handler = withRequestHeaders(route.RequestHeaders, handler)
+	}
+	return handler, nil
+}
+
+// newReverseProxy creates the proxy for an upstream. modifyResponse may be nil.
+func newReverseProxy(target *url.URL, modifyResponse func(*http.Response) error) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = modifyResponse
+	return proxy
+}
+
+// userUpstreamProxy proxies to an upstream whose URL contains ${user}, expanded
+// with the authenticated user for each request
+func userUpstreamProxy(upstream string, modifyResponse func(*http.Response) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := userFromRequest(r)
+		if user == nil {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		target, err := expandUserURL(upstream, user.Username)
+		if err != nil {
+			log.Printf("Invalid upstream URL for user %s: %v", user.Username, err)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		newReverseProxy(target, modifyResponse).ServeHTTP(w, r)
+	})
 }
 
 func watchConfig(path string, fallbackProviderConfig providers.ProviderConfig) {
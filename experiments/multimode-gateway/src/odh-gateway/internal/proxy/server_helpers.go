@@ -1,9 +1,13 @@
 package proxy
 
 import (
+	"fmt"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/jctanner/odh-gateway/internal/proxy/providers"
+	"github.com/jctanner/odh-gateway/pkg/config"
 )
 
 // shouldUpdateProvider determines if the provider should be updated
@@ -33,3 +37,61 @@ func getBaseURL() string {
 	// Use HTTPS for external hostnames
 	return "https://" + hostname
 }
+
+// overlayKey identifies the routes a config file route replaces
+func overlayKey(route config.Route) string {
+	pathType := route.PathType
+	if pathType == "" {
+		pathType = config.PathTypePrefix
+	}
+	hosts := slices.Clone(route.Hosts)
+	for i := range hosts {
+		hosts[i] = strings.ToLower(hosts[i])
+	}
+	slices.Sort(hosts)
+	return pathType + " " + route.PathPrefix + " " + strings.Join(hosts, ",")
+}
+
+// describeMatch summarizes a route's matchers for logging
+func describeMatch(route config.Route) string {
+	desc := route.PathPrefix
+	if route.PathType != "" && route.PathType != config.PathTypePrefix {
+		desc = fmt.Sprintf("%s %s", strings.ToLower(route.PathType), desc)
+	}
+	if len(route.Hosts) > 0 {
+		desc = strings.Join(route.Hosts, ",") + " " + desc
+	}
+	if len(route.Methods) > 0 {
+		desc = strings.Join(route.Methods, ",") + " " + desc
+	}
+	if n := len(route.Headers) + len(route.QueryParams); n > 0 {
+		desc += fmt.Sprintf(" (+%d matchers)", n)
+	}
+	if route.Priority != 0 {
+		desc += fmt.Sprintf(" [priority %d]", route.Priority)
+	}
+	return desc
+}
+
+// describeTarget summarizes where a route sends requests for logging
+func describeTarget(route config.Route) string {
+	if route.Redirect != nil {
+		return "redirect"
+	}
+	if len(route.Upstreams) == 0 {
+		return route.Upstream
+	}
+
+	targets := make([]string, 0, len(route.Upstreams))
+	for _, upstream := range route.Upstreams {
+		target := upstream.URL
+		if target == "" {
+			target = "<unresolved>"
+		}
+		if upstream.Weight != nil {
+			target += fmt.Sprintf(" (weight %d)", *upstream.Weight)
+		}
+		targets = append(targets, target)
+	}
+	return strings.Join(targets, ", ")
+}
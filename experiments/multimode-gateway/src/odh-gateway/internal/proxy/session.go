@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jctanner/odh-gateway/internal/proxy/providers"
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+const (
+	sessionCookieName = "odh_session"
+
+	defaultSessionTTL           = 24 * time.Hour
+	defaultSessionRefreshWindow = time.Minute
+)
+
+// Session is a browser session. The tokens stay on the server, the browser
+// only holds the encrypted session ID.
+type Session struct {
+	ID        string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+
+	// mu serializes token refreshes
+	mu     sync.Mutex
+	tokens providers.Tokens
+}
+
+// SessionStore stores sessions by ID
+type SessionStore interface {
+	Get(id string) (*Session, bool)
+	Save(session *Session)
+	Delete(id string)
+}
+
+// memorySessionStore keeps sessions in memory
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewMemorySessionStore creates an in-memory session store that drops
+// sessions once they expire
+func NewMemorySessionStore() SessionStore {
+	s := &memorySessionStore{sessions: make(map[string]*Session)}
+	go s.sweep()
+	return s
+}
+
+func (s *memorySessionStore) Get(id string) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || time.Now().After(session.ExpiresAt) {
+		return nil, false
+	}
+	return session, true
+}
+
+func (s *memorySessionStore) Save(session *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[session.ID] = session
+}
+
+func (s *memorySessionStore) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+// sweep removes expired sessions every minute
+func (s *memorySessionStore) sweep() {
+	for {
+		time.Sleep(time.Minute)
+
+		now := time.Now()
+		s.mu.Lock()
+		for id, session := range s.sessions {
+			if now.After(session.ExpiresAt) {
+				delete(s.sessions, id)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// SessionManager issues session cookies and refreshes session tokens
+type SessionManager struct {
+	store         SessionStore
+	aead          cipher.AEAD
+	ttl           time.Duration
+	refreshWindow time.Duration
+}
+
+// NewSessionManager creates a session manager backed by store
+func NewSessionManager(cfg config.SessionConfig, store SessionStore) (*SessionManager, error) {
+	var key []byte
+	if cfg.Secret != "" {
+		sum := sha256.Sum256([]byte(cfg.Secret))
+		key = sum[:]
+	} else {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate session key: %w", err)
+		}
+		log.Printf("No session secret configured, using a random key")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session cipher: %w", err)
+	}
+
+	m := &SessionManager{
+		store:         store,
+		aead:          aead,
+		ttl:           cfg.TTL,
+		refreshWindow: cfg.RefreshWindow,
+	}
+	if m.ttl <= 0 {
+		m.ttl = defaultSessionTTL
+	}
+	if m.refreshWindow <= 0 {
+		m.refreshWindow = defaultSessionRefreshWindow
+	}
+	return m, nil
+}
+
+// Create starts a session holding tokens and sets its cookie
+func (m *SessionManager) Create(w http.ResponseWriter, r *http.Request, tokens *providers.Tokens) error {
+	// A new ID on every login so a session can't be fixed before authentication
+	m.Destroy(w, r)
+
+	id, err := generateRandomString(43)
+	if err != nil {
+		return fmt.Errorf("failed to generate session ID: %w", err)
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:        id,
+		CreatedAt: now,
+		ExpiresAt: now.Add(m.ttl),
+		tokens:    *tokens,
+	}
+	m.store.Save(session)
+
+	value, err := m.seal(id)
+	if err != nil {
+		m.store.Delete(id)
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(m.ttl.Seconds()),
+	})
+	return nil
+}
+
+// Load returns the session referenced by the request's cookie
+func (m *SessionManager) Load(r *http.Request) *Session {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil
+	}
+
+	id, err := m.open(cookie.Value)
+	if err != nil {
+		log.Printf("Ignoring invalid session cookie: %v", err)
+		return nil
+	}
+
+	session, ok := m.store.Get(id)
+	if !ok {
+		return nil
+	}
+	return session
+}
+
+// Destroy removes the request's session from the store and clears its cookie
+func (m *SessionManager) Destroy(w http.ResponseWriter, r *http.Request) {
+	if session := m.Load(r); session != nil {
+		m.store.Delete(session.ID)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   sessionCookieName,
+		Value:  "",
+		Path:   "/",
+		MaxAge: -1,
+	})
+}
+
+// Tokens returns the session's tokens, refreshing them first if they expire
+// within the refresh window. Refresh tokens are replaced when the provider
+// rotates them. The session is deleted once its tokens can't be refreshed.
+func (m *SessionManager) Tokens(session *Session, provider providers.AuthProvider) (providers.Tokens, error) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	tokens := session.tokens
+	if tokens.Expiry.IsZero() || time.Until(tokens.Expiry) > m.refreshWindow {
+		return tokens, nil
+	}
+
+	expired := time.Now().After(tokens.Expiry)
+	if tokens.RefreshToken == "" {
+		if expired {
+			m.store.Delete(session.ID)
+			return providers.Tokens{}, errors.New("session tokens expired and no refresh token is available")
+		}
+		return tokens, nil
+	}
+
+	refreshed, err := provider.RefreshTokens(tokens.RefreshToken)
+	if err != nil {
+		if expired {
+			m.store.Delete(session.ID)
+			return providers.Tokens{}, fmt.Errorf("session tokens expired: %w", err)
+		}
+		// Keep using the current tokens until they expire
+		log.Printf("Failed to refresh session tokens, retrying on next request: %v", err)
+		return tokens, nil
+	}
+
+	// Providers that don't rotate refresh tokens don't return a new one
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = tokens.RefreshToken
+	}
+	// Refresh responses without a new ID token keep the session's identity
+	if refreshed.Token == "" && refreshed.User == nil {
+		refreshed.Token, refreshed.User = tokens.Token, tokens.User
+	}
+	session.tokens = *refreshed
+	m.store.Save(session)
+
+	return session.tokens, nil
+}
+
+// seal encrypts a session ID into a cookie value
+func (m *SessionManager) seal(id string) (string, error) {
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := m.aead.Seal(nonce, nonce, []byte(id), []byte(sessionCookieName))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a cookie value into a session ID
+func (m *SessionManager) open(value string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return "", fmt.Errorf("malformed session cookie: %w", err)
+	}
+	if len(sealed) < m.aead.NonceSize() {
+		return "", errors.New("malformed session cookie")
+	}
+
+	nonce, ciphertext := sealed[:m.aead.NonceSize()], sealed[m.aead.NonceSize():]
+	id, err := m.aead.Open(nil, nonce, ciphertext, []byte(sessionCookieName))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt session cookie: %w", err)
+	}
+	return string(id), nil
+}
@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+const defaultAffinityCookie = "odh_upstream"
+
+// balancer splits requests between a route's upstreams in proportion to their
+// weights, skipping unhealthy ones and keeping pinned clients on theirs
+type balancer struct {
+	backends []*backend
+
+	affinity   *config.SessionAffinityConfig
+	cookieName string
+	cookiePath string
+}
+
+type backend struct {
+	handler http.Handler
+	weight  int
+	// id identifies the backend in affinity cookies, stable across restarts
+	id string
+	// health is nil if the route doesn't check its upstreams
+	health *upstreamHealth
+}
+
+// validateUpstreams checks a route's upstreams without creating any handlers
+// or health state, and returns them with the route's health check settings
+func validateUpstreams(route config.Route) ([]config.WeightedUpstream, *config.HealthCheckConfig, *config.PassiveHealthCheckConfig, error) {
+	upstreams := route.Upstreams
+	if len(upstreams) == 0 {
+		upstreams = []config.WeightedUpstream{{URL: route.Upstream}}
+	} else if route.Upstream != "" {
+		return nil, nil, nil, fmt.Errorf("upstream and upstreams are mutually exclusive")
+	}
+
+	active, passive, err := withHealthDefaults(route)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for _, upstream := range upstreams {
+		if upstream.Weight != nil && *upstream.Weight < 0 {
+			return nil, nil, nil, fmt.Errorf("upstream %q has a negative weight", upstream.URL)
+		}
+		if upstream.URL == "" {
+			continue
+		}
+		// Upstreams containing ${user} are checked with a sample username
+		if _, err := expandUserURL(upstream.URL, "user"); err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid upstream URL %q: %w", upstream.URL, err)
+		}
+		// A ${user} upstream is a different server for every user
+		if (active != nil || passive != nil) && strings.Contains(upstream.URL, config.UserPlaceholder) {
+			return nil, nil, nil, fmt.Errorf("health checks aren't supported for %s upstreams", config.UserPlaceholder)
+		}
+	}
+	return upstreams, active, passive, nil
+}
+
+// newUpstreamHandler returns the handler proxying to a route's upstreams
+func newUpstreamHandler(route config.Route, modifyResponse func(*http.Response) error) (http.Handler, error) {
+	upstreams, active, passive, err := validateUpstreams(route)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &balancer{affinity: route.SessionAffinity}
+	if b.affinity != nil {
+		b.cookieName = b.affinity.CookieName
+		if b.cookieName == "" {
+			b.cookieName = defaultAffinityCookie
+		}
+		b.cookiePath = affinityCookiePath(route)
+	}
+
+	total := 0
+	for _, upstream := range upstreams {
+		weight := 1
+		if upstream.Weight != nil {
+			weight = *upstream.Weight
+		}
+
+		handler, err := newUpstreamProxy(upstream.URL, modifyResponse)
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256([]byte(upstream.URL))
+		be := &backend{handler: handler, weight: weight, id: hex.EncodeToString(sum[:8])}
+		if upstream.URL != "" && (active != nil || passive != nil) {
+			be.health = healthStates.get(upstream.URL, active, passive)
+		}
+		b.backends = append(b.backends, be)
+		total += weight
+	}
+
+	// Plain single upstreams don't need balancing
+	if len(b.backends) == 1 && total > 0 && b.backends[0].health == nil {
+		return b.backends[0].handler, nil
+	}
+	return b, nil
+}
+
+// affinityCookiePath scopes the affinity cookie to the route's path
+func affinityCookiePath(route config.Route) string {
+	if route.PathType != "" && route.PathType != config.PathTypePrefix {
+		return "/"
+	}
+	path, _, _ := strings.Cut(route.PathPrefix, config.UserPlaceholder)
+	if path = strings.TrimSuffix(path, "/"); path == "" {
+		return "/"
+	}
+	return path
+}
+
+// newUpstreamProxy returns the proxy for a single upstream URL
+func newUpstreamProxy(upstream string, modifyResponse func(*http.Response) error) (http.Handler, error) {
+	if upstream == "" {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Upstream Unavailable", http.StatusInternalServerError)
+		}), nil
+	}
+
+	// Upstreams containing ${user} are checked with a sample username
+	target, err := expandUserURL(upstream, "user")
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream URL %q: %w", upstream, err)
+	}
+
+	if strings.Contains(upstream, config.UserPlaceholder) {
+		return userUpstreamProxy(upstream, modifyResponse), nil
+	}
+	return newReverseProxy(target, modifyResponse), nil
+}
+
+func (b *balancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var candidates []*backend
+	total, configured := 0, 0
+	for _, be := range b.backends {
+		configured += be.weight
+		if be.weight > 0 && (be.health == nil || be.health.available()) {
+			candidates = append(candidates, be)
+			total += be.weight
+		}
+	}
+
+	if configured == 0 {
+		log.Printf("No upstream with a non-zero weight for %s", r.URL.Path)
+		http.Error(w, "Upstream Unavailable", http.StatusInternalServerError)
+		return
+	}
+	if len(candidates) == 0 {
+		log.Printf("No healthy upstream for %s", r.URL.Path)
+		http.Error(w, "No Healthy Upstream", http.StatusServiceUnavailable)
+		return
+	}
+
+	chosen := b.pinned(r, candidates)
+	if chosen == nil {
+		n := rand.IntN(total)
+		for _, be := range candidates {
+			if n < be.weight {
+				chosen = be
+				break
+			}
+			n -= be.weight
+		}
+		if b.affinity != nil {
+			b.pin(w, r, chosen)
+		}
+	}
+
+	if chosen.health == nil || chosen.health.passive == nil {
+		chosen.handler.ServeHTTP(w, r)
+		return
+	}
+
+	sw := &statusWriter{ResponseWriter: w}
+	chosen.handler.ServeHTTP(sw, r)
+	chosen.health.recordResponse(sw.status)
+}
+
+// pinned returns the candidate named by the request's affinity cookie, nil if
+// there is none or it isn't available
+func (b *balancer) pinned(r *http.Request, candidates []*backend) *backend {
+	if b.affinity == nil {
+		return nil
+	}
+	cookie, err := r.Cookie(b.cookieName)
+	if err != nil {
+		return nil
+	}
+	for _, be := range candidates {
+		if be.id == cookie.Value {
+			return be
+		}
+	}
+	return nil
+}
+
+// pin sets the affinity cookie for a backend
+func (b *balancer) pin(w http.ResponseWriter, r *http.Request, be *backend) {
+	cookie := &http.Cookie{
+		Name:     b.cookieName,
+		Value:    be.id,
+		Path:     b.cookiePath,
+		HttpOnly: true,
+		Secure:   requestScheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+	if b.affinity.TTL > 0 {
+		cookie.MaxAge = int(b.affinity.TTL.Seconds())
+	}
+	http.SetCookie(w, cookie)
+}
+
+// statusWriter records the status code of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	// Informational responses such as 103 Early Hints precede the real one
+	if sw.status == 0 && code >= 200 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, the
+// reverse proxy needs it to flush and to hijack upgraded connections
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+// testBackend answers with its name, or with 503 while failing
+type testBackend struct {
+	*httptest.Server
+	failing atomic.Bool
+}
+
+func newTestBackend(t *testing.T, name string) *testBackend {
+	b := &testBackend{}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if b.failing.Load() {
+			http.Error(w, "failing", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(name))
+	}))
+	t.Cleanup(b.Close)
+	return b
+}
+
+// newTestBalancer proxies to upstreams, stopping their health checks when
+// the test ends
+func newTestBalancer(t *testing.T, route config.Route) http.Handler {
+	t.Cleanup(func() {
+		healthStates.nextGeneration()
+		healthStates.prune()
+	})
+	handler, err := newUpstreamHandler(route, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+// get sends a request through handler and returns the status and body
+func get(handler http.Handler) (int, string) {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rec.Code, rec.Body.String()
+}
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// servedBy sends n requests and counts the responses of each backend
+func servedBy(handler http.Handler, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		code, body := get(handler)
+		if code != http.StatusOK {
+			body = http.StatusText(code)
+		}
+		counts[body]++
+	}
+	return counts
+}
+
+func weighted(url string, weight int) config.WeightedUpstream {
+	return config.WeightedUpstream{URL: url, Weight: &weight}
+}
+
+func TestBalancerWeights(t *testing.T) {
+	a, b, c := newTestBackend(t, "a"), newTestBackend(t, "b"), newTestBackend(t, "c")
+	handler := newTestBalancer(t, config.Route{
+		PathPrefix: "/",
+		Upstreams:  []config.WeightedUpstream{weighted(a.URL, 3), weighted(b.URL, 1), weighted(c.URL, 0)},
+	})
+
+	counts := servedBy(handler, 400)
+	if counts["a"]+counts["b"] != 400 {
+		t.Fatalf("got %v, want every request served by a or b", counts)
+	}
+	// a gets three quarters of the requests, well within the random spread
+	if counts["a"] < 250 || counts["a"] > 350 {
+		t.Errorf("got %v, want a 3:1 split", counts)
+	}
+}
+
+func TestBalancerActiveHealthCheck(t *testing.T) {
+	a, b := newTestBackend(t, "a"), newTestBackend(t, "b")
+	handler := newTestBalancer(t, config.Route{
+		PathPrefix: "/",
+		Upstreams:  []config.WeightedUpstream{weighted(a.URL, 1), weighted(b.URL, 1)},
+		HealthCheck: &config.HealthCheckConfig{
+			Path:               "/healthz",
+			Interval:           10 * time.Millisecond,
+			HealthyThreshold:   2,
+			UnhealthyThreshold: 2,
+		},
+	})
+	bHealth := handler.(*balancer).backends[1].health
+
+	if counts := servedBy(handler, 50); counts["b"] == 0 {
+		t.Fatalf("got %v, want requests to b while it's healthy", counts)
+	}
+
+	// b fails its probes and gets no more requests
+	b.failing.Store(true)
+	waitFor(t, "b to be marked unhealthy", func() bool { return !bHealth.available() })
+	if counts := servedBy(handler, 50); counts["a"] != 50 {
+		t.Errorf("got %v, want every request sent to a", counts)
+	}
+
+	// b passes its probes again and is back in rotation
+	b.failing.Store(false)
+	waitFor(t, "b to be marked healthy", bHealth.available)
+	if counts := servedBy(handler, 50); counts["b"] == 0 || counts["a"]+counts["b"] != 50 {
+		t.Errorf("got %v, want requests to both backends", counts)
+	}
+
+	// With every backend unhealthy requests fail fast
+	a.failing.Store(true)
+	b.failing.Store(true)
+	aHealth := handler.(*balancer).backends[0].health
+	waitFor(t, "both backends to be marked unhealthy", func() bool { return !aHealth.available() && !bHealth.available() })
+	if code, _ := get(handler); code != http.StatusServiceUnavailable {
+		t.Errorf("got %d, want 503", code)
+	}
+}
+
+func TestBalancerPassiveEjection(t *testing.T) {
+	a, b := newTestBackend(t, "a"), newTestBackend(t, "b")
+	handler := newTestBalancer(t, config.Route{
+		PathPrefix: "/",
+		Upstreams:  []config.WeightedUpstream{weighted(a.URL, 1), weighted(b.URL, 1)},
+		PassiveHealthCheck: &config.PassiveHealthCheckConfig{
+			ConsecutiveFailures: 3,
+			EjectionTime:        time.Second,
+		},
+	})
+	bHealth := handler.(*balancer).backends[1].health
+
+	// b answers 503 until it has failed three requests in a row
+	b.failing.Store(true)
+	failures := 0
+	for i := 0; i < 200 && bHealth.available(); i++ {
+		if code, _ := get(handler); code == http.StatusServiceUnavailable {
+			failures++
+		}
+	}
+	if bHealth.available() || failures != 3 {
+		t.Fatalf("b served %d failures and is available: %v, want it ejected after 3", failures, bHealth.available())
+	}
+
+	// While ejected only a gets requests, even once b recovers
+	b.failing.Store(false)
+	if counts := servedBy(handler, 50); counts["a"] != 50 {
+		t.Errorf("got %v, want every request sent to a", counts)
+	}
+
+	// b is re-admitted when the ejection time is over
+	waitFor(t, "b to be re-admitted", bHealth.available)
+	if counts := servedBy(handler, 50); counts["b"] == 0 || counts["a"]+counts["b"] != 50 {
+		t.Errorf("got %v, want requests to both backends", counts)
+	}
+}
+
+func TestBalancerPassiveEjectionOnConnectionFailure(t *testing.T) {
+	a, b := newTestBackend(t, "a"), newTestBackend(t, "b")
+	handler := newTestBalancer(t, config.Route{
+		PathPrefix:         "/",
+		Upstreams:          []config.WeightedUpstream{weighted(a.URL, 1), weighted(b.URL, 1)},
+		PassiveHealthCheck: &config.PassiveHealthCheckConfig{ConsecutiveFailures: 2, EjectionTime: time.Minute},
+	})
+	bHealth := handler.(*balancer).backends[1].health
+
+	// The proxy answers 502 for a backend that's gone, which counts as a failure
+	b.Close()
+	for i := 0; i < 200 && bHealth.available(); i++ {
+		get(handler)
+	}
+	if bHealth.available() {
+		t.Fatal("b wasn't ejected")
+	}
+	if counts := servedBy(handler, 50); counts["a"] != 50 {
+		t.Errorf("got %v, want every request sent to a", counts)
+	}
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/jctanner/odh-gateway/pkg/config"
+)
+
+// dnsLabel matches RFC 1123 labels, the usernames that can replace ${user} in
+// a host name or namespace
+var dnsLabel = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// validateUsername checks that a username can replace ${user} as a single path
+// segment: not empty, not a dot segment, without slashes or control characters
+func validateUsername(username string) error {
+	if username == "" || username == "." || username == ".." {
+		return fmt.Errorf("username %q can't be used as a path segment", username)
+	}
+	if strings.ContainsFunc(username, func(r rune) bool { return r == '/' || r == '\\' || unicode.IsControl(r) }) {
+		return fmt.Errorf("username %q can't be used as a path segment", username)
+	}
+	return nil
+}
+
+// validateDNSLabelUsername checks that a username can replace ${user} in a
+// host name or namespace
+func validateDNSLabelUsername(username string) error {
+	if !dnsLabel.MatchString(username) {
+		return fmt.Errorf("username %q is not a valid DNS label", username)
+	}
+	return nil
+}
+
+// expandUserPath replaces ${user} in an unescaped path
+func expandUserPath(path, username string) (string, error) {
+	if !strings.Contains(path, config.UserPlaceholder) {
+		return path, nil
+	}
+	if err := validateUsername(username); err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(path, config.UserPlaceholder, username), nil
+}
+
+// expandUserURL replaces ${user} in an upstream URL. In the host the username
+// must be a DNS label, elsewhere it is escaped. The host of the result is
+// checked against the template's, so no username can send the request to
+// another host.
+func expandUserURL(template, username string) (*url.URL, error) {
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
+
+	// Split off scheme://authority, the rest is path, query and fragment
+	authorityEnd := 0
+	if i := strings.Index(template, "://"); i >= 0 {
+		authorityEnd = i + 3
+		if j := strings.IndexAny(template[authorityEnd:], "/?#"); j >= 0 {
+			authorityEnd += j
+		} else {
+			authorityEnd = len(template)
+		}
+	}
+	authority, rest := template[:authorityEnd], template[authorityEnd:]
+
+	if strings.Contains(authority, config.UserPlaceholder) {
+		if err := validateDNSLabelUsername(username); err != nil {
+			return nil, err
+		}
+		authority = strings.ReplaceAll(authority, config.UserPlaceholder, username)
+	}
+	path, query, hasQuery := strings.Cut(rest, "?")
+	rest = strings.ReplaceAll(path, config.UserPlaceholder, url.PathEscape(username))
+	if hasQuery {
+		rest += "?" + strings.ReplaceAll(query, config.UserPlaceholder, url.QueryEscape(username))
+	}
+
+	target, err := url.Parse(authority + rest)
+	if err != nil {
+		return nil, err
+	}
+
+	expectedHost := authority[strings.LastIndex(authority, "/")+1:]
+	if i := strings.LastIndex(expectedHost, "@"); i >= 0 {
+		expectedHost = expectedHost[i+1:]
+	}
+	if target.Host != expectedHost {
+		return nil, fmt.Errorf("expanded upstream host %q doesn't match %q", target.Host, expectedHost)
+	}
+	return target, nil
+}
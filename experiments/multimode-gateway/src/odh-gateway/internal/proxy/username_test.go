@@ -0,0 +1,83 @@
+package proxy
+
+import "testing"
+
+func TestExpandUserPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		username string
+		want     string
+		wantErr  bool
+	}{
+		{"/notebooks/${user}/lab", "alice", "/notebooks/alice/lab", false},
+		{"/notebooks/${user}/lab", "kube:admin", "/notebooks/kube:admin/lab", false},
+		{"/notebooks/${user}/lab", "alice@example.com", "/notebooks/alice@example.com/lab", false},
+		{"/notebooks/${user}/lab", "50%", "/notebooks/50%/lab", false},
+		{"/notebooks/${user}/lab", "a/b", "", true},
+		{"/notebooks/${user}/lab", "a\\b", "", true},
+		{"/notebooks/${user}/lab", "..", "", true},
+		{"/notebooks/${user}/lab", ".", "", true},
+		{"/notebooks/${user}/lab", "", "", true},
+		{"/notebooks/${user}/lab", "alice\n", "", true},
+		// Paths without ${user} are left alone, whatever the username
+		{"/static/", "../admin", "/static/", false},
+	}
+
+	for _, tt := range tests {
+		got, err := expandUserPath(tt.path, tt.username)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("expandUserPath(%q, %q): got error %v, want error %v", tt.path, tt.username, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expandUserPath(%q, %q) = %q, want %q", tt.path, tt.username, got, tt.want)
+		}
+	}
+}
+
+func TestExpandUserURL(t *testing.T) {
+	const (
+		hostTemplate = "http://jupyter-${user}.notebooks.svc:8888/user/${user}/lab"
+		pathTemplate = "http://jupyter.notebooks.svc:8888/user/${user}/lab?owner=${user}"
+	)
+
+	tests := []struct {
+		template string
+		username string
+		want     string
+		wantErr  bool
+	}{
+		{hostTemplate, "alice", "http://jupyter-alice.notebooks.svc:8888/user/alice/lab", false},
+		{pathTemplate, "alice", "http://jupyter.notebooks.svc:8888/user/alice/lab?owner=alice", false},
+
+		// In the host the username must be a DNS label
+		{hostTemplate, "Alice", "", true},
+		{hostTemplate, "alice.evil.com", "", true},
+		{hostTemplate, "evil.com#", "", true},
+		{hostTemplate, "alice@evil.com", "", true},
+		{hostTemplate, "alice%2f", "", true},
+
+		// Elsewhere it is escaped, so it can't add segments or parameters
+		{pathTemplate, "alice@example.com", "http://jupyter.notebooks.svc:8888/user/alice@example.com/lab?owner=alice%40example.com", false},
+		{pathTemplate, "a b&admin=true", "http://jupyter.notebooks.svc:8888/user/a%20b&admin=true/lab?owner=a+b%26admin%3Dtrue", false},
+		{pathTemplate, "50%", "http://jupyter.notebooks.svc:8888/user/50%25/lab?owner=50%25", false},
+		{pathTemplate, "%2e%2e", "http://jupyter.notebooks.svc:8888/user/%252e%252e/lab?owner=%252e%252e", false},
+		{pathTemplate, "a?b#c", "http://jupyter.notebooks.svc:8888/user/a%3Fb%23c/lab?owner=a%3Fb%23c", false},
+
+		// Names that aren't a single path segment are rejected everywhere
+		{pathTemplate, "a/b", "", true},
+		{pathTemplate, "..", "", true},
+		{pathTemplate, "", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := expandUserURL(tt.template, tt.username)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("expandUserURL(%q, %q): got error %v, want error %v", tt.template, tt.username, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got.String() != tt.want {
+			t.Errorf("expandUserURL(%q, %q) = %q, want %q", tt.template, tt.username, got, tt.want)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -12,10 +14,175 @@ type Config struct {
 	Provider *ProviderConfig `yaml:"provider,omitempty"`
 }
 
+// UserPlaceholder is replaced with the authenticated username in route paths,
+// upstreams and SubjectAccessReview attributes
+const UserPlaceholder = "${user}"
+
+// Path match types
+const (
+	PathTypePrefix = "Prefix"
+	PathTypeExact  = "Exact"
+	PathTypeRegex  = "Regex"
+)
+
+// Value match types for header and query matchers
+const (
+	MatchExact   = "Exact"
+	MatchRegex   = "Regex"
+	MatchPresent = "Present"
+)
+
 type Route struct {
 	PathPrefix   string `yaml:"path"`
 	Upstream     string `yaml:"upstream"`
 	AuthRequired *bool  `yaml:"authRequired,omitempty"` // Optional per-route auth override
+
+	// Upstreams splits requests between several upstreams by weight, in place of Upstream
+	Upstreams []WeightedUpstream `yaml:"upstreams,omitempty"`
+
+	// Upstream health checking and session pinning
+	HealthCheck        *HealthCheckConfig        `yaml:"healthCheck,omitempty"`
+	PassiveHealthCheck *PassiveHealthCheckConfig `yaml:"passiveHealthCheck,omitempty"`
+	SessionAffinity    *SessionAffinityConfig    `yaml:"sessionAffinity,omitempty"`
+
+	// Matching, all configured matchers must match
+	PathType    string         `yaml:"pathType,omitempty"` // Prefix (default), Exact or Regex
+	Hosts       []string       `yaml:"hosts,omitempty"`    // Exact hostnames or wildcards like *.example.com
+	Methods     []string       `yaml:"methods,omitempty"`
+	Headers     []ValueMatcher `yaml:"headers,omitempty"`
+	QueryParams []ValueMatcher `yaml:"queryParams,omitempty"`
+	// Priority orders overlapping routes, higher first. Routes with equal
+	// priority are ordered by specificity, then by their order in the file.
+	Priority int `yaml:"priority,omitempty"`
+
+	// Rewriting of the path sent upstream, prefix rewrites also apply to
+	// Location and Set-Cookie paths in responses
+	StripPrefix   bool          `yaml:"stripPrefix,omitempty"`
+	RewritePrefix string        `yaml:"rewritePrefix,omitempty"`
+	RewriteRegex  *RegexRewrite `yaml:"rewriteRegex,omitempty"`
+	// RewriteHost replaces the Host header sent upstream
+	RewriteHost string `yaml:"rewriteHost,omitempty"`
+
+	// Header modification of requests sent upstream and responses sent back
+	RequestHeaders  *HeaderModifier `yaml:"requestHeaders,omitempty"`
+	ResponseHeaders *HeaderModifier `yaml:"responseHeaders,omitempty"`
+
+	// Redirect answers requests with a redirect instead of proxying them
+	Redirect *Redirect `yaml:"redirect,omitempty"`
+
+	// Authorization, all configured checks must pass. Any of them implies authRequired.
+	AllowedUsers        []string                   `yaml:"allowedUsers,omitempty"`
+	AllowedGroups       []string                   `yaml:"allowedGroups,omitempty"`
+	SubjectAccessReview *SubjectAccessReviewConfig `yaml:"subjectAccessReview,omitempty"`
+}
+
+// HasAuthorization returns whether the route restricts which users may use it
+func (r Route) HasAuthorization() bool {
+	if len(r.AllowedUsers) > 0 || len(r.AllowedGroups) > 0 || r.SubjectAccessReview != nil ||
+		strings.Contains(r.PathPrefix, UserPlaceholder) {
+		return true
+	}
+	for _, upstream := range r.UpstreamURLs() {
+		if strings.Contains(upstream, UserPlaceholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpstreamURLs returns the URLs of the route's upstreams
+func (r Route) UpstreamURLs() []string {
+	if len(r.Upstreams) == 0 {
+		return []string{r.Upstream}
+	}
+	urls := make([]string, 0, len(r.Upstreams))
+	for _, upstream := range r.Upstreams {
+		urls = append(urls, upstream.URL)
+	}
+	return urls
+}
+
+// WeightedUpstream is one of a route's upstreams. An empty URL stands for a
+// backend that couldn't be resolved and fails its share of requests.
+type WeightedUpstream struct {
+	URL    string `yaml:"url"`
+	Weight *int   `yaml:"weight,omitempty"` // default: 1, 0 sends no requests
+}
+
+// HealthCheckConfig probes each upstream with HTTP GET requests. Upstreams
+// failing UnhealthyThreshold probes in a row get no requests until they pass
+// HealthyThreshold probes in a row.
+type HealthCheckConfig struct {
+	Path               string        `yaml:"path"`
+	Interval           time.Duration `yaml:"interval,omitempty"`           // default: 10s
+	Timeout            time.Duration `yaml:"timeout,omitempty"`            // default: 2s
+	HealthyThreshold   int           `yaml:"healthyThreshold,omitempty"`   // default: 2
+	UnhealthyThreshold int           `yaml:"unhealthyThreshold,omitempty"` // default: 3
+}
+
+// PassiveHealthCheckConfig ejects upstreams answering ConsecutiveFailures
+// requests in a row with 502, 503 or 504 (including connection failures) for
+// EjectionTime
+type PassiveHealthCheckConfig struct {
+	ConsecutiveFailures int           `yaml:"consecutiveFailures,omitempty"` // default: 5
+	EjectionTime        time.Duration `yaml:"ejectionTime,omitempty"`        // default: 30s
+}
+
+// SessionAffinityConfig pins clients to an upstream with a cookie
+type SessionAffinityConfig struct {
+	CookieName string        `yaml:"cookieName,omitempty"` // default: odh_upstream
+	TTL        time.Duration `yaml:"ttl,omitempty"`        // default: until the browser closes
+}
+
+// HeaderModifier sets, adds and removes HTTP headers
+type HeaderModifier struct {
+	Set    []HeaderValue `yaml:"set,omitempty"`
+	Add    []HeaderValue `yaml:"add,omitempty"`
+	Remove []string      `yaml:"remove,omitempty"`
+}
+
+// HeaderValue is an HTTP header name and value
+type HeaderValue struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+// Redirect describes the redirect a route answers with. Unset parts are
+// taken from the request.
+type Redirect struct {
+	Scheme   string `yaml:"scheme,omitempty"`
+	Hostname string `yaml:"hostname,omitempty"`
+	Port     int    `yaml:"port,omitempty"`
+	// ReplacePath replaces the whole path, ReplacePrefix the part matched by
+	// a Prefix route's path
+	ReplacePath   string `yaml:"replacePath,omitempty"`
+	ReplacePrefix string `yaml:"replacePrefix,omitempty"`
+	StatusCode    int    `yaml:"statusCode,omitempty"` // default: 302
+}
+
+// RegexRewrite replaces matches of Pattern in the request path with
+// Replacement, which may reference capture groups as $1 or ${name}
+type RegexRewrite struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+// ValueMatcher matches a request header or query parameter
+type ValueMatcher struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value,omitempty"`
+	Type  string `yaml:"type,omitempty"` // Exact (default), Regex or Present
+}
+
+// SubjectAccessReviewConfig holds the resource attributes checked with a
+// SubjectAccessReview for the authenticated user
+type SubjectAccessReviewConfig struct {
+	Namespace   string `yaml:"namespace,omitempty"`
+	Verb        string `yaml:"verb,omitempty"` // default: get
+	Group       string `yaml:"group,omitempty"`
+	Resource    string `yaml:"resource"`
+	Subresource string `yaml:"subresource,omitempty"`
+	Name        string `yaml:"name,omitempty"`
 }
 
 // ProviderConfig holds configuration for authentication providers
@@ -48,6 +215,71 @@ type OpenShiftProviderConfig struct {
 	
 	// Service account mode (automatic configuration)
 	ServiceAccount bool `yaml:"serviceAccount,omitempty"`
+
+	// Token validation cache
+	TokenCache TokenCacheConfig `yaml:"tokenCache,omitempty"`
+
+	// Resolve group membership from a Group informer run as the gateway's
+	// service account instead of listing groups with the user's token
+	GroupInformer bool `yaml:"groupInformer,omitempty"`
+}
+
+// TokenCacheConfig controls caching of token validation results. Zero values
+// use the defaults.
+type TokenCacheConfig struct {
+	// TTL of successful validations (default 30s)
+	TTL time.Duration `yaml:"ttl,omitempty"`
+
+	// NegativeTTL of rejected tokens (default 5s)
+	NegativeTTL time.Duration `yaml:"negativeTtl,omitempty"`
+
+	// MaxEntries bounds the cache size (default 1000)
+	MaxEntries int `yaml:"maxEntries,omitempty"`
+
+	// Disabled validates every request against the API
+	Disabled bool `yaml:"disabled,omitempty"`
+}
+
+// SessionConfig holds configuration for browser sessions
+type SessionConfig struct {
+	// Secret the session cookie encryption key is derived from. A random key
+	// is generated if empty.
+	Secret string
+
+	// TTL is the maximum lifetime of a session
+	TTL time.Duration
+
+	// RefreshWindow is how long before the tokens expire they are refreshed
+	RefreshWindow time.Duration
+}
+
+// IdentityAssertionConfig holds configuration for the signed identity
+// assertions sent to upstreams
+type IdentityAssertionConfig struct {
+	// Enabled sends a signed JWT with the user's identity to upstreams
+	Enabled bool
+
+	// KeyFile is a PEM encoded ECDSA P-256 private key. A random key is
+	// generated if empty.
+	KeyFile string
+
+	// TTL is the lifetime of an assertion
+	TTL time.Duration
+}
+
+// KubernetesSourceConfig holds configuration for routes read from the
+// Kubernetes API alongside the config file
+type KubernetesSourceConfig struct {
+	// Services routes to Services annotated with odhgateway.opendatahub.io/enabled
+	Services bool
+
+	// GatewayClassName routes HTTPRoutes attached to Gateways of this class,
+	// disabled if empty
+	GatewayClassName string
+
+	// NamespaceSelector is a label selector limiting the watched namespaces,
+	// all namespaces if empty
+	NamespaceSelector string
 }
 
 func LoadConfig(path string) (*Config, error) {